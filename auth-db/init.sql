@@ -1,5 +1,169 @@
 CREATE TABLE auth (
   id UUID PRIMARY KEY,
   email TEXT UNIQUE,
-  password TEXT
+  password TEXT,
+  email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+  role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin')),
+  suspended BOOLEAN NOT NULL DEFAULT FALSE,
+  tokens_revoked_before TIMESTAMPTZ
 );
+
+CREATE INDEX auth_tokens_revoked_before ON auth (tokens_revoked_before) WHERE tokens_revoked_before IS NOT NULL;
+
+CREATE TABLE refresh_token (
+  id UUID PRIMARY KEY,
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  family_id UUID NOT NULL,
+  token_hash TEXT UNIQUE NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked BOOLEAN NOT NULL DEFAULT FALSE,
+  rotated BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX refresh_token_family_id ON refresh_token (family_id);
+
+CREATE TABLE revoked_token (
+  jti TEXT PRIMARY KEY,
+  auth_id UUID NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE password_reset (
+  id UUID PRIMARY KEY,
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE email_verification (
+  id UUID PRIMARY KEY,
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE account_deletion (
+  auth_id UUID PRIMARY KEY,
+  user_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+  matches_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE login_attempt (
+  key TEXT PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  last_failure TIMESTAMPTZ NOT NULL,
+  locked_until TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE two_factor (
+  auth_id UUID PRIMARY KEY REFERENCES auth(id) ON DELETE CASCADE,
+  secret TEXT NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT FALSE,
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE recovery_code (
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  code_hash TEXT NOT NULL,
+  PRIMARY KEY (auth_id, code_hash)
+);
+
+CREATE TABLE two_factor_challenge (
+  id UUID PRIMARY KEY,
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE signing_key (
+  id TEXT PRIMARY KEY,
+  algorithm TEXT NOT NULL,
+  private_key TEXT NOT NULL,
+  state TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX signing_key_active ON signing_key (state) WHERE state = 'active';
+
+CREATE TABLE admin_action (
+  id UUID PRIMARY KEY,
+  admin_id UUID NOT NULL,
+  auth_id UUID NOT NULL,
+  action TEXT NOT NULL,
+  detail TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX admin_action_auth_id ON admin_action (auth_id, created_at);
+
+CREATE TABLE oidc_login (
+  state_hash TEXT PRIMARY KEY,
+  provider TEXT NOT NULL,
+  nonce TEXT NOT NULL,
+  code_verifier TEXT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE auth_identity (
+  provider TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE magic_link (
+  id UUID PRIMARY KEY,
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE api_key (
+  id UUID PRIMARY KEY,
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  name TEXT NOT NULL,
+  prefix TEXT NOT NULL,
+  key_hash TEXT UNIQUE NOT NULL,
+  scopes TEXT[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX api_key_auth_id ON api_key (auth_id);
+
+CREATE TABLE auth_session (
+  id UUID PRIMARY KEY,
+  auth_id UUID NOT NULL REFERENCES auth(id) ON DELETE CASCADE,
+  ip_address TEXT NOT NULL,
+  user_agent TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  last_used_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX auth_session_auth_id ON auth_session (auth_id);
+
+CREATE TABLE login_event (
+  id UUID PRIMARY KEY,
+  auth_id UUID,
+  email TEXT NOT NULL,
+  event TEXT NOT NULL,
+  outcome TEXT NOT NULL,
+  ip_address TEXT NOT NULL,
+  user_agent TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX login_event_auth_id ON login_event (auth_id, created_at);
+
+-- Login events form an audit trail, so are never changed once recorded
+CREATE RULE login_event_no_update AS ON UPDATE TO login_event DO INSTEAD NOTHING;
+CREATE RULE login_event_no_delete AS ON DELETE TO login_event DO INSTEAD NOTHING;
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// changePasswordRequest contains the client-provided current password and the password to replace it
+type changePasswordRequest struct {
+	CurrentPassword string `valid:"type(string),required"`
+	NewPassword     string `valid:"type(string),required,stringlength(8|64)"`
+}
+
+// changeEmailRequest contains the client-provided new email and current password
+type changeEmailRequest struct {
+	Email    string `valid:"email,required"`
+	Password string `valid:"type(string),required"`
+}
+
+// changePasswordResponse contains an access token and a refresh token to replace those revoked by the change
+type changePasswordResponse struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+func (env *env) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestChangePassword)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestChangePassword)
+		return
+	}
+
+	var req changePasswordRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestChangePassword)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestChangePassword)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestChangePassword))
+	current, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestChangePassword)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangePassword)
+		}
+		return
+	}
+
+	if !verifyPassword(env, w, r, current, req.CurrentPassword, metric.RequestChangePassword) {
+		return
+	}
+
+	reasons, err := env.passwordPolicy.Check(req.NewPassword, current.Email)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not check password: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangePassword)
+		return
+	}
+
+	if len(reasons) > 0 {
+		respondWithFieldErrors(w, "Password does not meet the password policy", map[string][]string{"NewPassword": reasons}, http.StatusBadRequest, metric.RequestChangePassword)
+		return
+	}
+
+	hashedPassword, err := env.passwords.Hash(req.NewPassword)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not hash password: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangePassword)
+		return
+	}
+
+	// Updating the password revokes every session, then a new one is started for this client only
+	input := dao.UpdateAuthPasswordInput{
+		ID:            auth.ID,
+		Password:      hashedPassword,
+		RevokedBefore: util.TokenCutoff(time.Now()),
+	}
+
+	for _, hook := range env.hook.beforeChangePasswordHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestChangePassword)
+			return
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestChangePassword))
+	updated, err := env.dao.UpdateAuthPassword(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestChangePassword)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangePassword)
+		}
+		return
+	}
+
+	accessToken, err := createAccessToken(env, updated)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangePassword)
+		return
+	}
+
+	refreshToken, err := createRefreshTokenFamily(env, updated.ID, r, metric.RequestChangePassword)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create refresh token: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangePassword)
+		return
+	}
+
+	for _, hook := range env.hook.afterChangePasswordHooks {
+		err := (*hook)(env, updated)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestChangePassword)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(changePasswordResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestChangePassword).Inc()
+}
+
+func (env *env) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestChangeEmail)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestChangeEmail)
+		return
+	}
+
+	var req changeEmailRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestChangeEmail)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestChangeEmail)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestChangeEmail))
+	current, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestChangeEmail)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangeEmail)
+		}
+		return
+	}
+
+	if !verifyPassword(env, w, r, current, req.Password, metric.RequestChangeEmail) {
+		return
+	}
+
+	input := dao.UpdateAuthEmailInput{
+		ID:    auth.ID,
+		Email: req.Email,
+	}
+
+	for _, hook := range env.hook.beforeChangeEmailHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestChangeEmail)
+			return
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestChangeEmail))
+	updated, err := env.dao.UpdateAuthEmail(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrDuplicateAuth:
+			respondWithError(w, err.Error(), http.StatusForbidden, metric.RequestChangeEmail)
+		case dao.ErrAuthNotFound:
+			respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestChangeEmail)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangeEmail)
+		}
+		return
+	}
+
+	// Verification tokens sent to the previous address must not verify the new one
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestChangeEmail))
+	err = env.dao.DeleteAuthEmailVerifications(dao.DeleteAuthEmailVerificationsInput{
+		AuthID: updated.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangeEmail)
+		return
+	}
+
+	err = sendVerificationEmail(env, updated, metric.RequestChangeEmail)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestChangeEmail)
+		return
+	}
+
+	for _, hook := range env.hook.afterChangeEmailHooks {
+		err := (*hook)(env, updated, current.Email)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestChangeEmail)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestChangeEmail).Inc()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/util"
+)
+
+// Test that a password can be changed, revoking every other session
+func TestChangePasswordHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	// Tokens issued within the same millisecond as the cutoff remain valid
+	time.Sleep(2 * util.TokenTimePrecision)
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/password", `{"currentPassword": "BlackcurrantCrush123", "newPassword": "RaspberryRipple456"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	// The previous refresh token has been revoked
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The previous access token has been revoked, but the one returned can still be used
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/sessions", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/sessions", "", decoded["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The refresh token returned can still be used
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, decoded["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "RaspberryRipple456"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that changing a password with an incorrect current password fails
+func TestChangePasswordHandlerFailsOnIncorrectPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/password", `{"currentPassword": "NotMyPassword", "newPassword": "RaspberryRipple456"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that changing a password without an access token fails
+func TestChangePasswordHandlerFailsOnNoToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPut, "/auth/password", `{"currentPassword": "BlackcurrantCrush123", "newPassword": "RaspberryRipple456"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that an email can be changed, requiring the new address to be verified
+func TestChangeEmailHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	oldToken := emailedToken(t, mockEnv, "jay@test.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/email", `{"email": "jay@example.com", "password": "BlackcurrantCrush123"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	auth := mockEnv.dao.(*mockDAO).authList[0]
+	if auth.Email != "jay@example.com" || auth.EmailVerified {
+		t.Fatalf("Email was not updated correctly: %+v", auth)
+	}
+
+	// The verification token sent to the previous address cannot verify the new one
+	res, err = makeRequest(mockEnv, http.MethodGet, fmt.Sprintf("/auth/verify?token=%s", oldToken), "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodGet, fmt.Sprintf("/auth/verify?token=%s", emailedToken(t, mockEnv, "jay@example.com")), "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that changing to an email used by another auth fails
+func TestChangeEmailHandlerFailsOnDuplicate(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	registerAuth(t, mockEnv, "jay@example.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/email", `{"email": "jay@example.com", "password": "BlackcurrantCrush123"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that changing an email with an incorrect password fails
+func TestChangeEmailHandlerFailsOnIncorrectPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/email", `{"email": "jay@example.com", "password": "NotMyPassword"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that an after change email hook is provided with the previous email
+func TestChangeEmailHandlerAfterHookSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	var notified string
+	mockEnv.hook.AfterChangeEmail(func(env *env, auth *dao.Auth, previousEmail string) *HookError {
+		notified = previousEmail
+		return nil
+	})
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/email", `{"email": "jay@example.com", "password": "BlackcurrantCrush123"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if notified != "jay@test.com" {
+		t.Fatalf("Hook was not provided with the previous email: %s", notified)
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// adminPolicy permits only admins to manage the accounts of other auths
+var adminPolicy = util.HasRole(util.RoleAdmin)
+
+// The default and maximum number of results returned in a single page of a listing
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+// The actions that admins can take against an auth, as recorded in the audit log
+const (
+	adminActionUpdateRole         = "update_role"
+	adminActionSuspend            = "suspend"
+	adminActionUnsuspend          = "unsuspend"
+	adminActionForceLogout        = "force_logout"
+	adminActionForcePasswordReset = "force_password_reset"
+)
+
+// readAccountResponse contains a single auth to be returned to an admin, excluding its password
+type readAccountResponse struct {
+	ID            uuid.UUID
+	Email         string
+	EmailVerified bool
+	Role          string
+	Suspended     bool
+}
+
+// listAccountsResponse contains a page of auths to be returned to an admin, and the offset of the next page if there is one
+type listAccountsResponse struct {
+	Accounts   []readAccountResponse
+	NextOffset *int
+}
+
+// readAdminActionResponse contains a single admin action to be returned to an admin
+type readAdminActionResponse struct {
+	ID        uuid.UUID
+	AdminID   uuid.UUID
+	AuthID    uuid.UUID
+	Action    string
+	Detail    string
+	CreatedAt string
+}
+
+// listAdminActionsResponse contains a page of admin actions to be returned to an admin, and the offset of the next page if there is one
+type listAdminActionsResponse struct {
+	Actions    []readAdminActionResponse
+	NextOffset *int
+}
+
+// Parse the limit and offset of a page of results from the query of a request
+func parsePage(query url.Values) (int, int, error) {
+	limit := defaultPageLimit
+	if len(query.Get("limit")) > 0 {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+
+	offset := 0
+	if len(query.Get("offset")) > 0 {
+		var err error
+		offset, err = strconv.Atoi(query.Get("offset"))
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must not be negative")
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// Return the offset of the page after the current one, given the number of results found when requesting one more than
+// the limit, or nil if the current page is the last
+func nextOffset(found int, limit int, offset int) *int {
+	if found <= limit {
+		return nil
+	}
+	next := offset + limit
+	return &next
+}
+
+// Construct the audit log record of an action taken by an admin against an auth, to be recorded alongside the action
+// The record's time is also the time before which any tokens the action revokes were issued
+func newAdminAction(admin *util.Auth, authID uuid.UUID, action string, detail string) (*dao.CreateAdminActionInput, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &dao.CreateAdminActionInput{
+		ID:        id,
+		AdminID:   admin.ID,
+		AuthID:    authID,
+		Action:    action,
+		Detail:    detail,
+		CreatedAt: util.TokenCutoff(time.Now()),
+	}, nil
+}
+
+func (env *env) listAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestListAccounts)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestListAccounts)
+		return
+	}
+
+	if !adminPolicy(auth, uuid.Nil) {
+		respondWithError(w, "Not authorized to make request", http.StatusForbidden, metric.RequestListAccounts)
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestListAccounts)
+		return
+	}
+
+	// Request one more than the limit, to find whether there is another page
+	input := dao.ListAuthInput{
+		Email:  r.URL.Query().Get("email"),
+		Limit:  limit + 1,
+		Offset: offset,
+	}
+
+	for _, hook := range env.hook.beforeListAccountsHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestListAccounts)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestListAccounts))
+	authList, err := env.dao.ListAuth(input)
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestListAccounts)
+		return
+	}
+
+	for _, hook := range env.hook.afterListAccountsHooks {
+		err := (*hook)(env, authList)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestListAccounts)
+			return
+		}
+	}
+
+	listAccountsResp := listAccountsResponse{
+		Accounts:   make([]readAccountResponse, 0),
+		NextOffset: nextOffset(len(*authList), limit, offset),
+	}
+	for i, account := range *authList {
+		if i == limit {
+			break
+		}
+		listAccountsResp.Accounts = append(listAccountsResp.Accounts, readAccountResponse{
+			ID:            account.ID,
+			Email:         account.Email,
+			EmailVerified: account.EmailVerified,
+			Role:          account.Role,
+			Suspended:     account.Suspended,
+		})
+	}
+
+	json.NewEncoder(w).Encode(listAccountsResp)
+	metric.RequestSuccess.WithLabelValues(metric.RequestListAccounts).Inc()
+}
+
+func (env *env) suspendAccountHandler(w http.ResponseWriter, r *http.Request) {
+	env.updateSuspended(w, r, true, metric.RequestSuspendAccount)
+}
+
+func (env *env) unsuspendAccountHandler(w http.ResponseWriter, r *http.Request) {
+	env.updateSuspended(w, r, false, metric.RequestUnsuspendAccount)
+}
+
+// Suspend or unsuspend the auth named in the request, on behalf of both the suspend and unsuspend handlers
+// Suspending an auth also revokes every session and access token, such that it is logged out immediately
+func (env *env) updateSuspended(w http.ResponseWriter, r *http.Request, suspended bool, requestType string) {
+	auth, err := extractAuth(env, r.Header, requestType)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, requestType)
+		return
+	}
+
+	authID, err := util.ExtractIDFromRequest(mux.Vars(r))
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest, requestType)
+		return
+	}
+
+	if !adminPolicy(auth, authID) {
+		respondWithError(w, "Not authorized to make request", http.StatusForbidden, requestType)
+		return
+	}
+
+	// Admins cannot suspend themselves, such that there is always an admin able to unsuspend them
+	if auth.ID == authID {
+		respondWithError(w, "Cannot suspend your own account", http.StatusForbidden, requestType)
+		return
+	}
+
+	beforeHooks, afterHooks, action := env.hook.beforeUnsuspendAccountHooks, env.hook.afterUnsuspendAccountHooks, adminActionUnsuspend
+	if suspended {
+		beforeHooks, afterHooks, action = env.hook.beforeSuspendAccountHooks, env.hook.afterSuspendAccountHooks, adminActionSuspend
+	}
+
+	adminAction, err := newAdminAction(auth, authID, action, "")
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, requestType)
+		return
+	}
+
+	input := dao.UpdateAuthSuspendedInput{
+		ID:          authID,
+		Suspended:   suspended,
+		AdminAction: *adminAction,
+	}
+
+	for _, hook := range beforeHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, requestType)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	updated, err := env.dao.UpdateAuthSuspended(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, err.Error(), http.StatusNotFound, requestType)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, requestType)
+		}
+		return
+	}
+
+	for _, hook := range afterHooks {
+		err := (*hook)(env, updated)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, requestType)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(readAccountResponse{
+		ID:            updated.ID,
+		Email:         updated.Email,
+		EmailVerified: updated.EmailVerified,
+		Role:          updated.Role,
+		Suspended:     updated.Suspended,
+	})
+	metric.RequestSuccess.WithLabelValues(requestType).Inc()
+}
+
+func (env *env) forceLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestForceLogout)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestForceLogout)
+		return
+	}
+
+	authID, err := util.ExtractIDFromRequest(mux.Vars(r))
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest, metric.RequestForceLogout)
+		return
+	}
+
+	if !adminPolicy(auth, authID) {
+		respondWithError(w, "Not authorized to make request", http.StatusForbidden, metric.RequestForceLogout)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestForceLogout))
+	target, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: authID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, err.Error(), http.StatusNotFound, metric.RequestForceLogout)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestForceLogout)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.beforeForceLogoutHooks {
+		err := (*hook)(env, target)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestForceLogout)
+			return
+		}
+	}
+
+	adminAction, err := newAdminAction(auth, target.ID, adminActionForceLogout, "")
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestForceLogout)
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestForceLogout))
+	_, err = env.dao.InvalidateAuthCredentials(dao.InvalidateAuthCredentialsInput{
+		AuthID:        target.ID,
+		RevokedBefore: adminAction.CreatedAt,
+		AdminAction:   adminAction,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, err.Error(), http.StatusNotFound, metric.RequestForceLogout)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestForceLogout)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.afterForceLogoutHooks {
+		err := (*hook)(env, target)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestForceLogout)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestForceLogout).Inc()
+}
+
+func (env *env) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestForcePasswordReset)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestForcePasswordReset)
+		return
+	}
+
+	authID, err := util.ExtractIDFromRequest(mux.Vars(r))
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest, metric.RequestForcePasswordReset)
+		return
+	}
+
+	if !adminPolicy(auth, authID) {
+		respondWithError(w, "Not authorized to make request", http.StatusForbidden, metric.RequestForcePasswordReset)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestForcePasswordReset))
+	target, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: authID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, err.Error(), http.StatusNotFound, metric.RequestForcePasswordReset)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestForcePasswordReset)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.beforeForcePasswordResetHooks {
+		err := (*hook)(env, target)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestForcePasswordReset)
+			return
+		}
+	}
+
+	// Replace the password with one nobody knows, such that the auth can only regain access through the emailed link
+	unusablePassword, err := util.GenerateToken()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not generate password: %s", err.Error()), http.StatusInternalServerError, metric.RequestForcePasswordReset)
+		return
+	}
+
+	hashedPassword, err := env.passwords.Hash(unusablePassword)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not hash password: %s", err.Error()), http.StatusInternalServerError, metric.RequestForcePasswordReset)
+		return
+	}
+
+	adminAction, err := newAdminAction(auth, target.ID, adminActionForcePasswordReset, "")
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestForcePasswordReset)
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestForcePasswordReset))
+	updated, err := env.dao.UpdateAuthPassword(dao.UpdateAuthPasswordInput{
+		ID:            target.ID,
+		Password:      hashedPassword,
+		RevokedBefore: adminAction.CreatedAt,
+		AdminAction:   adminAction,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, err.Error(), http.StatusNotFound, metric.RequestForcePasswordReset)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestForcePasswordReset)
+		}
+		return
+	}
+
+	token, err := createPasswordReset(env, updated.ID, metric.RequestForcePasswordReset)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create password reset token: %s", err.Error()), http.StatusInternalServerError, metric.RequestForcePasswordReset)
+		return
+	}
+
+	// Unlike a forgotten password, the admin is told if the email cannot be sent, such that they can retry
+	err = env.mailer.SendMail(updated.Email, "Reset your password", passwordResetBody(env.config.PasswordResetURL, token))
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not send password reset email: %s", err.Error()), http.StatusInternalServerError, metric.RequestForcePasswordReset)
+		return
+	}
+
+	for _, hook := range env.hook.afterForcePasswordResetHooks {
+		err := (*hook)(env, updated)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestForcePasswordReset)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestForcePasswordReset).Inc()
+}
+
+func (env *env) listAdminActionsHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestListAdminActions)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestListAdminActions)
+		return
+	}
+
+	if !adminPolicy(auth, uuid.Nil) {
+		respondWithError(w, "Not authorized to make request", http.StatusForbidden, metric.RequestListAdminActions)
+		return
+	}
+
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestListAdminActions)
+		return
+	}
+
+	// Request one more than the limit, to find whether there is another page
+	input := dao.ListAdminActionInput{
+		Limit:  limit + 1,
+		Offset: offset,
+	}
+
+	if len(r.URL.Query().Get("account")) > 0 {
+		authID, err := uuid.Parse(r.URL.Query().Get("account"))
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestListAdminActions)
+			return
+		}
+		input.AuthID = &authID
+	}
+
+	for _, hook := range env.hook.beforeListAdminActionsHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestListAdminActions)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestListAdminActions))
+	adminActionList, err := env.dao.ListAdminAction(input)
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestListAdminActions)
+		return
+	}
+
+	for _, hook := range env.hook.afterListAdminActionsHooks {
+		err := (*hook)(env, adminActionList)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestListAdminActions)
+			return
+		}
+	}
+
+	listAdminActionsResp := listAdminActionsResponse{
+		Actions:    make([]readAdminActionResponse, 0),
+		NextOffset: nextOffset(len(*adminActionList), limit, offset),
+	}
+	for i, adminAction := range *adminActionList {
+		if i == limit {
+			break
+		}
+		listAdminActionsResp.Actions = append(listAdminActionsResp.Actions, readAdminActionResponse{
+			ID:        adminAction.ID,
+			AdminID:   adminAction.AdminID,
+			AuthID:    adminAction.AuthID,
+			Action:    adminAction.Action,
+			Detail:    adminAction.Detail,
+			CreatedAt: adminAction.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	json.NewEncoder(w).Encode(listAdminActionsResp)
+	metric.RequestSuccess.WithLabelValues(metric.RequestListAdminActions).Inc()
+}
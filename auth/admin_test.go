@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/util"
+)
+
+// decodeBody decodes the JSON body of a response into the given value
+func decodeBody(t *testing.T, body string, v interface{}) {
+	err := json.Unmarshal([]byte(body), v)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+}
+
+// Test that admins can list accounts a page at a time, searching by email
+func TestListAccountsPaginatesAndSearches(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+	registerAuth(t, mockEnv, "jay@test.com")
+	registerAuth(t, mockEnv, "lewis@test.com")
+	registerAuth(t, mockEnv, "jay.two@example.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/admin/accounts?limit=3", "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var page listAccountsResponse
+	decodeBody(t, res.Body.String(), &page)
+	if len(page.Accounts) != 3 || page.Accounts[0].Email != "admin@test.com" || page.NextOffset == nil || *page.NextOffset != 3 {
+		t.Fatalf("Wrong first page: %+v", page)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/admin/accounts?limit=3&offset=3", "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	page = listAccountsResponse{}
+	decodeBody(t, res.Body.String(), &page)
+	if len(page.Accounts) != 1 || page.Accounts[0].Email != "lewis@test.com" || page.NextOffset != nil {
+		t.Fatalf("Wrong last page: %+v", page)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/admin/accounts?email=JAY", "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	page = listAccountsResponse{}
+	decodeBody(t, res.Body.String(), &page)
+	if len(page.Accounts) != 2 || page.Accounts[0].Email != "jay.two@example.com" || page.Accounts[1].Email != "jay@test.com" {
+		t.Fatalf("Wrong search results: %+v", page)
+	}
+}
+
+// Test that listing accounts fails for an invalid page
+func TestListAccountsFailsOnInvalidPage(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=ten", "offset=-1"} {
+		res, err := makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/admin/accounts?"+query, "", accessToken)
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("Wrong status code for %s: %v", query, res.Code)
+		}
+	}
+}
+
+// Test that a suspended account cannot login or refresh until it is unsuspended, and that both actions are audited
+func TestSuspendAccountBlocksLogin(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	target := mockEnv.dao.(*mockDAO).authList[1]
+
+	// Tokens issued within the same millisecond as the cutoff remain valid
+	time.Sleep(2 * util.TokenTimePrecision)
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/suspend", target.ID), "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var account readAccountResponse
+	decodeBody(t, res.Body.String(), &account)
+	if account.ID != target.ID || !account.Suspended {
+		t.Fatalf("Account was not suspended: %+v", account)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Access token of a suspended account was accepted: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The wrong password is still reported as such, without revealing the suspension
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "WrongPassword123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"refreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code == http.StatusOK {
+		t.Fatalf("Refresh token of a suspended account was accepted")
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/unsuspend", target.ID), "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, fmt.Sprintf("/auth/admin/actions?account=%s", target.ID), "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var actions listAdminActionsResponse
+	decodeBody(t, res.Body.String(), &actions)
+	if len(actions.Actions) != 2 || actions.Actions[0].Action != adminActionUnsuspend || actions.Actions[1].Action != adminActionSuspend {
+		t.Fatalf("Wrong audit log: %+v", actions)
+	}
+
+	admin := mockEnv.dao.(*mockDAO).authList[0]
+	if actions.Actions[0].AdminID != admin.ID || actions.Actions[0].AuthID != target.ID {
+		t.Fatalf("Wrong audit record: %+v", actions.Actions[0])
+	}
+}
+
+// Test that an admin cannot suspend their own account
+func TestSuspendAccountFailsForSelf(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+	admin := mockEnv.dao.(*mockDAO).authList[0]
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/suspend", admin.ID), "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a forced logout revokes every access and refresh token of the account, publishing the cutoff in the
+// revocation feed
+func TestForceLogoutRevokesTokens(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	target := mockEnv.dao.(*mockDAO).authList[1]
+
+	// Tokens issued within the same millisecond as the cutoff remain valid
+	time.Sleep(2 * util.TokenTimePrecision)
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/logout", target.ID), "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"refreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/revoked", "", issueServiceToken(t, mockEnv))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	var revoked listRevokedResponse
+	decodeBody(t, res.Body.String(), &revoked)
+	if len(revoked.RevokedAuths) != 1 || revoked.RevokedAuths[0].AuthID != target.ID {
+		t.Fatalf("Revocation feed is incorrect: %+v", revoked.RevokedAuths)
+	}
+
+	if len(mockEnv.dao.(*mockDAO).adminActionList) != 1 || mockEnv.dao.(*mockDAO).adminActionList[0].Action != adminActionForceLogout {
+		t.Fatalf("Forced logout was not audited: %+v", mockEnv.dao.(*mockDAO).adminActionList)
+	}
+}
+
+// Test that a forced password reset stops the old password working, and emails a link to set a new one
+func TestForcePasswordResetReplacesPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+	registerAuth(t, mockEnv, "jay@test.com")
+	target := mockEnv.dao.(*mockDAO).authList[1]
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/password/reset", target.ID), "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	token := emailedToken(t, mockEnv, "jay@test.com")
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "RaspberryRipple456"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "RaspberryRipple456"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that every admin endpoint is forbidden to auths without the admin role
+func TestAdminEndpointsFailForNonAdmin(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	registerAuth(t, mockEnv, "lewis@test.com")
+	target := mockEnv.dao.(*mockDAO).authList[1]
+
+	for _, endpoint := range []struct {
+		method string
+		url    string
+	}{
+		{http.MethodGet, "/auth/admin/accounts"},
+		{http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/suspend", target.ID)},
+		{http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/unsuspend", target.ID)},
+		{http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/logout", target.ID)},
+		{http.MethodPost, fmt.Sprintf("/auth/admin/accounts/%s/password/reset", target.ID)},
+		{http.MethodGet, "/auth/admin/actions"},
+	} {
+		res, err := makeAuthenticatedRequest(mockEnv, endpoint.method, endpoint.url, "", tokens["AccessToken"])
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusForbidden {
+			t.Fatalf("Wrong status code for %s %s: %v", endpoint.method, endpoint.url, res.Code)
+		}
+	}
+
+	if mockEnv.dao.(*mockDAO).authList[1].Suspended {
+		t.Fatalf("Account was suspended")
+	}
+}
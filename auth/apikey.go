@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// apiKeyPrefix begins every API key, such that leaked keys are easy to recognise
+const apiKeyPrefix = "sg_"
+
+// apiKeyDisplayLength is the number of characters of an API key, including its prefix, that are stored and shown to
+// the auth, such that they can tell their keys apart
+const apiKeyDisplayLength = len(apiKeyPrefix) + 6
+
+// maxAPIKeysPerAuth is the most API keys an auth may hold at once
+const maxAPIKeysPerAuth = 25
+
+// createAPIKeyRequest contains the client-provided name and scopes of a new API key
+type createAPIKeyRequest struct {
+	Name   string   `valid:"type(string),required,stringlength(1|64)"`
+	Scopes []string `valid:"required"`
+}
+
+// introspectAPIKeyRequest contains an API key presented to another service
+type introspectAPIKeyRequest struct {
+	Key string `valid:"type(string),required"`
+}
+
+// readAPIKeyResponse contains a single API key to be returned to the auth it belongs to, excluding the key itself
+type readAPIKeyResponse struct {
+	ID        uuid.UUID
+	Name      string
+	Prefix    string
+	Scopes    []string
+	CreatedAt string
+}
+
+// createAPIKeyResponse contains a newly created API key, which is only ever returned here
+type createAPIKeyResponse struct {
+	readAPIKeyResponse
+	Key string
+}
+
+// listAPIKeysResponse contains every API key belonging to an auth
+type listAPIKeysResponse struct {
+	APIKeys []readAPIKeyResponse
+}
+
+// introspectAPIKeyResponse contains the auth an API key acts on behalf of, and the scopes it is limited to
+// As with access tokens, EmailVerified is only false if restricted access is given before email verification
+type introspectAPIKeyResponse struct {
+	ID            uuid.UUID
+	AuthID        uuid.UUID
+	Prefix        string
+	Role          string
+	EmailVerified bool
+	Scopes        []string
+}
+
+func (env *env) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestCreateAPIKey)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestCreateAPIKey)
+		return
+	}
+
+	var req createAPIKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestCreateAPIKey)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestCreateAPIKey)
+		return
+	}
+
+	scopes, err := normaliseScopes(req.Scopes)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestCreateAPIKey)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestCreateAPIKey))
+	apiKeyList, err := env.dao.ListAPIKey(dao.ListAPIKeyInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestCreateAPIKey)
+		return
+	}
+
+	if len(*apiKeyList) >= maxAPIKeysPerAuth {
+		respondWithError(w, fmt.Sprintf("Cannot hold more than %d API keys, revoke one first", maxAPIKeysPerAuth), http.StatusForbidden, metric.RequestCreateAPIKey)
+		return
+	}
+
+	token, err := util.GenerateToken()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not generate API key: %s", err.Error()), http.StatusInternalServerError, metric.RequestCreateAPIKey)
+		return
+	}
+	key := apiKeyPrefix + token
+
+	uuid, err := uuid.NewUUID()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create UUID: %s", err.Error()), http.StatusInternalServerError, metric.RequestCreateAPIKey)
+		return
+	}
+
+	input := dao.CreateAPIKeyInput{
+		ID:        uuid,
+		AuthID:    auth.ID,
+		Name:      req.Name,
+		Prefix:    key[:apiKeyDisplayLength],
+		KeyHash:   util.HashToken(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	for _, hook := range env.hook.beforeCreateAPIKeyHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestCreateAPIKey)
+			return
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestCreateAPIKey))
+	apiKey, err := env.dao.CreateAPIKey(input)
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestCreateAPIKey)
+		return
+	}
+
+	for _, hook := range env.hook.afterCreateAPIKeyHooks {
+		err := (*hook)(env, apiKey)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestCreateAPIKey)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(createAPIKeyResponse{
+		readAPIKeyResponse: makeReadAPIKeyResponse(apiKey),
+		Key:                key,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestCreateAPIKey).Inc()
+}
+
+func (env *env) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestListAPIKeys)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestListAPIKeys)
+		return
+	}
+
+	input := dao.ListAPIKeyInput{
+		AuthID: auth.ID,
+	}
+
+	for _, hook := range env.hook.beforeListAPIKeysHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestListAPIKeys)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestListAPIKeys))
+	apiKeyList, err := env.dao.ListAPIKey(input)
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestListAPIKeys)
+		return
+	}
+
+	for _, hook := range env.hook.afterListAPIKeysHooks {
+		err := (*hook)(env, apiKeyList)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestListAPIKeys)
+			return
+		}
+	}
+
+	listAPIKeysResp := listAPIKeysResponse{
+		APIKeys: make([]readAPIKeyResponse, 0),
+	}
+	for i := range *apiKeyList {
+		listAPIKeysResp.APIKeys = append(listAPIKeysResp.APIKeys, makeReadAPIKeyResponse(&(*apiKeyList)[i]))
+	}
+
+	json.NewEncoder(w).Encode(listAPIKeysResp)
+	metric.RequestSuccess.WithLabelValues(metric.RequestListAPIKeys).Inc()
+}
+
+func (env *env) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestRevokeAPIKey)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestRevokeAPIKey)
+		return
+	}
+
+	apiKeyID, err := util.ExtractIDFromRequest(mux.Vars(r))
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest, metric.RequestRevokeAPIKey)
+		return
+	}
+
+	input := dao.DeleteAPIKeyInput{
+		ID:     apiKeyID,
+		AuthID: auth.ID,
+	}
+
+	for _, hook := range env.hook.beforeRevokeAPIKeyHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRevokeAPIKey)
+			return
+		}
+	}
+
+	// Keys belonging to other auths are reported as not found, such that their IDs are not revealed
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRevokeAPIKey))
+	err = env.dao.DeleteAPIKey(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAPIKeyNotFound:
+			respondWithError(w, err.Error(), http.StatusNotFound, metric.RequestRevokeAPIKey)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRevokeAPIKey)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.afterRevokeAPIKeyHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRevokeAPIKey)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestRevokeAPIKey).Inc()
+}
+
+// introspectAPIKeyHandler resolves an API key presented to another service to the auth it acts on behalf of
+// As with the revoked token list, this is called by other services, which cache the result for a short time
+func (env *env) introspectAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := extractService(env, r.Header, metric.RequestIntrospectAPIKey)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestIntrospectAPIKey)
+		return
+	}
+
+	var req introspectAPIKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestIntrospectAPIKey)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestIntrospectAPIKey)
+		return
+	}
+
+	input := dao.ReadAPIKeyInput{
+		KeyHash: util.HashToken(req.Key),
+	}
+
+	for _, hook := range env.hook.beforeIntrospectAPIKeyHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestIntrospectAPIKey)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestIntrospectAPIKey))
+	apiKey, err := env.dao.ReadAPIKey(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAPIKeyNotFound:
+			respondWithError(w, "Invalid API key", http.StatusNotFound, metric.RequestIntrospectAPIKey)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestIntrospectAPIKey)
+		}
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestIntrospectAPIKey))
+	auth, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: apiKey.AuthID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, "Invalid API key", http.StatusNotFound, metric.RequestIntrospectAPIKey)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestIntrospectAPIKey)
+		}
+		return
+	}
+
+	// The keys of suspended auths are kept, but cannot be used until the auth is unsuspended
+	if auth.Suspended {
+		respondWithError(w, "Account has been suspended", http.StatusForbidden, metric.RequestIntrospectAPIKey)
+		return
+	}
+
+	for _, hook := range env.hook.afterIntrospectAPIKeyHooks {
+		err := (*hook)(env, apiKey, auth)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestIntrospectAPIKey)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(introspectAPIKeyResponse{
+		ID:            apiKey.ID,
+		AuthID:        auth.ID,
+		Prefix:        apiKey.Prefix,
+		Role:          auth.Role,
+		EmailVerified: env.config.EmailVerification.Mode != util.EmailVerificationRestricted || auth.EmailVerified,
+		Scopes:        apiKey.Scopes,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestIntrospectAPIKey).Inc()
+}
+
+// Check every requested scope is valid, returning them without duplicates
+func normaliseScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, errors.New("Scopes: at least one scope is required")
+	}
+
+	scopes := make([]string, 0, len(requested))
+	seen := make(map[string]bool)
+	for _, scope := range requested {
+		if !util.ValidScope(scope) {
+			return nil, fmt.Errorf("Scopes: %s is not a valid scope", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// Construct the response for a single API key, excluding the key itself
+func makeReadAPIKeyResponse(apiKey *dao.APIKey) readAPIKeyResponse {
+	return readAPIKeyResponse{
+		ID:        apiKey.ID,
+		Name:      apiKey.Name,
+		Prefix:    apiKey.Prefix,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: apiKey.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// createAPIKey creates an API key with the given scopes for the auth the access token belongs to, returning the
+// decoded response
+func createAPIKey(t *testing.T, env env, accessToken string, scopes string) map[string]interface{} {
+	res, err := makeAuthenticatedRequest(env, http.MethodPost, "/auth/api-keys", fmt.Sprintf(`{"name": "Partner", "scopes": %s}`, scopes), accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v %s", res.Code, res.Body.String())
+	}
+
+	var decoded map[string]interface{}
+	decodeBody(t, res.Body.String(), &decoded)
+	return decoded
+}
+
+// introspectAPIKey resolves an API key as another service would, returning the response
+func introspectAPIKey(t *testing.T, env env, key string) (int, map[string]interface{}) {
+	res, err := makeAuthenticatedRequest(env, http.MethodPost, "/auth/api-keys/introspect", fmt.Sprintf(`{"key": "%s"}`, key), issueServiceToken(t, env))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	var decoded map[string]interface{}
+	decodeBody(t, res.Body.String(), &decoded)
+	return res.Code, decoded
+}
+
+// Test that an API key can be created, listed without revealing it, resolved to its auth and revoked
+func TestAPIKeyLifecycleSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	created := createAPIKey(t, mockEnv, tokens["AccessToken"], `["user:read", "match:write", "user:read"]`)
+	key, _ := created["Key"].(string)
+	if !strings.HasPrefix(key, "sg_") || created["Prefix"] != key[:apiKeyDisplayLength] {
+		t.Fatalf("Wrong key created: %v", created)
+	}
+
+	if mockEnv.dao.(*mockDAO).apiKeyList[0].KeyHash == key {
+		t.Fatalf("API key was not stored as a hash")
+	}
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/api-keys", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK || strings.Contains(res.Body.String(), key) || !strings.Contains(res.Body.String(), created["Prefix"].(string)) {
+		t.Fatalf("Wrong listing: %v %s", res.Code, res.Body.String())
+	}
+
+	code, introspected := introspectAPIKey(t, mockEnv, key)
+	if code != http.StatusOK || introspected["AuthID"] != mockEnv.dao.(*mockDAO).authList[0].ID.String() || introspected["Role"] != "user" {
+		t.Fatalf("Wrong introspection: %v %v", code, introspected)
+	}
+
+	if scopes := introspected["Scopes"].([]interface{}); len(scopes) != 2 || scopes[0] != "user:read" || scopes[1] != "match:write" {
+		t.Fatalf("Wrong scopes: %v", scopes)
+	}
+
+	for _, code := range []int{http.StatusOK, http.StatusNotFound} {
+		res, err = makeAuthenticatedRequest(mockEnv, http.MethodDelete, fmt.Sprintf("/auth/api-keys/%s", created["ID"]), "", tokens["AccessToken"])
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != code {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	if code, _ := introspectAPIKey(t, mockEnv, key); code != http.StatusNotFound {
+		t.Fatalf("Revoked key was resolved: %v", code)
+	}
+}
+
+// Test that an API key cannot be resolved without a service token, even by the auth it acts on behalf of
+func TestIntrospectAPIKeyFailsWithoutServiceToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	created := createAPIKey(t, mockEnv, tokens["AccessToken"], `["user:read"]`)
+
+	for _, accessToken := range []string{"", tokens["AccessToken"]} {
+		res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/api-keys/introspect", fmt.Sprintf(`{"key": "%s"}`, created["Key"]), accessToken)
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+}
+
+// Test that an API key must be limited to at least one valid scope
+func TestCreateAPIKeyFailsOnInvalidScopes(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	for _, scopes := range []string{`[]`, `["admin"]`, `["user:read", "user:delete"]`} {
+		res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/api-keys", fmt.Sprintf(`{"name": "Partner", "scopes": %s}`, scopes), tokens["AccessToken"])
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("Wrong status code for %s: %v", scopes, res.Code)
+		}
+	}
+
+	if len(mockEnv.dao.(*mockDAO).apiKeyList) != 0 {
+		t.Fatalf("API key was created")
+	}
+}
+
+// Test that an auth cannot revoke another auth's API key
+func TestRevokeAPIKeyFailsForAnotherAuth(t *testing.T) {
+	mockEnv := makeMockEnv()
+	owner := registerAuth(t, mockEnv, "jay@test.com")
+	other := registerAuth(t, mockEnv, "lewis@test.com")
+	created := createAPIKey(t, mockEnv, owner["AccessToken"], `["user:read"]`)
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodDelete, fmt.Sprintf("/auth/api-keys/%s", created["ID"]), "", other["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if code, _ := introspectAPIKey(t, mockEnv, created["Key"].(string)); code != http.StatusOK {
+		t.Fatalf("API key was revoked: %v", code)
+	}
+}
+
+// Test that the API keys of a suspended auth cannot be used until they are unsuspended
+func TestIntrospectAPIKeyFailsOnSuspendedAuth(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	created := createAPIKey(t, mockEnv, tokens["AccessToken"], `["user:read"]`)
+
+	mockEnv.dao.(*mockDAO).authList[0].Suspended = true
+	if code, _ := introspectAPIKey(t, mockEnv, created["Key"].(string)); code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", code)
+	}
+
+	mockEnv.dao.(*mockDAO).authList[0].Suspended = false
+	if code, _ := introspectAPIKey(t, mockEnv, created["Key"].(string)); code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", code)
+	}
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
 	"github.com/TempleEight/spec-golang/auth/comm"
 	"github.com/TempleEight/spec-golang/auth/dao"
 	"github.com/TempleEight/spec-golang/auth/metric"
@@ -23,12 +25,21 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// accessTokenLifetime is how long an access token is valid for, and so how long a signing key verifies tokens after rotation
+const accessTokenLifetime = 24 * time.Hour
+
 // env defines the environment that requests should be executed within
 type env struct {
-	dao           dao.Datastore
-	comm          comm.Comm
-	jwtCredential *comm.JWTCredential
-	hook          Hook
+	dao            dao.Datastore
+	loginAttempts  dao.LoginAttemptStore
+	comm           comm.Comm
+	mailer         comm.Mailer
+	oidcProviders  map[string]comm.OIDCProvider
+	passwords      *util.Passwords
+	passwordPolicy *util.PasswordPolicy
+	keyRing        *keyRing
+	config         *util.Config
+	hook           Hook
 }
 
 // registerAuthRequest contains the client-provided information required to create a single auth
@@ -43,14 +54,23 @@ type loginAuthRequest struct {
 	Password string `valid:"type(string),required,stringlength(8|64)"`
 }
 
-// registerAuthResponse contains an access token
+// registerAuthResponse contains an access token and a refresh token
+// Both are omitted if email verification is required before logging in
 type registerAuthResponse struct {
-	AccessToken string
+	AccessToken  string `json:",omitempty"`
+	RefreshToken string `json:",omitempty"`
 }
 
-// loginAuthResponse contains an access token
+// loginAuthResponse contains an access token and a refresh token
 type loginAuthResponse struct {
-	AccessToken string
+	AccessToken  string
+	RefreshToken string
+}
+
+// twoFactorChallengeResponse contains a challenge token, returned by login in place of any tokens if two-factor
+// authentication is enabled
+type twoFactorChallengeResponse struct {
+	ChallengeToken string
 }
 
 // defaultRouter generates a router for this service
@@ -58,6 +78,39 @@ func defaultRouter(env *env) *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/auth/register", env.registerAuthHandler).Methods(http.MethodPost)
 	r.HandleFunc("/auth/login", env.loginAuthHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/refresh", env.refreshAuthHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/logout", env.logoutAuthHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/revoked", env.listRevokedHandler).Methods(http.MethodGet)
+	r.HandleFunc("/auth/password/forgot", env.forgotPasswordHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/password/reset", env.resetPasswordHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/verify", env.verifyEmailHandler).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/auth/verify/resend", env.resendVerificationHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/password", env.changePasswordHandler).Methods(http.MethodPut)
+	r.HandleFunc("/auth/email", env.changeEmailHandler).Methods(http.MethodPut)
+	r.HandleFunc("/auth/account", env.deleteAccountHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/auth/token", env.serviceTokenHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/magic-link", env.magicLinkHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/magic-link/redeem", env.redeemMagicLinkHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/api-keys", env.createAPIKeyHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/api-keys", env.listAPIKeysHandler).Methods(http.MethodGet)
+	r.HandleFunc("/auth/api-keys/introspect", env.introspectAPIKeyHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/api-keys/{id}", env.revokeAPIKeyHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/auth/sessions", env.listSessionsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/auth/sessions/{id}", env.revokeSessionHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/auth/oidc/{provider}/start", env.oidcStartHandler).Methods(http.MethodGet)
+	r.HandleFunc("/auth/oidc/{provider}/callback", env.oidcCallbackHandler).Methods(http.MethodGet)
+	r.HandleFunc("/auth/2fa/setup", env.setupTwoFactorHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/2fa/confirm", env.confirmTwoFactorHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/2fa/disable", env.disableTwoFactorHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/2fa/verify", env.verifyTwoFactorHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/admin/accounts", env.listAccountsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/auth/admin/accounts/{id}/role", env.updateRoleHandler).Methods(http.MethodPut)
+	r.HandleFunc("/auth/admin/accounts/{id}/suspend", env.suspendAccountHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/admin/accounts/{id}/unsuspend", env.unsuspendAccountHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/admin/accounts/{id}/logout", env.forceLogoutHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/admin/accounts/{id}/password/reset", env.forcePasswordResetHandler).Methods(http.MethodPost)
+	r.HandleFunc("/auth/admin/actions", env.listAdminActionsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/auth/.well-known/jwks.json", env.jwksHandler).Methods(http.MethodGet)
 	r.Use(jsonMiddleware)
 	return r
 }
@@ -69,6 +122,25 @@ func respondWithError(w http.ResponseWriter, err string, statusCode int, request
 	metric.RequestFailure.WithLabelValues(requestType, strconv.Itoa(statusCode)).Inc()
 }
 
+// respondWithFieldErrors responds to a HTTP request with a JSON error response, including the reasons each of the given
+// request fields was refused
+func respondWithFieldErrors(w http.ResponseWriter, err string, fields map[string][]string, statusCode int, requestType string) {
+	w.WriteHeader(statusCode)
+	fmt.Fprintln(w, util.CreateFieldErrorJSON(err, fields))
+	metric.RequestFailure.WithLabelValues(requestType, strconv.Itoa(statusCode)).Inc()
+}
+
+// sendMailInBackground sends an email without waiting for it to be delivered, logging rather than returning any failure,
+// such that the response time doesn't reveal whether an email was sent
+func sendMailInBackground(env *env, to string, subject string, body string) {
+	go func() {
+		err := env.mailer.SendMail(to, subject, body)
+		if err != nil {
+			log.Printf("Could not send email with subject %q: %s", subject, err.Error())
+		}
+	}()
+}
+
 func main() {
 	configPtr := flag.String("config", "/etc/auth-service/config.json", "configuration filepath")
 	flag.Parse()
@@ -81,6 +153,80 @@ func main() {
 		log.Fatal(err)
 	}
 
+	switch config.EmailVerification.Mode {
+	case "":
+		config.EmailVerification.Mode = util.EmailVerificationOptional
+	case util.EmailVerificationOptional, util.EmailVerificationRequired, util.EmailVerificationRestricted:
+		break
+	default:
+		log.Fatalf("Unknown email verification mode %s", config.EmailVerification.Mode)
+	}
+
+	if config.LoginLockout.EmailMaxAttempts <= 0 || config.LoginLockout.IPMaxAttempts <= 0 {
+		log.Fatal("loginLockout maximum attempts must be positive")
+	}
+	if config.LoginLockout.BaseLockoutSeconds <= 0 || config.LoginLockout.MaxLockoutSeconds < config.LoginLockout.BaseLockoutSeconds {
+		log.Fatal("loginLockout lockout durations must be positive, with the maximum no less than the base")
+	}
+
+	switch config.Signing.Mode {
+	case "":
+		config.Signing.Mode = util.SigningModeKong
+	case util.SigningModeKong, util.SigningModeLocal:
+		break
+	default:
+		log.Fatalf("Unknown signing mode %s", config.Signing.Mode)
+	}
+
+	if config.Signing.RotationIntervalSeconds <= 0 || config.Signing.RotationCheckSeconds <= 0 {
+		log.Fatal("signing rotation interval and check must be positive")
+	}
+
+	// Kong is only contacted in Kong mode
+	if config.Signing.Mode == util.SigningModeKong && (config.KongRetry.MaxAttempts <= 0 || config.KongRetry.BaseDelayMilliseconds <= 0 || config.KongRetry.MaxDelayMilliseconds < config.KongRetry.BaseDelayMilliseconds) {
+		log.Fatal("kongRetry attempts and delays must be positive, with the maximum delay no less than the base")
+	}
+
+	if config.TwoFactor.ChallengeLifetimeSeconds <= 0 || config.TwoFactor.MaxChallengeAttempts <= 0 {
+		log.Fatal("twoFactor challenge lifetime and maximum attempts must be positive")
+	}
+
+	err = util.LoadServiceClientSecrets(&config.ServiceClients)
+	if err != nil {
+		log.Fatalf("Could not load serviceClients secrets: %s", err.Error())
+	}
+	if len(config.ServiceClients.Secrets) > 0 && config.ServiceClients.TokenLifetimeSeconds <= 0 {
+		log.Fatal("serviceClients token lifetime must be positive")
+	}
+	for clientID, secretHash := range config.ServiceClients.Secrets {
+		if decoded, err := hex.DecodeString(secretHash); err != nil || len(decoded) != sha256.Size {
+			log.Fatalf("serviceClients secret for %s must be a hex-encoded SHA-256 hash", clientID)
+		}
+	}
+
+	if config.MagicLink.LifetimeSeconds <= 0 {
+		log.Fatal("magicLink lifetime must be positive")
+	}
+
+	if len(config.OIDC.Providers) > 0 && config.OIDC.LoginLifetimeSeconds <= 0 {
+		log.Fatal("oidc login lifetime must be positive")
+	}
+	for name, provider := range config.OIDC.Providers {
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			log.Fatalf("oidc provider %s must have an issuer, client ID and redirect URL", name)
+		}
+	}
+
+	passwords, err := util.NewPasswords(config.PasswordHashing)
+	if err != nil {
+		log.Fatalf("Invalid passwordHashing config: %s", err.Error())
+	}
+
+	passwordPolicy, err := util.NewPasswordPolicy(config.PasswordPolicy)
+	if err != nil {
+		log.Fatalf("Invalid passwordPolicy config: %s", err.Error())
+	}
+
 	// Prometheus metrics
 	promPort, ok := config.Ports["prometheus"]
 	if !ok {
@@ -96,13 +242,38 @@ func main() {
 		log.Fatal(err)
 	}
 
+	var loginAttempts dao.LoginAttemptStore
+	switch config.LoginLockout.Store {
+	case util.LoginLockoutStoreDatastore:
+		loginAttempts = d
+	case util.LoginLockoutStoreMemory:
+		loginAttempts = dao.NewMemoryLoginAttemptStore()
+	default:
+		log.Fatalf("Unknown login lockout store %s", config.LoginLockout.Store)
+	}
+
 	c := comm.Init(config)
-	jwtCredential, err := c.CreateJWTCredential()
+
+	mailer := comm.InitMailer(config)
+	if _, ok := mailer.(*comm.MemoryMailer); ok {
+		log.Print("No mail host was configured, emails will not be sent")
+	}
+
+	env := env{d, loginAttempts, c, mailer, comm.InitOIDCProviders(config), passwords, passwordPolicy, &keyRing{}, config, Hook{}}
+
+	rotating, err := initKeyRing(&env)
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Printf("Signing access tokens with key %s", env.keyRing.activeKey().ID)
+	if rotating {
+		go runKeyRotation(&env, time.Duration(config.Signing.RotationCheckSeconds)*time.Second)
+	}
 
-	env := env{d, c, jwtCredential, Hook{}}
+	if config.AccountDeletionRetrySeconds <= 0 {
+		log.Fatal("accountDeletionRetrySeconds must be positive")
+	}
+	go runAccountDeletions(&env, time.Duration(config.AccountDeletionRetrySeconds)*time.Second)
 
 	// Call into non-generated entry-point
 	router := defaultRouter(&env)
@@ -137,8 +308,19 @@ func (env *env) registerAuthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	reasons, err := env.passwordPolicy.Check(req.Password, req.Email)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not check password: %s", err.Error()), http.StatusInternalServerError, metric.RequestRegister)
+		return
+	}
+
+	if len(reasons) > 0 {
+		respondWithFieldErrors(w, "Password does not meet the password policy", map[string][]string{"Password": reasons}, http.StatusBadRequest, metric.RequestRegister)
+		return
+	}
+
 	// Hash and salt the password before storing
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := env.passwords.Hash(req.Password)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not hash password: %s", err.Error()), http.StatusInternalServerError, metric.RequestRegister)
 		return
@@ -153,7 +335,7 @@ func (env *env) registerAuthHandler(w http.ResponseWriter, r *http.Request) {
 	input := dao.CreateAuthInput{
 		ID:       uuid,
 		Email:    req.Email,
-		Password: string(hashedPassword),
+		Password: hashedPassword,
 	}
 
 	for _, hook := range env.hook.beforeRegisterHooks {
@@ -170,6 +352,7 @@ func (env *env) registerAuthHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		switch err {
 		case dao.ErrDuplicateAuth:
+			recordLoginEvent(env, r, nil, input.Email, loginEventRegister, loginOutcomeDuplicateEmail, metric.RequestRegister)
 			respondWithError(w, err.Error(), http.StatusForbidden, metric.RequestRegister)
 		default:
 			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRegister)
@@ -177,12 +360,29 @@ func (env *env) registerAuthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := createToken(auth.ID, env.jwtCredential.Key, env.jwtCredential.Secret)
+	err = sendVerificationEmail(env, auth, metric.RequestRegister)
 	if err != nil {
-		respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestRegister)
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRegister)
 		return
 	}
 
+	var accessToken, refreshToken string
+	if env.config.EmailVerification.Mode != util.EmailVerificationRequired {
+		accessToken, err = createAccessToken(env, auth)
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestRegister)
+			return
+		}
+
+		refreshToken, err = createRefreshTokenFamily(env, auth.ID, r, metric.RequestRegister)
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Could not create refresh token: %s", err.Error()), http.StatusInternalServerError, metric.RequestRegister)
+			return
+		}
+	}
+
+	recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventRegister, loginOutcomeSuccess, metric.RequestRegister)
+
 	for _, hook := range env.hook.afterRegisterHooks {
 		err := (*hook)(env, auth, accessToken)
 		if err != nil {
@@ -192,7 +392,8 @@ func (env *env) registerAuthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	json.NewEncoder(w).Encode(registerAuthResponse{
-		AccessToken: accessToken,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
 	})
 	metric.RequestSuccess.WithLabelValues(metric.RequestRegister).Inc()
 }
@@ -223,12 +424,42 @@ func (env *env) loginAuthHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	now := time.Now()
+	loginAttemptKeys := loginAttemptKeys(env, input.Email, r)
+	remaining, err := loginLockoutRemaining(env, loginAttemptKeys, now, metric.RequestLogin)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogin)
+		return
+	}
+
+	if remaining > 0 {
+		recordLoginEvent(env, r, nil, input.Email, loginEventLogin, loginOutcomeLockedOut, metric.RequestLogin)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+		respondWithError(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests, metric.RequestLogin)
+		return
+	}
+
 	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestLogin))
 	auth, err := env.dao.ReadAuth(input)
 	timer.ObserveDuration()
+	rehash := false
+	if err == nil {
+		rehash, err = env.passwords.Verify(auth.Password, req.Password)
+	}
 	if err != nil {
 		switch err {
-		case dao.ErrAuthNotFound:
+		case dao.ErrAuthNotFound, util.ErrPasswordMismatch:
+			var authID *uuid.UUID
+			if auth != nil {
+				authID = &auth.ID
+			}
+			recordLoginEvent(env, r, authID, input.Email, loginEventLogin, loginOutcomeInvalidCredentials, metric.RequestLogin)
+
+			err = recordFailedLogin(env, loginAttemptKeys, now, metric.RequestLogin)
+			if err != nil {
+				respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogin)
+				return
+			}
 			respondWithError(w, fmt.Sprintf("Invalid email or password"), http.StatusUnauthorized, metric.RequestLogin)
 		default:
 			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogin)
@@ -236,18 +467,71 @@ func (env *env) loginAuthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(auth.Password), []byte(req.Password))
+	err = resetLoginAttempts(env, loginAttemptKeys, metric.RequestLogin)
 	if err != nil {
-		respondWithError(w, fmt.Sprintf("Invalid email or password"), http.StatusUnauthorized, metric.RequestLogin)
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogin)
 		return
 	}
 
-	accessToken, err := createToken(auth.ID, env.jwtCredential.Key, env.jwtCredential.Secret)
+	// Migrate the password to the configured algorithm and parameters while it is known
+	if rehash {
+		rehashPassword(env, auth, req.Password)
+	}
+
+	// Only reveal the account is suspended once the password has been checked
+	if auth.Suspended {
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeSuspended, metric.RequestLogin)
+		respondWithError(w, "Account has been suspended", http.StatusForbidden, metric.RequestLogin)
+		return
+	}
+
+	if env.config.EmailVerification.Mode == util.EmailVerificationRequired && !auth.EmailVerified {
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeEmailUnverified, metric.RequestLogin)
+		respondWithError(w, "Email address has not been verified", http.StatusForbidden, metric.RequestLogin)
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestLogin))
+	twoFactor, err := env.dao.ReadTwoFactor(dao.ReadTwoFactorInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil && err != dao.ErrTwoFactorNotFound {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogin)
+		return
+	}
+
+	// Issue a challenge in place of any tokens, to be redeemed alongside a second factor
+	if twoFactor != nil && twoFactor.Enabled {
+		challengeToken, err := createTwoFactorChallenge(env, auth.ID, metric.RequestLogin)
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Could not create challenge token: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogin)
+			return
+		}
+
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeTwoFactorRequired, metric.RequestLogin)
+
+		json.NewEncoder(w).Encode(twoFactorChallengeResponse{
+			ChallengeToken: challengeToken,
+		})
+		metric.RequestSuccess.WithLabelValues(metric.RequestLogin).Inc()
+		return
+	}
+
+	accessToken, err := createAccessToken(env, auth)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogin)
 		return
 	}
 
+	refreshToken, err := createRefreshTokenFamily(env, auth.ID, r, metric.RequestLogin)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create refresh token: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogin)
+		return
+	}
+
+	recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeSuccess, metric.RequestLogin)
+
 	for _, hook := range env.hook.afterLoginHooks {
 		err := (*hook)(env, auth, accessToken)
 		if err != nil {
@@ -257,18 +541,150 @@ func (env *env) loginAuthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	json.NewEncoder(w).Encode(loginAuthResponse{
-		AccessToken: accessToken,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
 	})
 	metric.RequestSuccess.WithLabelValues(metric.RequestLogin).Inc()
 }
 
+// Rehash an auth's password with the configured algorithm and parameters, leaving it unchanged if it has been changed
+// since it was read
+// Failures are logged rather than returned, as the existing hash remains valid and is migrated on a later login
+func rehashPassword(env *env, auth *dao.Auth, password string) {
+	hashedPassword, err := env.passwords.Hash(password)
+	if err != nil {
+		log.Printf("Could not rehash password: %s", err.Error())
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestLogin))
+	err = env.dao.RehashAuthPassword(dao.RehashAuthPasswordInput{
+		ID:              auth.ID,
+		CurrentPassword: auth.Password,
+		Password:        hashedPassword,
+	})
+	timer.ObserveDuration()
+	if err != nil && err != dao.ErrAuthNotFound {
+		log.Printf("Could not rehash password: %s", err.Error())
+		return
+	}
+
+	auth.Password = hashedPassword
+}
+
+// Create an access token for an auth, including its role, and marking it as unverified if restricted tokens are issued
+// before email verification
+func createAccessToken(env *env, auth *dao.Auth) (string, error) {
+	claims := jwt.MapClaims{"role": auth.Role}
+	if env.config.EmailVerification.Mode == util.EmailVerificationRestricted && !auth.EmailVerified {
+		claims["email_verified"] = false
+	}
+	return createTokenWithClaims(auth.ID, env.keyRing.activeKey(), claims)
+}
+
 // Create an access token with a 24 hour lifetime
-func createToken(id uuid.UUID, issuer string, secret string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  id.String(),
-		"iss": issuer,
-		"exp": time.Now().Add(24 * time.Hour).Unix(),
+func createToken(id uuid.UUID, signingKey *util.SigningKey) (string, error) {
+	return createTokenWithClaims(id, signingKey, jwt.MapClaims{})
+}
+
+// Create an access token with a 24 hour lifetime, including any additional claims provided
+func createTokenWithClaims(id uuid.UUID, signingKey *util.SigningKey, claims jwt.MapClaims) (string, error) {
+	claims["id"] = id.String()
+	return signToken(signingKey, claims, accessTokenLifetime)
+}
+
+// Sign a token with the given claims and lifetime
+// Each token is given a unique jti, allowing it to be revoked before it expires, and an iat with millisecond precision,
+// allowing every token issued to an auth before a given time to be revoked
+// Both the kid header and the iss claim name the key it was signed by, the latter being how Kong finds the credential
+func signToken(signingKey *util.SigningKey, claims jwt.MapClaims, lifetime time.Duration) (string, error) {
+	jti, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims["jti"] = jti.String()
+	claims["iss"] = signingKey.ID
+	claims["iat"] = util.NumericDate(now)
+	claims["exp"] = now.Add(lifetime).Unix()
+
+	token := jwt.NewWithClaims(signingKey.SigningMethod(), claims)
+	token.Header["kid"] = signingKey.ID
+	return token.SignedString(signingKey.PrivateKey)
+}
+
+// extractAuth extracts and verifies the access token from a request, rejecting service tokens, which do not act on
+// behalf of any auth
+func extractAuth(env *env, headers http.Header, requestType string) (*util.Auth, error) {
+	auth, err := extractToken(env, headers, requestType)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(auth.Service) > 0 {
+		return nil, errors.New("service tokens cannot act on behalf of an auth")
+	}
+
+	return auth, nil
+}
+
+// extractService extracts and verifies the service token from a request, for routes that only other services call
+func extractService(env *env, headers http.Header, requestType string) (*util.Auth, error) {
+	auth, err := extractToken(env, headers, requestType)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(auth.Service) == 0 {
+		return nil, errors.New("only services may make this request")
+	}
+
+	return auth, nil
+}
+
+// extractToken extracts and verifies the access or service token from a request, rejecting tokens that have been revoked
+func extractToken(env *env, headers http.Header, requestType string) (*util.Auth, error) {
+	auth, err := util.ExtractAuthFromRequest(headers, func(token *jwt.Token) (interface{}, error) {
+		return verificationKey(env, token)
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return token.SignedString([]byte(secret))
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	_, err = env.dao.ReadRevokedToken(dao.ReadRevokedTokenInput{
+		JTI: auth.JTI,
+	})
+	timer.ObserveDuration()
+	switch err {
+	case nil:
+		return nil, errors.New("token has been revoked")
+	case dao.ErrRevokedTokenNotFound:
+		break
+	default:
+		return nil, err
+	}
+
+	if len(auth.Service) > 0 {
+		return auth, nil
+	}
+
+	// Every token issued before the auth's credentials were last invalidated is revoked
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	current, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: auth.ID,
+	})
+	timer.ObserveDuration()
+	switch err {
+	case nil:
+		if current.TokensRevokedBefore != nil && auth.IssuedAt.Before(*current.TokensRevokedBefore) {
+			return nil, errors.New("token has been revoked")
+		}
+		return auth, nil
+	case dao.ErrAuthNotFound:
+		return nil, errors.New("auth no longer exists")
+	default:
+		return nil, err
+	}
 }
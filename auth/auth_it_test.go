@@ -29,13 +29,23 @@ func TestMain(m *testing.M) {
 		log.Fatal(err)
 	}
 
-	c := comm.Init(config)
-	jwtCredential, err := c.CreateJWTCredential()
+	passwords, err := util.NewPasswords(config.PasswordHashing)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	passwordPolicy, err := util.NewPasswordPolicy(config.PasswordPolicy)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	environment = env{d, c, jwtCredential, Hook{}}
+	c := comm.Init(config)
+	environment = env{d, d, c, &comm.MemoryMailer{}, comm.InitOIDCProviders(config), passwords, passwordPolicy, &keyRing{}, config, Hook{}}
+
+	_, err = initKeyRing(&environment)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	os.Exit(m.Run())
 }
@@ -88,8 +98,8 @@ func TestIntegrationAuth(t *testing.T) {
 		t.Fatalf("Claims doesn't contain an iss key")
 	}
 
-	if iss.(string) != environment.jwtCredential.Key {
-		t.Fatalf("iss is incorrect: found %v, wanted %s", iss, environment.jwtCredential.Key)
+	if iss.(string) != environment.keyRing.activeKey().ID {
+		t.Fatalf("iss is incorrect: found %v, wanted %s", iss, environment.keyRing.activeKey().ID)
 	}
 
 	// Access that same auth
@@ -138,7 +148,7 @@ func TestIntegrationAuth(t *testing.T) {
 		t.Fatalf("Claims doesn't contain an iss key")
 	}
 
-	if iss.(string) != environment.jwtCredential.Key {
-		t.Fatalf("iss is incorrect: found %v, wanted %s", iss, environment.jwtCredential.Key)
+	if iss.(string) != environment.keyRing.activeKey().ID {
+		t.Fatalf("iss is incorrect: found %v, wanted %s", iss, environment.keyRing.activeKey().ID)
 	}
 }
@@ -3,22 +3,60 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/TempleEight/spec-golang/auth/comm"
 	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/util"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type mockDAO struct {
-	authList []dao.Auth
+	authList               []dao.Auth
+	refreshTokenList       []dao.RefreshToken
+	revokedTokenList       []dao.RevokedToken
+	passwordResetList      []dao.PasswordReset
+	emailVerificationList  []dao.EmailVerification
+	accountDeletionList    []dao.AccountDeletion
+	twoFactorList          []dao.TwoFactor
+	recoveryCodeList       []mockRecoveryCode
+	twoFactorChallengeList []dao.TwoFactorChallenge
+	signingKeyList         []dao.SigningKey
+	adminActionList        []dao.AdminAction
+	oidcLoginList          []dao.OIDCLogin
+	identityList           []dao.Identity
+	magicLinkList          []dao.MagicLink
+	apiKeyList             []dao.APIKey
+	sessionList            []dao.Session
+	loginEventList         []dao.LoginEvent
 }
 
-type mockComm struct{}
+type mockRecoveryCode struct {
+	authID   uuid.UUID
+	codeHash string
+}
+
+type mockComm struct {
+	jwtCredentials   []string
+	deletedUsers     []uuid.UUID
+	deletedMatches   []uuid.UUID
+	userUnavailable  bool
+	matchUnavailable bool
+}
+
+// mockServiceSecret is the client secret of the match service
+const mockServiceSecret = "match-service-secret"
+
+var emailTokenRegexp = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
 
 func (md *mockDAO) CreateAuth(input dao.CreateAuthInput) (*dao.Auth, error) {
 	// Check if auth already exists
@@ -32,6 +70,7 @@ func (md *mockDAO) CreateAuth(input dao.CreateAuthInput) (*dao.Auth, error) {
 		ID:       input.ID,
 		Email:    input.Email,
 		Password: input.Password,
+		Role:     util.RoleUser,
 	}
 	md.authList = append(md.authList, mockAuth)
 	return &mockAuth, nil
@@ -41,22 +80,888 @@ func (md *mockDAO) ReadAuth(input dao.ReadAuthInput) (*dao.Auth, error) {
 	for _, auth := range md.authList {
 		if auth.Email == input.Email {
 			return &dao.Auth{
-				ID:       auth.ID,
-				Email:    auth.Email,
-				Password: auth.Password,
+				ID:                  auth.ID,
+				Email:               auth.Email,
+				Password:            auth.Password,
+				EmailVerified:       auth.EmailVerified,
+				Role:                auth.Role,
+				Suspended:           auth.Suspended,
+				TokensRevokedBefore: auth.TokensRevokedBefore,
 			}, nil
 		}
 	}
 	return nil, dao.ErrAuthNotFound
 }
 
-func (mc *mockComm) CreateJWTCredential() (*comm.JWTCredential, error) {
+func (md *mockDAO) CreateRefreshToken(input dao.CreateRefreshTokenInput) (*dao.RefreshToken, error) {
+	mockRefreshToken := dao.RefreshToken{
+		ID:        input.ID,
+		AuthID:    input.AuthID,
+		FamilyID:  input.FamilyID,
+		TokenHash: input.TokenHash,
+		ExpiresAt: input.ExpiresAt,
+	}
+	md.refreshTokenList = append(md.refreshTokenList, mockRefreshToken)
+	return &mockRefreshToken, nil
+}
+
+func (md *mockDAO) ReadRefreshToken(input dao.ReadRefreshTokenInput) (*dao.RefreshToken, error) {
+	for _, refreshToken := range md.refreshTokenList {
+		if refreshToken.TokenHash == input.TokenHash {
+			return &refreshToken, nil
+		}
+	}
+	return nil, dao.ErrRefreshTokenNotFound
+}
+
+func (md *mockDAO) RevokeRefreshToken(input dao.RevokeRefreshTokenInput) error {
+	for i, refreshToken := range md.refreshTokenList {
+		if refreshToken.ID == input.ID {
+			switch {
+			case refreshToken.Rotated:
+				return dao.ErrRefreshTokenReused
+			case refreshToken.Revoked:
+				return dao.ErrRefreshTokenRevoked
+			}
+			md.refreshTokenList[i].Revoked = true
+			md.refreshTokenList[i].Rotated = true
+			return nil
+		}
+	}
+	return dao.ErrRefreshTokenNotFound
+}
+
+func (md *mockDAO) RevokeRefreshTokenFamily(input dao.RevokeRefreshTokenFamilyInput) error {
+	for i, refreshToken := range md.refreshTokenList {
+		if refreshToken.FamilyID == input.FamilyID {
+			md.refreshTokenList[i].Revoked = true
+		}
+	}
+	return nil
+}
+
+func (md *mockDAO) RevokeAuthRefreshTokens(input dao.RevokeAuthRefreshTokensInput) error {
+	for i, refreshToken := range md.refreshTokenList {
+		if refreshToken.AuthID == input.AuthID {
+			md.refreshTokenList[i].Revoked = true
+		}
+	}
+	return nil
+}
+
+func (md *mockDAO) CreateRevokedToken(input dao.CreateRevokedTokenInput) (*dao.RevokedToken, error) {
+	mockRevokedToken := dao.RevokedToken{
+		JTI:       input.JTI,
+		AuthID:    input.AuthID,
+		ExpiresAt: input.ExpiresAt,
+	}
+	md.revokedTokenList = append(md.revokedTokenList, mockRevokedToken)
+	return &mockRevokedToken, nil
+}
+
+func (md *mockDAO) ReadRevokedToken(input dao.ReadRevokedTokenInput) (*dao.RevokedToken, error) {
+	for _, revokedToken := range md.revokedTokenList {
+		if revokedToken.JTI == input.JTI {
+			return &revokedToken, nil
+		}
+	}
+	return nil, dao.ErrRevokedTokenNotFound
+}
+
+func (md *mockDAO) ListRevokedToken() (*[]dao.RevokedToken, error) {
+	mockRevokedTokenList := make([]dao.RevokedToken, 0)
+	for _, revokedToken := range md.revokedTokenList {
+		if revokedToken.ExpiresAt.After(time.Now()) {
+			mockRevokedTokenList = append(mockRevokedTokenList, revokedToken)
+		}
+	}
+	return &mockRevokedTokenList, nil
+}
+
+func (md *mockDAO) UpdateAuthPassword(input dao.UpdateAuthPasswordInput) (*dao.Auth, error) {
+	for i, auth := range md.authList {
+		if auth.ID == input.ID {
+			md.authList[i].Password = input.Password
+			md.invalidateCredentials(&md.authList[i], input.RevokedBefore, input.AdminAction)
+			return &md.authList[i], nil
+		}
+	}
+	return nil, dao.ErrAuthNotFound
+}
+
+func (md *mockDAO) ListTokenCutoff(input dao.ListTokenCutoffInput) (*[]dao.TokenCutoff, error) {
+	mockTokenCutoffList := make([]dao.TokenCutoff, 0)
+	for _, auth := range md.authList {
+		if auth.TokensRevokedBefore != nil && auth.TokensRevokedBefore.After(input.After) {
+			mockTokenCutoffList = append(mockTokenCutoffList, dao.TokenCutoff{
+				AuthID:        auth.ID,
+				RevokedBefore: *auth.TokensRevokedBefore,
+			})
+		}
+	}
+	for _, accountDeletion := range md.accountDeletionList {
+		if accountDeletion.CreatedAt.After(input.After) {
+			mockTokenCutoffList = append(mockTokenCutoffList, dao.TokenCutoff{
+				AuthID:        accountDeletion.AuthID,
+				RevokedBefore: accountDeletion.CreatedAt,
+			})
+		}
+	}
+	return &mockTokenCutoffList, nil
+}
+
+func (md *mockDAO) InvalidateAuthCredentials(input dao.InvalidateAuthCredentialsInput) (*dao.Auth, error) {
+	for i, auth := range md.authList {
+		if auth.ID == input.AuthID {
+			md.invalidateCredentials(&md.authList[i], input.RevokedBefore, input.AdminAction)
+			return &md.authList[i], nil
+		}
+	}
+	return nil, dao.ErrAuthNotFound
+}
+
+// invalidateCredentials deletes the password resets and magic links of an auth and revokes its tokens, recording the
+// admin action that did so if there is one
+func (md *mockDAO) invalidateCredentials(auth *dao.Auth, revokedBefore time.Time, adminAction *dao.CreateAdminActionInput) {
+	md.revokeTokens(auth, revokedBefore)
+
+	passwordResetList := make([]dao.PasswordReset, 0)
+	for _, passwordReset := range md.passwordResetList {
+		if passwordReset.AuthID != auth.ID {
+			passwordResetList = append(passwordResetList, passwordReset)
+		}
+	}
+	md.passwordResetList = passwordResetList
+
+	magicLinkList := make([]dao.MagicLink, 0)
+	for _, magicLink := range md.magicLinkList {
+		if magicLink.AuthID != auth.ID {
+			magicLinkList = append(magicLinkList, magicLink)
+		}
+	}
+	md.magicLinkList = magicLinkList
+
+	if adminAction != nil {
+		md.createAdminAction(*adminAction)
+	}
+}
+
+// revokeTokens revokes the refresh tokens of an auth, and moves its token cutoff forward
+func (md *mockDAO) revokeTokens(auth *dao.Auth, revokedBefore time.Time) {
+	if auth.TokensRevokedBefore == nil || revokedBefore.After(*auth.TokensRevokedBefore) {
+		auth.TokensRevokedBefore = &revokedBefore
+	}
+
+	md.RevokeAuthRefreshTokens(dao.RevokeAuthRefreshTokensInput{AuthID: auth.ID})
+}
+
+func (md *mockDAO) RehashAuthPassword(input dao.RehashAuthPasswordInput) error {
+	for i, auth := range md.authList {
+		if auth.ID == input.ID && auth.Password == input.CurrentPassword {
+			md.authList[i].Password = input.Password
+			return nil
+		}
+	}
+	return dao.ErrAuthNotFound
+}
+
+func (md *mockDAO) CreatePasswordReset(input dao.CreatePasswordResetInput) (*dao.PasswordReset, error) {
+	mockPasswordReset := dao.PasswordReset{
+		ID:        input.ID,
+		AuthID:    input.AuthID,
+		TokenHash: input.TokenHash,
+		CreatedAt: input.CreatedAt,
+		ExpiresAt: input.ExpiresAt,
+	}
+	md.passwordResetList = append(md.passwordResetList, mockPasswordReset)
+	return &mockPasswordReset, nil
+}
+
+func (md *mockDAO) ReadPasswordReset(input dao.ReadPasswordResetInput) (*dao.PasswordReset, error) {
+	for _, passwordReset := range md.passwordResetList {
+		if passwordReset.TokenHash == input.TokenHash {
+			return &passwordReset, nil
+		}
+	}
+	return nil, dao.ErrPasswordResetNotFound
+}
+
+func (md *mockDAO) ReadLatestPasswordReset(input dao.ReadLatestPasswordResetInput) (*dao.PasswordReset, error) {
+	var latest *dao.PasswordReset
+	for i, passwordReset := range md.passwordResetList {
+		if passwordReset.AuthID == input.AuthID && (latest == nil || passwordReset.CreatedAt.After(latest.CreatedAt)) {
+			latest = &md.passwordResetList[i]
+		}
+	}
+	if latest == nil {
+		return nil, dao.ErrPasswordResetNotFound
+	}
+	return latest, nil
+}
+
+func (md *mockDAO) DeletePasswordReset(input dao.DeletePasswordResetInput) error {
+	for i, passwordReset := range md.passwordResetList {
+		if passwordReset.ID == input.ID {
+			md.passwordResetList = append(md.passwordResetList[:i], md.passwordResetList[i+1:]...)
+			return nil
+		}
+	}
+	return dao.ErrPasswordResetNotFound
+}
+
+func (md *mockDAO) ReadAuthByID(input dao.ReadAuthByIDInput) (*dao.Auth, error) {
+	for _, auth := range md.authList {
+		if auth.ID == input.ID {
+			return &auth, nil
+		}
+	}
+	return nil, dao.ErrAuthNotFound
+}
+
+func (md *mockDAO) VerifyAuthEmail(input dao.VerifyAuthEmailInput) (*dao.Auth, error) {
+	for i, auth := range md.authList {
+		if auth.ID == input.ID {
+			md.authList[i].EmailVerified = true
+			return &md.authList[i], nil
+		}
+	}
+	return nil, dao.ErrAuthNotFound
+}
+
+func (md *mockDAO) CreateEmailVerification(input dao.CreateEmailVerificationInput) (*dao.EmailVerification, error) {
+	mockEmailVerification := dao.EmailVerification{
+		ID:        input.ID,
+		AuthID:    input.AuthID,
+		TokenHash: input.TokenHash,
+		CreatedAt: input.CreatedAt,
+		ExpiresAt: input.ExpiresAt,
+	}
+	md.emailVerificationList = append(md.emailVerificationList, mockEmailVerification)
+	return &mockEmailVerification, nil
+}
+
+func (md *mockDAO) ReadEmailVerification(input dao.ReadEmailVerificationInput) (*dao.EmailVerification, error) {
+	for _, emailVerification := range md.emailVerificationList {
+		if emailVerification.TokenHash == input.TokenHash {
+			return &emailVerification, nil
+		}
+	}
+	return nil, dao.ErrEmailVerificationNotFound
+}
+
+func (md *mockDAO) ReadLatestEmailVerification(input dao.ReadLatestEmailVerificationInput) (*dao.EmailVerification, error) {
+	var latest *dao.EmailVerification
+	for i, emailVerification := range md.emailVerificationList {
+		if emailVerification.AuthID == input.AuthID && (latest == nil || emailVerification.CreatedAt.After(latest.CreatedAt)) {
+			latest = &md.emailVerificationList[i]
+		}
+	}
+	if latest == nil {
+		return nil, dao.ErrEmailVerificationNotFound
+	}
+	return latest, nil
+}
+
+func (md *mockDAO) DeleteAuthEmailVerifications(input dao.DeleteAuthEmailVerificationsInput) error {
+	emailVerificationList := make([]dao.EmailVerification, 0)
+	for _, emailVerification := range md.emailVerificationList {
+		if emailVerification.AuthID != input.AuthID {
+			emailVerificationList = append(emailVerificationList, emailVerification)
+		}
+	}
+	md.emailVerificationList = emailVerificationList
+	return nil
+}
+
+func (md *mockDAO) UpdateAuthEmail(input dao.UpdateAuthEmailInput) (*dao.Auth, error) {
+	for _, auth := range md.authList {
+		if auth.Email == input.Email && auth.ID != input.ID {
+			return nil, dao.ErrDuplicateAuth
+		}
+	}
+
+	for i, auth := range md.authList {
+		if auth.ID == input.ID {
+			md.authList[i].Email = input.Email
+			md.authList[i].EmailVerified = false
+			return &md.authList[i], nil
+		}
+	}
+	return nil, dao.ErrAuthNotFound
+}
+
+func (md *mockDAO) UpdateAuthRole(input dao.UpdateAuthRoleInput) (*dao.Auth, error) {
+	for i, auth := range md.authList {
+		if auth.ID == input.ID {
+			md.authList[i].Role = input.Role
+			md.revokeTokens(&md.authList[i], input.AdminAction.CreatedAt)
+			md.createAdminAction(input.AdminAction)
+			return &md.authList[i], nil
+		}
+	}
+	return nil, dao.ErrAuthNotFound
+}
+
+func (md *mockDAO) CreateAccountDeletion(input dao.CreateAccountDeletionInput) (*dao.AccountDeletion, error) {
+	for i, auth := range md.authList {
+		if auth.ID == input.AuthID {
+			md.authList = append(md.authList[:i], md.authList[i+1:]...)
+			mockAccountDeletion := dao.AccountDeletion{
+				AuthID:    input.AuthID,
+				CreatedAt: input.CreatedAt,
+			}
+			md.accountDeletionList = append(md.accountDeletionList, mockAccountDeletion)
+			return &mockAccountDeletion, nil
+		}
+	}
+	return nil, dao.ErrAuthNotFound
+}
+
+func (md *mockDAO) ListAccountDeletion() (*[]dao.AccountDeletion, error) {
+	mockAccountDeletionList := make([]dao.AccountDeletion, 0)
+	for _, accountDeletion := range md.accountDeletionList {
+		if !accountDeletion.UserDeleted || !accountDeletion.MatchesDeleted {
+			mockAccountDeletionList = append(mockAccountDeletionList, accountDeletion)
+		}
+	}
+	return &mockAccountDeletionList, nil
+}
+
+func (md *mockDAO) UpdateAccountDeletion(input dao.UpdateAccountDeletionInput) (*dao.AccountDeletion, error) {
+	for i, accountDeletion := range md.accountDeletionList {
+		if accountDeletion.AuthID == input.AuthID {
+			md.accountDeletionList[i].UserDeleted = input.UserDeleted
+			md.accountDeletionList[i].MatchesDeleted = input.MatchesDeleted
+			md.accountDeletionList[i].Attempts = input.Attempts
+			md.accountDeletionList[i].LastError = input.LastError
+			return &md.accountDeletionList[i], nil
+		}
+	}
+	return nil, dao.ErrAccountDeletionNotFound
+}
+
+func (md *mockDAO) DeleteCompletedAccountDeletions(input dao.DeleteCompletedAccountDeletionsInput) error {
+	accountDeletionList := make([]dao.AccountDeletion, 0)
+	for _, accountDeletion := range md.accountDeletionList {
+		if !accountDeletion.UserDeleted || !accountDeletion.MatchesDeleted || !accountDeletion.CreatedAt.Before(input.CreatedBefore) {
+			accountDeletionList = append(accountDeletionList, accountDeletion)
+		}
+	}
+	md.accountDeletionList = accountDeletionList
+	return nil
+}
+
+func (md *mockDAO) CreateTwoFactor(input dao.CreateTwoFactorInput) (*dao.TwoFactor, error) {
+	for i, twoFactor := range md.twoFactorList {
+		if twoFactor.AuthID == input.AuthID {
+			if twoFactor.Enabled {
+				return nil, dao.ErrTwoFactorEnabled
+			}
+			md.twoFactorList[i].Secret = input.Secret
+			md.twoFactorList[i].CreatedAt = input.CreatedAt
+			return &md.twoFactorList[i], nil
+		}
+	}
+
+	twoFactor := dao.TwoFactor{
+		AuthID:    input.AuthID,
+		Secret:    input.Secret,
+		CreatedAt: input.CreatedAt,
+	}
+	md.twoFactorList = append(md.twoFactorList, twoFactor)
+	return &twoFactor, nil
+}
+
+func (md *mockDAO) ReadTwoFactor(input dao.ReadTwoFactorInput) (*dao.TwoFactor, error) {
+	for _, twoFactor := range md.twoFactorList {
+		if twoFactor.AuthID == input.AuthID {
+			return &twoFactor, nil
+		}
+	}
+	return nil, dao.ErrTwoFactorNotFound
+}
+
+func (md *mockDAO) EnableTwoFactor(input dao.EnableTwoFactorInput) (*dao.TwoFactor, error) {
+	for i, twoFactor := range md.twoFactorList {
+		if twoFactor.AuthID == input.AuthID && !twoFactor.Enabled {
+			md.twoFactorList[i].Enabled = true
+			md.twoFactorList[i].LastUsedStep = input.Step
+
+			recoveryCodeList := make([]mockRecoveryCode, 0)
+			for _, recoveryCode := range md.recoveryCodeList {
+				if recoveryCode.authID != input.AuthID {
+					recoveryCodeList = append(recoveryCodeList, recoveryCode)
+				}
+			}
+			for _, codeHash := range input.RecoveryCodeHashes {
+				recoveryCodeList = append(recoveryCodeList, mockRecoveryCode{input.AuthID, codeHash})
+			}
+			md.recoveryCodeList = recoveryCodeList
+
+			return &md.twoFactorList[i], nil
+		}
+	}
+	return nil, dao.ErrTwoFactorNotFound
+}
+
+func (md *mockDAO) UseTwoFactorStep(input dao.UseTwoFactorStepInput) error {
+	for i, twoFactor := range md.twoFactorList {
+		if twoFactor.AuthID == input.AuthID && twoFactor.Enabled && twoFactor.LastUsedStep < input.Step {
+			md.twoFactorList[i].LastUsedStep = input.Step
+			return nil
+		}
+	}
+	return dao.ErrTwoFactorStepUsed
+}
+
+func (md *mockDAO) DeleteTwoFactor(input dao.DeleteTwoFactorInput) error {
+	recoveryCodeList := make([]mockRecoveryCode, 0)
+	for _, recoveryCode := range md.recoveryCodeList {
+		if recoveryCode.authID != input.AuthID {
+			recoveryCodeList = append(recoveryCodeList, recoveryCode)
+		}
+	}
+	md.recoveryCodeList = recoveryCodeList
+
+	for i, twoFactor := range md.twoFactorList {
+		if twoFactor.AuthID == input.AuthID {
+			md.twoFactorList = append(md.twoFactorList[:i], md.twoFactorList[i+1:]...)
+			return nil
+		}
+	}
+	return dao.ErrTwoFactorNotFound
+}
+
+func (md *mockDAO) DeleteRecoveryCode(input dao.DeleteRecoveryCodeInput) error {
+	for i, recoveryCode := range md.recoveryCodeList {
+		if recoveryCode.authID == input.AuthID && recoveryCode.codeHash == input.CodeHash {
+			md.recoveryCodeList = append(md.recoveryCodeList[:i], md.recoveryCodeList[i+1:]...)
+			return nil
+		}
+	}
+	return dao.ErrRecoveryCodeNotFound
+}
+
+func (md *mockDAO) CreateTwoFactorChallenge(input dao.CreateTwoFactorChallengeInput) (*dao.TwoFactorChallenge, error) {
+	twoFactorChallenge := dao.TwoFactorChallenge{
+		ID:        input.ID,
+		AuthID:    input.AuthID,
+		TokenHash: input.TokenHash,
+		ExpiresAt: input.ExpiresAt,
+	}
+	md.twoFactorChallengeList = append(md.twoFactorChallengeList, twoFactorChallenge)
+	return &twoFactorChallenge, nil
+}
+
+func (md *mockDAO) ReadTwoFactorChallenge(input dao.ReadTwoFactorChallengeInput) (*dao.TwoFactorChallenge, error) {
+	for _, twoFactorChallenge := range md.twoFactorChallengeList {
+		if twoFactorChallenge.TokenHash == input.TokenHash {
+			return &twoFactorChallenge, nil
+		}
+	}
+	return nil, dao.ErrTwoFactorChallengeNotFound
+}
+
+func (md *mockDAO) RecordTwoFactorChallengeFailure(input dao.RecordTwoFactorChallengeFailureInput) (*dao.TwoFactorChallenge, error) {
+	for i, twoFactorChallenge := range md.twoFactorChallengeList {
+		if twoFactorChallenge.ID == input.ID {
+			md.twoFactorChallengeList[i].Attempts++
+			return &md.twoFactorChallengeList[i], nil
+		}
+	}
+	return nil, dao.ErrTwoFactorChallengeNotFound
+}
+
+func (md *mockDAO) DeleteTwoFactorChallenge(input dao.DeleteTwoFactorChallengeInput) error {
+	for i, twoFactorChallenge := range md.twoFactorChallengeList {
+		if twoFactorChallenge.ID == input.ID {
+			md.twoFactorChallengeList = append(md.twoFactorChallengeList[:i], md.twoFactorChallengeList[i+1:]...)
+			return nil
+		}
+	}
+	return dao.ErrTwoFactorChallengeNotFound
+}
+
+func (md *mockDAO) CreateSigningKey(input dao.CreateSigningKeyInput) (*dao.SigningKey, error) {
+	if input.ReplaceID != "" {
+		replaced := false
+		for i, signingKey := range md.signingKeyList {
+			if signingKey.ID == input.ReplaceID && signingKey.State == dao.SigningKeyActive {
+				md.signingKeyList[i].State = dao.SigningKeyVerification
+				md.signingKeyList[i].UpdatedAt = input.CreatedAt
+				replaced = true
+			}
+		}
+		if !replaced {
+			return nil, dao.ErrSigningKeyRotated
+		}
+	}
+
+	for _, signingKey := range md.signingKeyList {
+		if signingKey.State == dao.SigningKeyActive {
+			return nil, dao.ErrSigningKeyRotated
+		}
+	}
+
+	signingKey := dao.SigningKey{
+		ID:         input.ID,
+		Algorithm:  input.Algorithm,
+		PrivateKey: input.PrivateKey,
+		State:      dao.SigningKeyActive,
+		CreatedAt:  input.CreatedAt,
+		UpdatedAt:  input.CreatedAt,
+	}
+	md.signingKeyList = append(md.signingKeyList, signingKey)
+	return &signingKey, nil
+}
+
+func (md *mockDAO) ListSigningKey() (*[]dao.SigningKey, error) {
+	signingKeyList := make([]dao.SigningKey, 0)
+	for _, signingKey := range md.signingKeyList {
+		if signingKey.State != dao.SigningKeyRetired {
+			signingKeyList = append(signingKeyList, signingKey)
+		}
+	}
+	return &signingKeyList, nil
+}
+
+func (md *mockDAO) RetireSigningKey(input dao.RetireSigningKeyInput) error {
+	for i, signingKey := range md.signingKeyList {
+		if signingKey.ID == input.ID && signingKey.State == dao.SigningKeyVerification {
+			md.signingKeyList[i].State = dao.SigningKeyRetired
+			md.signingKeyList[i].PrivateKey = ""
+			md.signingKeyList[i].UpdatedAt = input.RetiredAt
+			return nil
+		}
+	}
+	return dao.ErrSigningKeyNotFound
+}
+
+func (md *mockDAO) ListAuth(input dao.ListAuthInput) (*[]dao.Auth, error) {
+	matching := make([]dao.Auth, 0)
+	for _, auth := range md.authList {
+		if strings.Contains(strings.ToLower(auth.Email), strings.ToLower(input.Email)) {
+			matching = append(matching, auth)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].Email < matching[j].Email
+	})
+
+	authList := make([]dao.Auth, 0)
+	for i := input.Offset; i < len(matching) && len(authList) < input.Limit; i++ {
+		authList = append(authList, matching[i])
+	}
+	return &authList, nil
+}
+
+func (md *mockDAO) UpdateAuthSuspended(input dao.UpdateAuthSuspendedInput) (*dao.Auth, error) {
+	for i, auth := range md.authList {
+		if auth.ID == input.ID {
+			md.authList[i].Suspended = input.Suspended
+			if input.Suspended {
+				md.invalidateCredentials(&md.authList[i], input.AdminAction.CreatedAt, &input.AdminAction)
+			} else {
+				md.createAdminAction(input.AdminAction)
+			}
+			return &md.authList[i], nil
+		}
+	}
+	return nil, dao.ErrAuthNotFound
+}
+
+// createAdminAction records an admin action, as the datastore does alongside the action itself
+func (md *mockDAO) createAdminAction(input dao.CreateAdminActionInput) {
+	md.adminActionList = append(md.adminActionList, dao.AdminAction{
+		ID:        input.ID,
+		AdminID:   input.AdminID,
+		AuthID:    input.AuthID,
+		Action:    input.Action,
+		Detail:    input.Detail,
+		CreatedAt: input.CreatedAt,
+	})
+}
+
+func (md *mockDAO) ListAdminAction(input dao.ListAdminActionInput) (*[]dao.AdminAction, error) {
+	// Actions are recorded in order, so the most recent are last
+	matching := make([]dao.AdminAction, 0)
+	for i := len(md.adminActionList) - 1; i >= 0; i-- {
+		if input.AuthID == nil || md.adminActionList[i].AuthID == *input.AuthID {
+			matching = append(matching, md.adminActionList[i])
+		}
+	}
+
+	adminActionList := make([]dao.AdminAction, 0)
+	for i := input.Offset; i < len(matching) && len(adminActionList) < input.Limit; i++ {
+		adminActionList = append(adminActionList, matching[i])
+	}
+	return &adminActionList, nil
+}
+
+func (md *mockDAO) CreateOIDCLogin(input dao.CreateOIDCLoginInput) (*dao.OIDCLogin, error) {
+	mockOIDCLogin := dao.OIDCLogin{
+		StateHash:    input.StateHash,
+		Provider:     input.Provider,
+		Nonce:        input.Nonce,
+		CodeVerifier: input.CodeVerifier,
+		ExpiresAt:    input.ExpiresAt,
+	}
+	md.oidcLoginList = append(md.oidcLoginList, mockOIDCLogin)
+	return &mockOIDCLogin, nil
+}
+
+func (md *mockDAO) UseOIDCLogin(input dao.UseOIDCLoginInput) (*dao.OIDCLogin, error) {
+	for i, oidcLogin := range md.oidcLoginList {
+		if oidcLogin.StateHash == input.StateHash {
+			md.oidcLoginList = append(md.oidcLoginList[:i], md.oidcLoginList[i+1:]...)
+			return &oidcLogin, nil
+		}
+	}
+	return nil, dao.ErrOIDCLoginNotFound
+}
+
+func (md *mockDAO) ReadIdentity(input dao.ReadIdentityInput) (*dao.Identity, error) {
+	for _, identity := range md.identityList {
+		if identity.Provider == input.Provider && identity.Subject == input.Subject {
+			return &identity, nil
+		}
+	}
+	return nil, dao.ErrIdentityNotFound
+}
+
+func (md *mockDAO) CreateAuthWithIdentity(input dao.CreateAuthWithIdentityInput) (*dao.Auth, error) {
+	for _, identity := range md.identityList {
+		if identity.Provider == input.Provider && identity.Subject == input.Subject {
+			return nil, dao.ErrDuplicateIdentity
+		}
+	}
+
+	auth, err := md.CreateAuth(dao.CreateAuthInput{
+		ID:       input.ID,
+		Email:    input.Email,
+		Password: input.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	md.authList[len(md.authList)-1].EmailVerified = input.EmailVerified
+	auth.EmailVerified = input.EmailVerified
+	md.identityList = append(md.identityList, dao.Identity{
+		Provider:  input.Provider,
+		Subject:   input.Subject,
+		AuthID:    input.ID,
+		CreatedAt: input.CreatedAt,
+	})
+	return auth, nil
+}
+
+func (md *mockDAO) CreateMagicLink(input dao.CreateMagicLinkInput) (*dao.MagicLink, error) {
+	mockMagicLink := dao.MagicLink{
+		ID:        input.ID,
+		AuthID:    input.AuthID,
+		TokenHash: input.TokenHash,
+		CreatedAt: input.CreatedAt,
+		ExpiresAt: input.ExpiresAt,
+	}
+	md.magicLinkList = append(md.magicLinkList, mockMagicLink)
+	return &mockMagicLink, nil
+}
+
+func (md *mockDAO) ReadLatestMagicLink(input dao.ReadLatestMagicLinkInput) (*dao.MagicLink, error) {
+	var latest *dao.MagicLink
+	for i, magicLink := range md.magicLinkList {
+		if magicLink.AuthID == input.AuthID && (latest == nil || magicLink.CreatedAt.After(latest.CreatedAt)) {
+			latest = &md.magicLinkList[i]
+		}
+	}
+	if latest == nil {
+		return nil, dao.ErrMagicLinkNotFound
+	}
+	return latest, nil
+}
+
+func (md *mockDAO) UseMagicLink(input dao.UseMagicLinkInput) (*dao.MagicLink, error) {
+	for i, magicLink := range md.magicLinkList {
+		if magicLink.TokenHash == input.TokenHash {
+			md.magicLinkList = append(md.magicLinkList[:i], md.magicLinkList[i+1:]...)
+			return &magicLink, nil
+		}
+	}
+	return nil, dao.ErrMagicLinkNotFound
+}
+
+func (md *mockDAO) CreateAPIKey(input dao.CreateAPIKeyInput) (*dao.APIKey, error) {
+	mockAPIKey := dao.APIKey{
+		ID:        input.ID,
+		AuthID:    input.AuthID,
+		Name:      input.Name,
+		Prefix:    input.Prefix,
+		KeyHash:   input.KeyHash,
+		Scopes:    input.Scopes,
+		CreatedAt: input.CreatedAt,
+	}
+	md.apiKeyList = append(md.apiKeyList, mockAPIKey)
+	return &mockAPIKey, nil
+}
+
+func (md *mockDAO) ListAPIKey(input dao.ListAPIKeyInput) (*[]dao.APIKey, error) {
+	apiKeyList := make([]dao.APIKey, 0)
+	for _, apiKey := range md.apiKeyList {
+		if apiKey.AuthID == input.AuthID {
+			apiKeyList = append(apiKeyList, apiKey)
+		}
+	}
+	return &apiKeyList, nil
+}
+
+func (md *mockDAO) ReadAPIKey(input dao.ReadAPIKeyInput) (*dao.APIKey, error) {
+	for _, apiKey := range md.apiKeyList {
+		if apiKey.KeyHash == input.KeyHash {
+			return &apiKey, nil
+		}
+	}
+	return nil, dao.ErrAPIKeyNotFound
+}
+
+func (md *mockDAO) DeleteAPIKey(input dao.DeleteAPIKeyInput) error {
+	for i, apiKey := range md.apiKeyList {
+		if apiKey.ID == input.ID && apiKey.AuthID == input.AuthID {
+			md.apiKeyList = append(md.apiKeyList[:i], md.apiKeyList[i+1:]...)
+			return nil
+		}
+	}
+	return dao.ErrAPIKeyNotFound
+}
+
+func (md *mockDAO) CreateSession(input dao.CreateSessionInput) (*dao.Session, error) {
+	mockSession := dao.Session{
+		ID:         input.ID,
+		AuthID:     input.AuthID,
+		IPAddress:  input.IPAddress,
+		UserAgent:  input.UserAgent,
+		CreatedAt:  input.CreatedAt,
+		LastUsedAt: input.CreatedAt,
+	}
+	md.sessionList = append(md.sessionList, mockSession)
+	return &mockSession, nil
+}
+
+func (md *mockDAO) TouchSession(input dao.TouchSessionInput) error {
+	for i, session := range md.sessionList {
+		if session.ID == input.ID {
+			md.sessionList[i].IPAddress = input.IPAddress
+			md.sessionList[i].UserAgent = input.UserAgent
+			md.sessionList[i].LastUsedAt = input.LastUsedAt
+			return nil
+		}
+	}
+	return dao.ErrSessionNotFound
+}
+
+func (md *mockDAO) ListSession(input dao.ListSessionInput) (*[]dao.Session, error) {
+	sessionList := make([]dao.Session, 0)
+	for _, session := range md.sessionList {
+		if session.AuthID != input.AuthID {
+			continue
+		}
+
+		for _, refreshToken := range md.refreshTokenList {
+			if refreshToken.FamilyID == session.ID && !refreshToken.Revoked && refreshToken.ExpiresAt.After(input.Now) {
+				sessionList = append(sessionList, session)
+				break
+			}
+		}
+	}
+	sort.SliceStable(sessionList, func(i, j int) bool {
+		return sessionList[i].LastUsedAt.After(sessionList[j].LastUsedAt)
+	})
+	return &sessionList, nil
+}
+
+func (md *mockDAO) DeleteSession(input dao.DeleteSessionInput) error {
+	for i, session := range md.sessionList {
+		if session.ID == input.ID && session.AuthID == input.AuthID {
+			md.sessionList = append(md.sessionList[:i], md.sessionList[i+1:]...)
+			return md.RevokeRefreshTokenFamily(dao.RevokeRefreshTokenFamilyInput{
+				FamilyID: input.ID,
+			})
+		}
+	}
+	return dao.ErrSessionNotFound
+}
+
+func (md *mockDAO) CreateLoginEvent(input dao.CreateLoginEventInput) (*dao.LoginEvent, error) {
+	mockLoginEvent := dao.LoginEvent{
+		ID:        input.ID,
+		AuthID:    input.AuthID,
+		Email:     input.Email,
+		Event:     input.Event,
+		Outcome:   input.Outcome,
+		IPAddress: input.IPAddress,
+		UserAgent: input.UserAgent,
+		CreatedAt: input.CreatedAt,
+	}
+	md.loginEventList = append(md.loginEventList, mockLoginEvent)
+	return &mockLoginEvent, nil
+}
+
+func (mc *mockComm) CreateJWTCredential(signingKey *util.SigningKey) (*comm.JWTCredential, error) {
+	mc.jwtCredentials = append(mc.jwtCredentials, signingKey.ID)
 	return &comm.JWTCredential{
-		Key:    "MyKey",
-		Secret: "ShhItsASecret",
+		Key:       signingKey.ID,
+		Algorithm: signingKey.Algorithm,
 	}, nil
 }
 
+func (mc *mockComm) DeleteJWTCredential(key string) error {
+	for i, jwtCredential := range mc.jwtCredentials {
+		if jwtCredential == key {
+			mc.jwtCredentials = append(mc.jwtCredentials[:i], mc.jwtCredentials[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// DeleteUser only accepts service tokens issued to the auth service, as the user service does for the users of others
+func (mc *mockComm) DeleteUser(userID uuid.UUID, token string) error {
+	if mc.userUnavailable {
+		return errors.New("user service unavailable")
+	}
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return err
+	}
+
+	if service := parsed.Claims.(jwt.MapClaims)["service"]; service != "auth" {
+		return fmt.Errorf("service %v cannot delete another user", service)
+	}
+
+	mc.deletedUsers = append(mc.deletedUsers, userID)
+	return nil
+}
+
+// DeleteUserMatches only accepts service tokens issued to the auth service, as the match service does
+func (mc *mockComm) DeleteUserMatches(userID uuid.UUID, token string) error {
+	if mc.matchUnavailable {
+		return errors.New("match service unavailable")
+	}
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return err
+	}
+
+	if service := parsed.Claims.(jwt.MapClaims)["service"]; service != "auth" {
+		return fmt.Errorf("service %v cannot delete the matches of another user", service)
+	}
+
+	mc.deletedMatches = append(mc.deletedMatches, userID)
+	return nil
+}
+
 func makeRequest(env env, method string, url string, body string) (*httptest.ResponseRecorder, error) {
 	rec := httptest.NewRecorder()
 	req, err := http.NewRequest(method, url, strings.NewReader(body))
@@ -68,13 +973,156 @@ func makeRequest(env env, method string, url string, body string) (*httptest.Res
 	return rec, nil
 }
 
+func makeAuthenticatedRequest(env env, method string, url string, body string, accessToken string) (*httptest.ResponseRecorder, error) {
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	defaultRouter(&env).ServeHTTP(rec, req)
+	return rec, nil
+}
+
+// registerAuth registers a new auth, returning the decoded tokens from the response
+func registerAuth(t *testing.T, env env, email string) map[string]string {
+	res, err := makeRequest(env, http.MethodPost, "/auth/register", fmt.Sprintf(`{"email": "%s", "password": "BlackcurrantCrush123"}`, email))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	return decoded
+}
+
+// awaitEmails waits for at least the given number of emails to have been sent to an address, as some are sent in the
+// background
+func awaitEmails(t *testing.T, env env, email string, count int) {
+	mailer := env.mailer.(*comm.MemoryMailer)
+	deadline := time.Now().Add(time.Second)
+	for len(mailer.MessagesTo(email)) < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Fewer than %d emails were sent to %s", count, email)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// emailedToken returns the token linked to in the most recent email sent to the given address
+func emailedToken(t *testing.T, env env, email string) string {
+	awaitEmails(t, env, email, 1)
+	message, ok := env.mailer.(*comm.MemoryMailer).LastMessage(email)
+	if !ok {
+		t.Fatalf("No email was sent to %s", email)
+	}
+
+	match := emailTokenRegexp.FindStringSubmatch(message.Body)
+	if match == nil {
+		t.Fatalf("Email doesn't contain a token: %s", message.Body)
+	}
+
+	return match[1]
+}
+
+// mockSigningKey is shared between tests, as generating an RSA key is slow
+var mockSigningKey, _ = util.GenerateSigningKey(util.SigningAlgorithmRS256)
+
+// mockPasswordHashing hashes passwords with Argon2id, using minimal parameters to keep tests fast
+var mockPasswordHashing = util.PasswordHashingConfig{
+	Algorithm:  util.PasswordAlgorithmArgon2id,
+	BcryptCost: bcrypt.MinCost,
+	Argon2id: util.Argon2idConfig{
+		Time:      1,
+		MemoryKiB: 64,
+		Threads:   1,
+	},
+}
+
+// mockPasswordPolicy refuses weak passwords, without checking for breached passwords unless a test provides ranges
+var mockPasswordPolicy = util.PasswordPolicyConfig{
+	MinEntropyBits:   40,
+	BannedSubstrings: []string{"password"},
+}
+
 func makeMockEnv() env {
 	mockComm := mockComm{}
-	cred, _ := mockComm.CreateJWTCredential()
+	mockComm.CreateJWTCredential(mockSigningKey)
+	privateKey, _ := util.EncodeSigningKey(mockSigningKey)
+	ring := keyRing{}
+	ring.set(mockSigningKey, []*util.SigningKey{mockSigningKey})
+	passwords, _ := util.NewPasswords(mockPasswordHashing)
+	passwordPolicy, _ := util.NewPasswordPolicy(mockPasswordPolicy)
 	return env{
-		&mockDAO{authList: make([]dao.Auth, 0)},
+		&mockDAO{
+			authList: make([]dao.Auth, 0),
+			signingKeyList: []dao.SigningKey{
+				{
+					ID:         mockSigningKey.ID,
+					Algorithm:  mockSigningKey.Algorithm,
+					PrivateKey: privateKey,
+					State:      dao.SigningKeyActive,
+					CreatedAt:  time.Now(),
+					UpdatedAt:  time.Now(),
+				},
+			},
+		},
+		dao.NewMemoryLoginAttemptStore(),
 		&mockComm,
-		cred,
+		&comm.MemoryMailer{},
+		map[string]comm.OIDCProvider{},
+		passwords,
+		passwordPolicy,
+		&ring,
+		&util.Config{
+			PasswordResetURL:           "http://localhost/reset-password",
+			PasswordResetResendSeconds: 60,
+			EmailVerification: util.EmailVerificationConfig{
+				Mode:                  util.EmailVerificationOptional,
+				URL:                   "http://localhost/verify",
+				ResendIntervalSeconds: 60,
+			},
+			LoginLockout: util.LoginLockoutConfig{
+				Store:              util.LoginLockoutStoreMemory,
+				EmailMaxAttempts:   3,
+				IPMaxAttempts:      10,
+				BaseLockoutSeconds: 30,
+				MaxLockoutSeconds:  3600,
+				WindowSeconds:      900,
+			},
+			TwoFactor: util.TwoFactorConfig{
+				Issuer:                   "spec-golang",
+				ChallengeLifetimeSeconds: 300,
+				MaxChallengeAttempts:     3,
+			},
+			MagicLink: util.MagicLinkConfig{
+				URL:                   "http://localhost/magic-link",
+				LifetimeSeconds:       900,
+				ResendIntervalSeconds: 60,
+			},
+			PasswordHashing: mockPasswordHashing,
+			PasswordPolicy:  mockPasswordPolicy,
+			ServiceClients: util.ServiceClientsConfig{
+				TokenLifetimeSeconds: 300,
+				Secrets: map[string]string{
+					"match": util.HashToken(mockServiceSecret),
+				},
+			},
+			Signing: util.SigningConfig{
+				Mode:                    util.SigningModeKong,
+				Algorithm:               util.SigningAlgorithmRS256,
+				RotationIntervalSeconds: 604800,
+				RotationCheckSeconds:    300,
+			},
+		},
 		Hook{},
 	}
 }
@@ -129,8 +1177,8 @@ func TestCreateAuthHandlerSucceeds(t *testing.T) {
 		t.Fatalf("Claims doesn't contain an iss key")
 	}
 
-	if iss.(string) != mockEnv.jwtCredential.Key {
-		t.Fatalf("iss is incorrect: found %v, wanted %s", iss, mockEnv.jwtCredential.Key)
+	if iss.(string) != mockSigningKey.ID {
+		t.Fatalf("iss is incorrect: found %v, wanted %s", iss, mockSigningKey.ID)
 	}
 }
 
@@ -344,8 +1392,8 @@ func TestReadAuthHandlerSucceeds(t *testing.T) {
 		t.Fatalf("Claims doesn't contain an iss key")
 	}
 
-	if iss.(string) != mockEnv.jwtCredential.Key {
-		t.Fatalf("iss is incorrect: found %v, wanted %s", iss, mockEnv.jwtCredential.Key)
+	if iss.(string) != mockSigningKey.ID {
+		t.Fatalf("iss is incorrect: found %v, wanted %s", iss, mockSigningKey.ID)
 	}
 }
 
@@ -4,57 +4,133 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/google/uuid"
 )
 
 // Comm provides the interface adopted by Handler, allowing for mocking
 type Comm interface {
-	CreateJWTCredential() (*JWTCredential, error)
+	CreateJWTCredential(signingKey *util.SigningKey) (*JWTCredential, error)
+	DeleteJWTCredential(key string) error
+	DeleteUser(userID uuid.UUID, token string) error
+	DeleteUserMatches(userID uuid.UUID, token string) error
 }
 
 // Handler maintains the list of services and their associated hostnames
 type Handler struct {
-	Services map[string]string
+	Services  map[string]string
+	kongRetry util.KongRetryConfig
 }
 
-// consumerResponse encapsulates the response from Kong after creating a consumer
+// consumerResponse encapsulates the response from Kong after creating or reading a consumer
 type consumerResponse struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 }
 
-// JWTCredential store the issuer and secret key that must be used to sign requests
+// JWTCredential stores the issuer that must be used to sign requests, and the algorithm and public key Kong verifies them with
 type JWTCredential struct {
-	Key    string `json:"key"`
-	Secret string `json:"secret"`
+	Key          string `json:"key"`
+	Algorithm    string `json:"algorithm"`
+	RSAPublicKey string `json:"rsa_public_key"`
 }
 
+// errKongUnavailable is returned when Kong cannot be reached or fails to handle a request, such that it may be retried
+var errKongUnavailable = errors.New("kong is unavailable")
+
 // Init sets up the Handler object with a list of services from the config
 func Init(config *util.Config) *Handler {
-	return &Handler{config.Services}
+	return &Handler{
+		Services:  config.Services,
+		kongRetry: config.KongRetry,
+	}
 }
 
-func createKongConsumer(hostname string) (*consumerResponse, error) {
-	postData := url.Values{}
-	postData.Set("username", "auth-service")
+// kongConsumer is the username of the Kong consumer that every JWT credential belongs to
+const kongConsumer = "auth-service"
+
+// retryKong calls the given function until it succeeds, fails for a reason other than Kong being unavailable, or runs
+// out of attempts, waiting exponentially longer between each attempt
+func (coms *Handler) retryKong(f func() error) error {
+	delay := time.Duration(coms.kongRetry.BaseDelayMilliseconds) * time.Millisecond
+	maxDelay := time.Duration(coms.kongRetry.MaxDelayMilliseconds) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !errors.Is(err, errKongUnavailable) || attempt >= coms.kongRetry.MaxAttempts {
+			return err
+		}
+
+		log.Printf("Retrying in %s: %s", delay, err.Error())
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// kongRequest makes a request to the Kong admin API, with the given form as its body if provided
+// Failing to reach Kong, or a server error from it, is reported as errKongUnavailable
+func kongRequest(method string, reqURL string, form url.Values) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
 
-	res, err := http.PostForm(fmt.Sprintf("%s/consumers", hostname), postData)
+	req, err := http.NewRequest(method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	res, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errKongUnavailable, err.Error())
+	}
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: %s %s returned status %d", errKongUnavailable, method, reqURL, res.StatusCode)
+	}
+
+	return res, nil
+}
+
+// kongResponseError returns the error from an unexpected response from Kong
+func kongResponseError(res *http.Response, message string) error {
+	// If we have an error code, the message _should_ be in the body
+	bodyBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.New(message)
+	}
+	return errors.New(string(bodyBytes))
+}
+
+// readKongConsumer reads the Kong consumer, returning nil if it does not yet exist
+func readKongConsumer(hostname string) (*consumerResponse, error) {
+	res, err := kongRequest(http.MethodGet, fmt.Sprintf("%s/consumers/%s", hostname, kongConsumer), nil)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusCreated {
-		// If we have an error code, the message _should_ be in the body
-		bodyBytes, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return nil, errors.New("unable to create JWT consumer")
-		}
-		return nil, errors.New(string(bodyBytes))
+	switch res.StatusCode {
+	case http.StatusOK:
+		break
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, kongResponseError(res, "unable to read JWT consumer")
 	}
 
 	consumer := consumerResponse{}
@@ -66,21 +142,123 @@ func createKongConsumer(hostname string) (*consumerResponse, error) {
 	return &consumer, nil
 }
 
-func requestCredential(hostname string, consumer *consumerResponse) (*JWTCredential, error) {
+// provisionKongConsumer returns the Kong consumer, creating it if it does not yet exist
+func provisionKongConsumer(hostname string) (*consumerResponse, error) {
+	consumer, err := readKongConsumer(hostname)
+	if err != nil || consumer != nil {
+		return consumer, err
+	}
+
+	postData := url.Values{}
+	postData.Set("username", kongConsumer)
+
+	res, err := kongRequest(http.MethodPost, fmt.Sprintf("%s/consumers", hostname), postData)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusCreated:
+		break
+	case http.StatusConflict:
+		// Another replica created the consumer since it was read
+		consumer, err = readKongConsumer(hostname)
+		if err == nil && consumer == nil {
+			err = errors.New("unable to create JWT consumer")
+		}
+		return consumer, err
+	default:
+		return nil, kongResponseError(res, "unable to create JWT consumer")
+	}
+
+	consumer = &consumerResponse{}
+	err = json.NewDecoder(res.Body).Decode(consumer)
+	if err != nil {
+		return nil, err
+	}
+
+	return consumer, nil
+}
+
+// readCredential reads the JWT credential with the given key, returning nil if it does not exist
+func readCredential(hostname string, consumer *consumerResponse, key string) (*JWTCredential, error) {
+	reqURL := fmt.Sprintf("%s/consumers/%s/jwt/%s", hostname, consumer.Username, url.PathEscape(key))
+	res, err := kongRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		break
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, kongResponseError(res, "unable to read JWT credential")
+	}
+
+	jwt := JWTCredential{}
+	err = json.NewDecoder(res.Body).Decode(&jwt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwt, nil
+}
+
+// checkCredential checks that an existing credential verifies tokens with the signing key, such that it can be reused
+func checkCredential(jwt *JWTCredential, signingKey *util.SigningKey, publicKey string) (*JWTCredential, error) {
+	if jwt.Algorithm != signingKey.Algorithm || strings.TrimSpace(jwt.RSAPublicKey) != strings.TrimSpace(publicKey) {
+		return nil, fmt.Errorf("JWT credential %s is registered with a different public key", jwt.Key)
+	}
+	return jwt, nil
+}
+
+// requestCredential registers the signing key with Kong, reusing the credential if it has already been registered
+func requestCredential(hostname string, consumer *consumerResponse, signingKey *util.SigningKey) (*JWTCredential, error) {
+	publicKey, err := signingKey.PublicKeyPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := readCredential(hostname, consumer, signingKey.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return checkCredential(existing, signingKey, publicKey)
+	}
+
+	// The key ID is used as the issuer, such that Kong verifies each token with the public key it was signed by
+	postData := url.Values{}
+	postData.Set("key", signingKey.ID)
+	postData.Set("algorithm", signingKey.Algorithm)
+	postData.Set("rsa_public_key", publicKey)
+
 	reqUrl := fmt.Sprintf("%s/consumers/%s/jwt", hostname, consumer.Username)
-	res, err := http.Post(reqUrl, "application/x-www-form-urlencoded", nil)
+	res, err := kongRequest(http.MethodPost, reqUrl, postData)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusCreated {
-		// If we have an error code, the message _should_ be in the body
-		bodyBytes, err := ioutil.ReadAll(res.Body)
+	switch res.StatusCode {
+	case http.StatusCreated:
+		break
+	case http.StatusConflict:
+		// Another replica registered the same key since it was read
+		existing, err = readCredential(hostname, consumer, signingKey.ID)
 		if err != nil {
-			return nil, errors.New("unable to create JWT token")
+			return nil, err
+		}
+		if existing == nil {
+			return nil, fmt.Errorf("JWT credential %s conflicts with a credential of another consumer", signingKey.ID)
 		}
-		return nil, errors.New(string(bodyBytes))
+		return checkCredential(existing, signingKey, publicKey)
+	default:
+		return nil, kongResponseError(res, "unable to create JWT token")
 	}
 
 	jwt := JWTCredential{}
@@ -92,19 +270,100 @@ func requestCredential(hostname string, consumer *consumerResponse) (*JWTCredent
 	return &jwt, nil
 }
 
-// CreateJWTCredential provisions a new HS256 JWT credential
-func (coms *Handler) CreateJWTCredential() (*JWTCredential, error) {
+// CreateJWTCredential registers the public key of a signing key with Kong as a JWT credential
+// Both the consumer and the credential are reused if they already exist, so replicas may register the same key
+// concurrently, and requests are retried while Kong is unavailable, such as while it is starting
+func (coms *Handler) CreateJWTCredential(signingKey *util.SigningKey) (*JWTCredential, error) {
 	hostname, ok := coms.Services["kong-admin"]
 	if !ok {
 		return nil, fmt.Errorf("service %s's hostname not in config file", "kong-admin")
 	}
 
-	// Create a consumer
-	consumer, err := createKongConsumer(hostname)
+	var jwt *JWTCredential
+	err := coms.retryKong(func() error {
+		consumer, err := provisionKongConsumer(hostname)
+		if err != nil {
+			return err
+		}
+
+		jwt, err = requestCredential(hostname, consumer, signingKey)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Use the consumer to request a credential
-	return requestCredential(hostname, consumer)
+	return jwt, nil
+}
+
+// DeleteJWTCredential removes the JWT credential with the given key from Kong, such that tokens signed by it are rejected
+// A credential that does not exist is treated as already deleted
+func (coms *Handler) DeleteJWTCredential(key string) error {
+	hostname, ok := coms.Services["kong-admin"]
+	if !ok {
+		return fmt.Errorf("service %s's hostname not in config file", "kong-admin")
+	}
+
+	reqURL := fmt.Sprintf("%s/consumers/%s/jwt/%s", hostname, kongConsumer, url.PathEscape(key))
+	return coms.retryKong(func() error {
+		res, err := kongRequest(http.MethodDelete, reqURL, nil)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotFound {
+			return kongResponseError(res, fmt.Sprintf("unable to delete JWT credential %s: status %d", key, res.StatusCode))
+		}
+
+		return nil
+	})
+}
+
+// DeleteUser makes a request to the user service to delete the user with the given ID, using a service token issued to
+// the auth service
+// A user that does not exist is treated as already deleted
+func (coms *Handler) DeleteUser(userID uuid.UUID, token string) error {
+	hostname, ok := coms.Services["user"]
+	if !ok {
+		return fmt.Errorf("service %s's hostname not in config file", "user")
+	}
+
+	return makeDeleteRequest(fmt.Sprintf("%s/%s", hostname, userID.String()), token)
+}
+
+// DeleteUserMatches makes a request to the match service to delete every match created by or involving the user, using
+// a service token issued to the auth service, as users may only delete the matches they created
+func (coms *Handler) DeleteUserMatches(userID uuid.UUID, token string) error {
+	hostname, ok := coms.Services["match"]
+	if !ok {
+		return fmt.Errorf("service %s's hostname not in config file", "match")
+	}
+
+	return makeDeleteRequest(fmt.Sprintf("%s/all?user=%s", hostname, userID.String()), token)
+}
+
+func makeDeleteRequest(reqURL string, token string) error {
+	req, err := http.NewRequest(http.MethodDelete, reqURL, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		// If we have an error code, the message _should_ be in the body
+		bodyBytes, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return fmt.Errorf("unable to delete %s: status %d", reqURL, res.StatusCode)
+		}
+		return errors.New(string(bodyBytes))
+	}
+
+	return nil
 }
@@ -0,0 +1,99 @@
+package comm
+
+import (
+	"fmt"
+	"net/smtp"
+	"sync"
+
+	"github.com/TempleEight/spec-golang/auth/util"
+)
+
+// Mailer provides the interface for sending emails, allowing for mocking
+type Mailer interface {
+	SendMail(to string, subject string, body string) error
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// MemoryMailer stores emails in memory rather than sending them, for use in tests and local development
+type MemoryMailer struct {
+	mutex    sync.Mutex
+	Messages []Message
+}
+
+// Message encapsulates a single email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// InitMailer sets up a Mailer from the config, storing emails in memory if no SMTP server is configured
+func InitMailer(config *util.Config) Mailer {
+	if len(config.Mail.Host) == 0 {
+		return &MemoryMailer{}
+	}
+
+	return &SMTPMailer{
+		Host:     config.Mail.Host,
+		Port:     config.Mail.Port,
+		Username: config.Mail.Username,
+		Password: config.Mail.Password,
+		From:     config.Mail.From,
+	}
+}
+
+// SendMail sends a plain text email through the SMTP server
+func (mailer *SMTPMailer) SendMail(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if len(mailer.Username) > 0 {
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", mailer.From, to, subject, body)
+	return smtp.SendMail(fmt.Sprintf("%s:%d", mailer.Host, mailer.Port), auth, mailer.From, []string{to}, []byte(msg))
+}
+
+// SendMail stores the email in memory
+func (mailer *MemoryMailer) SendMail(to string, subject string, body string) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	mailer.Messages = append(mailer.Messages, Message{to, subject, body})
+	return nil
+}
+
+// LastMessage returns the most recent email sent to the given address
+func (mailer *MemoryMailer) LastMessage(to string) (*Message, bool) {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	for i := len(mailer.Messages) - 1; i >= 0; i-- {
+		if mailer.Messages[i].To == to {
+			message := mailer.Messages[i]
+			return &message, true
+		}
+	}
+	return nil, false
+}
+
+// MessagesTo returns every email sent to the given address, oldest first
+func (mailer *MemoryMailer) MessagesTo(to string) []Message {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	messages := make([]Message, 0)
+	for _, message := range mailer.Messages {
+		if message.To == to {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
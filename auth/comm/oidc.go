@@ -0,0 +1,460 @@
+package comm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// oidcKeysRefreshInterval is the minimum time between fetching a provider's signing keys on encountering an unknown key
+// ID, such that ID tokens naming made-up keys cannot overwhelm the provider
+const oidcKeysRefreshInterval = time.Minute
+
+// oidcRequiredScopes are requested from every provider, as the subject and email are needed to link an auth
+var oidcRequiredScopes = []string{"openid", "email"}
+
+// ErrIdentityRejected is returned when the provider refuses to exchange the authorization code, or issues an ID token
+// that cannot be verified, such that the login cannot be completed
+var ErrIdentityRejected = errors.New("identity provider rejected login")
+
+// OIDCProvider provides the interface for logging in with an external OpenID Connect identity provider, allowing for mocking
+type OIDCProvider interface {
+	AuthorizationURL(state string, nonce string, codeVerifier string) (string, error)
+	Exchange(code string, codeVerifier string, nonce string) (*OIDCIdentity, error)
+}
+
+// OIDCIdentity encapsulates the verified claims of an ID token that identify its subject
+type OIDCIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// OIDCHandler logs in with a single identity provider using the authorization code flow with PKCE
+// The provider's discovery document and signing keys are cached, such that it is only contacted when they are needed
+type OIDCHandler struct {
+	name   string
+	config util.OIDCProviderConfig
+
+	mutex         sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+// oidcDiscovery encapsulates the endpoints published in a provider's discovery document
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcTokenResponse encapsulates the response from a provider's token endpoint after exchanging an authorization code
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// oidcKeysResponse encapsulates the signing keys published by a provider, in the JSON Web Key Set format defined by RFC 7517
+type oidcKeysResponse struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"keys"`
+}
+
+// InitOIDCProviders sets up an OIDCHandler for every identity provider in the config, keyed by name
+func InitOIDCProviders(config *util.Config) map[string]OIDCProvider {
+	providers := make(map[string]OIDCProvider)
+	for name, providerConfig := range config.OIDC.Providers {
+		providers[name] = &OIDCHandler{
+			name:   name,
+			config: providerConfig,
+		}
+	}
+	return providers
+}
+
+// AuthorizationURL returns the URL to send the client to in order to log in with the provider, which returns it to the
+// redirect URL with the state and an authorization code
+// Only the S256 challenge of the code verifier is sent, such that an intercepted code cannot be exchanged without it
+func (oidc *OIDCHandler) AuthorizationURL(state string, nonce string, codeVerifier string) (string, error) {
+	discovery, err := oidc.discover()
+	if err != nil {
+		return "", err
+	}
+
+	authorizationURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := authorizationURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", oidc.config.ClientID)
+	query.Set("redirect_uri", oidc.config.RedirectURL)
+	query.Set("scope", strings.Join(oidc.scopes(), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authorizationURL.RawQuery = query.Encode()
+
+	return authorizationURL.String(), nil
+}
+
+// Exchange exchanges an authorization code for an ID token at the provider, returning the identity it asserts once its
+// signature, issuer, audience, expiry and nonce have been verified
+func (oidc *OIDCHandler) Exchange(code string, codeVerifier string, nonce string) (*OIDCIdentity, error) {
+	discovery, err := oidc.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidc.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+		"client_id":     {oidc.config.ClientID},
+	}
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(oidc.config.ClientSecret) > 0 {
+		// The client credentials are form-encoded before being used for basic authentication, as required by RFC 6749
+		req.SetBasicAuth(url.QueryEscape(oidc.config.ClientID), url.QueryEscape(oidc.config.ClientSecret))
+	}
+
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("identity provider %s responded with status code %d", oidc.name, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: token endpoint responded with status code %d", ErrIdentityRejected, resp.StatusCode)
+	}
+
+	var tokenResponse oidcTokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return oidc.verifyIDToken(tokenResponse.IDToken, discovery, nonce)
+}
+
+// verifyIDToken verifies an ID token issued to this client, returning the identity it asserts
+func (oidc *OIDCHandler) verifyIDToken(idToken string, discovery *oidcDiscovery, nonce string) (*OIDCIdentity, error) {
+	token, err := jwt.Parse(idToken, oidc.verificationKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIdentityRejected, err.Error())
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("%w: ID token contains no claims", ErrIdentityRejected)
+	}
+
+	// jwt-go only verifies the expiry if there is one, but every ID token must expire
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: ID token does not expire", ErrIdentityRejected)
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer %v", ErrIdentityRejected, claims["iss"])
+	}
+
+	if !oidc.verifyAudience(claims) {
+		return nil, fmt.Errorf("%w: ID token was not issued to this client", ErrIdentityRejected)
+	}
+
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrIdentityRejected)
+	}
+
+	subject, _ := claims["sub"].(string)
+	if len(subject) == 0 {
+		return nil, fmt.Errorf("%w: ID token contains no subject", ErrIdentityRejected)
+	}
+
+	email, _ := claims["email"].(string)
+	emailVerified, _ := claims["email_verified"].(bool)
+	return &OIDCIdentity{
+		Provider:      oidc.name,
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: emailVerified,
+	}, nil
+}
+
+// verifyAudience checks the ID token was issued to this client, which must be named by the aud claim, and by the azp
+// claim if the token has one
+// jwt-go's VerifyAudience does not accept the array form of the aud claim, so it is checked here
+func (oidc *OIDCHandler) verifyAudience(claims jwt.MapClaims) bool {
+	if azp, ok := claims["azp"]; ok && azp != oidc.config.ClientID {
+		return false
+	}
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == oidc.config.ClientID
+	case []interface{}:
+		for _, audience := range aud {
+			if audience == oidc.config.ClientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verificationKey returns the provider's public key to verify an ID token with, looked up by its kid, provided it was
+// signed with an algorithm matching the key
+// Tokens without a kid are accepted if the provider publishes exactly one key
+func (oidc *OIDCHandler) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := oidc.lookupKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+	case *ecdsa.PublicKey:
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+	}
+
+	return key, nil
+}
+
+// lookupKey returns the provider's signing key with the given ID, fetching the keys from the provider if they have not
+// been fetched or do not contain it
+func (oidc *OIDCHandler) lookupKey(kid string) (interface{}, error) {
+	discovery, err := oidc.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	oidc.mutex.Lock()
+	defer oidc.mutex.Unlock()
+
+	key, ok := oidc.findKey(kid)
+	now := time.Now()
+	if !ok && (oidc.keys == nil || now.Sub(oidc.keysFetchedAt) >= oidcKeysRefreshInterval) {
+		keys, err := oidc.listKeys(discovery.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+
+		oidc.keys = keys
+		oidc.keysFetchedAt = now
+		key, ok = oidc.findKey(kid)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+
+	return key, nil
+}
+
+// findKey returns the cached signing key with the given ID, or the only cached key if no ID is given
+func (oidc *OIDCHandler) findKey(kid string) (interface{}, bool) {
+	if len(kid) == 0 && len(oidc.keys) == 1 {
+		for _, key := range oidc.keys {
+			return key, true
+		}
+	}
+
+	key, ok := oidc.keys[kid]
+	return key, ok
+}
+
+// listKeys makes a request to the provider to list the public keys that its ID tokens may be signed by
+func (oidc *OIDCHandler) listKeys(jwksURI string) (map[string]interface{}, error) {
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	resp, err := client.Get(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity provider %s responded with status code %d", oidc.name, resp.StatusCode)
+	}
+
+	var jwks oidcKeysResponse
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+
+		var publicKey interface{}
+		switch {
+		case jwk.Kty == "RSA":
+			publicKey, err = rsaPublicKey(jwk.N, jwk.E)
+		case jwk.Kty == "EC" && jwk.Crv == "P-256":
+			publicKey, err = ecdsaPublicKey(jwk.X, jwk.Y)
+		default:
+			err = fmt.Errorf("unsupported key type %s", jwk.Kty)
+		}
+		if err != nil {
+			log.Printf("Ignoring signing key %s of identity provider %s: %s", jwk.Kid, oidc.name, err.Error())
+			continue
+		}
+
+		keys[jwk.Kid] = publicKey
+	}
+
+	return keys, nil
+}
+
+// discover returns the provider's discovery document, fetching it from the provider the first time it is needed
+// The document must name the configured issuer, such that ID tokens from another issuer are not accepted
+func (oidc *OIDCHandler) discover() (*oidcDiscovery, error) {
+	oidc.mutex.Lock()
+	defer oidc.mutex.Unlock()
+
+	if oidc.discovery != nil {
+		return oidc.discovery, nil
+	}
+
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	resp, err := client.Get(fmt.Sprintf("%s/.well-known/openid-configuration", strings.TrimSuffix(oidc.config.Issuer, "/")))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity provider %s responded with status code %d", oidc.name, resp.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	err = json.NewDecoder(resp.Body).Decode(&discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	if discovery.Issuer != oidc.config.Issuer {
+		return nil, fmt.Errorf("identity provider %s names unexpected issuer %s", oidc.name, discovery.Issuer)
+	}
+
+	if len(discovery.AuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 || len(discovery.JWKSURI) == 0 {
+		return nil, fmt.Errorf("identity provider %s does not publish every required endpoint", oidc.name)
+	}
+
+	oidc.discovery = &discovery
+	return oidc.discovery, nil
+}
+
+// scopes returns the configured scopes, including those required by every provider
+func (oidc *OIDCHandler) scopes() []string {
+	scopes := append([]string{}, oidcRequiredScopes...)
+	for _, scope := range oidc.config.Scopes {
+		required := false
+		for _, requiredScope := range oidcRequiredScopes {
+			required = required || scope == requiredScope
+		}
+		if !required {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// rsaPublicKey decodes an RSA public key from its base64url-encoded modulus and exponent
+func rsaPublicKey(encodedN string, encodedE string) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(encodedN)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(encodedE)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("RSA exponent is too large")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+// ecdsaPublicKey decodes a P-256 public key from its base64url-encoded coordinates
+func ecdsaPublicKey(encodedX string, encodedY string) (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(encodedX)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(encodedY)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("EC point is not on the curve")
+	}
+
+	return &publicKey, nil
+}
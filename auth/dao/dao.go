@@ -3,6 +3,8 @@ package dao
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/TempleEight/spec-golang/auth/util"
 	"github.com/google/uuid"
@@ -18,6 +20,74 @@ const psqlUniqueViolation = "unique_violation"
 type BaseDatastore interface {
 	CreateAuth(input CreateAuthInput) (*Auth, error)
 	ReadAuth(input ReadAuthInput) (*Auth, error)
+	CreateRefreshToken(input CreateRefreshTokenInput) (*RefreshToken, error)
+	ReadRefreshToken(input ReadRefreshTokenInput) (*RefreshToken, error)
+	RevokeRefreshToken(input RevokeRefreshTokenInput) error
+	RevokeRefreshTokenFamily(input RevokeRefreshTokenFamilyInput) error
+	RevokeAuthRefreshTokens(input RevokeAuthRefreshTokensInput) error
+	CreateRevokedToken(input CreateRevokedTokenInput) (*RevokedToken, error)
+	ReadRevokedToken(input ReadRevokedTokenInput) (*RevokedToken, error)
+	ListRevokedToken() (*[]RevokedToken, error)
+	ListTokenCutoff(input ListTokenCutoffInput) (*[]TokenCutoff, error)
+	InvalidateAuthCredentials(input InvalidateAuthCredentialsInput) (*Auth, error)
+	UpdateAuthPassword(input UpdateAuthPasswordInput) (*Auth, error)
+	RehashAuthPassword(input RehashAuthPasswordInput) error
+	CreatePasswordReset(input CreatePasswordResetInput) (*PasswordReset, error)
+	ReadPasswordReset(input ReadPasswordResetInput) (*PasswordReset, error)
+	ReadLatestPasswordReset(input ReadLatestPasswordResetInput) (*PasswordReset, error)
+	DeletePasswordReset(input DeletePasswordResetInput) error
+	ReadAuthByID(input ReadAuthByIDInput) (*Auth, error)
+	VerifyAuthEmail(input VerifyAuthEmailInput) (*Auth, error)
+	CreateEmailVerification(input CreateEmailVerificationInput) (*EmailVerification, error)
+	ReadEmailVerification(input ReadEmailVerificationInput) (*EmailVerification, error)
+	ReadLatestEmailVerification(input ReadLatestEmailVerificationInput) (*EmailVerification, error)
+	DeleteAuthEmailVerifications(input DeleteAuthEmailVerificationsInput) error
+	UpdateAuthEmail(input UpdateAuthEmailInput) (*Auth, error)
+	UpdateAuthRole(input UpdateAuthRoleInput) (*Auth, error)
+	CreateAccountDeletion(input CreateAccountDeletionInput) (*AccountDeletion, error)
+	ListAccountDeletion() (*[]AccountDeletion, error)
+	UpdateAccountDeletion(input UpdateAccountDeletionInput) (*AccountDeletion, error)
+	DeleteCompletedAccountDeletions(input DeleteCompletedAccountDeletionsInput) error
+	CreateTwoFactor(input CreateTwoFactorInput) (*TwoFactor, error)
+	ReadTwoFactor(input ReadTwoFactorInput) (*TwoFactor, error)
+	EnableTwoFactor(input EnableTwoFactorInput) (*TwoFactor, error)
+	UseTwoFactorStep(input UseTwoFactorStepInput) error
+	DeleteTwoFactor(input DeleteTwoFactorInput) error
+	DeleteRecoveryCode(input DeleteRecoveryCodeInput) error
+	CreateTwoFactorChallenge(input CreateTwoFactorChallengeInput) (*TwoFactorChallenge, error)
+	ReadTwoFactorChallenge(input ReadTwoFactorChallengeInput) (*TwoFactorChallenge, error)
+	RecordTwoFactorChallengeFailure(input RecordTwoFactorChallengeFailureInput) (*TwoFactorChallenge, error)
+	DeleteTwoFactorChallenge(input DeleteTwoFactorChallengeInput) error
+	CreateSigningKey(input CreateSigningKeyInput) (*SigningKey, error)
+	ListSigningKey() (*[]SigningKey, error)
+	RetireSigningKey(input RetireSigningKeyInput) error
+	ListAuth(input ListAuthInput) (*[]Auth, error)
+	UpdateAuthSuspended(input UpdateAuthSuspendedInput) (*Auth, error)
+	ListAdminAction(input ListAdminActionInput) (*[]AdminAction, error)
+	CreateOIDCLogin(input CreateOIDCLoginInput) (*OIDCLogin, error)
+	UseOIDCLogin(input UseOIDCLoginInput) (*OIDCLogin, error)
+	ReadIdentity(input ReadIdentityInput) (*Identity, error)
+	CreateAuthWithIdentity(input CreateAuthWithIdentityInput) (*Auth, error)
+	CreateMagicLink(input CreateMagicLinkInput) (*MagicLink, error)
+	ReadLatestMagicLink(input ReadLatestMagicLinkInput) (*MagicLink, error)
+	UseMagicLink(input UseMagicLinkInput) (*MagicLink, error)
+	CreateAPIKey(input CreateAPIKeyInput) (*APIKey, error)
+	ListAPIKey(input ListAPIKeyInput) (*[]APIKey, error)
+	ReadAPIKey(input ReadAPIKeyInput) (*APIKey, error)
+	DeleteAPIKey(input DeleteAPIKeyInput) error
+	CreateSession(input CreateSessionInput) (*Session, error)
+	TouchSession(input TouchSessionInput) error
+	ListSession(input ListSessionInput) (*[]Session, error)
+	DeleteSession(input DeleteSessionInput) error
+	CreateLoginEvent(input CreateLoginEventInput) (*LoginEvent, error)
+}
+
+// LoginAttemptStore tracks failed login attempts, allowing for an in-memory implementation
+type LoginAttemptStore interface {
+	ReadLoginAttempt(input ReadLoginAttemptInput) (*LoginAttempt, error)
+	RecordFailedLogin(input RecordFailedLoginInput) (*LoginAttempt, error)
+	LockLoginAttempt(input LockLoginAttemptInput) error
+	DeleteLoginAttempt(input DeleteLoginAttemptInput) error
 }
 
 // DAO encapsulates access to the datastore
@@ -26,10 +96,194 @@ type DAO struct {
 }
 
 // Auth encapsulates the object stored in the datastore
+// Every access token issued to the auth before TokensRevokedBefore is revoked, if it is set
 type Auth struct {
-	ID       uuid.UUID
-	Email    string
-	Password string
+	ID                  uuid.UUID
+	Email               string
+	Password            string
+	EmailVerified       bool
+	Role                string
+	Suspended           bool
+	TokensRevokedBefore *time.Time
+}
+
+// RefreshToken encapsulates a refresh token stored in the datastore
+// Only a hash of the token is stored, such that a leaked datastore cannot be used to mint access tokens
+type RefreshToken struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	FamilyID  uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
+	Revoked   bool
+	Rotated   bool
+}
+
+// RevokedToken encapsulates an access token that has been revoked before its expiry
+type RevokedToken struct {
+	JTI       string
+	AuthID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+// TokenCutoff encapsulates the time before which every access token issued to an auth is revoked
+type TokenCutoff struct {
+	AuthID        uuid.UUID
+	RevokedBefore time.Time
+}
+
+// PasswordReset encapsulates a password reset token stored in the datastore
+// As with refresh tokens, only a hash of the token is stored
+type PasswordReset struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// EmailVerification encapsulates an email verification token stored in the datastore
+// As with refresh tokens, only a hash of the token is stored
+type EmailVerification struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// AccountDeletion encapsulates the progress of deleting an account across every service
+// It outlives the auth it refers to, such that the deletion can be retried until every service has completed it
+type AccountDeletion struct {
+	AuthID         uuid.UUID
+	UserDeleted    bool
+	MatchesDeleted bool
+	Attempts       int
+	LastError      string
+	CreatedAt      time.Time
+}
+
+// LoginAttempt encapsulates the recent failed login attempts for a single email or client IP
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// TwoFactor encapsulates the TOTP secret of an auth, which is only enforced once enabled
+// The last used time step is recorded, such that each code can only be used once
+type TwoFactor struct {
+	AuthID       uuid.UUID
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+// TwoFactorChallenge encapsulates a login awaiting a second factor, of which only the token hash is stored
+type TwoFactorChallenge struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	TokenHash string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+// Signing key states, through which each key moves in order
+const (
+	// SigningKeyActive keys sign new access tokens, of which there is only ever one
+	SigningKeyActive = "active"
+	// SigningKeyVerification keys no longer sign access tokens, but still verify those they signed until they expire
+	SigningKeyVerification = "verification"
+	// SigningKeyRetired keys no longer verify access tokens, and their private key is discarded
+	SigningKeyRetired = "retired"
+)
+
+// SigningKey encapsulates a key pair used to sign access tokens, with its private key PEM-encoded
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey string
+	State      string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// AdminAction encapsulates the audit record of an action taken by an admin against an auth
+// Records are kept after the auth is deleted, so neither ID references the auth table
+type AdminAction struct {
+	ID        uuid.UUID
+	AdminID   uuid.UUID
+	AuthID    uuid.UUID
+	Action    string
+	Detail    string
+	CreatedAt time.Time
+}
+
+// OIDCLogin encapsulates a login that has been sent to an identity provider, awaiting its return to the callback
+// Only a hash of the state is stored, alongside the nonce and PKCE code verifier needed to complete the login
+type OIDCLogin struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// Identity encapsulates the link between an auth and the subject that identifies it at an identity provider
+type Identity struct {
+	Provider  string
+	Subject   string
+	AuthID    uuid.UUID
+	CreatedAt time.Time
+}
+
+// MagicLink encapsulates a passwordless login token stored in the datastore
+// As with refresh tokens, only a hash of the token is stored
+type MagicLink struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// APIKey encapsulates a long-lived credential that acts on behalf of an auth, limited to its scopes
+// Only a hash of the key is stored, alongside its first few characters such that the auth can tell their keys apart
+type APIKey struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	Name      string
+	Prefix    string
+	KeyHash   string
+	Scopes    []string
+	CreatedAt time.Time
+}
+
+// Session encapsulates a single login, sharing its ID with the family of refresh tokens it was issued
+// The device it was last used from is updated on every refresh
+type Session struct {
+	ID         uuid.UUID
+	AuthID     uuid.UUID
+	IPAddress  string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// LoginEvent encapsulates the audit record of an attempt to register, login or refresh
+// Records are kept after the auth is deleted, so the ID does not reference the auth table, and is nil if the attempt
+// did not identify an auth
+type LoginEvent struct {
+	ID        uuid.UUID
+	AuthID    *uuid.UUID
+	Email     string
+	Event     string
+	Outcome   string
+	IPAddress string
+	UserAgent string
+	CreatedAt time.Time
 }
 
 // CreateAuthInput encapsulates the information required to create a single auth in the datastore
@@ -44,6 +298,426 @@ type ReadAuthInput struct {
 	Email string
 }
 
+// CreateRefreshTokenInput encapsulates the information required to create a single refresh token in the datastore
+type CreateRefreshTokenInput struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	FamilyID  uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+// ReadRefreshTokenInput encapsulates the information required to read a single refresh token in the datastore
+type ReadRefreshTokenInput struct {
+	TokenHash string
+}
+
+// RevokeRefreshTokenInput encapsulates the information required to revoke a single refresh token in the datastore
+type RevokeRefreshTokenInput struct {
+	ID uuid.UUID
+}
+
+// RevokeRefreshTokenFamilyInput encapsulates the information required to revoke every refresh token descended from the same login
+type RevokeRefreshTokenFamilyInput struct {
+	FamilyID uuid.UUID
+}
+
+// RevokeAuthRefreshTokensInput encapsulates the information required to revoke every refresh token belonging to a single auth
+type RevokeAuthRefreshTokensInput struct {
+	AuthID uuid.UUID
+}
+
+// CreateRevokedTokenInput encapsulates the information required to revoke a single access token in the datastore
+type CreateRevokedTokenInput struct {
+	JTI       string
+	AuthID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+// ReadRevokedTokenInput encapsulates the information required to read a single revoked access token in the datastore
+type ReadRevokedTokenInput struct {
+	JTI string
+}
+
+// ListTokenCutoffInput encapsulates the information required to list the token cutoffs in the datastore that are later
+// than a given time
+type ListTokenCutoffInput struct {
+	After time.Time
+}
+
+// InvalidateAuthCredentialsInput encapsulates the information required to invalidate every credential of a single auth
+// in the datastore, revoking every access token issued before the given time
+// The admin action that invalidated them is recorded alongside, if there is one
+type InvalidateAuthCredentialsInput struct {
+	AuthID        uuid.UUID
+	RevokedBefore time.Time
+	AdminAction   *CreateAdminActionInput
+}
+
+// UpdateAuthPasswordInput encapsulates the information required to update the password of a single auth in the datastore
+// Every credential issued under the old password is invalidated, as with InvalidateAuthCredentialsInput
+type UpdateAuthPasswordInput struct {
+	ID            uuid.UUID
+	Password      string
+	RevokedBefore time.Time
+	AdminAction   *CreateAdminActionInput
+}
+
+// RehashAuthPasswordInput encapsulates the information required to replace the password hash of a single auth in the
+// datastore, provided it has not changed since it was read
+type RehashAuthPasswordInput struct {
+	ID              uuid.UUID
+	CurrentPassword string
+	Password        string
+}
+
+// CreatePasswordResetInput encapsulates the information required to create a single password reset token in the datastore
+type CreatePasswordResetInput struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// ReadPasswordResetInput encapsulates the information required to read a single password reset token in the datastore
+type ReadPasswordResetInput struct {
+	TokenHash string
+}
+
+// ReadLatestPasswordResetInput encapsulates the information required to read the most recent password reset token belonging to a single auth
+type ReadLatestPasswordResetInput struct {
+	AuthID uuid.UUID
+}
+
+// DeletePasswordResetInput encapsulates the information required to delete a single password reset token in the datastore
+type DeletePasswordResetInput struct {
+	ID uuid.UUID
+}
+
+// ReadAuthByIDInput encapsulates the information required to read a single auth in the datastore by its ID
+type ReadAuthByIDInput struct {
+	ID uuid.UUID
+}
+
+// VerifyAuthEmailInput encapsulates the information required to mark the email of a single auth as verified in the datastore
+type VerifyAuthEmailInput struct {
+	ID uuid.UUID
+}
+
+// CreateEmailVerificationInput encapsulates the information required to create a single email verification token in the datastore
+type CreateEmailVerificationInput struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// ReadEmailVerificationInput encapsulates the information required to read a single email verification token in the datastore
+type ReadEmailVerificationInput struct {
+	TokenHash string
+}
+
+// ReadLatestEmailVerificationInput encapsulates the information required to read the most recent email verification token belonging to a single auth
+type ReadLatestEmailVerificationInput struct {
+	AuthID uuid.UUID
+}
+
+// DeleteAuthEmailVerificationsInput encapsulates the information required to delete every email verification token belonging to a single auth
+type DeleteAuthEmailVerificationsInput struct {
+	AuthID uuid.UUID
+}
+
+// UpdateAuthEmailInput encapsulates the information required to update the email of a single auth in the datastore
+type UpdateAuthEmailInput struct {
+	ID    uuid.UUID
+	Email string
+}
+
+// UpdateAuthRoleInput encapsulates the information required to change the role of a single auth in the datastore, along
+// with the admin action that did so
+// Every token issued before the admin action is revoked, such that none continue to carry the previous role
+type UpdateAuthRoleInput struct {
+	ID          uuid.UUID
+	Role        string
+	AdminAction CreateAdminActionInput
+}
+
+// CreateAccountDeletionInput encapsulates the information required to begin deleting a single account in the datastore
+type CreateAccountDeletionInput struct {
+	AuthID    uuid.UUID
+	CreatedAt time.Time
+}
+
+// UpdateAccountDeletionInput encapsulates the information required to record the progress of deleting a single account in the datastore
+type UpdateAccountDeletionInput struct {
+	AuthID         uuid.UUID
+	UserDeleted    bool
+	MatchesDeleted bool
+	Attempts       int
+	LastError      string
+}
+
+// DeleteCompletedAccountDeletionsInput encapsulates the information required to forget every completed account deletion begun before a given time
+type DeleteCompletedAccountDeletionsInput struct {
+	CreatedBefore time.Time
+}
+
+// ReadLoginAttemptInput encapsulates the information required to read the failed login attempts for a single key
+type ReadLoginAttemptInput struct {
+	Key string
+}
+
+// RecordFailedLoginInput encapsulates the information required to record a failed login attempt for a single key
+// Failures before the start of the window are forgotten
+type RecordFailedLoginInput struct {
+	Key         string
+	FailedAt    time.Time
+	WindowStart time.Time
+}
+
+// LockLoginAttemptInput encapsulates the information required to lock out a single key
+type LockLoginAttemptInput struct {
+	Key         string
+	LockedUntil time.Time
+}
+
+// DeleteLoginAttemptInput encapsulates the information required to forget the failed login attempts for a single key
+type DeleteLoginAttemptInput struct {
+	Key string
+}
+
+// CreateTwoFactorInput encapsulates the information required to begin enrolling a single auth in two-factor authentication
+type CreateTwoFactorInput struct {
+	AuthID    uuid.UUID
+	Secret    string
+	CreatedAt time.Time
+}
+
+// ReadTwoFactorInput encapsulates the information required to read the two-factor authentication of a single auth
+type ReadTwoFactorInput struct {
+	AuthID uuid.UUID
+}
+
+// EnableTwoFactorInput encapsulates the information required to enable two-factor authentication for a single auth,
+// replacing any existing recovery codes
+type EnableTwoFactorInput struct {
+	AuthID             uuid.UUID
+	Step               int64
+	RecoveryCodeHashes []string
+}
+
+// UseTwoFactorStepInput encapsulates the information required to use the TOTP code of a single time step
+type UseTwoFactorStepInput struct {
+	AuthID uuid.UUID
+	Step   int64
+}
+
+// DeleteTwoFactorInput encapsulates the information required to disable two-factor authentication for a single auth
+type DeleteTwoFactorInput struct {
+	AuthID uuid.UUID
+}
+
+// DeleteRecoveryCodeInput encapsulates the information required to use a single recovery code
+type DeleteRecoveryCodeInput struct {
+	AuthID   uuid.UUID
+	CodeHash string
+}
+
+// CreateTwoFactorChallengeInput encapsulates the information required to create a single two-factor challenge in the datastore
+type CreateTwoFactorChallengeInput struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+// ReadTwoFactorChallengeInput encapsulates the information required to read a single two-factor challenge by its token hash
+type ReadTwoFactorChallengeInput struct {
+	TokenHash string
+}
+
+// RecordTwoFactorChallengeFailureInput encapsulates the information required to record a failed attempt at a single two-factor challenge
+type RecordTwoFactorChallengeFailureInput struct {
+	ID uuid.UUID
+}
+
+// DeleteTwoFactorChallengeInput encapsulates the information required to delete a single two-factor challenge
+type DeleteTwoFactorChallengeInput struct {
+	ID uuid.UUID
+}
+
+// CreateSigningKeyInput encapsulates the information required to store a new active signing key in the datastore,
+// demoting the key it replaces to verification-only
+type CreateSigningKeyInput struct {
+	ID         string
+	Algorithm  string
+	PrivateKey string
+	CreatedAt  time.Time
+	ReplaceID  string
+}
+
+// RetireSigningKeyInput encapsulates the information required to retire a single verification-only signing key
+type RetireSigningKeyInput struct {
+	ID        string
+	RetiredAt time.Time
+}
+
+// ListAuthInput encapsulates the information required to list a page of auths in the datastore, ordered by email
+// An empty email lists every auth, otherwise only those whose email contains it, ignoring case
+type ListAuthInput struct {
+	Email  string
+	Limit  int
+	Offset int
+}
+
+// UpdateAuthSuspendedInput encapsulates the information required to suspend or unsuspend a single auth in the datastore,
+// along with the admin action that did so
+// Suspending an auth invalidates every credential issued before the admin action
+type UpdateAuthSuspendedInput struct {
+	ID          uuid.UUID
+	Suspended   bool
+	AdminAction CreateAdminActionInput
+}
+
+// CreateAdminActionInput encapsulates the information required to record a single admin action in the datastore
+type CreateAdminActionInput struct {
+	ID        uuid.UUID
+	AdminID   uuid.UUID
+	AuthID    uuid.UUID
+	Action    string
+	Detail    string
+	CreatedAt time.Time
+}
+
+// ListAdminActionInput encapsulates the information required to list a page of admin actions in the datastore, most recent first
+// A nil auth ID lists the actions against every auth
+type ListAdminActionInput struct {
+	AuthID *uuid.UUID
+	Limit  int
+	Offset int
+}
+
+// CreateOIDCLoginInput encapsulates the information required to store a single login sent to an identity provider
+type CreateOIDCLoginInput struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// UseOIDCLoginInput encapsulates the information required to complete a single login returning from an identity provider
+type UseOIDCLoginInput struct {
+	StateHash string
+}
+
+// ReadIdentityInput encapsulates the information required to read the identity linked to a subject at an identity provider
+type ReadIdentityInput struct {
+	Provider string
+	Subject  string
+}
+
+// CreateAuthWithIdentityInput encapsulates the information required to create a single auth in the datastore, linked to
+// the subject that identifies it at an identity provider
+type CreateAuthWithIdentityInput struct {
+	ID            uuid.UUID
+	Email         string
+	Password      string
+	EmailVerified bool
+	Provider      string
+	Subject       string
+	CreatedAt     time.Time
+}
+
+// CreateMagicLinkInput encapsulates the information required to create a single magic link token in the datastore
+type CreateMagicLinkInput struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// ReadLatestMagicLinkInput encapsulates the information required to read the most recent magic link token belonging to a single auth
+type ReadLatestMagicLinkInput struct {
+	AuthID uuid.UUID
+}
+
+// UseMagicLinkInput encapsulates the information required to redeem a single magic link token in the datastore
+type UseMagicLinkInput struct {
+	TokenHash string
+}
+
+// CreateAPIKeyInput encapsulates the information required to create a single API key in the datastore
+type CreateAPIKeyInput struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	Name      string
+	Prefix    string
+	KeyHash   string
+	Scopes    []string
+	CreatedAt time.Time
+}
+
+// ListAPIKeyInput encapsulates the information required to list the API keys belonging to a single auth in the datastore
+type ListAPIKeyInput struct {
+	AuthID uuid.UUID
+}
+
+// ReadAPIKeyInput encapsulates the information required to read a single API key by its hash in the datastore
+type ReadAPIKeyInput struct {
+	KeyHash string
+}
+
+// DeleteAPIKeyInput encapsulates the information required to delete a single API key belonging to an auth in the datastore
+type DeleteAPIKeyInput struct {
+	ID     uuid.UUID
+	AuthID uuid.UUID
+}
+
+// CreateSessionInput encapsulates the information required to create a single session in the datastore
+type CreateSessionInput struct {
+	ID        uuid.UUID
+	AuthID    uuid.UUID
+	IPAddress string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+// TouchSessionInput encapsulates the information required to record a session being used in the datastore
+type TouchSessionInput struct {
+	ID         uuid.UUID
+	IPAddress  string
+	UserAgent  string
+	LastUsedAt time.Time
+}
+
+// ListSessionInput encapsulates the information required to list the active sessions of a single auth in the datastore
+type ListSessionInput struct {
+	AuthID uuid.UUID
+	Now    time.Time
+}
+
+// DeleteSessionInput encapsulates the information required to delete a single session belonging to an auth in the datastore
+type DeleteSessionInput struct {
+	ID     uuid.UUID
+	AuthID uuid.UUID
+}
+
+// CreateLoginEventInput encapsulates the information required to record a single attempt to register, login or refresh
+type CreateLoginEventInput struct {
+	ID        uuid.UUID
+	AuthID    *uuid.UUID
+	Email     string
+	Event     string
+	Outcome   string
+	IPAddress string
+	UserAgent string
+	CreatedAt time.Time
+}
+
 // Init opens the datastore connection, returning a DAO
 func Init(config *util.Config) (*DAO, error) {
 	connStr := fmt.Sprintf("user=%s dbname=%s host=%s sslmode=%s", config.User, config.DBName, config.Host, config.SSLMode)
@@ -65,17 +739,22 @@ func executeQuery(db *sql.DB, query string, args ...interface{}) (int64, error)
 	return result.RowsAffected()
 }
 
-// Executes a query, returning the rows
+// Executes a query, returning the row
 func executeQueryWithRowResponse(db *sql.DB, query string, args ...interface{}) *sql.Row {
 	return db.QueryRow(query, args...)
 }
 
+// Executes a query, returning the rows
+func executeQueryWithRowResponses(db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.Query(query, args...)
+}
+
 // CreateAuth creates a new auth in the datastore, returning the newly created auth
 func (dao *DAO) CreateAuth(input CreateAuthInput) (*Auth, error) {
 	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO auth (id, email, password) VALUES ($1, $2, $3) RETURNING *", input.ID, input.Email, input.Password)
 
 	var auth Auth
-	err := row.Scan(&auth.ID, &auth.Email, &auth.Password)
+	err := row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
 	if err != nil {
 		// PQ specific error
 		if err, ok := err.(*pq.Error); ok {
@@ -94,7 +773,7 @@ func (dao *DAO) ReadAuth(input ReadAuthInput) (*Auth, error) {
 	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM auth WHERE email = $1", input.Email)
 
 	var auth Auth
-	err := row.Scan(&auth.ID, &auth.Email, &auth.Password)
+	err := row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -106,3 +785,1318 @@ func (dao *DAO) ReadAuth(input ReadAuthInput) (*Auth, error) {
 
 	return &auth, nil
 }
+
+// CreateRefreshToken creates a new refresh token in the datastore, returning the newly created refresh token
+func (dao *DAO) CreateRefreshToken(input CreateRefreshTokenInput) (*RefreshToken, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO refresh_token (id, auth_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *", input.ID, input.AuthID, input.FamilyID, input.TokenHash, input.ExpiresAt)
+
+	var refreshToken RefreshToken
+	err := row.Scan(&refreshToken.ID, &refreshToken.AuthID, &refreshToken.FamilyID, &refreshToken.TokenHash, &refreshToken.ExpiresAt, &refreshToken.Revoked, &refreshToken.Rotated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &refreshToken, nil
+}
+
+// ReadRefreshToken returns the refresh token in the datastore for a given hash
+func (dao *DAO) ReadRefreshToken(input ReadRefreshTokenInput) (*RefreshToken, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM refresh_token WHERE token_hash = $1", input.TokenHash)
+
+	var refreshToken RefreshToken
+	err := row.Scan(&refreshToken.ID, &refreshToken.AuthID, &refreshToken.FamilyID, &refreshToken.TokenHash, &refreshToken.ExpiresAt, &refreshToken.Revoked, &refreshToken.Rotated)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrRefreshTokenNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &refreshToken, nil
+}
+
+// RevokeRefreshToken revokes a single refresh token in the datastore as it is rotated, marking it as exchanged
+// The update is conditional on the token not already being revoked, so that two concurrent rotations of the same token cannot both succeed
+// A token that was already exchanged is distinguished from one revoked for any other reason, as only the former has been reused
+func (dao *DAO) RevokeRefreshToken(input RevokeRefreshTokenInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "UPDATE refresh_token SET revoked = TRUE, rotated = TRUE WHERE id = $1 AND revoked = FALSE", input.ID)
+	if err != nil {
+		return err
+	} else if rowsAffected > 0 {
+		return nil
+	}
+
+	row := executeQueryWithRowResponse(dao.DB, "SELECT rotated FROM refresh_token WHERE id = $1", input.ID)
+
+	var rotated bool
+	err = row.Scan(&rotated)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return ErrRefreshTokenNotFound
+		default:
+			return err
+		}
+	}
+
+	if rotated {
+		return ErrRefreshTokenReused
+	}
+	return ErrRefreshTokenRevoked
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token in the datastore belonging to a given family
+func (dao *DAO) RevokeRefreshTokenFamily(input RevokeRefreshTokenFamilyInput) error {
+	_, err := executeQuery(dao.DB, "UPDATE refresh_token SET revoked = TRUE WHERE family_id = $1", input.FamilyID)
+	return err
+}
+
+// RevokeAuthRefreshTokens revokes every refresh token in the datastore belonging to a given auth
+func (dao *DAO) RevokeAuthRefreshTokens(input RevokeAuthRefreshTokensInput) error {
+	_, err := executeQuery(dao.DB, "UPDATE refresh_token SET revoked = TRUE WHERE auth_id = $1", input.AuthID)
+	return err
+}
+
+// CreateRevokedToken revokes an access token in the datastore, returning the revocation
+// Revoking an already revoked token succeeds, such that logging out is idempotent
+func (dao *DAO) CreateRevokedToken(input CreateRevokedTokenInput) (*RevokedToken, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO revoked_token (jti, auth_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO UPDATE SET jti = EXCLUDED.jti RETURNING *", input.JTI, input.AuthID, input.ExpiresAt)
+
+	var revokedToken RevokedToken
+	err := row.Scan(&revokedToken.JTI, &revokedToken.AuthID, &revokedToken.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revokedToken, nil
+}
+
+// ReadRevokedToken returns the revocation in the datastore for a given access token ID
+func (dao *DAO) ReadRevokedToken(input ReadRevokedTokenInput) (*RevokedToken, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM revoked_token WHERE jti = $1", input.JTI)
+
+	var revokedToken RevokedToken
+	err := row.Scan(&revokedToken.JTI, &revokedToken.AuthID, &revokedToken.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrRevokedTokenNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revokedToken, nil
+}
+
+// ListRevokedToken returns every revocation in the datastore for access tokens that have not yet expired
+func (dao *DAO) ListRevokedToken() (*[]RevokedToken, error) {
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT * FROM revoked_token WHERE expires_at > NOW()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revokedTokenList := make([]RevokedToken, 0)
+	for rows.Next() {
+		var revokedToken RevokedToken
+		err = rows.Scan(&revokedToken.JTI, &revokedToken.AuthID, &revokedToken.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		revokedTokenList = append(revokedTokenList, revokedToken)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &revokedTokenList, nil
+}
+
+// ListTokenCutoff returns every token cutoff in the datastore later than the given time
+// Deleted accounts have no auth to hold a cutoff, so the time each was deleted is used, until its deletion is forgotten
+func (dao *DAO) ListTokenCutoff(input ListTokenCutoffInput) (*[]TokenCutoff, error) {
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT id, tokens_revoked_before FROM auth WHERE tokens_revoked_before > $1 UNION ALL SELECT auth_id, created_at FROM account_deletion WHERE created_at > $1", input.After)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokenCutoffList := make([]TokenCutoff, 0)
+	for rows.Next() {
+		var tokenCutoff TokenCutoff
+		err = rows.Scan(&tokenCutoff.AuthID, &tokenCutoff.RevokedBefore)
+		if err != nil {
+			return nil, err
+		}
+		tokenCutoffList = append(tokenCutoffList, tokenCutoff)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenCutoffList, nil
+}
+
+// InvalidateAuthCredentials revokes every access token issued to an auth before the given time, and every other
+// credential it holds, returning the updated auth
+// The admin action that invalidated them is recorded in the same transaction, if there is one
+func (dao *DAO) InvalidateAuthCredentials(input InvalidateAuthCredentialsInput) (*Auth, error) {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("UPDATE auth SET tokens_revoked_before = GREATEST(tokens_revoked_before, $2) WHERE id = $1 RETURNING *", input.AuthID, input.RevokedBefore)
+
+	var auth Auth
+	err = row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAuthNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = deleteAuthCredentials(tx, auth.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.AdminAction != nil {
+		err = createAdminAction(tx, *input.AdminAction)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
+// Delete every outstanding password reset and magic link token of an auth, and revoke every refresh token, within a
+// transaction
+func deleteAuthCredentials(tx *sql.Tx, authID uuid.UUID) error {
+	_, err := tx.Exec("DELETE FROM password_reset WHERE auth_id = $1", authID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM magic_link WHERE auth_id = $1", authID)
+	if err != nil {
+		return err
+	}
+
+	return revokeAuthRefreshTokens(tx, authID)
+}
+
+// Revoke every refresh token of an auth within a transaction
+func revokeAuthRefreshTokens(tx *sql.Tx, authID uuid.UUID) error {
+	_, err := tx.Exec("UPDATE refresh_token SET revoked = TRUE WHERE auth_id = $1", authID)
+	return err
+}
+
+// Record an admin action within a transaction, such that it is only recorded if the action itself is
+func createAdminAction(tx *sql.Tx, input CreateAdminActionInput) error {
+	_, err := tx.Exec("INSERT INTO admin_action (id, admin_id, auth_id, action, detail, created_at) VALUES ($1, $2, $3, $4, $5, $6)", input.ID, input.AdminID, input.AuthID, input.Action, input.Detail, input.CreatedAt)
+	return err
+}
+
+// UpdateAuthPassword updates the password of an auth in the datastore, returning the updated auth
+// Every credential issued under the old password is invalidated in the same transaction, alongside recording the admin
+// action that updated it, if there is one
+func (dao *DAO) UpdateAuthPassword(input UpdateAuthPasswordInput) (*Auth, error) {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("UPDATE auth SET password = $1, tokens_revoked_before = GREATEST(tokens_revoked_before, $3) WHERE id = $2 RETURNING *", input.Password, input.ID, input.RevokedBefore)
+
+	var auth Auth
+	err = row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAuthNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = deleteAuthCredentials(tx, auth.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.AdminAction != nil {
+		err = createAdminAction(tx, *input.AdminAction)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
+// RehashAuthPassword replaces the password hash of an auth in the datastore, provided it is still the current hash, such
+// that a concurrent password change is never overwritten
+func (dao *DAO) RehashAuthPassword(input RehashAuthPasswordInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "UPDATE auth SET password = $1 WHERE id = $2 AND password = $3", input.Password, input.ID, input.CurrentPassword)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAuthNotFound
+	}
+
+	return nil
+}
+
+// CreatePasswordReset creates a new password reset token in the datastore, returning the newly created password reset token
+func (dao *DAO) CreatePasswordReset(input CreatePasswordResetInput) (*PasswordReset, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO password_reset (id, auth_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *", input.ID, input.AuthID, input.TokenHash, input.CreatedAt, input.ExpiresAt)
+
+	var passwordReset PasswordReset
+	err := row.Scan(&passwordReset.ID, &passwordReset.AuthID, &passwordReset.TokenHash, &passwordReset.CreatedAt, &passwordReset.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passwordReset, nil
+}
+
+// ReadPasswordReset returns the password reset token in the datastore for a given hash
+func (dao *DAO) ReadPasswordReset(input ReadPasswordResetInput) (*PasswordReset, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM password_reset WHERE token_hash = $1", input.TokenHash)
+
+	var passwordReset PasswordReset
+	err := row.Scan(&passwordReset.ID, &passwordReset.AuthID, &passwordReset.TokenHash, &passwordReset.CreatedAt, &passwordReset.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrPasswordResetNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &passwordReset, nil
+}
+
+// ReadLatestPasswordReset returns the most recently created password reset token in the datastore for a given auth
+func (dao *DAO) ReadLatestPasswordReset(input ReadLatestPasswordResetInput) (*PasswordReset, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM password_reset WHERE auth_id = $1 ORDER BY created_at DESC LIMIT 1", input.AuthID)
+
+	var passwordReset PasswordReset
+	err := row.Scan(&passwordReset.ID, &passwordReset.AuthID, &passwordReset.TokenHash, &passwordReset.CreatedAt, &passwordReset.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrPasswordResetNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &passwordReset, nil
+}
+
+// DeletePasswordReset deletes a single password reset token in the datastore
+// Deleting is used to redeem the token, so that two concurrent resets with the same token cannot both succeed
+func (dao *DAO) DeletePasswordReset(input DeletePasswordResetInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "DELETE FROM password_reset WHERE id = $1", input.ID)
+	if err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return ErrPasswordResetNotFound
+	}
+
+	return nil
+}
+
+// ReadAuthByID returns the auth in the datastore for a given ID
+func (dao *DAO) ReadAuthByID(input ReadAuthByIDInput) (*Auth, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM auth WHERE id = $1", input.ID)
+
+	var auth Auth
+	err := row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAuthNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &auth, nil
+}
+
+// VerifyAuthEmail marks the email of an auth in the datastore as verified, returning the updated auth
+func (dao *DAO) VerifyAuthEmail(input VerifyAuthEmailInput) (*Auth, error) {
+	row := executeQueryWithRowResponse(dao.DB, "UPDATE auth SET email_verified = TRUE WHERE id = $1 RETURNING *", input.ID)
+
+	var auth Auth
+	err := row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAuthNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &auth, nil
+}
+
+// CreateEmailVerification creates a new email verification token in the datastore, returning the newly created email verification token
+func (dao *DAO) CreateEmailVerification(input CreateEmailVerificationInput) (*EmailVerification, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO email_verification (id, auth_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *", input.ID, input.AuthID, input.TokenHash, input.CreatedAt, input.ExpiresAt)
+
+	var emailVerification EmailVerification
+	err := row.Scan(&emailVerification.ID, &emailVerification.AuthID, &emailVerification.TokenHash, &emailVerification.CreatedAt, &emailVerification.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &emailVerification, nil
+}
+
+// ReadEmailVerification returns the email verification token in the datastore for a given hash
+func (dao *DAO) ReadEmailVerification(input ReadEmailVerificationInput) (*EmailVerification, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM email_verification WHERE token_hash = $1", input.TokenHash)
+
+	var emailVerification EmailVerification
+	err := row.Scan(&emailVerification.ID, &emailVerification.AuthID, &emailVerification.TokenHash, &emailVerification.CreatedAt, &emailVerification.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrEmailVerificationNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &emailVerification, nil
+}
+
+// ReadLatestEmailVerification returns the most recently created email verification token in the datastore for a given auth
+func (dao *DAO) ReadLatestEmailVerification(input ReadLatestEmailVerificationInput) (*EmailVerification, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM email_verification WHERE auth_id = $1 ORDER BY created_at DESC LIMIT 1", input.AuthID)
+
+	var emailVerification EmailVerification
+	err := row.Scan(&emailVerification.ID, &emailVerification.AuthID, &emailVerification.TokenHash, &emailVerification.CreatedAt, &emailVerification.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrEmailVerificationNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &emailVerification, nil
+}
+
+// DeleteAuthEmailVerifications deletes every email verification token in the datastore belonging to a given auth
+func (dao *DAO) DeleteAuthEmailVerifications(input DeleteAuthEmailVerificationsInput) error {
+	_, err := executeQuery(dao.DB, "DELETE FROM email_verification WHERE auth_id = $1", input.AuthID)
+	return err
+}
+
+// UpdateAuthEmail updates the email of an auth in the datastore, marking it as unverified and returning the updated auth
+func (dao *DAO) UpdateAuthEmail(input UpdateAuthEmailInput) (*Auth, error) {
+	row := executeQueryWithRowResponse(dao.DB, "UPDATE auth SET email = $1, email_verified = FALSE WHERE id = $2 RETURNING *", input.Email, input.ID)
+
+	var auth Auth
+	err := row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+	if err != nil {
+		// PQ specific error
+		if err, ok := err.(*pq.Error); ok {
+			if err.Code.Name() == psqlUniqueViolation {
+				return nil, ErrDuplicateAuth
+			}
+		}
+
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAuthNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &auth, nil
+}
+
+// UpdateAuthRole changes the role of an auth in the datastore, returning the updated auth
+// Every access and refresh token issued before the admin action is revoked, and the admin action recorded, in the same
+// transaction
+func (dao *DAO) UpdateAuthRole(input UpdateAuthRoleInput) (*Auth, error) {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("UPDATE auth SET role = $1, tokens_revoked_before = GREATEST(tokens_revoked_before, $3) WHERE id = $2 RETURNING *", input.Role, input.ID, input.AdminAction.CreatedAt)
+
+	var auth Auth
+	err = row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAuthNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = revokeAuthRefreshTokens(tx, auth.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createAdminAction(tx, input.AdminAction)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
+// CreateAccountDeletion deletes an auth in the datastore, recording that the account must be deleted from every other service
+// Both happen in a single transaction, such that an auth is never deleted without the rest of the account eventually following
+func (dao *DAO) CreateAccountDeletion(input CreateAccountDeletionInput) (*AccountDeletion, error) {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("INSERT INTO account_deletion (auth_id, created_at) VALUES ($1, $2) RETURNING *", input.AuthID, input.CreatedAt)
+
+	var accountDeletion AccountDeletion
+	err = row.Scan(&accountDeletion.AuthID, &accountDeletion.UserDeleted, &accountDeletion.MatchesDeleted, &accountDeletion.Attempts, &accountDeletion.LastError, &accountDeletion.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec("DELETE FROM auth WHERE id = $1", input.AuthID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	} else if rowsAffected == 0 {
+		return nil, ErrAuthNotFound
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountDeletion, nil
+}
+
+// ListAccountDeletion returns every account deletion in the datastore that has not yet completed
+func (dao *DAO) ListAccountDeletion() (*[]AccountDeletion, error) {
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT * FROM account_deletion WHERE NOT (user_deleted AND matches_deleted) ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accountDeletionList := make([]AccountDeletion, 0)
+	for rows.Next() {
+		var accountDeletion AccountDeletion
+		err = rows.Scan(&accountDeletion.AuthID, &accountDeletion.UserDeleted, &accountDeletion.MatchesDeleted, &accountDeletion.Attempts, &accountDeletion.LastError, &accountDeletion.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		accountDeletionList = append(accountDeletionList, accountDeletion)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &accountDeletionList, nil
+}
+
+// UpdateAccountDeletion records the progress of an account deletion in the datastore, returning the updated account deletion
+func (dao *DAO) UpdateAccountDeletion(input UpdateAccountDeletionInput) (*AccountDeletion, error) {
+	row := executeQueryWithRowResponse(dao.DB, "UPDATE account_deletion SET user_deleted = $1, matches_deleted = $2, attempts = $3, last_error = $4 WHERE auth_id = $5 RETURNING *", input.UserDeleted, input.MatchesDeleted, input.Attempts, input.LastError, input.AuthID)
+
+	var accountDeletion AccountDeletion
+	err := row.Scan(&accountDeletion.AuthID, &accountDeletion.UserDeleted, &accountDeletion.MatchesDeleted, &accountDeletion.Attempts, &accountDeletion.LastError, &accountDeletion.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAccountDeletionNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &accountDeletion, nil
+}
+
+// DeleteCompletedAccountDeletions removes every completed account deletion from the datastore that was begun before the
+// given time
+func (dao *DAO) DeleteCompletedAccountDeletions(input DeleteCompletedAccountDeletionsInput) error {
+	_, err := executeQuery(dao.DB, "DELETE FROM account_deletion WHERE user_deleted AND matches_deleted AND created_at < $1", input.CreatedBefore)
+	return err
+}
+
+// ReadLoginAttempt returns the failed login attempts in the datastore for a given key
+func (dao *DAO) ReadLoginAttempt(input ReadLoginAttemptInput) (*LoginAttempt, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM login_attempt WHERE key = $1", input.Key)
+
+	var loginAttempt LoginAttempt
+	err := row.Scan(&loginAttempt.Key, &loginAttempt.Failures, &loginAttempt.LastFailure, &loginAttempt.LockedUntil)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrLoginAttemptNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &loginAttempt, nil
+}
+
+// RecordFailedLogin records a failed login attempt in the datastore for a given key, returning the updated failed login attempts
+// The count is incremented atomically, such that concurrent failures are all counted
+func (dao *DAO) RecordFailedLogin(input RecordFailedLoginInput) (*LoginAttempt, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO login_attempt (key, failures, last_failure, locked_until) VALUES ($1, 1, $2, $2) ON CONFLICT (key) DO UPDATE SET failures = CASE WHEN GREATEST(login_attempt.last_failure, login_attempt.locked_until) < $3 THEN 1 ELSE login_attempt.failures + 1 END, last_failure = $2 RETURNING *", input.Key, input.FailedAt, input.WindowStart)
+
+	var loginAttempt LoginAttempt
+	err := row.Scan(&loginAttempt.Key, &loginAttempt.Failures, &loginAttempt.LastFailure, &loginAttempt.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loginAttempt, nil
+}
+
+// LockLoginAttempt locks out a given key in the datastore until the given time
+func (dao *DAO) LockLoginAttempt(input LockLoginAttemptInput) error {
+	_, err := executeQuery(dao.DB, "UPDATE login_attempt SET locked_until = $1 WHERE key = $2", input.LockedUntil, input.Key)
+	return err
+}
+
+// DeleteLoginAttempt forgets the failed login attempts in the datastore for a given key
+func (dao *DAO) DeleteLoginAttempt(input DeleteLoginAttemptInput) error {
+	_, err := executeQuery(dao.DB, "DELETE FROM login_attempt WHERE key = $1", input.Key)
+	return err
+}
+
+// CreateTwoFactor stores a new TOTP secret in the datastore for a given auth, replacing any that has not yet been enabled
+func (dao *DAO) CreateTwoFactor(input CreateTwoFactorInput) (*TwoFactor, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO two_factor (auth_id, secret, created_at) VALUES ($1, $2, $3) ON CONFLICT (auth_id) DO UPDATE SET secret = $2, created_at = $3 WHERE two_factor.enabled = FALSE RETURNING *", input.AuthID, input.Secret, input.CreatedAt)
+
+	var twoFactor TwoFactor
+	err := row.Scan(&twoFactor.AuthID, &twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastUsedStep, &twoFactor.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrTwoFactorEnabled
+		default:
+			return nil, err
+		}
+	}
+
+	return &twoFactor, nil
+}
+
+// ReadTwoFactor returns the two-factor authentication in the datastore for a given auth
+func (dao *DAO) ReadTwoFactor(input ReadTwoFactorInput) (*TwoFactor, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM two_factor WHERE auth_id = $1", input.AuthID)
+
+	var twoFactor TwoFactor
+	err := row.Scan(&twoFactor.AuthID, &twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastUsedStep, &twoFactor.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrTwoFactorNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &twoFactor, nil
+}
+
+// EnableTwoFactor enables two-factor authentication in the datastore for a given auth, storing its recovery codes
+func (dao *DAO) EnableTwoFactor(input EnableTwoFactorInput) (*TwoFactor, error) {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("UPDATE two_factor SET enabled = TRUE, last_used_step = $1 WHERE auth_id = $2 AND enabled = FALSE RETURNING *", input.Step, input.AuthID)
+
+	var twoFactor TwoFactor
+	err = row.Scan(&twoFactor.AuthID, &twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastUsedStep, &twoFactor.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrTwoFactorNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_code WHERE auth_id = $1", input.AuthID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, codeHash := range input.RecoveryCodeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_code (auth_id, code_hash) VALUES ($1, $2)", input.AuthID, codeHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &twoFactor, nil
+}
+
+// UseTwoFactorStep records the TOTP code of a given time step as used in the datastore, provided no code from the
+// same or a later time step has already been used
+func (dao *DAO) UseTwoFactorStep(input UseTwoFactorStepInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "UPDATE two_factor SET last_used_step = $1 WHERE auth_id = $2 AND enabled = TRUE AND last_used_step < $1", input.Step, input.AuthID)
+	if err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return ErrTwoFactorStepUsed
+	}
+
+	return nil
+}
+
+// DeleteTwoFactor disables two-factor authentication in the datastore for a given auth, deleting its recovery codes
+func (dao *DAO) DeleteTwoFactor(input DeleteTwoFactorInput) error {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_code WHERE auth_id = $1", input.AuthID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM two_factor WHERE auth_id = $1", input.AuthID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return ErrTwoFactorNotFound
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRecoveryCode uses a recovery code in the datastore for a given auth, such that it cannot be used again
+func (dao *DAO) DeleteRecoveryCode(input DeleteRecoveryCodeInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "DELETE FROM recovery_code WHERE auth_id = $1 AND code_hash = $2", input.AuthID, input.CodeHash)
+	if err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+
+	return nil
+}
+
+// CreateTwoFactorChallenge stores a new two-factor challenge in the datastore
+func (dao *DAO) CreateTwoFactorChallenge(input CreateTwoFactorChallengeInput) (*TwoFactorChallenge, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO two_factor_challenge (id, auth_id, token_hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING *", input.ID, input.AuthID, input.TokenHash, input.ExpiresAt)
+
+	var twoFactorChallenge TwoFactorChallenge
+	err := row.Scan(&twoFactorChallenge.ID, &twoFactorChallenge.AuthID, &twoFactorChallenge.TokenHash, &twoFactorChallenge.Attempts, &twoFactorChallenge.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &twoFactorChallenge, nil
+}
+
+// ReadTwoFactorChallenge returns the two-factor challenge in the datastore for a given token hash
+func (dao *DAO) ReadTwoFactorChallenge(input ReadTwoFactorChallengeInput) (*TwoFactorChallenge, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM two_factor_challenge WHERE token_hash = $1", input.TokenHash)
+
+	var twoFactorChallenge TwoFactorChallenge
+	err := row.Scan(&twoFactorChallenge.ID, &twoFactorChallenge.AuthID, &twoFactorChallenge.TokenHash, &twoFactorChallenge.Attempts, &twoFactorChallenge.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrTwoFactorChallengeNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &twoFactorChallenge, nil
+}
+
+// RecordTwoFactorChallengeFailure records a failed attempt at a two-factor challenge in the datastore, returning the updated challenge
+func (dao *DAO) RecordTwoFactorChallengeFailure(input RecordTwoFactorChallengeFailureInput) (*TwoFactorChallenge, error) {
+	row := executeQueryWithRowResponse(dao.DB, "UPDATE two_factor_challenge SET attempts = attempts + 1 WHERE id = $1 RETURNING *", input.ID)
+
+	var twoFactorChallenge TwoFactorChallenge
+	err := row.Scan(&twoFactorChallenge.ID, &twoFactorChallenge.AuthID, &twoFactorChallenge.TokenHash, &twoFactorChallenge.Attempts, &twoFactorChallenge.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrTwoFactorChallengeNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &twoFactorChallenge, nil
+}
+
+// DeleteTwoFactorChallenge deletes a two-factor challenge in the datastore, such that it can only be redeemed once
+func (dao *DAO) DeleteTwoFactorChallenge(input DeleteTwoFactorChallengeInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "DELETE FROM two_factor_challenge WHERE id = $1", input.ID)
+	if err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return ErrTwoFactorChallengeNotFound
+	}
+
+	return nil
+}
+
+// CreateSigningKey stores a new active signing key in the datastore, demoting the key it replaces to verification-only
+// If the key being replaced is no longer active, or there is already an active key when none is being replaced, another
+// instance has rotated the keys first
+func (dao *DAO) CreateSigningKey(input CreateSigningKeyInput) (*SigningKey, error) {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if input.ReplaceID != "" {
+		result, err := tx.Exec("UPDATE signing_key SET state = $1, updated_at = $2 WHERE id = $3 AND state = $4", SigningKeyVerification, input.CreatedAt, input.ReplaceID, SigningKeyActive)
+		if err != nil {
+			return nil, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		} else if rowsAffected == 0 {
+			return nil, ErrSigningKeyRotated
+		}
+	}
+
+	row := tx.QueryRow("INSERT INTO signing_key (id, algorithm, private_key, state, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5) RETURNING *", input.ID, input.Algorithm, input.PrivateKey, SigningKeyActive, input.CreatedAt)
+
+	var signingKey SigningKey
+	err = row.Scan(&signingKey.ID, &signingKey.Algorithm, &signingKey.PrivateKey, &signingKey.State, &signingKey.CreatedAt, &signingKey.UpdatedAt)
+	if err != nil {
+		if err, ok := err.(*pq.Error); ok && err.Code.Name() == psqlUniqueViolation {
+			return nil, ErrSigningKeyRotated
+		}
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey, nil
+}
+
+// ListSigningKey returns every signing key in the datastore that has not been retired
+func (dao *DAO) ListSigningKey() (*[]SigningKey, error) {
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT * FROM signing_key WHERE state != $1 ORDER BY created_at", SigningKeyRetired)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	signingKeyList := make([]SigningKey, 0)
+	for rows.Next() {
+		var signingKey SigningKey
+		err = rows.Scan(&signingKey.ID, &signingKey.Algorithm, &signingKey.PrivateKey, &signingKey.State, &signingKey.CreatedAt, &signingKey.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		signingKeyList = append(signingKeyList, signingKey)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKeyList, nil
+}
+
+// RetireSigningKey retires a verification-only signing key in the datastore, discarding its private key
+func (dao *DAO) RetireSigningKey(input RetireSigningKeyInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "UPDATE signing_key SET state = $1, private_key = '', updated_at = $2 WHERE id = $3 AND state = $4", SigningKeyRetired, input.RetiredAt, input.ID, SigningKeyVerification)
+	if err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return ErrSigningKeyNotFound
+	}
+
+	return nil
+}
+
+// ListAuth returns a page of auths in the datastore, ordered by email
+func (dao *DAO) ListAuth(input ListAuthInput) (*[]Auth, error) {
+	// Escape the wildcards of LIKE, such that the email is matched literally
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(input.Email) + "%"
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT * FROM auth WHERE email ILIKE $1 ORDER BY email LIMIT $2 OFFSET $3", pattern, input.Limit, input.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authList := make([]Auth, 0)
+	for rows.Next() {
+		var auth Auth
+		err = rows.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+		if err != nil {
+			return nil, err
+		}
+		authList = append(authList, auth)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &authList, nil
+}
+
+// UpdateAuthSuspended suspends or unsuspends an auth in the datastore, returning the updated auth
+// Suspending an auth also invalidates every credential issued before the admin action, which is recorded in the same
+// transaction
+func (dao *DAO) UpdateAuthSuspended(input UpdateAuthSuspendedInput) (*Auth, error) {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("UPDATE auth SET suspended = $1, tokens_revoked_before = CASE WHEN $1 THEN GREATEST(tokens_revoked_before, $3) ELSE tokens_revoked_before END WHERE id = $2 RETURNING *", input.Suspended, input.ID, input.AdminAction.CreatedAt)
+
+	var auth Auth
+	err = row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAuthNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if input.Suspended {
+		err = deleteAuthCredentials(tx, auth.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = createAdminAction(tx, input.AdminAction)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
+// ListAdminAction returns a page of admin actions in the datastore, most recent first
+func (dao *DAO) ListAdminAction(input ListAdminActionInput) (*[]AdminAction, error) {
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT * FROM admin_action WHERE $1::UUID IS NULL OR auth_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3", input.AuthID, input.Limit, input.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adminActionList := make([]AdminAction, 0)
+	for rows.Next() {
+		var adminAction AdminAction
+		err = rows.Scan(&adminAction.ID, &adminAction.AdminID, &adminAction.AuthID, &adminAction.Action, &adminAction.Detail, &adminAction.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		adminActionList = append(adminActionList, adminAction)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &adminActionList, nil
+}
+
+// CreateOIDCLogin stores a login sent to an identity provider in the datastore
+func (dao *DAO) CreateOIDCLogin(input CreateOIDCLoginInput) (*OIDCLogin, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO oidc_login (state_hash, provider, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *", input.StateHash, input.Provider, input.Nonce, input.CodeVerifier, input.ExpiresAt)
+
+	var oidcLogin OIDCLogin
+	err := row.Scan(&oidcLogin.StateHash, &oidcLogin.Provider, &oidcLogin.Nonce, &oidcLogin.CodeVerifier, &oidcLogin.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcLogin, nil
+}
+
+// UseOIDCLogin deletes and returns the login in the datastore for a given state hash, such that it can only be completed once
+func (dao *DAO) UseOIDCLogin(input UseOIDCLoginInput) (*OIDCLogin, error) {
+	row := executeQueryWithRowResponse(dao.DB, "DELETE FROM oidc_login WHERE state_hash = $1 RETURNING *", input.StateHash)
+
+	var oidcLogin OIDCLogin
+	err := row.Scan(&oidcLogin.StateHash, &oidcLogin.Provider, &oidcLogin.Nonce, &oidcLogin.CodeVerifier, &oidcLogin.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrOIDCLoginNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &oidcLogin, nil
+}
+
+// ReadIdentity returns the identity in the datastore for a given subject at an identity provider
+func (dao *DAO) ReadIdentity(input ReadIdentityInput) (*Identity, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM auth_identity WHERE provider = $1 AND subject = $2", input.Provider, input.Subject)
+
+	var identity Identity
+	err := row.Scan(&identity.Provider, &identity.Subject, &identity.AuthID, &identity.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrIdentityNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &identity, nil
+}
+
+// CreateAuthWithIdentity creates a new auth in the datastore linked to a subject at an identity provider, returning the
+// newly created auth
+func (dao *DAO) CreateAuthWithIdentity(input CreateAuthWithIdentityInput) (*Auth, error) {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow("INSERT INTO auth (id, email, password, email_verified) VALUES ($1, $2, $3, $4) RETURNING *", input.ID, input.Email, input.Password, input.EmailVerified)
+
+	var auth Auth
+	err = row.Scan(&auth.ID, &auth.Email, &auth.Password, &auth.EmailVerified, &auth.Role, &auth.Suspended, &auth.TokensRevokedBefore)
+	if err != nil {
+		// PQ specific error
+		if err, ok := err.(*pq.Error); ok {
+			if err.Code.Name() == psqlUniqueViolation {
+				return nil, ErrDuplicateAuth
+			}
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec("INSERT INTO auth_identity (provider, subject, auth_id, created_at) VALUES ($1, $2, $3, $4)", input.Provider, input.Subject, input.ID, input.CreatedAt)
+	if err != nil {
+		// PQ specific error
+		if err, ok := err.(*pq.Error); ok {
+			if err.Code.Name() == psqlUniqueViolation {
+				return nil, ErrDuplicateIdentity
+			}
+		}
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth, nil
+}
+
+// CreateMagicLink creates a new magic link token in the datastore, returning the newly created token
+func (dao *DAO) CreateMagicLink(input CreateMagicLinkInput) (*MagicLink, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO magic_link (id, auth_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *", input.ID, input.AuthID, input.TokenHash, input.CreatedAt, input.ExpiresAt)
+
+	var magicLink MagicLink
+	err := row.Scan(&magicLink.ID, &magicLink.AuthID, &magicLink.TokenHash, &magicLink.CreatedAt, &magicLink.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &magicLink, nil
+}
+
+// ReadLatestMagicLink returns the most recently created magic link token in the datastore for a given auth
+func (dao *DAO) ReadLatestMagicLink(input ReadLatestMagicLinkInput) (*MagicLink, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM magic_link WHERE auth_id = $1 ORDER BY created_at DESC LIMIT 1", input.AuthID)
+
+	var magicLink MagicLink
+	err := row.Scan(&magicLink.ID, &magicLink.AuthID, &magicLink.TokenHash, &magicLink.CreatedAt, &magicLink.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrMagicLinkNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &magicLink, nil
+}
+
+// UseMagicLink deletes and returns the magic link token in the datastore for a given hash, such that it can only be redeemed once
+func (dao *DAO) UseMagicLink(input UseMagicLinkInput) (*MagicLink, error) {
+	row := executeQueryWithRowResponse(dao.DB, "DELETE FROM magic_link WHERE token_hash = $1 RETURNING *", input.TokenHash)
+
+	var magicLink MagicLink
+	err := row.Scan(&magicLink.ID, &magicLink.AuthID, &magicLink.TokenHash, &magicLink.CreatedAt, &magicLink.ExpiresAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrMagicLinkNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &magicLink, nil
+}
+
+// CreateAPIKey creates a new API key in the datastore, returning the newly created API key
+func (dao *DAO) CreateAPIKey(input CreateAPIKeyInput) (*APIKey, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO api_key (id, auth_id, name, prefix, key_hash, scopes, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING *", input.ID, input.AuthID, input.Name, input.Prefix, input.KeyHash, pq.Array(input.Scopes), input.CreatedAt)
+
+	var apiKey APIKey
+	err := row.Scan(&apiKey.ID, &apiKey.AuthID, &apiKey.Name, &apiKey.Prefix, &apiKey.KeyHash, pq.Array(&apiKey.Scopes), &apiKey.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+// ListAPIKey returns a list containing every API key in the datastore belonging to a given auth, oldest first
+func (dao *DAO) ListAPIKey(input ListAPIKeyInput) (*[]APIKey, error) {
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT * FROM api_key WHERE auth_id = $1 ORDER BY created_at", input.AuthID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeyList := make([]APIKey, 0)
+	for rows.Next() {
+		var apiKey APIKey
+		err = rows.Scan(&apiKey.ID, &apiKey.AuthID, &apiKey.Name, &apiKey.Prefix, &apiKey.KeyHash, pq.Array(&apiKey.Scopes), &apiKey.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		apiKeyList = append(apiKeyList, apiKey)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKeyList, nil
+}
+
+// ReadAPIKey returns the API key in the datastore for a given hash
+func (dao *DAO) ReadAPIKey(input ReadAPIKeyInput) (*APIKey, error) {
+	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM api_key WHERE key_hash = $1", input.KeyHash)
+
+	var apiKey APIKey
+	err := row.Scan(&apiKey.ID, &apiKey.AuthID, &apiKey.Name, &apiKey.Prefix, &apiKey.KeyHash, pq.Array(&apiKey.Scopes), &apiKey.CreatedAt)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrAPIKeyNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &apiKey, nil
+}
+
+// DeleteAPIKey deletes an API key in the datastore, provided it belongs to the given auth
+func (dao *DAO) DeleteAPIKey(input DeleteAPIKeyInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "DELETE FROM api_key WHERE id = $1 AND auth_id = $2", input.ID, input.AuthID)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// CreateSession creates a new session in the datastore, returning the newly created session
+func (dao *DAO) CreateSession(input CreateSessionInput) (*Session, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO auth_session (id, auth_id, ip_address, user_agent, created_at, last_used_at) VALUES ($1, $2, $3, $4, $5, $5) RETURNING *", input.ID, input.AuthID, input.IPAddress, input.UserAgent, input.CreatedAt)
+
+	var session Session
+	err := row.Scan(&session.ID, &session.AuthID, &session.IPAddress, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// TouchSession records the device a session was last used from, and when, in the datastore
+func (dao *DAO) TouchSession(input TouchSessionInput) error {
+	rowsAffected, err := executeQuery(dao.DB, "UPDATE auth_session SET ip_address = $1, user_agent = $2, last_used_at = $3 WHERE id = $4", input.IPAddress, input.UserAgent, input.LastUsedAt, input.ID)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// ListSession returns the sessions of an auth in the datastore that hold an unrevoked and unexpired refresh token, most
+// recently used first
+func (dao *DAO) ListSession(input ListSessionInput) (*[]Session, error) {
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT * FROM auth_session WHERE auth_id = $1 AND EXISTS (SELECT 1 FROM refresh_token WHERE family_id = auth_session.id AND revoked = FALSE AND expires_at > $2) ORDER BY last_used_at DESC", input.AuthID, input.Now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessionList := make([]Session, 0)
+	for rows.Next() {
+		var session Session
+		err = rows.Scan(&session.ID, &session.AuthID, &session.IPAddress, &session.UserAgent, &session.CreatedAt, &session.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		sessionList = append(sessionList, session)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionList, nil
+}
+
+// DeleteSession deletes a session in the datastore, provided it belongs to the given auth, revoking its refresh tokens
+// Both happen in a single transaction, such that a session is never hidden from its auth while it can still be refreshed
+func (dao *DAO) DeleteSession(input DeleteSessionInput) error {
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM auth_session WHERE id = $1 AND auth_id = $2", input.ID, input.AuthID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if rowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+
+	_, err = tx.Exec("UPDATE refresh_token SET revoked = TRUE WHERE family_id = $1 AND auth_id = $2", input.ID, input.AuthID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateLoginEvent records an attempt to register, login or refresh in the datastore, returning the newly created record
+// Login events are only ever inserted, such that they form an append-only audit trail
+func (dao *DAO) CreateLoginEvent(input CreateLoginEventInput) (*LoginEvent, error) {
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO login_event (id, auth_id, email, event, outcome, ip_address, user_agent, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *", input.ID, input.AuthID, input.Email, input.Event, input.Outcome, input.IPAddress, input.UserAgent, input.CreatedAt)
+
+	var loginEvent LoginEvent
+	err := row.Scan(&loginEvent.ID, &loginEvent.AuthID, &loginEvent.Email, &loginEvent.Event, &loginEvent.Outcome, &loginEvent.IPAddress, &loginEvent.UserAgent, &loginEvent.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loginEvent, nil
+}
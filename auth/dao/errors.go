@@ -9,3 +9,66 @@ var ErrAuthNotFound = errors.New("auth not found")
 
 // ErrDuplicateAuth is returned when an auth already exists
 var ErrDuplicateAuth = errors.New("auth already exists")
+
+// ErrRefreshTokenNotFound is returned when the provided refresh token was not found
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+// ErrRefreshTokenRevoked is returned when the provided refresh token has already been revoked
+var ErrRefreshTokenRevoked = errors.New("refresh token has been revoked")
+
+// ErrRefreshTokenReused is returned when the provided refresh token has already been exchanged for another
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+// ErrRevokedTokenNotFound is returned when the provided access token has not been revoked
+var ErrRevokedTokenNotFound = errors.New("revoked token not found")
+
+// ErrPasswordResetNotFound is returned when the provided password reset token was not found
+var ErrPasswordResetNotFound = errors.New("password reset token not found")
+
+// ErrEmailVerificationNotFound is returned when the provided email verification token was not found
+var ErrEmailVerificationNotFound = errors.New("email verification token not found")
+
+// ErrAccountDeletionNotFound is returned when the provided account is not being deleted
+var ErrAccountDeletionNotFound = errors.New("account deletion not found")
+
+// ErrLoginAttemptNotFound is returned when there are no recent failed login attempts for the provided key
+var ErrLoginAttemptNotFound = errors.New("login attempt not found")
+
+// ErrTwoFactorNotFound is returned when the provided auth has not set up two-factor authentication
+var ErrTwoFactorNotFound = errors.New("two-factor authentication not found")
+
+// ErrTwoFactorEnabled is returned when the provided auth has already enabled two-factor authentication
+var ErrTwoFactorEnabled = errors.New("two-factor authentication already enabled")
+
+// ErrTwoFactorStepUsed is returned when a TOTP code from the provided time step, or a later one, has already been used
+var ErrTwoFactorStepUsed = errors.New("two-factor code already used")
+
+// ErrRecoveryCodeNotFound is returned when the provided recovery code does not exist or has already been used
+var ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+
+// ErrTwoFactorChallengeNotFound is returned when a two-factor challenge for the provided token hash was not found
+var ErrTwoFactorChallengeNotFound = errors.New("two-factor challenge not found")
+
+// ErrSigningKeyRotated is returned when the signing keys have already been rotated by another instance
+var ErrSigningKeyRotated = errors.New("signing key already rotated")
+
+// ErrSigningKeyNotFound is returned when a verification-only signing key with the provided ID was not found
+var ErrSigningKeyNotFound = errors.New("signing key not found")
+
+// ErrOIDCLoginNotFound is returned when a login for the provided state was not found, or has already been completed
+var ErrOIDCLoginNotFound = errors.New("login not found")
+
+// ErrIdentityNotFound is returned when the provided subject is not linked to an auth
+var ErrIdentityNotFound = errors.New("identity not found")
+
+// ErrDuplicateIdentity is returned when the provided subject is already linked to an auth
+var ErrDuplicateIdentity = errors.New("identity already exists")
+
+// ErrMagicLinkNotFound is returned when the provided magic link token was not found, or has already been redeemed
+var ErrMagicLinkNotFound = errors.New("magic link token not found")
+
+// ErrAPIKeyNotFound is returned when the provided API key was not found, or does not belong to the auth
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// ErrSessionNotFound is returned when the provided session was not found, or does not belong to the auth
+var ErrSessionNotFound = errors.New("session not found")
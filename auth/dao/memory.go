@@ -0,0 +1,99 @@
+package dao
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryLoginAttemptStore tracks failed login attempts in memory, for use when running a single instance
+// Attempts are forgotten once their window has passed, such that the store doesn't grow with every key ever seen
+type MemoryLoginAttemptStore struct {
+	mutex         sync.Mutex
+	loginAttempts map[string]LoginAttempt
+	lastPruned    time.Time
+}
+
+// NewMemoryLoginAttemptStore creates an empty MemoryLoginAttemptStore
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		loginAttempts: make(map[string]LoginAttempt),
+	}
+}
+
+// ReadLoginAttempt returns the failed login attempts in memory for a given key
+func (store *MemoryLoginAttemptStore) ReadLoginAttempt(input ReadLoginAttemptInput) (*LoginAttempt, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	loginAttempt, ok := store.loginAttempts[input.Key]
+	if !ok {
+		return nil, ErrLoginAttemptNotFound
+	}
+
+	return &loginAttempt, nil
+}
+
+// RecordFailedLogin records a failed login attempt in memory for a given key, returning the updated failed login attempts
+func (store *MemoryLoginAttemptStore) RecordFailedLogin(input RecordFailedLoginInput) (*LoginAttempt, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// Pruning scans every key, so is done at most once per window
+	if store.lastPruned.Before(input.WindowStart) {
+		for key, loginAttempt := range store.loginAttempts {
+			if lastActive(loginAttempt).Before(input.WindowStart) {
+				delete(store.loginAttempts, key)
+			}
+		}
+		store.lastPruned = input.FailedAt
+	}
+
+	loginAttempt, ok := store.loginAttempts[input.Key]
+	if !ok {
+		loginAttempt = LoginAttempt{
+			Key:         input.Key,
+			LockedUntil: input.FailedAt,
+		}
+	}
+
+	if lastActive(loginAttempt).Before(input.WindowStart) {
+		loginAttempt.Failures = 1
+	} else {
+		loginAttempt.Failures++
+	}
+	loginAttempt.LastFailure = input.FailedAt
+
+	store.loginAttempts[input.Key] = loginAttempt
+	return &loginAttempt, nil
+}
+
+// LockLoginAttempt locks out a given key in memory until the given time
+func (store *MemoryLoginAttemptStore) LockLoginAttempt(input LockLoginAttemptInput) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	loginAttempt, ok := store.loginAttempts[input.Key]
+	if ok {
+		loginAttempt.LockedUntil = input.LockedUntil
+		store.loginAttempts[input.Key] = loginAttempt
+	}
+
+	return nil
+}
+
+// DeleteLoginAttempt forgets the failed login attempts in memory for a given key
+func (store *MemoryLoginAttemptStore) DeleteLoginAttempt(input DeleteLoginAttemptInput) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.loginAttempts, input.Key)
+	return nil
+}
+
+// lastActive returns the time a key last failed to login or was locked out until, whichever is later
+func lastActive(loginAttempt LoginAttempt) time.Time {
+	if loginAttempt.LockedUntil.After(loginAttempt.LastFailure) {
+		return loginAttempt.LockedUntil
+	}
+	return loginAttempt.LastFailure
+}
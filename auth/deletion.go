@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// deleteAccountRequest contains the client-provided password, confirming the account should be deleted
+type deleteAccountRequest struct {
+	Password string `valid:"type(string),required"`
+}
+
+func (env *env) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestDeleteAccount)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDeleteAccount)
+		return
+	}
+
+	var req deleteAccountRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestDeleteAccount)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestDeleteAccount)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDeleteAccount))
+	current, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDeleteAccount)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestDeleteAccount)
+		}
+		return
+	}
+
+	if !verifyPassword(env, w, r, current, req.Password, metric.RequestDeleteAccount) {
+		return
+	}
+
+	input := dao.CreateAccountDeletionInput{
+		AuthID:    auth.ID,
+		CreatedAt: time.Now(),
+	}
+
+	for _, hook := range env.hook.beforeDeleteAccountHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestDeleteAccount)
+			return
+		}
+	}
+
+	// Revoke the access token used, such that it cannot be used to recreate data in other services
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDeleteAccount))
+	_, err = env.dao.CreateRevokedToken(dao.CreateRevokedTokenInput{
+		JTI:       auth.JTI,
+		AuthID:    auth.ID,
+		ExpiresAt: auth.ExpiresAt,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestDeleteAccount)
+		return
+	}
+
+	// Deleting the auth also deletes its refresh tokens, so that no further access tokens can be issued
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDeleteAccount))
+	accountDeletion, err := env.dao.CreateAccountDeletion(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDeleteAccount)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestDeleteAccount)
+		}
+		return
+	}
+
+	// Attempt the rest of the deletion immediately, leaving anything that fails to be retried in the background
+	completed, err := progressAccountDeletion(env, accountDeletion)
+	if err != nil {
+		log.Printf("Could not progress deletion of account %s: %s", accountDeletion.AuthID, err.Error())
+	}
+
+	for _, hook := range env.hook.afterDeleteAccountHooks {
+		err := (*hook)(env, accountDeletion)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestDeleteAccount)
+			return
+		}
+	}
+
+	if !completed {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestDeleteAccount).Inc()
+}
+
+// Delete the account from every service it has not yet been deleted from, recording the progress made
+// Returns true once the account has been deleted from every service
+func progressAccountDeletion(env *env, accountDeletion *dao.AccountDeletion) (bool, error) {
+	// Both services permit the auth service to delete the data of another user, so no token is issued to the deleted auth
+	serviceToken, err := createServiceToken(env, "auth")
+	if err != nil {
+		return false, err
+	}
+
+	input := dao.UpdateAccountDeletionInput{
+		AuthID:         accountDeletion.AuthID,
+		UserDeleted:    accountDeletion.UserDeleted,
+		MatchesDeleted: accountDeletion.MatchesDeleted,
+		Attempts:       accountDeletion.Attempts + 1,
+	}
+
+	if !input.MatchesDeleted {
+		err = env.comm.DeleteUserMatches(accountDeletion.AuthID, serviceToken)
+		if err != nil {
+			metric.AccountDeletionFailure.WithLabelValues("match").Inc()
+			input.LastError = fmt.Sprintf("Could not delete matches: %s", err.Error())
+		} else {
+			input.MatchesDeleted = true
+		}
+	}
+
+	if !input.UserDeleted {
+		err = env.comm.DeleteUser(accountDeletion.AuthID, serviceToken)
+		if err != nil {
+			metric.AccountDeletionFailure.WithLabelValues("user").Inc()
+			input.LastError = fmt.Sprintf("Could not delete user: %s", err.Error())
+		} else {
+			input.UserDeleted = true
+		}
+	}
+
+	// Completed deletions are kept, as they revoke every access token issued to the deleted auth until those expire
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDeleteAccount))
+	_, err = env.dao.UpdateAccountDeletion(input)
+	timer.ObserveDuration()
+	if err != nil {
+		return false, err
+	}
+
+	return input.MatchesDeleted && input.UserDeleted, nil
+}
+
+// Retry every account deletion that has not yet completed, and forget those completed before any access token issued to
+// the deleted auth could still be valid
+func retryAccountDeletions(env *env) {
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDeleteAccount))
+	accountDeletionList, err := env.dao.ListAccountDeletion()
+	timer.ObserveDuration()
+	if err != nil {
+		log.Printf("Could not list account deletions: %s", err.Error())
+		return
+	}
+
+	for _, accountDeletion := range *accountDeletionList {
+		_, err := progressAccountDeletion(env, &accountDeletion)
+		if err != nil {
+			log.Printf("Could not progress deletion of account %s: %s", accountDeletion.AuthID, err.Error())
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDeleteAccount))
+	err = env.dao.DeleteCompletedAccountDeletions(dao.DeleteCompletedAccountDeletionsInput{
+		CreatedBefore: time.Now().Add(-accessTokenLifetime),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		log.Printf("Could not delete completed account deletions: %s", err.Error())
+	}
+}
+
+// Periodically retry every account deletion that has not yet completed, such that partial failures are eventually completed
+func runAccountDeletions(env *env, interval time.Duration) {
+	for range time.Tick(interval) {
+		retryAccountDeletions(env)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Test that deleting an account deletes it from every service, preventing further logins
+func TestDeleteAccountHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	authID := mockEnv.dao.(*mockDAO).authList[0].ID
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodDelete, "/auth/account", `{"password": "BlackcurrantCrush123"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	mockComm := mockEnv.comm.(*mockComm)
+	if len(mockComm.deletedUsers) != 1 || mockComm.deletedUsers[0] != authID {
+		t.Fatalf("User was not deleted: %v", mockComm.deletedUsers)
+	}
+
+	if len(mockComm.deletedMatches) != 1 || mockComm.deletedMatches[0] != authID {
+		t.Fatalf("Matches were not deleted: %v", mockComm.deletedMatches)
+	}
+
+	accountDeletionList := mockEnv.dao.(*mockDAO).accountDeletionList
+	if len(accountDeletionList) != 1 || !accountDeletionList[0].UserDeleted || !accountDeletionList[0].MatchesDeleted {
+		t.Fatalf("Account deletion was not completed: %+v", accountDeletionList)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The access token used has been revoked
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a partially failed account deletion is recorded, and completed when retried
+func TestDeleteAccountHandlerRetriesPartialFailure(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	mockComm := mockEnv.comm.(*mockComm)
+	mockComm.userUnavailable = true
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodDelete, "/auth/account", `{"password": "BlackcurrantCrush123"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	accountDeletionList := mockEnv.dao.(*mockDAO).accountDeletionList
+	if len(accountDeletionList) != 1 {
+		t.Fatalf("Account deletion was not recorded")
+	}
+
+	accountDeletion := accountDeletionList[0]
+	if !accountDeletion.MatchesDeleted || accountDeletion.UserDeleted || accountDeletion.Attempts != 1 || len(accountDeletion.LastError) == 0 {
+		t.Fatalf("Account deletion progress was recorded incorrectly: %+v", accountDeletion)
+	}
+
+	mockComm.userUnavailable = false
+	retryAccountDeletions(&mockEnv)
+
+	accountDeletionList = mockEnv.dao.(*mockDAO).accountDeletionList
+	if len(accountDeletionList) != 1 || !accountDeletionList[0].UserDeleted {
+		t.Fatalf("Account deletion was not completed: %+v", accountDeletionList)
+	}
+
+	if len(mockComm.deletedUsers) != 1 {
+		t.Fatalf("User was not deleted: %v", mockComm.deletedUsers)
+	}
+
+	// Completed steps are not repeated
+	if len(mockComm.deletedMatches) != 1 {
+		t.Fatalf("Matches were deleted more than once: %v", mockComm.deletedMatches)
+	}
+}
+
+// Test that deleting an account revokes the access tokens of every other session, both here and through the revocation
+// feed, until the completed deletion is forgotten once they have all expired
+func TestDeleteAccountHandlerRevokesOtherSessions(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	authID := mockEnv.dao.(*mockDAO).authList[0].ID
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	var otherSession map[string]string
+	decodeBody(t, res.Body.String(), &otherSession)
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodDelete, "/auth/account", `{"password": "BlackcurrantCrush123"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/sessions", "", otherSession["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/revoked", "", issueServiceToken(t, mockEnv))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	var revoked listRevokedResponse
+	decodeBody(t, res.Body.String(), &revoked)
+	if len(revoked.RevokedAuths) != 1 || revoked.RevokedAuths[0].AuthID != authID {
+		t.Fatalf("Revocation feed is incorrect: %+v", revoked.RevokedAuths)
+	}
+
+	// The completed deletion is kept while any access token issued before it may still be valid
+	retryAccountDeletions(&mockEnv)
+	if len(mockEnv.dao.(*mockDAO).accountDeletionList) != 1 {
+		t.Fatalf("Completed account deletion was forgotten too early")
+	}
+
+	mockEnv.dao.(*mockDAO).accountDeletionList[0].CreatedAt = time.Now().Add(-accessTokenLifetime - time.Minute)
+	retryAccountDeletions(&mockEnv)
+	if len(mockEnv.dao.(*mockDAO).accountDeletionList) != 0 {
+		t.Fatalf("Completed account deletion was not forgotten")
+	}
+}
+
+// Test that deleting an account with an incorrect password fails
+func TestDeleteAccountHandlerFailsOnIncorrectPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodDelete, "/auth/account", `{"password": "NotMyPassword"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if len(mockEnv.dao.(*mockDAO).authList) != 1 {
+		t.Fatalf("Auth was deleted")
+	}
+}
+
+// Test that deleting an account without an access token fails
+func TestDeleteAccountHandlerFailsOnNoToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodDelete, "/auth/account", `{"password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
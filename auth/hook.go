@@ -1,15 +1,79 @@
 package main
 
-import "github.com/TempleEight/spec-golang/auth/dao"
+import (
+	"github.com/TempleEight/spec-golang/auth/comm"
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/util"
+)
 
 // Hook allows additional code to be executed before and after every datastore interaction
 // Hooks are executed in the order they are defined, such that if any hook errors, future hooks are not executed and the request is terminated
 type Hook struct {
-	beforeRegisterHooks []*func(env *env, req registerAuthRequest, input *dao.CreateAuthInput) *HookError
-	beforeLoginHooks    []*func(env *env, req loginAuthRequest, input *dao.ReadAuthInput) *HookError
+	beforeRegisterHooks           []*func(env *env, req registerAuthRequest, input *dao.CreateAuthInput) *HookError
+	beforeLoginHooks              []*func(env *env, req loginAuthRequest, input *dao.ReadAuthInput) *HookError
+	beforeRefreshHooks            []*func(env *env, req refreshAuthRequest, input *dao.ReadRefreshTokenInput) *HookError
+	beforeLogoutHooks             []*func(env *env, input *dao.CreateRevokedTokenInput) *HookError
+	beforeListRevokedHooks        []*func(env *env) *HookError
+	beforeForgotPasswordHooks     []*func(env *env, req forgotPasswordRequest, input *dao.ReadAuthInput) *HookError
+	beforeResetPasswordHooks      []*func(env *env, req resetPasswordRequest, input *dao.UpdateAuthPasswordInput) *HookError
+	beforeVerifyEmailHooks        []*func(env *env, req verifyEmailRequest, input *dao.VerifyAuthEmailInput) *HookError
+	beforeResendVerificationHooks []*func(env *env, req resendVerificationRequest, input *dao.ReadAuthInput) *HookError
+	beforeChangePasswordHooks     []*func(env *env, req changePasswordRequest, input *dao.UpdateAuthPasswordInput) *HookError
+	beforeChangeEmailHooks        []*func(env *env, req changeEmailRequest, input *dao.UpdateAuthEmailInput) *HookError
+	beforeDeleteAccountHooks      []*func(env *env, req deleteAccountRequest, input *dao.CreateAccountDeletionInput) *HookError
+	beforeSetupTwoFactorHooks     []*func(env *env, input *dao.CreateTwoFactorInput) *HookError
+	beforeConfirmTwoFactorHooks   []*func(env *env, req confirmTwoFactorRequest, input *dao.EnableTwoFactorInput) *HookError
+	beforeDisableTwoFactorHooks   []*func(env *env, req disableTwoFactorRequest, input *dao.DeleteTwoFactorInput) *HookError
+	beforeVerifyTwoFactorHooks    []*func(env *env, req verifyTwoFactorRequest, input *dao.ReadTwoFactorChallengeInput) *HookError
+	beforeUpdateRoleHooks         []*func(env *env, req updateRoleRequest, input *dao.UpdateAuthRoleInput) *HookError
+	beforeListAccountsHooks       []*func(env *env, input *dao.ListAuthInput) *HookError
+	beforeSuspendAccountHooks     []*func(env *env, input *dao.UpdateAuthSuspendedInput) *HookError
+	beforeUnsuspendAccountHooks   []*func(env *env, input *dao.UpdateAuthSuspendedInput) *HookError
+	beforeForceLogoutHooks        []*func(env *env, auth *dao.Auth) *HookError
+	beforeForcePasswordResetHooks []*func(env *env, auth *dao.Auth) *HookError
+	beforeListAdminActionsHooks   []*func(env *env, input *dao.ListAdminActionInput) *HookError
+	beforeServiceTokenHooks       []*func(env *env, req serviceTokenRequest) *HookError
+	beforeOIDCStartHooks          []*func(env *env, provider string) *HookError
+	beforeOIDCCallbackHooks       []*func(env *env, identity *comm.OIDCIdentity) *HookError
+	beforeCreateAPIKeyHooks       []*func(env *env, req createAPIKeyRequest, input *dao.CreateAPIKeyInput) *HookError
+	beforeListAPIKeysHooks        []*func(env *env, input *dao.ListAPIKeyInput) *HookError
+	beforeRevokeAPIKeyHooks       []*func(env *env, input *dao.DeleteAPIKeyInput) *HookError
+	beforeIntrospectAPIKeyHooks   []*func(env *env, input *dao.ReadAPIKeyInput) *HookError
+	beforeListSessionsHooks       []*func(env *env, input *dao.ListSessionInput) *HookError
+	beforeRevokeSessionHooks      []*func(env *env, input *dao.DeleteSessionInput) *HookError
 
-	afterRegisterHooks []*func(env *env, auth *dao.Auth, accessToken string) *HookError
-	afterLoginHooks    []*func(env *env, auth *dao.Auth, accessToken string) *HookError
+	afterRegisterHooks           []*func(env *env, auth *dao.Auth, accessToken string) *HookError
+	afterLoginHooks              []*func(env *env, auth *dao.Auth, accessToken string) *HookError
+	afterRefreshHooks            []*func(env *env, refreshToken *dao.RefreshToken, accessToken string) *HookError
+	afterLogoutHooks             []*func(env *env, auth *util.Auth) *HookError
+	afterListRevokedHooks        []*func(env *env, revokedTokenList *[]dao.RevokedToken) *HookError
+	afterForgotPasswordHooks     []*func(env *env, auth *dao.Auth) *HookError
+	afterResetPasswordHooks      []*func(env *env, auth *dao.Auth) *HookError
+	afterVerifyEmailHooks        []*func(env *env, auth *dao.Auth) *HookError
+	afterResendVerificationHooks []*func(env *env, auth *dao.Auth) *HookError
+	afterChangePasswordHooks     []*func(env *env, auth *dao.Auth) *HookError
+	afterChangeEmailHooks        []*func(env *env, auth *dao.Auth, previousEmail string) *HookError
+	afterDeleteAccountHooks      []*func(env *env, accountDeletion *dao.AccountDeletion) *HookError
+	afterSetupTwoFactorHooks     []*func(env *env, twoFactor *dao.TwoFactor) *HookError
+	afterConfirmTwoFactorHooks   []*func(env *env, twoFactor *dao.TwoFactor) *HookError
+	afterDisableTwoFactorHooks   []*func(env *env, auth *dao.Auth) *HookError
+	afterVerifyTwoFactorHooks    []*func(env *env, auth *dao.Auth, accessToken string) *HookError
+	afterUpdateRoleHooks         []*func(env *env, auth *dao.Auth) *HookError
+	afterListAccountsHooks       []*func(env *env, authList *[]dao.Auth) *HookError
+	afterSuspendAccountHooks     []*func(env *env, auth *dao.Auth) *HookError
+	afterUnsuspendAccountHooks   []*func(env *env, auth *dao.Auth) *HookError
+	afterForceLogoutHooks        []*func(env *env, auth *dao.Auth) *HookError
+	afterForcePasswordResetHooks []*func(env *env, auth *dao.Auth) *HookError
+	afterListAdminActionsHooks   []*func(env *env, adminActionList *[]dao.AdminAction) *HookError
+	afterServiceTokenHooks       []*func(env *env, service string, accessToken string) *HookError
+	afterOIDCStartHooks          []*func(env *env, provider string, authorizationURL string) *HookError
+	afterOIDCCallbackHooks       []*func(env *env, auth *dao.Auth, accessToken string) *HookError
+	afterCreateAPIKeyHooks       []*func(env *env, apiKey *dao.APIKey) *HookError
+	afterListAPIKeysHooks        []*func(env *env, apiKeyList *[]dao.APIKey) *HookError
+	afterRevokeAPIKeyHooks       []*func(env *env, input *dao.DeleteAPIKeyInput) *HookError
+	afterIntrospectAPIKeyHooks   []*func(env *env, apiKey *dao.APIKey, auth *dao.Auth) *HookError
+	afterListSessionsHooks       []*func(env *env, sessionList *[]dao.Session) *HookError
+	afterRevokeSessionHooks      []*func(env *env, input *dao.DeleteSessionInput) *HookError
 }
 
 // HookError wraps an existing error with HTTP status code
@@ -32,6 +96,156 @@ func (h *Hook) BeforeLogin(hook func(env *env, req loginAuthRequest, input *dao.
 	h.beforeLoginHooks = append(h.beforeLoginHooks, &hook)
 }
 
+// BeforeRefresh adds a new hook to be executed before reading a refresh token in the datastore
+func (h *Hook) BeforeRefresh(hook func(env *env, req refreshAuthRequest, input *dao.ReadRefreshTokenInput) *HookError) {
+	h.beforeRefreshHooks = append(h.beforeRefreshHooks, &hook)
+}
+
+// BeforeLogout adds a new hook to be executed before revoking an access token in the datastore
+func (h *Hook) BeforeLogout(hook func(env *env, input *dao.CreateRevokedTokenInput) *HookError) {
+	h.beforeLogoutHooks = append(h.beforeLogoutHooks, &hook)
+}
+
+// BeforeListRevoked adds a new hook to be executed before listing the revoked access tokens in the datastore
+func (h *Hook) BeforeListRevoked(hook func(env *env) *HookError) {
+	h.beforeListRevokedHooks = append(h.beforeListRevokedHooks, &hook)
+}
+
+// BeforeForgotPassword adds a new hook to be executed before reading an object in the datastore to issue a password reset token
+func (h *Hook) BeforeForgotPassword(hook func(env *env, req forgotPasswordRequest, input *dao.ReadAuthInput) *HookError) {
+	h.beforeForgotPasswordHooks = append(h.beforeForgotPasswordHooks, &hook)
+}
+
+// BeforeResetPassword adds a new hook to be executed before updating the password of an object in the datastore
+func (h *Hook) BeforeResetPassword(hook func(env *env, req resetPasswordRequest, input *dao.UpdateAuthPasswordInput) *HookError) {
+	h.beforeResetPasswordHooks = append(h.beforeResetPasswordHooks, &hook)
+}
+
+// BeforeVerifyEmail adds a new hook to be executed before marking the email of an object in the datastore as verified
+func (h *Hook) BeforeVerifyEmail(hook func(env *env, req verifyEmailRequest, input *dao.VerifyAuthEmailInput) *HookError) {
+	h.beforeVerifyEmailHooks = append(h.beforeVerifyEmailHooks, &hook)
+}
+
+// BeforeResendVerification adds a new hook to be executed before reading an object in the datastore to resend a verification email
+func (h *Hook) BeforeResendVerification(hook func(env *env, req resendVerificationRequest, input *dao.ReadAuthInput) *HookError) {
+	h.beforeResendVerificationHooks = append(h.beforeResendVerificationHooks, &hook)
+}
+
+// BeforeChangePassword adds a new hook to be executed before updating the password of an authenticated object in the datastore
+func (h *Hook) BeforeChangePassword(hook func(env *env, req changePasswordRequest, input *dao.UpdateAuthPasswordInput) *HookError) {
+	h.beforeChangePasswordHooks = append(h.beforeChangePasswordHooks, &hook)
+}
+
+// BeforeChangeEmail adds a new hook to be executed before updating the email of an authenticated object in the datastore
+func (h *Hook) BeforeChangeEmail(hook func(env *env, req changeEmailRequest, input *dao.UpdateAuthEmailInput) *HookError) {
+	h.beforeChangeEmailHooks = append(h.beforeChangeEmailHooks, &hook)
+}
+
+// BeforeDeleteAccount adds a new hook to be executed before deleting an authenticated object in the datastore
+func (h *Hook) BeforeDeleteAccount(hook func(env *env, req deleteAccountRequest, input *dao.CreateAccountDeletionInput) *HookError) {
+	h.beforeDeleteAccountHooks = append(h.beforeDeleteAccountHooks, &hook)
+}
+
+// BeforeSetupTwoFactor adds a new hook to be executed before storing a new TOTP secret for an authenticated object in the datastore
+func (h *Hook) BeforeSetupTwoFactor(hook func(env *env, input *dao.CreateTwoFactorInput) *HookError) {
+	h.beforeSetupTwoFactorHooks = append(h.beforeSetupTwoFactorHooks, &hook)
+}
+
+// BeforeConfirmTwoFactor adds a new hook to be executed before enabling two-factor authentication for an authenticated object in the datastore
+func (h *Hook) BeforeConfirmTwoFactor(hook func(env *env, req confirmTwoFactorRequest, input *dao.EnableTwoFactorInput) *HookError) {
+	h.beforeConfirmTwoFactorHooks = append(h.beforeConfirmTwoFactorHooks, &hook)
+}
+
+// BeforeDisableTwoFactor adds a new hook to be executed before disabling two-factor authentication for an authenticated object in the datastore
+func (h *Hook) BeforeDisableTwoFactor(hook func(env *env, req disableTwoFactorRequest, input *dao.DeleteTwoFactorInput) *HookError) {
+	h.beforeDisableTwoFactorHooks = append(h.beforeDisableTwoFactorHooks, &hook)
+}
+
+// BeforeVerifyTwoFactor adds a new hook to be executed before reading a two-factor challenge in the datastore
+func (h *Hook) BeforeVerifyTwoFactor(hook func(env *env, req verifyTwoFactorRequest, input *dao.ReadTwoFactorChallengeInput) *HookError) {
+	h.beforeVerifyTwoFactorHooks = append(h.beforeVerifyTwoFactorHooks, &hook)
+}
+
+// BeforeUpdateRole adds a new hook to be executed before changing the role of an authenticated object in the datastore
+func (h *Hook) BeforeUpdateRole(hook func(env *env, req updateRoleRequest, input *dao.UpdateAuthRoleInput) *HookError) {
+	h.beforeUpdateRoleHooks = append(h.beforeUpdateRoleHooks, &hook)
+}
+
+// BeforeListAccounts adds a new hook to be executed before listing a page of authenticated objects in the datastore
+func (h *Hook) BeforeListAccounts(hook func(env *env, input *dao.ListAuthInput) *HookError) {
+	h.beforeListAccountsHooks = append(h.beforeListAccountsHooks, &hook)
+}
+
+// BeforeSuspendAccount adds a new hook to be executed before suspending an authenticated object in the datastore
+func (h *Hook) BeforeSuspendAccount(hook func(env *env, input *dao.UpdateAuthSuspendedInput) *HookError) {
+	h.beforeSuspendAccountHooks = append(h.beforeSuspendAccountHooks, &hook)
+}
+
+// BeforeUnsuspendAccount adds a new hook to be executed before unsuspending an authenticated object in the datastore
+func (h *Hook) BeforeUnsuspendAccount(hook func(env *env, input *dao.UpdateAuthSuspendedInput) *HookError) {
+	h.beforeUnsuspendAccountHooks = append(h.beforeUnsuspendAccountHooks, &hook)
+}
+
+// BeforeForceLogout adds a new hook to be executed before revoking every session of an authenticated object in the datastore
+func (h *Hook) BeforeForceLogout(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.beforeForceLogoutHooks = append(h.beforeForceLogoutHooks, &hook)
+}
+
+// BeforeForcePasswordReset adds a new hook to be executed before replacing the password of an authenticated object in the datastore
+func (h *Hook) BeforeForcePasswordReset(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.beforeForcePasswordResetHooks = append(h.beforeForcePasswordResetHooks, &hook)
+}
+
+// BeforeListAdminActions adds a new hook to be executed before listing a page of admin actions in the datastore
+func (h *Hook) BeforeListAdminActions(hook func(env *env, input *dao.ListAdminActionInput) *HookError) {
+	h.beforeListAdminActionsHooks = append(h.beforeListAdminActionsHooks, &hook)
+}
+
+// BeforeServiceToken adds a new hook to be executed before issuing a service token to a service with valid credentials
+func (h *Hook) BeforeServiceToken(hook func(env *env, req serviceTokenRequest) *HookError) {
+	h.beforeServiceTokenHooks = append(h.beforeServiceTokenHooks, &hook)
+}
+
+// BeforeOIDCStart adds a new hook to be executed before sending a login to an identity provider
+func (h *Hook) BeforeOIDCStart(hook func(env *env, provider string) *HookError) {
+	h.beforeOIDCStartHooks = append(h.beforeOIDCStartHooks, &hook)
+}
+
+// BeforeOIDCCallback adds a new hook to be executed before reading or creating the auth linked to a verified identity
+func (h *Hook) BeforeOIDCCallback(hook func(env *env, identity *comm.OIDCIdentity) *HookError) {
+	h.beforeOIDCCallbackHooks = append(h.beforeOIDCCallbackHooks, &hook)
+}
+
+// BeforeCreateAPIKey adds a new hook to be executed before creating an API key in the datastore
+func (h *Hook) BeforeCreateAPIKey(hook func(env *env, req createAPIKeyRequest, input *dao.CreateAPIKeyInput) *HookError) {
+	h.beforeCreateAPIKeyHooks = append(h.beforeCreateAPIKeyHooks, &hook)
+}
+
+// BeforeListAPIKeys adds a new hook to be executed before listing an auth's API keys in the datastore
+func (h *Hook) BeforeListAPIKeys(hook func(env *env, input *dao.ListAPIKeyInput) *HookError) {
+	h.beforeListAPIKeysHooks = append(h.beforeListAPIKeysHooks, &hook)
+}
+
+// BeforeRevokeAPIKey adds a new hook to be executed before deleting an API key from the datastore
+func (h *Hook) BeforeRevokeAPIKey(hook func(env *env, input *dao.DeleteAPIKeyInput) *HookError) {
+	h.beforeRevokeAPIKeyHooks = append(h.beforeRevokeAPIKeyHooks, &hook)
+}
+
+// BeforeIntrospectAPIKey adds a new hook to be executed before reading an API key from the datastore for another service
+func (h *Hook) BeforeIntrospectAPIKey(hook func(env *env, input *dao.ReadAPIKeyInput) *HookError) {
+	h.beforeIntrospectAPIKeyHooks = append(h.beforeIntrospectAPIKeyHooks, &hook)
+}
+
+// BeforeListSessions adds a new hook to be executed before listing an auth's active sessions in the datastore
+func (h *Hook) BeforeListSessions(hook func(env *env, input *dao.ListSessionInput) *HookError) {
+	h.beforeListSessionsHooks = append(h.beforeListSessionsHooks, &hook)
+}
+
+// BeforeRevokeSession adds a new hook to be executed before deleting a session from the datastore
+func (h *Hook) BeforeRevokeSession(hook func(env *env, input *dao.DeleteSessionInput) *HookError) {
+	h.beforeRevokeSessionHooks = append(h.beforeRevokeSessionHooks, &hook)
+}
+
 // AfterRegister adds a new hook to be executed after creating an object in the datastore
 func (h *Hook) AfterRegister(hook func(env *env, auth *dao.Auth, accessToken string) *HookError) {
 	h.afterRegisterHooks = append(h.afterRegisterHooks, &hook)
@@ -41,3 +255,158 @@ func (h *Hook) AfterRegister(hook func(env *env, auth *dao.Auth, accessToken str
 func (h *Hook) AfterLogin(hook func(env *env, auth *dao.Auth, accessToken string) *HookError) {
 	h.afterLoginHooks = append(h.afterLoginHooks, &hook)
 }
+
+// AfterRefresh adds a new hook to be executed after rotating a refresh token in the datastore
+func (h *Hook) AfterRefresh(hook func(env *env, refreshToken *dao.RefreshToken, accessToken string) *HookError) {
+	h.afterRefreshHooks = append(h.afterRefreshHooks, &hook)
+}
+
+// AfterLogout adds a new hook to be executed after revoking an access token in the datastore
+func (h *Hook) AfterLogout(hook func(env *env, auth *util.Auth) *HookError) {
+	h.afterLogoutHooks = append(h.afterLogoutHooks, &hook)
+}
+
+// AfterListRevoked adds a new hook to be executed after listing the revoked access tokens in the datastore
+func (h *Hook) AfterListRevoked(hook func(env *env, revokedTokenList *[]dao.RevokedToken) *HookError) {
+	h.afterListRevokedHooks = append(h.afterListRevokedHooks, &hook)
+}
+
+// AfterForgotPassword adds a new hook to be executed after issuing a password reset token in the datastore
+func (h *Hook) AfterForgotPassword(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterForgotPasswordHooks = append(h.afterForgotPasswordHooks, &hook)
+}
+
+// AfterResetPassword adds a new hook to be executed after updating the password of an object in the datastore
+func (h *Hook) AfterResetPassword(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterResetPasswordHooks = append(h.afterResetPasswordHooks, &hook)
+}
+
+// AfterVerifyEmail adds a new hook to be executed after marking the email of an object in the datastore as verified
+func (h *Hook) AfterVerifyEmail(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterVerifyEmailHooks = append(h.afterVerifyEmailHooks, &hook)
+}
+
+// AfterResendVerification adds a new hook to be executed after issuing a new email verification token in the datastore
+func (h *Hook) AfterResendVerification(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterResendVerificationHooks = append(h.afterResendVerificationHooks, &hook)
+}
+
+// AfterChangePassword adds a new hook to be executed after updating the password of an authenticated object in the datastore
+func (h *Hook) AfterChangePassword(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterChangePasswordHooks = append(h.afterChangePasswordHooks, &hook)
+}
+
+// AfterChangeEmail adds a new hook to be executed after updating the email of an authenticated object in the datastore
+// The previous email is provided, such that the owner of the account can be notified of the change
+func (h *Hook) AfterChangeEmail(hook func(env *env, auth *dao.Auth, previousEmail string) *HookError) {
+	h.afterChangeEmailHooks = append(h.afterChangeEmailHooks, &hook)
+}
+
+// AfterDeleteAccount adds a new hook to be executed after deleting an authenticated object in the datastore
+// The account may not yet have been deleted from every other service, in which case the deletion is retried in the background
+func (h *Hook) AfterDeleteAccount(hook func(env *env, accountDeletion *dao.AccountDeletion) *HookError) {
+	h.afterDeleteAccountHooks = append(h.afterDeleteAccountHooks, &hook)
+}
+
+// AfterSetupTwoFactor adds a new hook to be executed after storing a new TOTP secret for an authenticated object in the datastore
+func (h *Hook) AfterSetupTwoFactor(hook func(env *env, twoFactor *dao.TwoFactor) *HookError) {
+	h.afterSetupTwoFactorHooks = append(h.afterSetupTwoFactorHooks, &hook)
+}
+
+// AfterConfirmTwoFactor adds a new hook to be executed after enabling two-factor authentication for an authenticated object in the datastore
+func (h *Hook) AfterConfirmTwoFactor(hook func(env *env, twoFactor *dao.TwoFactor) *HookError) {
+	h.afterConfirmTwoFactorHooks = append(h.afterConfirmTwoFactorHooks, &hook)
+}
+
+// AfterDisableTwoFactor adds a new hook to be executed after disabling two-factor authentication for an authenticated object in the datastore
+func (h *Hook) AfterDisableTwoFactor(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterDisableTwoFactorHooks = append(h.afterDisableTwoFactorHooks, &hook)
+}
+
+// AfterVerifyTwoFactor adds a new hook to be executed after redeeming a two-factor challenge in the datastore
+// Logins requiring a second factor complete here, rather than executing the AfterLogin hooks
+func (h *Hook) AfterVerifyTwoFactor(hook func(env *env, auth *dao.Auth, accessToken string) *HookError) {
+	h.afterVerifyTwoFactorHooks = append(h.afterVerifyTwoFactorHooks, &hook)
+}
+
+// AfterUpdateRole adds a new hook to be executed after changing the role of an authenticated object in the datastore
+// Every token already issued has been revoked, such that the new role takes effect once the auth next logs in
+func (h *Hook) AfterUpdateRole(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterUpdateRoleHooks = append(h.afterUpdateRoleHooks, &hook)
+}
+
+// AfterListAccounts adds a new hook to be executed after listing a page of authenticated objects in the datastore
+func (h *Hook) AfterListAccounts(hook func(env *env, authList *[]dao.Auth) *HookError) {
+	h.afterListAccountsHooks = append(h.afterListAccountsHooks, &hook)
+}
+
+// AfterSuspendAccount adds a new hook to be executed after suspending an authenticated object in the datastore
+func (h *Hook) AfterSuspendAccount(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterSuspendAccountHooks = append(h.afterSuspendAccountHooks, &hook)
+}
+
+// AfterUnsuspendAccount adds a new hook to be executed after unsuspending an authenticated object in the datastore
+func (h *Hook) AfterUnsuspendAccount(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterUnsuspendAccountHooks = append(h.afterUnsuspendAccountHooks, &hook)
+}
+
+// AfterForceLogout adds a new hook to be executed after revoking every session of an authenticated object in the datastore
+func (h *Hook) AfterForceLogout(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterForceLogoutHooks = append(h.afterForceLogoutHooks, &hook)
+}
+
+// AfterForcePasswordReset adds a new hook to be executed after replacing the password of an authenticated object in the datastore
+// A password reset link has been sent to the email of the auth by this point
+func (h *Hook) AfterForcePasswordReset(hook func(env *env, auth *dao.Auth) *HookError) {
+	h.afterForcePasswordResetHooks = append(h.afterForcePasswordResetHooks, &hook)
+}
+
+// AfterListAdminActions adds a new hook to be executed after listing a page of admin actions in the datastore
+func (h *Hook) AfterListAdminActions(hook func(env *env, adminActionList *[]dao.AdminAction) *HookError) {
+	h.afterListAdminActionsHooks = append(h.afterListAdminActionsHooks, &hook)
+}
+
+// AfterServiceToken adds a new hook to be executed after issuing a service token to a service
+func (h *Hook) AfterServiceToken(hook func(env *env, service string, accessToken string) *HookError) {
+	h.afterServiceTokenHooks = append(h.afterServiceTokenHooks, &hook)
+}
+
+// AfterOIDCStart adds a new hook to be executed after storing a login sent to an identity provider
+func (h *Hook) AfterOIDCStart(hook func(env *env, provider string, authorizationURL string) *HookError) {
+	h.afterOIDCStartHooks = append(h.afterOIDCStartHooks, &hook)
+}
+
+// AfterOIDCCallback adds a new hook to be executed after logging in an auth with an identity provider
+func (h *Hook) AfterOIDCCallback(hook func(env *env, auth *dao.Auth, accessToken string) *HookError) {
+	h.afterOIDCCallbackHooks = append(h.afterOIDCCallbackHooks, &hook)
+}
+
+// AfterCreateAPIKey adds a new hook to be executed after creating an API key in the datastore
+func (h *Hook) AfterCreateAPIKey(hook func(env *env, apiKey *dao.APIKey) *HookError) {
+	h.afterCreateAPIKeyHooks = append(h.afterCreateAPIKeyHooks, &hook)
+}
+
+// AfterListAPIKeys adds a new hook to be executed after listing an auth's API keys in the datastore
+func (h *Hook) AfterListAPIKeys(hook func(env *env, apiKeyList *[]dao.APIKey) *HookError) {
+	h.afterListAPIKeysHooks = append(h.afterListAPIKeysHooks, &hook)
+}
+
+// AfterRevokeAPIKey adds a new hook to be executed after deleting an API key from the datastore
+func (h *Hook) AfterRevokeAPIKey(hook func(env *env, input *dao.DeleteAPIKeyInput) *HookError) {
+	h.afterRevokeAPIKeyHooks = append(h.afterRevokeAPIKeyHooks, &hook)
+}
+
+// AfterIntrospectAPIKey adds a new hook to be executed after reading an API key and the auth it belongs to from the datastore
+func (h *Hook) AfterIntrospectAPIKey(hook func(env *env, apiKey *dao.APIKey, auth *dao.Auth) *HookError) {
+	h.afterIntrospectAPIKeyHooks = append(h.afterIntrospectAPIKeyHooks, &hook)
+}
+
+// AfterListSessions adds a new hook to be executed after listing an auth's active sessions in the datastore
+func (h *Hook) AfterListSessions(hook func(env *env, sessionList *[]dao.Session) *HookError) {
+	h.afterListSessionsHooks = append(h.afterListSessionsHooks, &hook)
+}
+
+// AfterRevokeSession adds a new hook to be executed after deleting a session from the datastore
+func (h *Hook) AfterRevokeSession(hook func(env *env, input *dao.DeleteSessionInput) *HookError) {
+	h.afterRevokeSessionHooks = append(h.afterRevokeSessionHooks, &hook)
+}
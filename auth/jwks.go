@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+)
+
+// jwksMaxAge is how long, in seconds, clients may cache the published keys before fetching them again
+const jwksMaxAge = 300
+
+func (env *env) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	keys := make([]util.JWK, 0)
+	for _, signingKey := range env.keyRing.verificationKeys() {
+		keys = append(keys, signingKey.JWK())
+	}
+
+	json.NewEncoder(w).Encode(util.JWKS{
+		Keys: keys,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestJWKS).Inc()
+}
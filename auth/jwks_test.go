@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Test that the signing key is published, and that access tokens can be verified using only the published key
+func TestJWKSHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodGet, "/auth/.well-known/jwks.json", "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var jwks util.JWKS
+	err = json.Unmarshal([]byte(res.Body.String()), &jwks)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != mockSigningKey.ID || jwks.Keys[0].Kty != "RSA" || jwks.Keys[0].Alg != "RS256" {
+		t.Fatalf("Wrong keys published: %s", res.Body.String())
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	if err != nil {
+		t.Fatalf("Could not decode modulus: %s", err.Error())
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	if err != nil {
+		t.Fatalf("Could not decode exponent: %s", err.Error())
+	}
+
+	publicKey := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+
+	token, err := jwt.Parse(tokens["AccessToken"], func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != jwks.Keys[0].Kid {
+			t.Fatalf("Wrong kid: %v", token.Header["kid"])
+		}
+		return publicKey, nil
+	})
+	if err != nil || !token.Valid {
+		t.Fatalf("Could not verify token with published key: %v", err)
+	}
+}
+
+// Test that a token signed with HS256, using the public key as the secret, is rejected
+func TestExtractAuthFailsOnSymmetricToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	publicKey, err := mockSigningKey.PublicKeyPEM()
+	if err != nil {
+		t.Fatalf("Could not encode public key: %s", err.Error())
+	}
+
+	claims := unverifiedClaims(t, tokens["AccessToken"])
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = mockSigningKey.ID
+	rawToken, err := forged.SignedString([]byte(publicKey))
+	if err != nil {
+		t.Fatalf("Could not sign token: %s", err.Error())
+	}
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", rawToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a signing key survives being encoded for the datastore, and cannot be decoded for a different algorithm
+func TestEncodeSigningKeyRoundTrips(t *testing.T) {
+	generated, err := util.GenerateSigningKey(util.SigningAlgorithmES256)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err.Error())
+	}
+
+	encoded, err := util.EncodeSigningKey(generated)
+	if err != nil {
+		t.Fatalf("Could not encode key: %s", err.Error())
+	}
+
+	decoded, err := util.DecodeSigningKey(encoded, util.SigningAlgorithmES256)
+	if err != nil {
+		t.Fatalf("Could not decode key: %s", err.Error())
+	}
+
+	if decoded.ID != generated.ID {
+		t.Fatalf("Decoded a different key: %s != %s", decoded.ID, generated.ID)
+	}
+
+	if decoded.JWK().Kty != "EC" || decoded.JWK().Crv != "P-256" {
+		t.Fatalf("Wrong key type: %+v", decoded.JWK())
+	}
+
+	// An existing key cannot be used with a different algorithm
+	_, err = util.DecodeSigningKey(encoded, util.SigningAlgorithmRS256)
+	if err == nil {
+		t.Fatalf("Decoded an ECDSA key for RS256")
+	}
+}
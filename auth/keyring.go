@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// keyRingRefreshInterval is the minimum time between reloading the key ring on encountering an unknown key ID, such that
+// tokens naming made-up keys cannot overwhelm the datastore
+const keyRingRefreshInterval = time.Second
+
+// signingKeyClockSkew is the margin allowed for the clocks of other instances, and the services verifying their tokens,
+// disagreeing with this one about when a previous key stopped signing tokens, or when those tokens expire
+const signingKeyClockSkew = 5 * time.Minute
+
+// keyRing holds every signing key that has not been retired, such that tokens signed before a rotation can still be
+// verified until they expire
+// A static key ring holds only the key provided in the config, and is never reloaded from the datastore
+type keyRing struct {
+	mutex       sync.RWMutex
+	active      *util.SigningKey
+	keys        []*util.SigningKey
+	refreshedAt time.Time
+	static      bool
+}
+
+// activeKey returns the key that new access tokens are signed with
+func (ring *keyRing) activeKey() *util.SigningKey {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	return ring.active
+}
+
+// verificationKeys returns every key that an unexpired access token may have been signed with
+func (ring *keyRing) verificationKeys() []*util.SigningKey {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	return ring.keys
+}
+
+// key returns the key with the given ID, if it has not been retired
+func (ring *keyRing) key(id string) (*util.SigningKey, bool) {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	for _, signingKey := range ring.keys {
+		if signingKey.ID == id {
+			return signingKey, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces the keys held by the key ring
+func (ring *keyRing) set(active *util.SigningKey, keys []*util.SigningKey) {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	ring.active = active
+	ring.keys = keys
+	ring.refreshedAt = time.Now()
+}
+
+// setStatic replaces the keys held by the key ring with a single key, which is never reloaded
+func (ring *keyRing) setStatic(signingKey *util.SigningKey) {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	ring.active = signingKey
+	ring.keys = []*util.SigningKey{signingKey}
+	ring.static = true
+}
+
+// stale returns whether the key ring may be reloaded, having not been reloaded recently
+func (ring *keyRing) stale() bool {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	return !ring.static && time.Since(ring.refreshedAt) >= keyRingRefreshInterval
+}
+
+// Load the signing key provided inline or as a file in the config, returning nil if neither is provided
+func configSigningKey(config *util.SigningConfig) (*util.SigningKey, error) {
+	encoded := config.PrivateKey
+	if config.KeyFile != "" {
+		if encoded != "" {
+			return nil, errors.New("only one of privateKey and keyFile may be provided")
+		}
+
+		contents, err := ioutil.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(contents)
+	}
+
+	if encoded == "" {
+		return nil, nil
+	}
+
+	return util.DecodeSigningKey(encoded, config.Algorithm)
+}
+
+// Load the signing keys, returning whether they should be periodically rotated
+// A key provided in the config is used for as long as it is configured, and is only registered with Kong in Kong mode
+func initKeyRing(env *env) (bool, error) {
+	signingKey, err := configSigningKey(&env.config.Signing)
+	if err != nil {
+		return false, err
+	}
+
+	if signingKey == nil {
+		return true, rotateSigningKeys(env)
+	}
+
+	if env.config.Signing.Mode == util.SigningModeKong {
+		_, err = env.comm.CreateJWTCredential(signingKey)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	env.keyRing.setStatic(signingKey)
+	return false, nil
+}
+
+// Reload the key ring from the datastore
+func refreshKeyRing(env *env) error {
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRotateSigningKey))
+	signingKeyList, err := env.dao.ListSigningKey()
+	timer.ObserveDuration()
+	if err != nil {
+		return err
+	}
+
+	var active *util.SigningKey
+	keys := make([]*util.SigningKey, 0, len(*signingKeyList))
+	for _, stored := range *signingKeyList {
+		signingKey, err := util.DecodeSigningKey(stored.PrivateKey, stored.Algorithm)
+		if err != nil {
+			return fmt.Errorf("could not decode signing key %s: %s", stored.ID, err.Error())
+		}
+
+		if stored.State == dao.SigningKeyActive {
+			active = signingKey
+		}
+		keys = append(keys, signingKey)
+	}
+
+	if active == nil {
+		return errors.New("there is no active signing key")
+	}
+
+	env.keyRing.set(active, keys)
+	return nil
+}
+
+// Return the public key to verify a token with, for use with jwt.Parse
+// An unknown key ID reloads the key ring, as the keys may have been rotated by another instance
+func verificationKey(env *env, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	signingKey, ok := env.keyRing.key(kid)
+	if !ok && env.keyRing.stale() {
+		err := refreshKeyRing(env)
+		if err != nil {
+			return nil, err
+		}
+		signingKey, ok = env.keyRing.key(kid)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %v", token.Header["kid"])
+	}
+
+	return signingKey.Keyfunc(token)
+}
+
+// Create a new active signing key once the current one is older than the rotation interval, and retire verification-only
+// keys once every access token they signed has expired
+// In Kong mode, each key is registered with Kong before it signs any access token, and removed from Kong once retired
+func rotateSigningKeys(env *env) error {
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRotateSigningKey))
+	signingKeyList, err := env.dao.ListSigningKey()
+	timer.ObserveDuration()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rotationInterval := time.Duration(env.config.Signing.RotationIntervalSeconds) * time.Second
+
+	var active *dao.SigningKey
+	for i, signingKey := range *signingKeyList {
+		switch signingKey.State {
+		case dao.SigningKeyActive:
+			active = &(*signingKeyList)[i]
+		case dao.SigningKeyVerification:
+			if signingKey.UpdatedAt.Add(signingKeyRetention(env)).After(now) {
+				continue
+			}
+
+			if env.config.Signing.Mode == util.SigningModeKong {
+				err = env.comm.DeleteJWTCredential(signingKey.ID)
+				if err != nil {
+					return err
+				}
+			}
+
+			timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRotateSigningKey))
+			err = env.dao.RetireSigningKey(dao.RetireSigningKeyInput{
+				ID:        signingKey.ID,
+				RetiredAt: now,
+			})
+			timer.ObserveDuration()
+			if err != nil && err != dao.ErrSigningKeyNotFound {
+				return err
+			}
+
+			metric.SigningKeyRotation.WithLabelValues(dao.SigningKeyRetired).Inc()
+		}
+	}
+
+	if active == nil || active.CreatedAt.Add(rotationInterval).Before(now) {
+		err = createSigningKey(env, active, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return refreshKeyRing(env)
+}
+
+// signingKeyRetention returns how long a previous key must keep verifying tokens after it stops being the active key
+// Other instances only reload the key ring at each rotation check, so may keep signing with the previous key for up to
+// one check after it was replaced, and those tokens must be allowed to expire, even if clocks disagree
+func signingKeyRetention(env *env) time.Duration {
+	tokenLifetime := accessTokenLifetime
+	serviceTokenLifetime := time.Duration(env.config.ServiceClients.TokenLifetimeSeconds) * time.Second
+	if serviceTokenLifetime > tokenLifetime {
+		tokenLifetime = serviceTokenLifetime
+	}
+	return tokenLifetime + time.Duration(env.config.Signing.RotationCheckSeconds)*time.Second + signingKeyClockSkew
+}
+
+// Generate a new signing key, register it with Kong and store it as the active key, replacing the given key
+func createSigningKey(env *env, replacing *dao.SigningKey, now time.Time) error {
+	signingKey, err := util.GenerateSigningKey(env.config.Signing.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	privateKey, err := util.EncodeSigningKey(signingKey)
+	if err != nil {
+		return err
+	}
+
+	if env.config.Signing.Mode == util.SigningModeKong {
+		_, err = env.comm.CreateJWTCredential(signingKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	input := dao.CreateSigningKeyInput{
+		ID:         signingKey.ID,
+		Algorithm:  signingKey.Algorithm,
+		PrivateKey: privateKey,
+		CreatedAt:  now,
+	}
+	if replacing != nil {
+		input.ReplaceID = replacing.ID
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRotateSigningKey))
+	_, err = env.dao.CreateSigningKey(input)
+	timer.ObserveDuration()
+	switch err {
+	case nil:
+		metric.SigningKeyRotation.WithLabelValues(dao.SigningKeyActive).Inc()
+		return nil
+	case dao.ErrSigningKeyRotated:
+		// Another instance rotated first, so this key never signs anything
+		if env.config.Signing.Mode == util.SigningModeKong {
+			return env.comm.DeleteJWTCredential(signingKey.ID)
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+// Periodically rotate the signing keys
+func runKeyRotation(env *env, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := rotateSigningKeys(env)
+		if err != nil {
+			log.Printf("Could not rotate signing keys: %s", err.Error())
+		}
+	}
+}
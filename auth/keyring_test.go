@@ -0,0 +1,293 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Test that the first signing key is generated and registered with Kong when there is none
+func TestRotateSigningKeysCreatesFirstKey(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.dao.(*mockDAO).signingKeyList = nil
+	mockEnv.comm.(*mockComm).jwtCredentials = nil
+	mockEnv.keyRing = &keyRing{}
+	mockEnv.config.Signing.Algorithm = util.SigningAlgorithmES256
+
+	err := rotateSigningKeys(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not rotate signing keys: %s", err.Error())
+	}
+
+	active := mockEnv.keyRing.activeKey()
+	if active == nil || active.Algorithm != util.SigningAlgorithmES256 {
+		t.Fatalf("No active key of the configured algorithm: %+v", active)
+	}
+
+	jwtCredentials := mockEnv.comm.(*mockComm).jwtCredentials
+	if len(jwtCredentials) != 1 || jwtCredentials[0] != active.ID {
+		t.Fatalf("Active key is not registered with Kong: %v", jwtCredentials)
+	}
+
+	// Rotating again within the interval leaves the key in place
+	err = rotateSigningKeys(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not rotate signing keys: %s", err.Error())
+	}
+
+	if mockEnv.keyRing.activeKey().ID != active.ID {
+		t.Fatalf("Key was rotated before the interval elapsed")
+	}
+}
+
+// Test that tokens signed before a rotation keep working, while new tokens are signed with the new key
+func TestRotateSigningKeysKeepsPreviousKeyVerifying(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	mockEnv.dao.(*mockDAO).signingKeyList[0].CreatedAt = time.Now().Add(-8 * 24 * time.Hour)
+	err := rotateSigningKeys(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not rotate signing keys: %s", err.Error())
+	}
+
+	active := mockEnv.keyRing.activeKey()
+	if active.ID == mockSigningKey.ID {
+		t.Fatalf("Key was not rotated")
+	}
+
+	if len(mockEnv.comm.(*mockComm).jwtCredentials) != 2 {
+		t.Fatalf("Both keys should be registered with Kong: %v", mockEnv.comm.(*mockComm).jwtCredentials)
+	}
+
+	if len(mockEnv.keyRing.verificationKeys()) != 2 {
+		t.Fatalf("Both keys should verify tokens: %v", mockEnv.keyRing.verificationKeys())
+	}
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The old access token is still accepted
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that once every token it signed has expired, a previous key is retired and removed from Kong
+func TestRotateSigningKeysRetiresExpiredKey(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	mockEnv.dao.(*mockDAO).signingKeyList[0].CreatedAt = time.Now().Add(-8 * 24 * time.Hour)
+	err := rotateSigningKeys(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not rotate signing keys: %s", err.Error())
+	}
+
+	// Another instance may have kept signing with the previous key until its next rotation check
+	mockEnv.dao.(*mockDAO).signingKeyList[0].UpdatedAt = time.Now().Add(-accessTokenLifetime - time.Minute)
+	err = rotateSigningKeys(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not rotate signing keys: %s", err.Error())
+	}
+
+	if mockEnv.dao.(*mockDAO).signingKeyList[0].State != dao.SigningKeyVerification {
+		t.Fatalf("Previous key was retired before the rotation check and clock skew had passed: %s", mockEnv.dao.(*mockDAO).signingKeyList[0].State)
+	}
+
+	mockEnv.dao.(*mockDAO).signingKeyList[0].UpdatedAt = time.Now().Add(-signingKeyRetention(&mockEnv) - time.Minute)
+	err = rotateSigningKeys(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not rotate signing keys: %s", err.Error())
+	}
+
+	if mockEnv.dao.(*mockDAO).signingKeyList[0].State != dao.SigningKeyRetired {
+		t.Fatalf("Previous key was not retired: %s", mockEnv.dao.(*mockDAO).signingKeyList[0].State)
+	}
+
+	jwtCredentials := mockEnv.comm.(*mockComm).jwtCredentials
+	if len(jwtCredentials) != 1 || jwtCredentials[0] != mockEnv.keyRing.activeKey().ID {
+		t.Fatalf("Only the active key should be registered with Kong: %v", jwtCredentials)
+	}
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a token signed by a key rotated in by another instance is accepted, by reloading the key ring
+func TestExtractAuthReloadsKeyRingOnUnknownKey(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	mockEnv.dao.(*mockDAO).signingKeyList[0].CreatedAt = time.Now().Add(-8 * 24 * time.Hour)
+	err := rotateSigningKeys(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not rotate signing keys: %s", err.Error())
+	}
+
+	accessToken, err := createToken(mockEnv.dao.(*mockDAO).authList[0].ID, mockEnv.keyRing.activeKey())
+	if err != nil {
+		t.Fatalf("Could not create token: %s", err.Error())
+	}
+
+	// The other instance only knows the previous key, and has not reloaded recently
+	otherEnv := mockEnv
+	otherEnv.keyRing = &keyRing{active: mockSigningKey, keys: []*util.SigningKey{mockSigningKey}}
+
+	res, err := makeAuthenticatedRequest(otherEnv, http.MethodPost, "/auth/logout", "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if otherEnv.keyRing.activeKey().ID == mockSigningKey.ID {
+		t.Fatalf("Key ring was not reloaded")
+	}
+}
+
+// Test that in local mode, signing keys are rotated and retired without contacting Kong
+func TestRotateSigningKeysSkipsKongInLocalMode(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.config.Signing.Mode = util.SigningModeLocal
+	mockEnv.comm.(*mockComm).jwtCredentials = nil
+
+	mockEnv.dao.(*mockDAO).signingKeyList[0].CreatedAt = time.Now().Add(-8 * 24 * time.Hour)
+	err := rotateSigningKeys(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not rotate signing keys: %s", err.Error())
+	}
+
+	if mockEnv.keyRing.activeKey().ID == mockSigningKey.ID {
+		t.Fatalf("Key was not rotated")
+	}
+
+	if len(mockEnv.comm.(*mockComm).jwtCredentials) != 0 {
+		t.Fatalf("Kong was contacted: %v", mockEnv.comm.(*mockComm).jwtCredentials)
+	}
+}
+
+// Test that a key provided in the config signs every access token, and is never replaced from the datastore
+func TestInitKeyRingUsesConfiguredKey(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.config.Signing.Mode = util.SigningModeLocal
+	mockEnv.comm.(*mockComm).jwtCredentials = nil
+	mockEnv.keyRing = &keyRing{}
+
+	configured, err := util.GenerateSigningKey(util.SigningAlgorithmES256)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err.Error())
+	}
+	mockEnv.config.Signing.Algorithm = util.SigningAlgorithmES256
+	mockEnv.config.Signing.PrivateKey, err = util.EncodeSigningKey(configured)
+	if err != nil {
+		t.Fatalf("Could not encode key: %s", err.Error())
+	}
+
+	rotating, err := initKeyRing(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not initialise key ring: %s", err.Error())
+	}
+
+	if rotating {
+		t.Fatalf("A configured key should not be rotated")
+	}
+
+	if len(mockEnv.comm.(*mockComm).jwtCredentials) != 0 {
+		t.Fatalf("Kong was contacted: %v", mockEnv.comm.(*mockComm).jwtCredentials)
+	}
+
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	token, _, err := new(jwt.Parser).ParseUnverified(tokens["AccessToken"], jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Could not decode JWT: %s", err.Error())
+	}
+
+	if token.Header["kid"] != configured.ID || token.Header["alg"] != util.SigningAlgorithmES256 {
+		t.Fatalf("Token was not signed with the configured key: %v", token.Header)
+	}
+
+	// A token naming a key from the datastore is rejected, rather than reloading the key ring
+	accessToken, err := createToken(mockEnv.dao.(*mockDAO).authList[0].ID, mockSigningKey)
+	if err != nil {
+		t.Fatalf("Could not create token: %s", err.Error())
+	}
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if mockEnv.keyRing.activeKey().ID != configured.ID {
+		t.Fatalf("Configured key was replaced")
+	}
+}
+
+// Test that a key can be provided as a file, but not both as a file and inline
+func TestInitKeyRingReadsKeyFile(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.keyRing = &keyRing{}
+
+	dir, err := ioutil.TempDir("", "auth-keys")
+	if err != nil {
+		t.Fatalf("Could not create directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	encoded, err := util.EncodeSigningKey(mockSigningKey)
+	if err != nil {
+		t.Fatalf("Could not encode key: %s", err.Error())
+	}
+
+	path := filepath.Join(dir, "signing-key.pem")
+	err = ioutil.WriteFile(path, []byte(encoded), 0600)
+	if err != nil {
+		t.Fatalf("Could not write key: %s", err.Error())
+	}
+	mockEnv.config.Signing.KeyFile = path
+
+	_, err = initKeyRing(&mockEnv)
+	if err != nil {
+		t.Fatalf("Could not initialise key ring: %s", err.Error())
+	}
+
+	if mockEnv.keyRing.activeKey().ID != mockSigningKey.ID {
+		t.Fatalf("Key file was not used")
+	}
+
+	mockEnv.config.Signing.PrivateKey = encoded
+	_, err = initKeyRing(&mockEnv)
+	if err == nil {
+		t.Fatalf("Both an inline key and a key file were accepted")
+	}
+}
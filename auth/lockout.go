@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Scopes that failed login attempts are tracked within
+const (
+	loginLockoutScopeEmail = "email"
+	loginLockoutScopeIP    = "ip"
+)
+
+// loginAttemptKey identifies a single email or client IP that failed login attempts are tracked against
+type loginAttemptKey struct {
+	scope       string
+	key         string
+	maxAttempts int
+}
+
+// loginAttemptKeys returns the keys that failed login attempts are tracked against for a login request
+func loginAttemptKeys(env *env, email string, r *http.Request) []loginAttemptKey {
+	policy := env.config.LoginLockout
+	return []loginAttemptKey{
+		{loginLockoutScopeEmail, loginLockoutScopeEmail + ":" + strings.ToLower(email), policy.EmailMaxAttempts},
+		{loginLockoutScopeIP, loginLockoutScopeIP + ":" + clientIP(r, policy.TrustForwardedFor), policy.IPMaxAttempts},
+	}
+}
+
+// clientIP returns the IP address a request originated from
+// If the service sits behind a trusted proxy, the address the proxy received the request from is used, being the last
+// entry in X-Forwarded-For, as any earlier entries are provided by the client
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+		last := strings.TrimSpace(forwarded[len(forwarded)-1])
+		if last != "" {
+			return last
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginLockoutRemaining returns how long until all of the keys may attempt to login again, or zero if none are locked out
+func loginLockoutRemaining(env *env, keys []loginAttemptKey, now time.Time, requestType string) (time.Duration, error) {
+	var remaining time.Duration
+	for _, key := range keys {
+		timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+		loginAttempt, err := env.loginAttempts.ReadLoginAttempt(dao.ReadLoginAttemptInput{
+			Key: key.key,
+		})
+		timer.ObserveDuration()
+		if err != nil {
+			switch err {
+			case dao.ErrLoginAttemptNotFound:
+				continue
+			default:
+				return 0, err
+			}
+		}
+
+		if loginAttempt.LockedUntil.Sub(now) > remaining {
+			remaining = loginAttempt.LockedUntil.Sub(now)
+		}
+	}
+
+	return remaining, nil
+}
+
+// recordFailedLogin records a failed login attempt against each of the keys, locking out any that have reached their
+// maximum attempts
+func recordFailedLogin(env *env, keys []loginAttemptKey, now time.Time, requestType string) error {
+	policy := env.config.LoginLockout
+	for _, key := range keys {
+		timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+		loginAttempt, err := env.loginAttempts.RecordFailedLogin(dao.RecordFailedLoginInput{
+			Key:         key.key,
+			FailedAt:    now,
+			WindowStart: now.Add(-time.Duration(policy.WindowSeconds) * time.Second),
+		})
+		timer.ObserveDuration()
+		if err != nil {
+			return err
+		}
+
+		if loginAttempt.Failures < key.maxAttempts {
+			continue
+		}
+
+		timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+		err = env.loginAttempts.LockLoginAttempt(dao.LockLoginAttemptInput{
+			Key:         key.key,
+			LockedUntil: now.Add(lockoutDuration(env, loginAttempt.Failures-key.maxAttempts)),
+		})
+		timer.ObserveDuration()
+		if err != nil {
+			return err
+		}
+
+		metric.LoginLockout.WithLabelValues(key.scope).Inc()
+	}
+
+	return nil
+}
+
+// resetLoginAttempts forgets the failed login attempts against the email of a successful login
+// Failures against the client IP are kept, such that an attacker cannot reset them by logging into their own account
+func resetLoginAttempts(env *env, keys []loginAttemptKey, requestType string) error {
+	for _, key := range keys {
+		if key.scope != loginLockoutScopeEmail {
+			continue
+		}
+
+		timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+		err := env.loginAttempts.DeleteLoginAttempt(dao.DeleteLoginAttemptInput{
+			Key: key.key,
+		})
+		timer.ObserveDuration()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyPassword checks the password of the auth making a request before it changes its credentials or account,
+// counting failures towards the same lockout as login, such that a stolen access token cannot be used to guess the password
+// An error response is written if the password is not accepted, in which case false is returned
+func verifyPassword(env *env, w http.ResponseWriter, r *http.Request, auth *dao.Auth, password string, requestType string) bool {
+	now := time.Now()
+	loginAttemptKeys := loginAttemptKeys(env, auth.Email, r)
+	remaining, err := loginLockoutRemaining(env, loginAttemptKeys, now, requestType)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, requestType)
+		return false
+	}
+
+	if remaining > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+		respondWithError(w, "Too many failed password attempts, try again later", http.StatusTooManyRequests, requestType)
+		return false
+	}
+
+	_, err = env.passwords.Verify(auth.Password, password)
+	if err != nil {
+		switch err {
+		case util.ErrPasswordMismatch:
+			err = recordFailedLogin(env, loginAttemptKeys, now, requestType)
+			if err != nil {
+				respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, requestType)
+				return false
+			}
+			respondWithError(w, "Invalid password", http.StatusUnauthorized, requestType)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, requestType)
+		}
+		return false
+	}
+
+	err = resetLoginAttempts(env, loginAttemptKeys, requestType)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, requestType)
+		return false
+	}
+
+	return true
+}
+
+// lockoutDuration returns the lockout applied after the given number of failures beyond the maximum attempts, doubling
+// with each failure up to the maximum lockout
+func lockoutDuration(env *env, excessFailures int) time.Duration {
+	policy := env.config.LoginLockout
+	lockout := float64(policy.BaseLockoutSeconds) * math.Pow(2, float64(excessFailures))
+	if lockout > float64(policy.MaxLockoutSeconds) {
+		lockout = float64(policy.MaxLockoutSeconds)
+	}
+	return time.Duration(lockout) * time.Second
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loginFrom attempts to login from the given client address, returning the response
+func loginFrom(t *testing.T, env env, remoteAddr string, email string, password string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(fmt.Sprintf(`{"email": "%s", "password": "%s"}`, email, password)))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+	req.RemoteAddr = remoteAddr
+
+	defaultRouter(&env).ServeHTTP(rec, req)
+	return rec
+}
+
+// Test that an email is locked out after repeated failed login attempts, even with the correct password
+func TestLoginAuthHandlerLocksOutEmail(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	for i := 0; i < mockEnv.config.LoginLockout.EmailMaxAttempts; i++ {
+		res := loginFrom(t, mockEnv, "192.0.2.1:1234", "jay@test.com", "WrongPassword123")
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	res := loginFrom(t, mockEnv, "192.0.2.2:1234", "jay@test.com", "BlackcurrantCrush123")
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if res.Header().Get("Retry-After") != "30" {
+		t.Fatalf("Wrong Retry-After header: %s", res.Header().Get("Retry-After"))
+	}
+
+	// Other emails are unaffected
+	registerAuth(t, mockEnv, "lewis@test.com")
+	res = loginFrom(t, mockEnv, "192.0.2.1:1234", "lewis@test.com", "BlackcurrantCrush123")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that failed login attempts against unknown emails are also counted
+func TestLoginAuthHandlerLocksOutUnknownEmail(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	for i := 0; i < mockEnv.config.LoginLockout.EmailMaxAttempts; i++ {
+		res := loginFrom(t, mockEnv, "192.0.2.1:1234", "jay@test.com", "WrongPassword123")
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	res := loginFrom(t, mockEnv, "192.0.2.1:1234", "jay@test.com", "WrongPassword123")
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a client IP is locked out after repeated failed login attempts across many emails
+func TestLoginAuthHandlerLocksOutClientIP(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	for i := 0; i < mockEnv.config.LoginLockout.IPMaxAttempts; i++ {
+		res := loginFrom(t, mockEnv, "192.0.2.1:1234", fmt.Sprintf("user%d@test.com", i), "WrongPassword123")
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	res := loginFrom(t, mockEnv, "192.0.2.1:1234", "jay@test.com", "BlackcurrantCrush123")
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// Other client IPs are unaffected
+	res = loginFrom(t, mockEnv, "192.0.2.2:1234", "jay@test.com", "BlackcurrantCrush123")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a successful login forgets previous failed login attempts against the email
+func TestLoginAuthHandlerResetsFailuresOnSuccess(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < mockEnv.config.LoginLockout.EmailMaxAttempts-1; i++ {
+			res := loginFrom(t, mockEnv, "192.0.2.1:1234", "jay@test.com", "WrongPassword123")
+			if res.Code != http.StatusUnauthorized {
+				t.Fatalf("Wrong status code: %v", res.Code)
+			}
+		}
+
+		res := loginFrom(t, mockEnv, "192.0.2.1:1234", "jay@test.com", "BlackcurrantCrush123")
+		if res.Code != http.StatusOK {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+}
+
+// Test that failed password checks on authenticated requests count towards the same lockout as login, such that an
+// access token cannot be used to guess the password
+func TestChangePasswordHandlerLocksOutEmail(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	for i := 0; i < mockEnv.config.LoginLockout.EmailMaxAttempts; i++ {
+		res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/password", `{"currentPassword": "WrongPassword123", "newPassword": "RaspberryRipple456"}`, tokens["AccessToken"])
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	for _, request := range []struct {
+		method string
+		url    string
+		body   string
+	}{
+		{http.MethodPut, "/auth/email", `{"email": "jay@example.com", "password": "BlackcurrantCrush123"}`},
+		{http.MethodDelete, "/auth/account", `{"password": "BlackcurrantCrush123"}`},
+	} {
+		res, err := makeAuthenticatedRequest(mockEnv, request.method, request.url, request.body, tokens["AccessToken"])
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "30" {
+			t.Fatalf("Wrong response: %v %s", res.Code, res.Header().Get("Retry-After"))
+		}
+	}
+
+	res := loginFrom(t, mockEnv, "192.0.2.1:1234", "jay@test.com", "BlackcurrantCrush123")
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that the lockout doubles with every further failure, up to the maximum
+func TestLockoutDurationBacksOffExponentially(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for excessFailures, duration := range expected {
+		if lockoutDuration(&mockEnv, excessFailures) != duration {
+			t.Fatalf("Wrong lockout after %d excess failures: %v", excessFailures, lockoutDuration(&mockEnv, excessFailures))
+		}
+	}
+
+	if lockoutDuration(&mockEnv, 20) != time.Hour {
+		t.Fatalf("Lockout was not capped: %v", lockoutDuration(&mockEnv, 20))
+	}
+}
+
+// Test that the client IP is taken from the last X-Forwarded-For entry only when the proxy is trusted
+func TestClientIPUsesTrustedProxy(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "/auth/login", nil)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+	req.RemoteAddr = "172.18.0.5:4321"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1, 198.51.100.7")
+
+	if clientIP(req, true) != "198.51.100.7" {
+		t.Fatalf("Wrong client IP: %s", clientIP(req, true))
+	}
+
+	if clientIP(req, false) != "172.18.0.5" {
+		t.Fatalf("Wrong client IP: %s", clientIP(req, false))
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// listRevokedResponse contains every access token that has been revoked but not yet expired, and every auth whose
+// access tokens issued before a time that may not yet have expired are revoked
+type listRevokedResponse struct {
+	RevokedTokens []readRevokedResponse
+	RevokedAuths  []readRevokedAuthResponse
+}
+
+// readRevokedResponse contains a single revoked access token
+type readRevokedResponse struct {
+	JTI       string
+	ExpiresAt string
+}
+
+// readRevokedAuthResponse contains a single auth, and the time before which every access token issued to it is revoked
+type readRevokedAuthResponse struct {
+	AuthID        uuid.UUID
+	RevokedBefore string
+}
+
+func (env *env) logoutAuthHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestLogout)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestLogout)
+		return
+	}
+
+	input := dao.CreateRevokedTokenInput{
+		JTI:       auth.JTI,
+		AuthID:    auth.ID,
+		ExpiresAt: auth.ExpiresAt,
+	}
+
+	for _, hook := range env.hook.beforeLogoutHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestLogout)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestLogout))
+	_, err = env.dao.CreateRevokedToken(input)
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogout)
+		return
+	}
+
+	// Revoke every refresh token, such that no session can mint further access tokens
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestLogout))
+	err = env.dao.RevokeAuthRefreshTokens(dao.RevokeAuthRefreshTokensInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestLogout)
+		return
+	}
+
+	for _, hook := range env.hook.afterLogoutHooks {
+		err := (*hook)(env, auth)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestLogout)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestLogout).Inc()
+}
+
+func (env *env) listRevokedHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := extractService(env, r.Header, metric.RequestRevoked)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestRevoked)
+		return
+	}
+
+	for _, hook := range env.hook.beforeListRevokedHooks {
+		err := (*hook)(env)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRevoked)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRevoked))
+	revokedTokenList, err := env.dao.ListRevokedToken()
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRevoked)
+		return
+	}
+
+	// Only cutoffs that could revoke an access token that has not yet expired are listed
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRevoked))
+	tokenCutoffList, err := env.dao.ListTokenCutoff(dao.ListTokenCutoffInput{
+		After: time.Now().Add(-accessTokenLifetime),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRevoked)
+		return
+	}
+
+	for _, hook := range env.hook.afterListRevokedHooks {
+		err := (*hook)(env, revokedTokenList)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRevoked)
+			return
+		}
+	}
+
+	revokedListResp := listRevokedResponse{
+		RevokedTokens: make([]readRevokedResponse, 0),
+		RevokedAuths:  make([]readRevokedAuthResponse, 0),
+	}
+	for _, revokedToken := range *revokedTokenList {
+		revokedListResp.RevokedTokens = append(revokedListResp.RevokedTokens, readRevokedResponse{
+			JTI:       revokedToken.JTI,
+			ExpiresAt: revokedToken.ExpiresAt.Format(time.RFC3339),
+		})
+	}
+	for _, tokenCutoff := range *tokenCutoffList {
+		revokedListResp.RevokedAuths = append(revokedListResp.RevokedAuths, readRevokedAuthResponse{
+			AuthID:        tokenCutoff.AuthID,
+			RevokedBefore: tokenCutoff.RevokedBefore.Format(time.RFC3339Nano),
+		})
+	}
+
+	json.NewEncoder(w).Encode(revokedListResp)
+	metric.RequestSuccess.WithLabelValues(metric.RequestRevoked).Inc()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Test that logging out revokes the access token and every refresh token
+func TestLogoutAuthHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The access token can no longer be used
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The refresh token can no longer be used
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that logging out without an access token fails
+func TestLogoutAuthHandlerFailsOnNoToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/logout", "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that logging out with a token signed by another key claiming the same key ID fails
+func TestLogoutAuthHandlerFailsOnForgedToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	forgedKey, err := util.GenerateSigningKey(mockSigningKey.Algorithm)
+	if err != nil {
+		t.Fatalf("Could not generate key: %s", err.Error())
+	}
+	forgedKey.ID = mockSigningKey.ID
+
+	forged, err := createToken(mockEnv.dao.(*mockDAO).authList[0].ID, forgedKey)
+	if err != nil {
+		t.Fatalf("Could not create token: %s", err.Error())
+	}
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", forged)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a before logout hook is successfully invoked and request is aborted
+func TestLogoutAuthHandlerBeforeHookAbortsRequest(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	mockEnv.hook.BeforeLogout(func(env *env, input *dao.CreateRevokedTokenInput) *HookError {
+		return &HookError{http.StatusTeapot, errors.New("Example")}
+	})
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusTeapot {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that the revocation feed contains a logged out access token
+func TestListRevokedHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	_, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/revoked", "", issueServiceToken(t, mockEnv))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded listRevokedResponse
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	token, _, err := new(jwt.Parser).ParseUnverified(tokens["AccessToken"], jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Could not decode JWT: %s", err.Error())
+	}
+
+	jti := token.Claims.(jwt.MapClaims)["jti"]
+	if len(decoded.RevokedTokens) != 1 || decoded.RevokedTokens[0].JTI != jti {
+		t.Fatalf("Revocation feed is incorrect: found %+v, wanted %v", decoded.RevokedTokens, jti)
+	}
+}
+
+// Test that the revocation feed is refused without a token, or with an access token issued to an auth
+func TestListRevokedHandlerFailsWithoutServiceToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	for _, accessToken := range []string{"", tokens["AccessToken"]} {
+		res, err := makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/revoked", "", accessToken)
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+}
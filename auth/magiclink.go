@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// magicLinkRequest contains the client-provided email of the auth to email a login link to
+type magicLinkRequest struct {
+	Email string `valid:"email,required"`
+}
+
+// redeemMagicLinkRequest contains the client-provided magic link token
+type redeemMagicLinkRequest struct {
+	Token string `valid:"type(string),required"`
+}
+
+func (env *env) magicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var req magicLinkRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestMagicLink)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestMagicLink)
+		return
+	}
+
+	input := dao.ReadAuthInput{
+		Email: req.Email,
+	}
+
+	// Magic links are a form of login, so share its hooks, with the request containing no password
+	for _, hook := range env.hook.beforeLoginHooks {
+		err := (*hook)(env, loginAuthRequest{Email: req.Email}, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestMagicLink)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestMagicLink))
+	auth, err := env.dao.ReadAuth(input)
+	timer.ObserveDuration()
+	if err != nil && err != dao.ErrAuthNotFound {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestMagicLink)
+		return
+	}
+
+	// Respond as if the email was sent to an unregistered or suspended auth, such that this endpoint cannot be used to
+	// discover either
+	if auth == nil || auth.Suspended {
+		json.NewEncoder(w).Encode(struct{}{})
+		metric.RequestSuccess.WithLabelValues(metric.RequestMagicLink).Inc()
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestMagicLink))
+	latest, err := env.dao.ReadLatestMagicLink(dao.ReadLatestMagicLinkInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	switch err {
+	case nil:
+		// Silently skip sending another link within the resend interval, as refusing the request would reveal the email
+		// is registered
+		resendInterval := time.Duration(env.config.MagicLink.ResendIntervalSeconds) * time.Second
+		if latest.CreatedAt.Add(resendInterval).After(time.Now()) {
+			json.NewEncoder(w).Encode(struct{}{})
+			metric.RequestSuccess.WithLabelValues(metric.RequestMagicLink).Inc()
+			return
+		}
+	case dao.ErrMagicLinkNotFound:
+		break
+	default:
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestMagicLink)
+		return
+	}
+
+	token, err := createMagicLink(env, auth.ID)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create magic link token: %s", err.Error()), http.StatusInternalServerError, metric.RequestMagicLink)
+		return
+	}
+
+	sendMailInBackground(env, auth.Email, "Log in to your account", magicLinkBody(env.config.MagicLink, token))
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestMagicLink).Inc()
+}
+
+// redeemMagicLinkHandler logs in the auth a magic link was emailed to, which also proves the email belongs to them
+// As with login, a challenge is returned in place of any tokens if two-factor authentication is enabled
+func (env *env) redeemMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var req redeemMagicLinkRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestRedeemMagicLink)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestRedeemMagicLink)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRedeemMagicLink))
+	magicLink, err := env.dao.UseMagicLink(dao.UseMagicLinkInput{
+		TokenHash: util.HashToken(req.Token),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrMagicLinkNotFound:
+			respondWithError(w, "Invalid magic link token", http.StatusUnauthorized, metric.RequestRedeemMagicLink)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRedeemMagicLink)
+		}
+		return
+	}
+
+	if magicLink.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, "Magic link token has expired", http.StatusUnauthorized, metric.RequestRedeemMagicLink)
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRedeemMagicLink))
+	auth, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: magicLink.AuthID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, "Invalid magic link token", http.StatusUnauthorized, metric.RequestRedeemMagicLink)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRedeemMagicLink)
+		}
+		return
+	}
+
+	if auth.Suspended {
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeSuspended, metric.RequestRedeemMagicLink)
+		respondWithError(w, "Account has been suspended", http.StatusForbidden, metric.RequestRedeemMagicLink)
+		return
+	}
+
+	if !auth.EmailVerified {
+		timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRedeemMagicLink))
+		auth, err = env.dao.VerifyAuthEmail(dao.VerifyAuthEmailInput{
+			ID: auth.ID,
+		})
+		timer.ObserveDuration()
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRedeemMagicLink)
+			return
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRedeemMagicLink))
+	twoFactor, err := env.dao.ReadTwoFactor(dao.ReadTwoFactorInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil && err != dao.ErrTwoFactorNotFound {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRedeemMagicLink)
+		return
+	}
+
+	if twoFactor != nil && twoFactor.Enabled {
+		challengeToken, err := createTwoFactorChallenge(env, auth.ID, metric.RequestRedeemMagicLink)
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Could not create challenge token: %s", err.Error()), http.StatusInternalServerError, metric.RequestRedeemMagicLink)
+			return
+		}
+
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeTwoFactorRequired, metric.RequestRedeemMagicLink)
+
+		json.NewEncoder(w).Encode(twoFactorChallengeResponse{
+			ChallengeToken: challengeToken,
+		})
+		metric.RequestSuccess.WithLabelValues(metric.RequestRedeemMagicLink).Inc()
+		return
+	}
+
+	accessToken, err := createAccessToken(env, auth)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestRedeemMagicLink)
+		return
+	}
+
+	refreshToken, err := createRefreshTokenFamily(env, auth.ID, r, metric.RequestRedeemMagicLink)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create refresh token: %s", err.Error()), http.StatusInternalServerError, metric.RequestRedeemMagicLink)
+		return
+	}
+
+	recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeSuccess, metric.RequestRedeemMagicLink)
+
+	for _, hook := range env.hook.afterLoginHooks {
+		err := (*hook)(env, auth, accessToken)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRedeemMagicLink)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(loginAuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestRedeemMagicLink).Inc()
+}
+
+// Create a magic link token for an auth, storing only its hash in the datastore and returning the token to be emailed
+func createMagicLink(env *env, authID uuid.UUID) (string, error) {
+	token, err := util.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestMagicLink))
+	_, err = env.dao.CreateMagicLink(dao.CreateMagicLinkInput{
+		ID:        id,
+		AuthID:    authID,
+		TokenHash: util.HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Duration(env.config.MagicLink.LifetimeSeconds) * time.Second),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Construct the body of a magic link email, linking to the configured login page if there is one
+func magicLinkBody(config util.MagicLinkConfig, token string) string {
+	lifetime := time.Duration(config.LifetimeSeconds) * time.Second
+	if len(config.URL) == 0 {
+		return fmt.Sprintf("Use the following token to log in: %s\n\nIt expires in %s. If you did not request it, you can ignore this email.", token, lifetime)
+	}
+	return fmt.Sprintf("Follow the link below to log in:\n\n%s?token=%s\n\nIt expires in %s. If you did not request it, you can ignore this email.", config.URL, token, lifetime)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/comm"
+	"github.com/TempleEight/spec-golang/auth/dao"
+)
+
+// requestMagicLink requests a magic link for the given email, returning the token emailed to it
+func requestMagicLink(t *testing.T, env env, email string) string {
+	sent := len(env.mailer.(*comm.MemoryMailer).MessagesTo(email))
+	res, err := makeRequest(env, http.MethodPost, "/auth/magic-link", fmt.Sprintf(`{"email": "%s"}`, email))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	awaitEmails(t, env, email, sent+1)
+	return emailedToken(t, env, email)
+}
+
+// Test that a magic link logs in the auth it was emailed to exactly once, verifying their email
+func TestMagicLinkSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := requestMagicLink(t, mockEnv, "jay@test.com")
+
+	if len(mockEnv.dao.(*mockDAO).magicLinkList) != 1 || mockEnv.dao.(*mockDAO).magicLinkList[0].TokenHash == token {
+		t.Fatalf("Magic link was not stored as a hash: %+v", mockEnv.dao.(*mockDAO).magicLinkList)
+	}
+
+	for _, code := range []int{http.StatusOK, http.StatusUnauthorized} {
+		res, err := makeRequest(mockEnv, http.MethodPost, "/auth/magic-link/redeem", fmt.Sprintf(`{"token": "%s"}`, token))
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != code {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+
+		if code == http.StatusOK {
+			var decoded map[string]string
+			decodeBody(t, res.Body.String(), &decoded)
+			if len(decoded["AccessToken"]) == 0 || len(decoded["RefreshToken"]) == 0 {
+				t.Fatalf("Tokens were not issued: %s", res.Body.String())
+			}
+		}
+	}
+
+	if !mockEnv.dao.(*mockDAO).authList[0].EmailVerified {
+		t.Fatalf("Email was not verified")
+	}
+}
+
+// Test that requesting a magic link for an unregistered email responds identically, without sending an email
+func TestMagicLinkDoesNotRevealEmail(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/magic-link", `{"email": "jay@test.com"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK || res.Body.String() != "{}\n" {
+		t.Fatalf("Wrong response: %v %s", res.Code, res.Body.String())
+	}
+
+	if _, ok := mockEnv.mailer.(*comm.MemoryMailer).LastMessage("jay@test.com"); ok {
+		t.Fatalf("Email was sent to an unregistered address")
+	}
+}
+
+// Test that another magic link isn't sent to the same email within the resend interval, while still responding as if it
+// was
+func TestMagicLinkThrottlesResends(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := requestMagicLink(t, mockEnv, "jay@test.com")
+	sent := len(mockEnv.mailer.(*comm.MemoryMailer).MessagesTo("jay@test.com"))
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/magic-link", `{"email": "jay@test.com"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK || res.Body.String() != "{}\n" {
+		t.Fatalf("Wrong response: %v %s", res.Code, res.Body.String())
+	}
+
+	if len(mockEnv.dao.(*mockDAO).magicLinkList) != 1 {
+		t.Fatalf("Another magic link was created within the resend interval: %+v", mockEnv.dao.(*mockDAO).magicLinkList)
+	}
+
+	mockEnv.dao.(*mockDAO).magicLinkList[0].CreatedAt = time.Now().Add(-time.Hour)
+	if requestMagicLink(t, mockEnv, "jay@test.com") == token {
+		t.Fatalf("The same magic link was sent again")
+	}
+
+	if len(mockEnv.mailer.(*comm.MemoryMailer).MessagesTo("jay@test.com")) != sent+1 {
+		t.Fatalf("Wrong number of emails sent: %+v", mockEnv.mailer.(*comm.MemoryMailer).Messages)
+	}
+}
+
+// Test that an expired magic link, or one issued before the credentials were invalidated, is rejected
+func TestRedeemMagicLinkFailsOnStaleToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	token := requestMagicLink(t, mockEnv, "jay@test.com")
+	mockEnv.dao.(*mockDAO).magicLinkList[0].ExpiresAt = time.Now().Add(-time.Minute)
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/magic-link/redeem", fmt.Sprintf(`{"token": "%s"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	token = requestMagicLink(t, mockEnv, "jay@test.com")
+	_, err = mockEnv.dao.InvalidateAuthCredentials(dao.InvalidateAuthCredentialsInput{
+		AuthID:        mockEnv.dao.(*mockDAO).authList[0].ID,
+		RevokedBefore: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Could not invalidate credentials: %s", err.Error())
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/magic-link/redeem", fmt.Sprintf(`{"token": "%s"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that magic links share the login hooks, such that a before login hook can abort the request
+func TestMagicLinkExecutesLoginHooks(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := requestMagicLink(t, mockEnv, "jay@test.com")
+
+	var loggedIn *dao.Auth
+	mockEnv.hook.AfterLogin(func(env *env, auth *dao.Auth, accessToken string) *HookError {
+		loggedIn = auth
+		return nil
+	})
+	mockEnv.hook.BeforeLogin(func(env *env, req loginAuthRequest, input *dao.ReadAuthInput) *HookError {
+		return &HookError{http.StatusTeapot, errors.New("Example")}
+	})
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/magic-link/redeem", fmt.Sprintf(`{"token": "%s"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK || loggedIn == nil || loggedIn.Email != "jay@test.com" {
+		t.Fatalf("After login hook was not executed: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/magic-link", `{"email": "jay@test.com"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusTeapot {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
@@ -6,8 +6,42 @@ import (
 )
 
 var (
-	RequestRegister = "register"
-	RequestLogin    = "login"
+	RequestRegister           = "register"
+	RequestLogin              = "login"
+	RequestRefresh            = "refresh"
+	RequestLogout             = "logout"
+	RequestRevoked            = "revoked"
+	RequestForgotPassword     = "forgot_password"
+	RequestResetPassword      = "reset_password"
+	RequestVerifyEmail        = "verify_email"
+	RequestResendVerification = "resend_verification"
+	RequestChangePassword     = "change_password"
+	RequestChangeEmail        = "change_email"
+	RequestDeleteAccount      = "delete_account"
+	RequestSetupTwoFactor     = "setup_two_factor"
+	RequestConfirmTwoFactor   = "confirm_two_factor"
+	RequestDisableTwoFactor   = "disable_two_factor"
+	RequestVerifyTwoFactor    = "verify_two_factor"
+	RequestJWKS               = "jwks"
+	RequestRotateSigningKey   = "rotate_signing_key"
+	RequestUpdateRole         = "update_role"
+	RequestListAccounts       = "list_accounts"
+	RequestSuspendAccount     = "suspend_account"
+	RequestUnsuspendAccount   = "unsuspend_account"
+	RequestForceLogout        = "force_logout"
+	RequestForcePasswordReset = "force_password_reset"
+	RequestListAdminActions   = "list_admin_actions"
+	RequestServiceToken       = "service_token"
+	RequestOIDCStart          = "oidc_start"
+	RequestOIDCCallback       = "oidc_callback"
+	RequestMagicLink          = "magic_link"
+	RequestRedeemMagicLink    = "redeem_magic_link"
+	RequestCreateAPIKey       = "create_api_key"
+	RequestListAPIKeys        = "list_api_keys"
+	RequestRevokeAPIKey       = "revoke_api_key"
+	RequestIntrospectAPIKey   = "introspect_api_key"
+	RequestListSessions       = "list_sessions"
+	RequestRevokeSession      = "revoke_session"
 
 	RequestSuccess = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "auth_request_success_total",
@@ -19,6 +53,26 @@ var (
 		Help: "The total number of failed requests",
 	}, []string{"request_type", "error_code"})
 
+	RefreshTokenReuse = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auth_refresh_token_reuse_total",
+		Help: "The total number of revoked refresh tokens presented, each of which revokes its token family",
+	})
+
+	AccountDeletionFailure = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_account_deletion_failure_total",
+		Help: "The total number of failed attempts to delete an account from another service, each of which is retried",
+	}, []string{"service"})
+
+	LoginLockout = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_login_lockout_total",
+		Help: "The total number of times an email or client IP has been locked out after repeated failed login attempts",
+	}, []string{"scope"})
+
+	SigningKeyRotation = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_signing_key_rotation_total",
+		Help: "The total number of signing keys that have been made active or retired",
+	}, []string{"state"})
+
 	DatabaseRequestDuration = promauto.NewSummaryVec(prometheus.SummaryOpts{
 		Name:       "auth_database_request_seconds",
 		Help:       "The time spent executing database requests in seconds",
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/comm"
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// oidcStartHandler sends the client to log in with an identity provider, storing the state, nonce and PKCE code
+// verifier needed to complete the login once the provider returns it to the callback
+func (env *env) oidcStartHandler(w http.ResponseWriter, r *http.Request) {
+	providerName := mux.Vars(r)["provider"]
+	provider, ok := env.oidcProviders[providerName]
+	if !ok {
+		respondWithError(w, fmt.Sprintf("Unknown identity provider: %s", providerName), http.StatusNotFound, metric.RequestOIDCStart)
+		return
+	}
+
+	for _, hook := range env.hook.beforeOIDCStartHooks {
+		err := (*hook)(env, providerName)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestOIDCStart)
+			return
+		}
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		secret, err := util.GenerateToken()
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Could not generate state: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCStart)
+			return
+		}
+		secrets[i] = secret
+	}
+	state, nonce, codeVerifier := secrets[0], secrets[1], secrets[2]
+
+	authorizationURL, err := provider.AuthorizationURL(state, nonce, codeVerifier)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Unable to reach identity provider: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCStart)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestOIDCStart))
+	_, err = env.dao.CreateOIDCLogin(dao.CreateOIDCLoginInput{
+		StateHash:    util.HashToken(state),
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(time.Duration(env.config.OIDC.LoginLifetimeSeconds) * time.Second),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCStart)
+		return
+	}
+
+	for _, hook := range env.hook.afterOIDCStartHooks {
+		err := (*hook)(env, providerName, authorizationURL)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestOIDCStart)
+			return
+		}
+	}
+
+	http.Redirect(w, r, authorizationURL, http.StatusFound)
+	metric.RequestSuccess.WithLabelValues(metric.RequestOIDCStart).Inc()
+}
+
+// oidcCallbackHandler completes a login returning from an identity provider, logging in the auth linked to the identity
+// it asserts, or creating one on its first login
+// As with login, a challenge is returned in place of any tokens if two-factor authentication is enabled
+func (env *env) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	providerName := mux.Vars(r)["provider"]
+	provider, ok := env.oidcProviders[providerName]
+	if !ok {
+		respondWithError(w, fmt.Sprintf("Unknown identity provider: %s", providerName), http.StatusNotFound, metric.RequestOIDCCallback)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); len(providerError) > 0 {
+		respondWithError(w, fmt.Sprintf("Identity provider refused login: %s", providerError), http.StatusUnauthorized, metric.RequestOIDCCallback)
+		return
+	}
+
+	state, code := query.Get("state"), query.Get("code")
+	if len(state) == 0 || len(code) == 0 {
+		respondWithError(w, "Invalid request parameters: state and code are required", http.StatusBadRequest, metric.RequestOIDCCallback)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestOIDCCallback))
+	login, err := env.dao.UseOIDCLogin(dao.UseOIDCLoginInput{
+		StateHash: util.HashToken(state),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrOIDCLoginNotFound:
+			respondWithError(w, "Invalid or expired login", http.StatusUnauthorized, metric.RequestOIDCCallback)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCCallback)
+		}
+		return
+	}
+
+	if login.Provider != providerName || time.Now().After(login.ExpiresAt) {
+		respondWithError(w, "Invalid or expired login", http.StatusUnauthorized, metric.RequestOIDCCallback)
+		return
+	}
+
+	identity, err := provider.Exchange(code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		if errors.Is(err, comm.ErrIdentityRejected) {
+			respondWithError(w, fmt.Sprintf("Could not verify identity: %s", err.Error()), http.StatusUnauthorized, metric.RequestOIDCCallback)
+		} else {
+			respondWithError(w, fmt.Sprintf("Unable to reach identity provider: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCCallback)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.beforeOIDCCallbackHooks {
+		err := (*hook)(env, identity)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestOIDCCallback)
+			return
+		}
+	}
+
+	auth, statusCode, err := readOrCreateIdentityAuth(env, identity)
+	if err != nil {
+		respondWithError(w, err.Error(), statusCode, metric.RequestOIDCCallback)
+		return
+	}
+
+	if auth.Suspended {
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeSuspended, metric.RequestOIDCCallback)
+		respondWithError(w, "Account has been suspended", http.StatusForbidden, metric.RequestOIDCCallback)
+		return
+	}
+
+	if env.config.EmailVerification.Mode == util.EmailVerificationRequired && !auth.EmailVerified {
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeEmailUnverified, metric.RequestOIDCCallback)
+		respondWithError(w, "Email address has not been verified", http.StatusForbidden, metric.RequestOIDCCallback)
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestOIDCCallback))
+	twoFactor, err := env.dao.ReadTwoFactor(dao.ReadTwoFactorInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil && err != dao.ErrTwoFactorNotFound {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCCallback)
+		return
+	}
+
+	if twoFactor != nil && twoFactor.Enabled {
+		challengeToken, err := createTwoFactorChallenge(env, auth.ID, metric.RequestOIDCCallback)
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Could not create challenge token: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCCallback)
+			return
+		}
+
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeTwoFactorRequired, metric.RequestOIDCCallback)
+
+		json.NewEncoder(w).Encode(twoFactorChallengeResponse{
+			ChallengeToken: challengeToken,
+		})
+		metric.RequestSuccess.WithLabelValues(metric.RequestOIDCCallback).Inc()
+		return
+	}
+
+	accessToken, err := createAccessToken(env, auth)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCCallback)
+		return
+	}
+
+	refreshToken, err := createRefreshTokenFamily(env, auth.ID, r, metric.RequestOIDCCallback)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create refresh token: %s", err.Error()), http.StatusInternalServerError, metric.RequestOIDCCallback)
+		return
+	}
+
+	recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeSuccess, metric.RequestOIDCCallback)
+
+	for _, hook := range env.hook.afterOIDCCallbackHooks {
+		err := (*hook)(env, auth, accessToken)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestOIDCCallback)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(loginAuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestOIDCCallback).Inc()
+}
+
+// Read the auth linked to an identity, creating one on its first login, returning the status code to respond with on error
+// An auth is only created for an email the provider has verified, and never for an email that already has an auth, as
+// an identity must not be able to take over an account that it has not been linked to
+// The password is one nobody knows, such that a password can only be set through the forgotten password flow
+func readOrCreateIdentityAuth(env *env, identity *comm.OIDCIdentity) (*dao.Auth, int, error) {
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestOIDCCallback))
+	linked, err := env.dao.ReadIdentity(dao.ReadIdentityInput{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+	})
+	timer.ObserveDuration()
+	switch err {
+	case nil:
+		timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestOIDCCallback))
+		auth, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+			ID: linked.AuthID,
+		})
+		timer.ObserveDuration()
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("Something went wrong: %s", err.Error())
+		}
+		return auth, 0, nil
+	case dao.ErrIdentityNotFound:
+		break
+	default:
+		return nil, http.StatusInternalServerError, fmt.Errorf("Something went wrong: %s", err.Error())
+	}
+
+	if len(identity.Email) == 0 || !identity.EmailVerified {
+		return nil, http.StatusForbidden, errors.New("Identity provider has not verified an email address")
+	}
+
+	unusablePassword, err := util.GenerateToken()
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Could not generate password: %s", err.Error())
+	}
+
+	hashedPassword, err := env.passwords.Hash(unusablePassword)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Could not hash password: %s", err.Error())
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Could not create UUID: %s", err.Error())
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestOIDCCallback))
+	auth, err := env.dao.CreateAuthWithIdentity(dao.CreateAuthWithIdentityInput{
+		ID:            id,
+		Email:         identity.Email,
+		Password:      hashedPassword,
+		EmailVerified: true,
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		CreatedAt:     time.Now(),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrDuplicateAuth:
+			return nil, http.StatusForbidden, errors.New("An account already exists for this email address, log in with its password instead")
+		case dao.ErrDuplicateIdentity:
+			return nil, http.StatusForbidden, errors.New("Identity is already linked to an account, try logging in again")
+		default:
+			return nil, http.StatusInternalServerError, fmt.Errorf("Something went wrong: %s", err.Error())
+		}
+	}
+
+	return auth, 0, nil
+}
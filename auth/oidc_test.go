@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/comm"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// stubProviderKey signs the ID tokens issued by the stub provider
+var stubProviderKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+const stubClientID = "spec-golang"
+const stubClientSecret = "stub-client-secret"
+
+// stubProvider is a minimal OpenID Connect identity provider, which logs in a fixed subject on every authorization
+// Each authorization code records the nonce and code challenge it was issued for, such that the token endpoint can
+// check the code verifier and include the nonce in the ID token
+type stubProvider struct {
+	*httptest.Server
+	t *testing.T
+
+	mutex sync.Mutex
+	codes map[string]url.Values
+
+	subject       string
+	email         string
+	emailVerified bool
+	claims        jwt.MapClaims
+}
+
+// newStubProvider starts a stub provider that logs in the given subject with a verified email
+func newStubProvider(t *testing.T, subject string, email string) *stubProvider {
+	provider := &stubProvider{
+		t:             t,
+		codes:         make(map[string]url.Values),
+		subject:       subject,
+		email:         email,
+		emailVerified: true,
+		claims:        jwt.MapClaims{},
+	}
+	provider.Server = httptest.NewServer(http.HandlerFunc(provider.serveHTTP))
+	return provider
+}
+
+func (provider *stubProvider) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 provider.URL,
+			"authorization_endpoint": provider.URL + "/authorize",
+			"token_endpoint":         provider.URL + "/token",
+			"jwks_uri":               provider.URL + "/jwks",
+		})
+	case "/jwks":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "EC",
+					"use": "sig",
+					"kid": "stub-key",
+					"crv": "P-256",
+					"x":   base64.RawURLEncoding.EncodeToString(stubProviderKey.X.Bytes()),
+					"y":   base64.RawURLEncoding.EncodeToString(stubProviderKey.Y.Bytes()),
+				},
+			},
+		})
+	case "/token":
+		provider.serveToken(w, r)
+	default:
+		provider.t.Errorf("Unexpected request to identity provider: %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serveToken exchanges an authorization code for an ID token, provided the client and code verifier are correct
+func (provider *stubProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != stubClientID || clientSecret != stubClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	provider.mutex.Lock()
+	authorization, ok := provider.codes[r.FormValue("code")]
+	delete(provider.codes, r.FormValue("code"))
+	provider.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || authorization.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": "invalid_grant"}`)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":            provider.URL,
+		"aud":            stubClientID,
+		"sub":            provider.subject,
+		"email":          provider.email,
+		"email_verified": provider.emailVerified,
+		"nonce":          authorization.Get("nonce"),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	}
+	for claim, value := range provider.claims {
+		claims[claim] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = "stub-key"
+	idToken, err := token.SignedString(stubProviderKey)
+	if err != nil {
+		provider.t.Errorf("Could not sign ID token: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "stub-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+// authorize logs in at the provider's authorization URL, returning the callback URL the client is redirected to
+func (provider *stubProvider) authorize(t *testing.T, authorizationURL string) string {
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("Could not parse authorization URL: %s", err.Error())
+	}
+
+	query := parsed.Query()
+	if query.Get("client_id") != stubClientID || query.Get("code_challenge_method") != "S256" || len(query.Get("code_challenge")) == 0 {
+		t.Fatalf("Invalid authorization request: %s", authorizationURL)
+	}
+
+	code, err := util.GenerateToken()
+	if err != nil {
+		t.Fatalf("Could not generate code: %s", err.Error())
+	}
+
+	provider.mutex.Lock()
+	provider.codes[code] = query
+	provider.mutex.Unlock()
+
+	return fmt.Sprintf("/auth/oidc/stub/callback?%s", url.Values{"state": {query.Get("state")}, "code": {code}}.Encode())
+}
+
+// makeOIDCEnv returns an environment that can log in with the given stub provider, named stub
+func makeOIDCEnv(provider *stubProvider) env {
+	mockEnv := makeMockEnv()
+	mockEnv.config.OIDC = util.OIDCConfig{
+		LoginLifetimeSeconds: 600,
+		Providers: map[string]util.OIDCProviderConfig{
+			"stub": {
+				Issuer:       provider.URL,
+				ClientID:     stubClientID,
+				ClientSecret: stubClientSecret,
+				RedirectURL:  "http://localhost/auth/oidc/stub/callback",
+			},
+		},
+	}
+	mockEnv.oidcProviders = comm.InitOIDCProviders(mockEnv.config)
+	return mockEnv
+}
+
+// loginWithProvider starts a login with the stub provider, returning the response from the callback
+func loginWithProvider(t *testing.T, env env, provider *stubProvider) *httptest.ResponseRecorder {
+	res, err := makeRequest(env, http.MethodGet, "/auth/oidc/stub/start", "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusFound {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(env, http.MethodGet, provider.authorize(t, res.Header().Get("Location")), "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	return res
+}
+
+// Test that logging in with a provider for the first time creates a verified auth, which later logins are linked to
+func TestOIDCLoginSucceeds(t *testing.T) {
+	provider := newStubProvider(t, "stub-subject", "jay@test.com")
+	defer provider.Close()
+	mockEnv := makeOIDCEnv(provider)
+
+	for i := 0; i < 2; i++ {
+		res := loginWithProvider(t, mockEnv, provider)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+
+		var decoded map[string]string
+		decodeBody(t, res.Body.String(), &decoded)
+		if len(decoded["AccessToken"]) == 0 || len(decoded["RefreshToken"]) == 0 {
+			t.Fatalf("Tokens were not issued: %s", res.Body.String())
+		}
+	}
+
+	authList := mockEnv.dao.(*mockDAO).authList
+	if len(authList) != 1 || authList[0].Email != "jay@test.com" || !authList[0].EmailVerified {
+		t.Fatalf("Wrong auths created: %+v", authList)
+	}
+
+	identityList := mockEnv.dao.(*mockDAO).identityList
+	if len(identityList) != 1 || identityList[0].AuthID != authList[0].ID || identityList[0].Subject != "stub-subject" {
+		t.Fatalf("Wrong identities linked: %+v", identityList)
+	}
+}
+
+// Test that a login can only be completed once
+func TestOIDCCallbackFailsOnReplayedState(t *testing.T) {
+	provider := newStubProvider(t, "stub-subject", "jay@test.com")
+	defer provider.Close()
+	mockEnv := makeOIDCEnv(provider)
+
+	res, err := makeRequest(mockEnv, http.MethodGet, "/auth/oidc/stub/start", "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	callbackURL := provider.authorize(t, res.Header().Get("Location"))
+	for _, code := range []int{http.StatusOK, http.StatusUnauthorized} {
+		res, err = makeRequest(mockEnv, http.MethodGet, callbackURL, "")
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != code {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+}
+
+// Test that ID tokens which were issued to another client, for another login, or by another issuer are rejected
+func TestOIDCCallbackFailsOnInvalidIDToken(t *testing.T) {
+	for _, claims := range []jwt.MapClaims{
+		{"aud": "another-client"},
+		{"nonce": "another-nonce"},
+		{"iss": "http://another-issuer"},
+		{"exp": time.Now().Add(-time.Minute).Unix()},
+	} {
+		provider := newStubProvider(t, "stub-subject", "jay@test.com")
+		provider.claims = claims
+		mockEnv := makeOIDCEnv(provider)
+
+		res := loginWithProvider(t, mockEnv, provider)
+		provider.Close()
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code for %v: %v", claims, res.Code)
+		}
+
+		if len(mockEnv.dao.(*mockDAO).authList) != 0 {
+			t.Fatalf("Auth was created for %v", claims)
+		}
+	}
+}
+
+// Test that an identity cannot take over an existing account with the same email, nor create one for an unverified email
+func TestOIDCCallbackFailsOnUnlinkedEmail(t *testing.T) {
+	provider := newStubProvider(t, "stub-subject", "jay@test.com")
+	defer provider.Close()
+	mockEnv := makeOIDCEnv(provider)
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	res := loginWithProvider(t, mockEnv, provider)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	provider.email = "lewis@test.com"
+	provider.emailVerified = false
+	res = loginWithProvider(t, mockEnv, provider)
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if len(mockEnv.dao.(*mockDAO).authList) != 1 || len(mockEnv.dao.(*mockDAO).identityList) != 0 {
+		t.Fatalf("Identity was linked: %+v", mockEnv.dao.(*mockDAO).identityList)
+	}
+}
+
+// Test that logins fail for an unknown provider, or one that returns an error
+func TestOIDCLoginFailsOnUnknownProvider(t *testing.T) {
+	provider := newStubProvider(t, "stub-subject", "jay@test.com")
+	defer provider.Close()
+	mockEnv := makeOIDCEnv(provider)
+
+	for _, test := range []struct {
+		url  string
+		code int
+	}{
+		{"/auth/oidc/another/start", http.StatusNotFound},
+		{"/auth/oidc/another/callback?state=state&code=code", http.StatusNotFound},
+		{"/auth/oidc/stub/callback?error=access_denied", http.StatusUnauthorized},
+		{"/auth/oidc/stub/callback?state=state", http.StatusBadRequest},
+		{"/auth/oidc/stub/callback?state=state&code=code", http.StatusUnauthorized},
+	} {
+		res, err := makeRequest(mockEnv, http.MethodGet, test.url, "")
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != test.code {
+			t.Fatalf("Wrong status code for %s: %v", test.url, res.Code)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// passwordResetLifetime is how long a password reset token can be redeemed for after being issued
+const passwordResetLifetime = time.Hour
+
+// forgotPasswordRequest contains the client-provided email of the auth whose password has been forgotten
+type forgotPasswordRequest struct {
+	Email string `valid:"email,required"`
+}
+
+// resetPasswordRequest contains the client-provided password reset token and the new password
+type resetPasswordRequest struct {
+	Token    string `valid:"type(string),required"`
+	Password string `valid:"type(string),required,stringlength(8|64)"`
+}
+
+func (env *env) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestForgotPassword)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestForgotPassword)
+		return
+	}
+
+	input := dao.ReadAuthInput{
+		Email: req.Email,
+	}
+
+	for _, hook := range env.hook.beforeForgotPasswordHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestForgotPassword)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestForgotPassword))
+	auth, err := env.dao.ReadAuth(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			// Respond as if the email was sent, such that this endpoint cannot be used to discover registered emails
+			json.NewEncoder(w).Encode(struct{}{})
+			metric.RequestSuccess.WithLabelValues(metric.RequestForgotPassword).Inc()
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestForgotPassword)
+		}
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestForgotPassword))
+	latest, err := env.dao.ReadLatestPasswordReset(dao.ReadLatestPasswordResetInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	switch err {
+	case nil:
+		// Silently skip sending another token within the resend interval, as refusing the request would reveal the email
+		// is registered
+		resendInterval := time.Duration(env.config.PasswordResetResendSeconds) * time.Second
+		if latest.CreatedAt.Add(resendInterval).After(time.Now()) {
+			json.NewEncoder(w).Encode(struct{}{})
+			metric.RequestSuccess.WithLabelValues(metric.RequestForgotPassword).Inc()
+			return
+		}
+	case dao.ErrPasswordResetNotFound:
+		break
+	default:
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestForgotPassword)
+		return
+	}
+
+	token, err := createPasswordReset(env, auth.ID, metric.RequestForgotPassword)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create password reset token: %s", err.Error()), http.StatusInternalServerError, metric.RequestForgotPassword)
+		return
+	}
+
+	sendMailInBackground(env, auth.Email, "Reset your password", passwordResetBody(env.config.PasswordResetURL, token))
+
+	for _, hook := range env.hook.afterForgotPasswordHooks {
+		err := (*hook)(env, auth)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestForgotPassword)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestForgotPassword).Inc()
+}
+
+func (env *env) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestResetPassword)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestResetPassword)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestResetPassword))
+	passwordReset, err := env.dao.ReadPasswordReset(dao.ReadPasswordResetInput{
+		TokenHash: util.HashToken(req.Token),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrPasswordResetNotFound:
+			respondWithError(w, "Invalid password reset token", http.StatusUnauthorized, metric.RequestResetPassword)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestResetPassword)
+		}
+		return
+	}
+
+	if passwordReset.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, "Password reset token has expired", http.StatusUnauthorized, metric.RequestResetPassword)
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestResetPassword))
+	current, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: passwordReset.AuthID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, "Invalid password reset token", http.StatusUnauthorized, metric.RequestResetPassword)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestResetPassword)
+		}
+		return
+	}
+
+	reasons, err := env.passwordPolicy.Check(req.Password, current.Email)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not check password: %s", err.Error()), http.StatusInternalServerError, metric.RequestResetPassword)
+		return
+	}
+
+	if len(reasons) > 0 {
+		respondWithFieldErrors(w, "Password does not meet the password policy", map[string][]string{"Password": reasons}, http.StatusBadRequest, metric.RequestResetPassword)
+		return
+	}
+
+	hashedPassword, err := env.passwords.Hash(req.Password)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not hash password: %s", err.Error()), http.StatusInternalServerError, metric.RequestResetPassword)
+		return
+	}
+
+	input := dao.UpdateAuthPasswordInput{
+		ID:            passwordReset.AuthID,
+		Password:      hashedPassword,
+		RevokedBefore: util.TokenCutoff(time.Now()),
+	}
+
+	for _, hook := range env.hook.beforeResetPasswordHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestResetPassword)
+			return
+		}
+	}
+
+	// Redeem the token before updating the password, such that it can only be used once
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestResetPassword))
+	err = env.dao.DeletePasswordReset(dao.DeletePasswordResetInput{
+		ID: passwordReset.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrPasswordResetNotFound:
+			respondWithError(w, "Invalid password reset token", http.StatusUnauthorized, metric.RequestResetPassword)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestResetPassword)
+		}
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestResetPassword))
+	auth, err := env.dao.UpdateAuthPassword(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, "Invalid password reset token", http.StatusUnauthorized, metric.RequestResetPassword)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestResetPassword)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.afterResetPasswordHooks {
+		err := (*hook)(env, auth)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestResetPassword)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestResetPassword).Inc()
+}
+
+// Create a password reset token for an auth, returning the token to be sent to its email
+func createPasswordReset(env *env, authID uuid.UUID, requestType string) (string, error) {
+	token, err := util.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	_, err = env.dao.CreatePasswordReset(dao.CreatePasswordResetInput{
+		ID:        id,
+		AuthID:    authID,
+		TokenHash: util.HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetLifetime),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Construct the body of a password reset email, linking to the configured reset page if there is one
+func passwordResetBody(resetURL string, token string) string {
+	if len(resetURL) == 0 {
+		return fmt.Sprintf("Use the following token to reset your password: %s\n\nIt expires in %s.", token, passwordResetLifetime)
+	}
+	return fmt.Sprintf("Follow the link below to reset your password:\n\n%s?token=%s\n\nIt expires in %s.", resetURL, token, passwordResetLifetime)
+}
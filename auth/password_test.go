@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/comm"
+	"github.com/TempleEight/spec-golang/auth/dao"
+)
+
+// forgotPassword requests a password reset for the given email, returning the token sent by email
+func forgotPassword(t *testing.T, env env, email string) string {
+	sent := len(env.mailer.(*comm.MemoryMailer).MessagesTo(email))
+	res, err := makeRequest(env, http.MethodPost, "/auth/password/forgot", fmt.Sprintf(`{"email": "%s"}`, email))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	awaitEmails(t, env, email, sent+1)
+	return emailedToken(t, env, email)
+}
+
+// Test that a password can be reset using the emailed token
+func TestResetPasswordHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := forgotPassword(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "RaspberryRipple456"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The new password can be used to login
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "RaspberryRipple456"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The old password can no longer be used
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a password reset token can only be used once
+func TestResetPasswordHandlerFailsOnReusedToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := forgotPassword(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "RaspberryRipple456"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "MintChocChip789"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that resetting a password invalidates every other outstanding reset token and refresh token
+func TestResetPasswordHandlerInvalidatesOutstandingTokens(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	firstToken := forgotPassword(t, mockEnv, "jay@test.com")
+	mockEnv.dao.(*mockDAO).passwordResetList[0].CreatedAt = time.Now().Add(-time.Hour)
+	secondToken := forgotPassword(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "RaspberryRipple456"}`, secondToken))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "MintChocChip789"}`, firstToken))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that an expired password reset token cannot be used
+func TestResetPasswordHandlerFailsOnExpiredToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := forgotPassword(t, mockEnv, "jay@test.com")
+
+	mockEnv.dao.(*mockDAO).passwordResetList[0].ExpiresAt = time.Now().Add(-time.Minute)
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "RaspberryRipple456"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that an unknown password reset token cannot be used
+func TestResetPasswordHandlerFailsOnUnknownToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", `{"token": "NotAToken", "password": "RaspberryRipple456"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a short password is rejected
+func TestResetPasswordHandlerFailsOnShortPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := forgotPassword(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "short"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that requesting a reset for an unregistered email responds identically, without sending an email
+func TestForgotPasswordHandlerSucceedsOnUnknownEmail(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/forgot", `{"email": "jay@test.com"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if len(mockEnv.mailer.(*comm.MemoryMailer).Messages) != 0 {
+		t.Fatalf("An email was sent to an unregistered address")
+	}
+}
+
+// Test that another reset token isn't sent to the same email within the resend interval, while still responding as if it
+// was
+func TestForgotPasswordHandlerThrottlesResends(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := forgotPassword(t, mockEnv, "jay@test.com")
+	sent := len(mockEnv.mailer.(*comm.MemoryMailer).MessagesTo("jay@test.com"))
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/forgot", `{"email": "jay@test.com"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK || res.Body.String() != "{}\n" {
+		t.Fatalf("Wrong response: %v %s", res.Code, res.Body.String())
+	}
+
+	if len(mockEnv.dao.(*mockDAO).passwordResetList) != 1 {
+		t.Fatalf("Another reset token was created within the resend interval: %+v", mockEnv.dao.(*mockDAO).passwordResetList)
+	}
+
+	mockEnv.dao.(*mockDAO).passwordResetList[0].CreatedAt = time.Now().Add(-time.Hour)
+	if forgotPassword(t, mockEnv, "jay@test.com") == token {
+		t.Fatalf("The same reset token was sent again")
+	}
+
+	if len(mockEnv.mailer.(*comm.MemoryMailer).MessagesTo("jay@test.com")) != sent+1 {
+		t.Fatalf("Wrong number of emails sent: %+v", mockEnv.mailer.(*comm.MemoryMailer).Messages)
+	}
+}
+
+// Test that a before reset password hook can abort the request
+func TestResetPasswordHandlerBeforeHookAborts(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := forgotPassword(t, mockEnv, "jay@test.com")
+
+	mockEnv.hook.BeforeResetPassword(func(env *env, req resetPasswordRequest, input *dao.UpdateAuthPasswordInput) *HookError {
+		return &HookError{http.StatusTeapot, errors.New("Something bad happened")}
+	})
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "RaspberryRipple456"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusTeapot {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The token has not been redeemed, so can still be used
+	if len(mockEnv.dao.(*mockDAO).passwordResetList) != 1 {
+		t.Fatalf("Password reset token was redeemed")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TempleEight/spec-golang/auth/util"
+)
+
+// fieldErrors decodes the reasons each request field was refused from an error response
+func fieldErrors(t *testing.T, body string) map[string][]string {
+	var decoded struct {
+		Error  string
+		Fields map[string][]string
+	}
+	decodeBody(t, body, &decoded)
+	return decoded.Fields
+}
+
+// writeBreachedRanges writes Have I Been Pwned range files listing the given passwords to a temporary directory,
+// returning the directory
+func writeBreachedRanges(t *testing.T, passwords ...string) string {
+	dir, err := ioutil.TempDir("", "breached")
+	if err != nil {
+		t.Fatalf("Could not create directory: %s", err.Error())
+	}
+
+	for _, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		// Each range is padded with an entry that has a count of 0, as they are when downloaded
+		contents := fmt.Sprintf("%s:0\r\n%s:42\r\n", strings.Repeat("0", 35), hash[5:])
+		err := ioutil.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("Could not write range: %s", err.Error())
+		}
+	}
+
+	return dir
+}
+
+// Test that weak passwords, and those containing the email or a banned substring, are refused with a reason for each
+func TestRegisterFailsOnWeakPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	for _, test := range []struct {
+		password string
+		reasons  int
+	}{
+		{"aaaaaaaaaaaa", 1},
+		{"abcdefgh12345678", 1},
+		{"JayBlackcurrant99", 1},
+		{"MyPassword!Cherry", 1},
+		{"jaypassword", 2},
+	} {
+		res, err := makeRequest(mockEnv, http.MethodPost, "/auth/register", fmt.Sprintf(`{"email": "jay@test.com", "password": "%s"}`, test.password))
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusBadRequest {
+			t.Fatalf("Wrong status code for %s: %v", test.password, res.Code)
+		}
+
+		fields := fieldErrors(t, res.Body.String())
+		if len(fields) != 1 || len(fields["Password"]) != test.reasons {
+			t.Fatalf("Wrong reasons for %s: %v", test.password, fields)
+		}
+	}
+
+	if len(mockEnv.dao.(*mockDAO).authList) != 0 {
+		t.Fatalf("Auth was created with a weak password")
+	}
+}
+
+// Test that passwords listed in the breached password ranges are refused on register and password change
+func TestBreachedPasswordIsRefused(t *testing.T) {
+	dir := writeBreachedRanges(t, "BlackcurrantCrush123", "RaspberryRipple456")
+	defer os.RemoveAll(dir)
+
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	config := mockPasswordPolicy
+	config.BreachedRangeDirectory = dir
+	passwordPolicy, err := util.NewPasswordPolicy(config)
+	if err != nil {
+		t.Fatalf("Could not set up password policy: %s", err.Error())
+	}
+	mockEnv.passwordPolicy = passwordPolicy
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/register", `{"email": "lewis@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusBadRequest || len(fieldErrors(t, res.Body.String())["Password"]) != 1 {
+		t.Fatalf("Wrong response: %v %s", res.Code, res.Body.String())
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/password", `{"currentPassword": "BlackcurrantCrush123", "newPassword": "RaspberryRipple456"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusBadRequest || len(fieldErrors(t, res.Body.String())["NewPassword"]) != 1 {
+		t.Fatalf("Wrong response: %v %s", res.Code, res.Body.String())
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPut, "/auth/password", `{"currentPassword": "BlackcurrantCrush123", "newPassword": "MintChocChip789"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a password reset cannot set a password that contains the email
+func TestResetPasswordFailsOnWeakPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := forgotPassword(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/password/reset", fmt.Sprintf(`{"token": "%s", "password": "JayRaspberry456"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusBadRequest || len(fieldErrors(t, res.Body.String())["Password"]) != 1 {
+		t.Fatalf("Wrong response: %v %s", res.Code, res.Body.String())
+	}
+}
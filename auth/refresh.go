@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// refreshTokenLifetime is how long a refresh token can be exchanged for before the user must login again
+const refreshTokenLifetime = 30 * 24 * time.Hour
+
+// refreshAuthRequest contains the client-provided refresh token to be exchanged for a new access token
+type refreshAuthRequest struct {
+	RefreshToken string `valid:"type(string),required"`
+}
+
+// refreshAuthResponse contains an access token and the refresh token that replaces the one provided
+type refreshAuthResponse struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+func (env *env) refreshAuthHandler(w http.ResponseWriter, r *http.Request) {
+	var req refreshAuthRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestRefresh)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestRefresh)
+		return
+	}
+
+	input := dao.ReadRefreshTokenInput{
+		TokenHash: util.HashToken(req.RefreshToken),
+	}
+
+	for _, hook := range env.hook.beforeRefreshHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRefresh)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRefresh))
+	refreshToken, err := env.dao.ReadRefreshToken(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrRefreshTokenNotFound:
+			recordLoginEvent(env, r, nil, "", loginEventRefresh, loginOutcomeInvalidToken, metric.RequestRefresh)
+			respondWithError(w, "Invalid refresh token", http.StatusUnauthorized, metric.RequestRefresh)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRefresh)
+		}
+		return
+	}
+
+	if refreshToken.ExpiresAt.Before(time.Now()) {
+		recordLoginEvent(env, r, &refreshToken.AuthID, "", loginEventRefresh, loginOutcomeExpiredToken, metric.RequestRefresh)
+		respondWithError(w, "Refresh token has expired", http.StatusUnauthorized, metric.RequestRefresh)
+		return
+	}
+
+	// Read the auth again, such that the access token reflects any change in email verification
+	// The account is checked before the refresh token is rotated, such that a refused request does not use it up
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRefresh))
+	auth, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: refreshToken.AuthID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, "Invalid refresh token", http.StatusUnauthorized, metric.RequestRefresh)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRefresh)
+		}
+		return
+	}
+
+	if auth.Suspended {
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventRefresh, loginOutcomeSuspended, metric.RequestRefresh)
+		respondWithError(w, "Account has been suspended", http.StatusForbidden, metric.RequestRefresh)
+		return
+	}
+
+	// The email may have been changed since the refresh token was issued
+	if env.config.EmailVerification.Mode == util.EmailVerificationRequired && !auth.EmailVerified {
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventRefresh, loginOutcomeEmailUnverified, metric.RequestRefresh)
+		respondWithError(w, "Email address has not been verified", http.StatusForbidden, metric.RequestRefresh)
+		return
+	}
+
+	// Rotate the refresh token, such that it can only be exchanged once
+	// A token that has already been exchanged is assumed to have been stolen, so every token in the family is revoked,
+	// while one revoked by logging out or invalidating credentials is simply refused
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRefresh))
+	err = env.dao.RevokeRefreshToken(dao.RevokeRefreshTokenInput{
+		ID: refreshToken.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrRefreshTokenReused:
+			err = revokeRefreshTokenFamily(env, refreshToken.FamilyID, metric.RequestRefresh)
+			if err != nil {
+				respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRefresh)
+				return
+			}
+			metric.RefreshTokenReuse.Inc()
+			recordLoginEvent(env, r, &refreshToken.AuthID, "", loginEventRefresh, loginOutcomeReusedToken, metric.RequestRefresh)
+			respondWithError(w, "Refresh token has been revoked", http.StatusUnauthorized, metric.RequestRefresh)
+		case dao.ErrRefreshTokenRevoked, dao.ErrRefreshTokenNotFound:
+			recordLoginEvent(env, r, &refreshToken.AuthID, "", loginEventRefresh, loginOutcomeInvalidToken, metric.RequestRefresh)
+			respondWithError(w, "Refresh token has been revoked", http.StatusUnauthorized, metric.RequestRefresh)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRefresh)
+		}
+		return
+	}
+
+	newRefreshToken, err := createRefreshToken(env, refreshToken.AuthID, refreshToken.FamilyID, metric.RequestRefresh)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create refresh token: %s", err.Error()), http.StatusInternalServerError, metric.RequestRefresh)
+		return
+	}
+
+	accessToken, err := createAccessToken(env, auth)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestRefresh)
+		return
+	}
+
+	touchSession(env, refreshToken.FamilyID, r)
+	recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventRefresh, loginOutcomeSuccess, metric.RequestRefresh)
+
+	for _, hook := range env.hook.afterRefreshHooks {
+		err := (*hook)(env, refreshToken, accessToken)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRefresh)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(refreshAuthResponse{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestRefresh).Inc()
+}
+
+// Create a refresh token starting a new family, such that each login can be revoked independently
+// The family is recorded as a session, alongside the device the request was made from
+func createRefreshTokenFamily(env *env, authID uuid.UUID, r *http.Request, requestType string) (string, error) {
+	familyID, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	err = createSession(env, familyID, authID, r, requestType)
+	if err != nil {
+		return "", err
+	}
+
+	return createRefreshToken(env, authID, familyID, requestType)
+}
+
+// Create a refresh token belonging to the given family, storing only its hash in the datastore
+func createRefreshToken(env *env, authID uuid.UUID, familyID uuid.UUID, requestType string) (string, error) {
+	token, err := util.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	_, err = env.dao.CreateRefreshToken(dao.CreateRefreshTokenInput{
+		ID:        id,
+		AuthID:    authID,
+		FamilyID:  familyID,
+		TokenHash: util.HashToken(token),
+		ExpiresAt: time.Now().Add(refreshTokenLifetime),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Revoke every refresh token in the given family
+func revokeRefreshTokenFamily(env *env, familyID uuid.UUID, requestType string) error {
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	err := env.dao.RevokeRefreshTokenFamily(dao.RevokeRefreshTokenFamilyInput{
+		FamilyID: familyID,
+	})
+	timer.ObserveDuration()
+	return err
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+)
+
+// Test that a refresh token can be exchanged for a new access token and refresh token
+func TestRefreshAuthHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	if len(decoded["AccessToken"]) == 0 {
+		t.Fatalf("Response doesn't contain an access token")
+	}
+
+	if len(decoded["RefreshToken"]) == 0 || decoded["RefreshToken"] == tokens["RefreshToken"] {
+		t.Fatalf("Refresh token was not rotated: %s", decoded["RefreshToken"])
+	}
+}
+
+// Test that reusing a rotated refresh token fails and revokes every token in its family
+func TestRefreshAuthHandlerRevokesFamilyOnReuse(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	var rotated map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &rotated)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	// Replay the original refresh token
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// The legitimately rotated token must no longer work either
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, rotated["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that retrying a refresh token revoked by logging out is refused without being treated as reuse
+func TestRefreshAuthHandlerFailsOnLoggedOutTokenWithoutReuse(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	for _, loginEvent := range mockEnv.dao.(*mockDAO).loginEventList {
+		if loginEvent.Outcome == loginOutcomeReusedToken {
+			t.Fatalf("Logged out refresh token was treated as reused: %+v", loginEvent)
+		}
+	}
+}
+
+// Test that reusing a token in one family does not revoke a separate login
+func TestRefreshAuthHandlerReuseDoesNotRevokeOtherFamilies(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	var login map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &login)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	// Exchange the registration token twice, revoking its family
+	for i := 0; i < 2; i++ {
+		_, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, login["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that an expired refresh token cannot be exchanged
+func TestRefreshAuthHandlerFailsOnExpiredToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	mockDAO := mockEnv.dao.(*mockDAO)
+	for i := range mockDAO.refreshTokenList {
+		mockDAO.refreshTokenList[i].ExpiresAt = time.Now().Add(-time.Minute)
+	}
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a refresh refused because the account is suspended does not use up the refresh token
+func TestRefreshAuthHandlerSuspendedDoesNotRotateToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	mockDAO := mockEnv.dao.(*mockDAO)
+
+	for _, suspended := range []bool{true, false} {
+		mockDAO.authList[0].Suspended = suspended
+
+		res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		expected := http.StatusOK
+		if suspended {
+			expected = http.StatusForbidden
+		}
+		if res.Code != expected {
+			t.Fatalf("Wrong status code when suspended is %v: %v", suspended, res.Code)
+		}
+	}
+
+	if len(mockDAO.refreshTokenList) != 2 {
+		t.Fatalf("Wrong number of refresh tokens: %d", len(mockDAO.refreshTokenList))
+	}
+}
+
+// Test that providing an unknown refresh token fails
+func TestRefreshAuthHandlerFailsOnUnknownToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", `{"RefreshToken": "idonotexist"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that providing no body to the refresh endpoint fails
+func TestRefreshAuthHandlerFailsOnNoBody(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a before refresh hook is successfully invoked and request is aborted
+func TestRefreshAuthHandlerBeforeHookAbortsRequest(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	mockEnv.hook.BeforeRefresh(func(env *env, req refreshAuthRequest, input *dao.ReadRefreshTokenInput) *HookError {
+		return &HookError{http.StatusTeapot, errors.New("Example")}
+	})
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusTeapot {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that an after refresh hook is successfully invoked
+func TestRefreshAuthHandlerAfterHookSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	isHookExecuted := false
+
+	mockEnv.hook.AfterRefresh(func(env *env, refreshToken *dao.RefreshToken, accessToken string) *HookError {
+		isHookExecuted = true
+		return nil
+	})
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if !isHookExecuted {
+		t.Fatalf("Hook was not executed")
+	}
+}
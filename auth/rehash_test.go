@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/TempleEight/spec-golang/auth/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// login logs in with the password every test auth is registered with, failing the test unless it succeeds
+func login(t *testing.T, env env, email string) {
+	res, err := makeRequest(env, http.MethodPost, "/auth/login", fmt.Sprintf(`{"email": "%s", "password": "BlackcurrantCrush123"}`, email))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that both hashers verify their own hashes, rejecting the wrong password
+func TestPasswordsVerifiesEachAlgorithm(t *testing.T) {
+	for _, algorithm := range []string{util.PasswordAlgorithmBcrypt, util.PasswordAlgorithmArgon2id} {
+		config := mockPasswordHashing
+		config.Algorithm = algorithm
+		passwords, err := util.NewPasswords(config)
+		if err != nil {
+			t.Fatalf("Could not set up %s: %s", algorithm, err.Error())
+		}
+
+		hash, err := passwords.Hash("BlackcurrantCrush123")
+		if err != nil {
+			t.Fatalf("Could not hash with %s: %s", algorithm, err.Error())
+		}
+
+		rehash, err := passwords.Verify(hash, "BlackcurrantCrush123")
+		if err != nil || rehash {
+			t.Fatalf("Could not verify %s hash %s: %v %v", algorithm, hash, rehash, err)
+		}
+
+		_, err = passwords.Verify(hash, "RaspberryRipple456")
+		if err != util.ErrPasswordMismatch {
+			t.Fatalf("Wrong password was not rejected by %s: %v", algorithm, err)
+		}
+	}
+}
+
+// Test that a bcrypt hash is migrated to Argon2id on the next successful login, after which login still succeeds
+func TestLoginRehashesBcryptPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("BlackcurrantCrush123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Could not hash password: %s", err.Error())
+	}
+	mockEnv.dao.(*mockDAO).authList[0].Password = string(hash)
+
+	login(t, mockEnv, "jay@test.com")
+	if !strings.HasPrefix(mockEnv.dao.(*mockDAO).authList[0].Password, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("Password was not rehashed: %s", mockEnv.dao.(*mockDAO).authList[0].Password)
+	}
+
+	login(t, mockEnv, "jay@test.com")
+}
+
+// Test that changing the target parameters rehashes existing hashes on login, but a failed login leaves them unchanged
+func TestLoginRehashesOnChangedParameters(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	hash := mockEnv.dao.(*mockDAO).authList[0].Password
+
+	config := mockPasswordHashing
+	config.Argon2id.Time = 2
+	passwords, err := util.NewPasswords(config)
+	if err != nil {
+		t.Fatalf("Could not set up passwords: %s", err.Error())
+	}
+	mockEnv.passwords = passwords
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "RaspberryRipple456"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized || mockEnv.dao.(*mockDAO).authList[0].Password != hash {
+		t.Fatalf("Failed login changed the password: %v", res.Code)
+	}
+
+	login(t, mockEnv, "jay@test.com")
+	if !strings.Contains(mockEnv.dao.(*mockDAO).authList[0].Password, "$m=64,t=2,p=1$") {
+		t.Fatalf("Password was not rehashed: %s", mockEnv.dao.(*mockDAO).authList[0].Password)
+	}
+}
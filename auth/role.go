@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// updateRoleRequest contains the client-provided role to grant
+type updateRoleRequest struct {
+	Role string `valid:"in(user|moderator|admin),required"`
+}
+
+// updateRoleResponse contains the auth after its role has been changed
+type updateRoleResponse struct {
+	ID    uuid.UUID
+	Email string
+	Role  string
+}
+
+func (env *env) updateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestUpdateRole)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestUpdateRole)
+		return
+	}
+
+	authID, err := util.ExtractIDFromRequest(mux.Vars(r))
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest, metric.RequestUpdateRole)
+		return
+	}
+
+	if !adminPolicy(auth, authID) {
+		respondWithError(w, "Not authorized to make request", http.StatusForbidden, metric.RequestUpdateRole)
+		return
+	}
+
+	// Admins cannot demote themselves, such that there is always an admin able to grant the role back
+	if auth.ID == authID {
+		respondWithError(w, "Cannot change your own role", http.StatusForbidden, metric.RequestUpdateRole)
+		return
+	}
+
+	var req updateRoleRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestUpdateRole)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestUpdateRole)
+		return
+	}
+
+	adminAction, err := newAdminAction(auth, authID, adminActionUpdateRole, req.Role)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestUpdateRole)
+		return
+	}
+
+	// Changing the role revokes every token of the auth, such that none continue to carry the previous role
+	input := dao.UpdateAuthRoleInput{
+		ID:          authID,
+		Role:        req.Role,
+		AdminAction: *adminAction,
+	}
+
+	for _, hook := range env.hook.beforeUpdateRoleHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestUpdateRole)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestUpdateRole))
+	updated, err := env.dao.UpdateAuthRole(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, err.Error(), http.StatusNotFound, metric.RequestUpdateRole)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestUpdateRole)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.afterUpdateRoleHooks {
+		err := (*hook)(env, updated)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestUpdateRole)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(updateRoleResponse{
+		ID:    updated.ID,
+		Email: updated.Email,
+		Role:  updated.Role,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestUpdateRole).Inc()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// loginAdmin registers an auth, grants it the admin role in the datastore, and returns an access token including the role
+func loginAdmin(t *testing.T, env env, email string) string {
+	registerAuth(t, env, email)
+	for i, auth := range env.dao.(*mockDAO).authList {
+		if auth.Email == email {
+			env.dao.(*mockDAO).authList[i].Role = util.RoleAdmin
+		}
+	}
+
+	res, err := makeRequest(env, http.MethodPost, "/auth/login", fmt.Sprintf(`{"email": "%s", "password": "BlackcurrantCrush123"}`, email))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	return decoded["AccessToken"]
+}
+
+// Test that access tokens include the role of the auth, defaulting to user
+func TestAccessTokenIncludesRole(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	token, _, err := new(jwt.Parser).ParseUnverified(tokens["AccessToken"], jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Could not decode JWT: %s", err.Error())
+	}
+
+	if role := token.Claims.(jwt.MapClaims)["role"]; role != util.RoleUser {
+		t.Fatalf("Wrong role: %v", role)
+	}
+}
+
+// Test that an admin can change the role of another auth, revoking its tokens, such that the role is included in the
+// tokens it next logs in with, and that the change is audited
+func TestUpdateRoleSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	target := mockEnv.dao.(*mockDAO).authList[1]
+
+	// Tokens issued within the same millisecond as the cutoff remain valid
+	time.Sleep(2 * util.TokenTimePrecision)
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, fmt.Sprintf("/auth/admin/accounts/%s/role", target.ID), `{"role": "moderator"}`, accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	expected := fmt.Sprintf(`{"ID":"%s","Email":"jay@test.com","Role":"moderator"}`, target.ID)
+	received := res.Body.String()
+	if expected != received[:len(received)-1] {
+		t.Fatalf("Handler returned incorrect body: got %s want %s", received, expected)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"refreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodGet, "/auth/sessions", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	token, _, err := new(jwt.Parser).ParseUnverified(decoded["AccessToken"], jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Could not decode JWT: %s", err.Error())
+	}
+
+	if role := token.Claims.(jwt.MapClaims)["role"]; role != util.RoleModerator {
+		t.Fatalf("Wrong role: %v", role)
+	}
+
+	actions := mockEnv.dao.(*mockDAO).adminActionList
+	if len(actions) != 1 || actions[0].Action != adminActionUpdateRole || actions[0].Detail != util.RoleModerator {
+		t.Fatalf("Role change was not audited: %+v", actions)
+	}
+}
+
+// Test that only admins can change roles
+func TestUpdateRoleFailsForNonAdmin(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	registerAuth(t, mockEnv, "lewis@test.com")
+	target := mockEnv.dao.(*mockDAO).authList[1]
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, fmt.Sprintf("/auth/admin/accounts/%s/role", target.ID), `{"role": "admin"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if mockEnv.dao.(*mockDAO).authList[1].Role != util.RoleUser {
+		t.Fatalf("Role was changed: %s", mockEnv.dao.(*mockDAO).authList[1].Role)
+	}
+}
+
+// Test that an admin cannot change their own role
+func TestUpdateRoleFailsForSelf(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+	admin := mockEnv.dao.(*mockDAO).authList[0]
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, fmt.Sprintf("/auth/admin/accounts/%s/role", admin.ID), `{"role": "user"}`, accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that changing the role of an auth fails for an unknown role or auth
+func TestUpdateRoleFailsForInvalidRequest(t *testing.T) {
+	mockEnv := makeMockEnv()
+	accessToken := loginAdmin(t, mockEnv, "admin@test.com")
+	registerAuth(t, mockEnv, "jay@test.com")
+	target := mockEnv.dao.(*mockDAO).authList[1]
+
+	for _, test := range []struct {
+		url  string
+		body string
+		code int
+	}{
+		{fmt.Sprintf("/auth/admin/accounts/%s/role", target.ID), `{"role": "owner"}`, http.StatusBadRequest},
+		{"/auth/admin/accounts/123/role", `{"role": "moderator"}`, http.StatusBadRequest},
+		{"/auth/admin/accounts/00000000-1234-5678-9012-000000000000/role", `{"role": "moderator"}`, http.StatusNotFound},
+	} {
+		res, err := makeAuthenticatedRequest(mockEnv, http.MethodPut, test.url, test.body, accessToken)
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != test.code {
+			t.Fatalf("Wrong status code for %s %s: %v", test.url, test.body, res.Code)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// clientCredentialsGrant is the only OAuth2 grant type that the token endpoint supports
+const clientCredentialsGrant = "client_credentials"
+
+// serviceTokenRequest contains the client-provided credentials of a service, sent as a form as specified by OAuth2
+type serviceTokenRequest struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+}
+
+// serviceTokenResponse contains a service token, in the format specified by OAuth2
+type serviceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// serviceTokenHandler issues a short-lived service token to another service, following the OAuth2 client credentials
+// grant, such that it can call other services independently of any end user's access token
+// The credentials may be provided using HTTP basic authentication or in the form
+func (env *env) serviceTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestServiceToken)
+		return
+	}
+
+	req := serviceTokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+	}
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		req.ClientID, req.ClientSecret = clientID, clientSecret
+	}
+
+	if req.GrantType != clientCredentialsGrant {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: grant_type must be %s", clientCredentialsGrant), http.StatusBadRequest, metric.RequestServiceToken)
+		return
+	}
+
+	// Compare hashes in constant time, such that the secret cannot be discovered by timing responses
+	secretHash, ok := env.config.ServiceClients.Secrets[req.ClientID]
+	if !ok || len(req.ClientSecret) == 0 || subtle.ConstantTimeCompare([]byte(util.HashToken(req.ClientSecret)), []byte(secretHash)) != 1 {
+		respondWithError(w, "Invalid client credentials", http.StatusUnauthorized, metric.RequestServiceToken)
+		return
+	}
+
+	for _, hook := range env.hook.beforeServiceTokenHooks {
+		err := (*hook)(env, req)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestServiceToken)
+			return
+		}
+	}
+
+	accessToken, err := createServiceToken(env, req.ClientID)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestServiceToken)
+		return
+	}
+
+	for _, hook := range env.hook.afterServiceTokenHooks {
+		err := (*hook)(env, req.ClientID, accessToken)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestServiceToken)
+			return
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(serviceTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   env.config.ServiceClients.TokenLifetimeSeconds,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestServiceToken).Inc()
+}
+
+// Create a service token, which contains a service claim naming the service in place of the id of an auth
+func createServiceToken(env *env, service string) (string, error) {
+	lifetime := time.Duration(env.config.ServiceClients.TokenLifetimeSeconds) * time.Second
+	return signToken(env.keyRing.activeKey(), jwt.MapClaims{"service": service}, lifetime)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// requestServiceToken makes a client credentials request to the token endpoint, using HTTP basic authentication if a
+// client ID is given and the form otherwise
+func requestServiceToken(env env, clientID string, form url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(clientID) > 0 {
+		req.SetBasicAuth(clientID, mockServiceSecret)
+	}
+	defaultRouter(&env).ServeHTTP(rec, req)
+	return rec
+}
+
+// issueServiceToken requests a service token for the match service, returning the access token from the response
+func issueServiceToken(t *testing.T, env env) string {
+	res := requestServiceToken(env, "match", url.Values{"grant_type": {"client_credentials"}})
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded serviceTokenResponse
+	err := json.Unmarshal(res.Body.Bytes(), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	return decoded.AccessToken
+}
+
+// Test that a service token is issued for valid client credentials, naming the service in place of an auth
+func TestServiceTokenSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	for _, res := range []*httptest.ResponseRecorder{
+		requestServiceToken(mockEnv, "match", url.Values{"grant_type": {"client_credentials"}}),
+		requestServiceToken(mockEnv, "", url.Values{"grant_type": {"client_credentials"}, "client_id": {"match"}, "client_secret": {mockServiceSecret}}),
+	} {
+		if res.Code != http.StatusOK {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+
+		if res.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("Service token may be cached: %s", res.Header().Get("Cache-Control"))
+		}
+
+		var decoded serviceTokenResponse
+		err := json.Unmarshal(res.Body.Bytes(), &decoded)
+		if err != nil {
+			t.Fatalf("Could not decode json: %s", err.Error())
+		}
+
+		if decoded.TokenType != "Bearer" || decoded.ExpiresIn != 300 {
+			t.Fatalf("Wrong response: %+v", decoded)
+		}
+
+		token, err := jwt.Parse(decoded.AccessToken, mockSigningKey.Keyfunc)
+		if err != nil {
+			t.Fatalf("Could not verify service token: %s", err.Error())
+		}
+
+		claims := token.Claims.(jwt.MapClaims)
+		if claims["service"] != "match" || claims["id"] != nil || claims["iss"] != mockSigningKey.ID || claims["jti"] == nil {
+			t.Fatalf("Wrong claims: %v", claims)
+		}
+	}
+}
+
+// Test that a service token is refused for invalid client credentials or an unsupported grant
+func TestServiceTokenFailsOnInvalidCredentials(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	for _, test := range []struct {
+		form url.Values
+		code int
+	}{
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"match"}, "client_secret": {"wrong"}}, http.StatusUnauthorized},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"user"}, "client_secret": {mockServiceSecret}}, http.StatusUnauthorized},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"match"}}, http.StatusUnauthorized},
+		{url.Values{"grant_type": {"password"}, "client_id": {"match"}, "client_secret": {mockServiceSecret}}, http.StatusBadRequest},
+	} {
+		res := requestServiceToken(mockEnv, "", test.form)
+		if res.Code != test.code {
+			t.Fatalf("Wrong status code for %v: %v", test.form, res.Code)
+		}
+	}
+}
+
+// Test that a service token cannot be used in place of an access token for an auth
+func TestServiceTokenRejectedByAuthEndpoints(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	accessToken, err := createServiceToken(&mockEnv, "match")
+	if err != nil {
+		t.Fatalf("Could not create service token: %s", err.Error())
+	}
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/logout", "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
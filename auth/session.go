@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Events recorded in the login audit trail
+const (
+	loginEventRegister = "register"
+	loginEventLogin    = "login"
+	loginEventRefresh  = "refresh"
+)
+
+// Outcomes of the events recorded in the login audit trail
+const (
+	loginOutcomeSuccess            = "success"
+	loginOutcomeDuplicateEmail     = "duplicate_email"
+	loginOutcomeInvalidCredentials = "invalid_credentials"
+	loginOutcomeLockedOut          = "locked_out"
+	loginOutcomeSuspended          = "suspended"
+	loginOutcomeEmailUnverified    = "email_unverified"
+	loginOutcomeTwoFactorRequired  = "two_factor_required"
+	loginOutcomeInvalidToken       = "invalid_token"
+	loginOutcomeExpiredToken       = "expired_token"
+	loginOutcomeReusedToken        = "reused_token"
+)
+
+// maxUserAgentLength is the longest user agent that is stored, such that clients cannot fill the datastore
+const maxUserAgentLength = 512
+
+// listSessionsResponse contains every active session belonging to an auth
+type listSessionsResponse struct {
+	Sessions []readSessionResponse
+}
+
+// readSessionResponse contains a single session, and the device it was last used from
+type readSessionResponse struct {
+	ID         uuid.UUID
+	IPAddress  string
+	UserAgent  string
+	CreatedAt  string
+	LastUsedAt string
+}
+
+func (env *env) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestListSessions)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestListSessions)
+		return
+	}
+
+	input := dao.ListSessionInput{
+		AuthID: auth.ID,
+		Now:    time.Now(),
+	}
+
+	for _, hook := range env.hook.beforeListSessionsHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestListSessions)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestListSessions))
+	sessionList, err := env.dao.ListSession(input)
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestListSessions)
+		return
+	}
+
+	for _, hook := range env.hook.afterListSessionsHooks {
+		err := (*hook)(env, sessionList)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestListSessions)
+			return
+		}
+	}
+
+	listSessionsResp := listSessionsResponse{
+		Sessions: make([]readSessionResponse, 0),
+	}
+	for _, session := range *sessionList {
+		listSessionsResp.Sessions = append(listSessionsResp.Sessions, readSessionResponse{
+			ID:         session.ID,
+			IPAddress:  session.IPAddress,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt.Format(time.RFC3339),
+			LastUsedAt: session.LastUsedAt.Format(time.RFC3339),
+		})
+	}
+
+	json.NewEncoder(w).Encode(listSessionsResp)
+	metric.RequestSuccess.WithLabelValues(metric.RequestListSessions).Inc()
+}
+
+// revokeSessionHandler revokes a single session, such that it can no longer be refreshed
+// As with a forced logout, the session is logged out once its access tokens expire
+func (env *env) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestRevokeSession)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestRevokeSession)
+		return
+	}
+
+	sessionID, err := util.ExtractIDFromRequest(mux.Vars(r))
+	if err != nil {
+		respondWithError(w, err.Error(), http.StatusBadRequest, metric.RequestRevokeSession)
+		return
+	}
+
+	input := dao.DeleteSessionInput{
+		ID:     sessionID,
+		AuthID: auth.ID,
+	}
+
+	for _, hook := range env.hook.beforeRevokeSessionHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRevokeSession)
+			return
+		}
+	}
+
+	// Sessions belonging to other auths are reported as not found, such that their IDs are not revealed
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRevokeSession))
+	err = env.dao.DeleteSession(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrSessionNotFound:
+			respondWithError(w, err.Error(), http.StatusNotFound, metric.RequestRevokeSession)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestRevokeSession)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.afterRevokeSessionHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestRevokeSession)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestRevokeSession).Inc()
+}
+
+// clientDevice returns the IP address and user agent a request was made from
+func clientDevice(env *env, r *http.Request) (string, string) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	return clientIP(r, env.config.LoginLockout.TrustForwardedFor), userAgent
+}
+
+// Create a session for a new family of refresh tokens, recording the device it was created from
+func createSession(env *env, familyID uuid.UUID, authID uuid.UUID, r *http.Request, requestType string) error {
+	ipAddress, userAgent := clientDevice(env, r)
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	_, err := env.dao.CreateSession(dao.CreateSessionInput{
+		ID:        familyID,
+		AuthID:    authID,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		CreatedAt: time.Now(),
+	})
+	timer.ObserveDuration()
+	return err
+}
+
+// Record the device a session was refreshed from
+// Failures are logged rather than returned, as the session remains valid, and refresh tokens issued before sessions
+// were recorded have no session to update
+func touchSession(env *env, familyID uuid.UUID, r *http.Request) {
+	ipAddress, userAgent := clientDevice(env, r)
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestRefresh))
+	err := env.dao.TouchSession(dao.TouchSessionInput{
+		ID:         familyID,
+		IPAddress:  ipAddress,
+		UserAgent:  userAgent,
+		LastUsedAt: time.Now(),
+	})
+	timer.ObserveDuration()
+	if err != nil && err != dao.ErrSessionNotFound {
+		log.Printf("Could not update session: %s", err.Error())
+	}
+}
+
+// Record an attempt to register, login or refresh in the login audit trail, with a nil auth ID if none was identified
+// Failures are logged rather than returned, such that the audit trail being unavailable does not prevent logging in
+func recordLoginEvent(env *env, r *http.Request, authID *uuid.UUID, email string, event string, outcome string, requestType string) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		log.Printf("Could not record login event: %s", err.Error())
+		return
+	}
+
+	ipAddress, userAgent := clientDevice(env, r)
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	_, err = env.dao.CreateLoginEvent(dao.CreateLoginEventInput{
+		ID:        id,
+		AuthID:    authID,
+		Email:     email,
+		Event:     event,
+		Outcome:   outcome,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		CreatedAt: time.Now(),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		log.Printf("Could not record login event: %s", err.Error())
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// makeDeviceRequest makes a request from the given device
+func makeDeviceRequest(env env, method string, url string, body string, ipAddress string, userAgent string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.RemoteAddr = ipAddress + ":43210"
+	req.Header.Set("User-Agent", userAgent)
+	defaultRouter(&env).ServeHTTP(rec, req)
+	return rec
+}
+
+// listSessions lists the active sessions of the auth the access token belongs to
+func listSessions(t *testing.T, env env, accessToken string) []map[string]interface{} {
+	res, err := makeAuthenticatedRequest(env, http.MethodGet, "/auth/sessions", "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded struct {
+		Sessions []map[string]interface{}
+	}
+	decodeBody(t, res.Body.String(), &decoded)
+	return decoded.Sessions
+}
+
+// Test that each login is listed as a session with the device it was last used from, until it is revoked
+func TestSessionLifecycleSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res := makeDeviceRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`, "192.0.2.1", "Firefox")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var login map[string]string
+	decodeBody(t, res.Body.String(), &login)
+
+	sessions := listSessions(t, mockEnv, tokens["AccessToken"])
+	if len(sessions) != 2 {
+		t.Fatalf("Wrong number of sessions: %v", sessions)
+	}
+
+	// The session is moved to the device it was refreshed from
+	res = makeDeviceRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"refreshToken": "%s"}`, login["RefreshToken"]), "192.0.2.2", "Chrome")
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var refreshed map[string]string
+	decodeBody(t, res.Body.String(), &refreshed)
+
+	sessions = listSessions(t, mockEnv, tokens["AccessToken"])
+	if len(sessions) != 2 || sessions[0]["IPAddress"] != "192.0.2.2" || sessions[0]["UserAgent"] != "Chrome" {
+		t.Fatalf("Wrong sessions: %v", sessions)
+	}
+
+	for _, code := range []int{http.StatusOK, http.StatusNotFound} {
+		res, err := makeAuthenticatedRequest(mockEnv, http.MethodDelete, fmt.Sprintf("/auth/sessions/%s", sessions[0]["ID"]), "", tokens["AccessToken"])
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != code {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	if sessions = listSessions(t, mockEnv, tokens["AccessToken"]); len(sessions) != 1 {
+		t.Fatalf("Session was not revoked: %v", sessions)
+	}
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"refreshToken": "%s"}`, refreshed["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Revoked session was refreshed: %v", res.Code)
+	}
+}
+
+// Test that an auth cannot revoke another auth's session
+func TestRevokeSessionFailsForAnotherAuth(t *testing.T) {
+	mockEnv := makeMockEnv()
+	owner := registerAuth(t, mockEnv, "jay@test.com")
+	other := registerAuth(t, mockEnv, "lewis@test.com")
+	sessions := listSessions(t, mockEnv, owner["AccessToken"])
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodDelete, fmt.Sprintf("/auth/sessions/%s", sessions[0]["ID"]), "", other["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if sessions = listSessions(t, mockEnv, owner["AccessToken"]); len(sessions) != 1 {
+		t.Fatalf("Session was revoked: %v", sessions)
+	}
+}
+
+// Test that every attempt to register, login and refresh is recorded in the audit trail with its outcome
+func TestLoginEventsAreRecorded(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	for _, body := range []string{
+		`{"email": "jay@test.com", "password": "RaspberryRipple456"}`,
+		`{"email": "lewis@test.com", "password": "BlackcurrantCrush123"}`,
+	} {
+		res := makeDeviceRequest(mockEnv, http.MethodPost, "/auth/login", body, "192.0.2.1", "Firefox")
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	login(t, mockEnv, "jay@test.com")
+
+	for i := 0; i < 2; i++ {
+		res, err := makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"refreshToken": "%s"}`, tokens["RefreshToken"]))
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if i == 0 && res.Code != http.StatusOK {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	authID := mockEnv.dao.(*mockDAO).authList[0].ID
+	expected := []struct {
+		event   string
+		outcome string
+		known   bool
+	}{
+		{loginEventRegister, loginOutcomeSuccess, true},
+		{loginEventLogin, loginOutcomeInvalidCredentials, true},
+		{loginEventLogin, loginOutcomeInvalidCredentials, false},
+		{loginEventLogin, loginOutcomeSuccess, true},
+		{loginEventRefresh, loginOutcomeSuccess, true},
+		{loginEventRefresh, loginOutcomeReusedToken, true},
+	}
+
+	loginEventList := mockEnv.dao.(*mockDAO).loginEventList
+	if len(loginEventList) != len(expected) {
+		t.Fatalf("Wrong number of login events: %v", loginEventList)
+	}
+
+	for i, test := range expected {
+		loginEvent := loginEventList[i]
+		if loginEvent.Event != test.event || loginEvent.Outcome != test.outcome || (loginEvent.AuthID != nil) != test.known {
+			t.Errorf("Wrong login event %d: %+v", i, loginEvent)
+		}
+
+		if test.known && *loginEvent.AuthID != authID {
+			t.Errorf("Wrong auth for login event %d: %v", i, *loginEvent.AuthID)
+		}
+	}
+
+	if loginEventList[1].IPAddress != "192.0.2.1" || loginEventList[1].UserAgent != "Firefox" || loginEventList[2].Email != "lewis@test.com" {
+		t.Errorf("Wrong device recorded: %+v", loginEventList[1:3])
+	}
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recoveryCodeCount is the number of single-use recovery codes issued when two-factor authentication is enabled
+const recoveryCodeCount = 10
+
+// confirmTwoFactorRequest contains a client-provided TOTP code, proving the secret was enrolled successfully
+type confirmTwoFactorRequest struct {
+	Code string `valid:"type(string),required"`
+}
+
+// disableTwoFactorRequest contains the client-provided current password and a TOTP or recovery code
+type disableTwoFactorRequest struct {
+	Password string `valid:"type(string),required"`
+	Code     string `valid:"type(string),required"`
+}
+
+// verifyTwoFactorRequest contains the client-provided challenge token issued by login, and a TOTP or recovery code
+type verifyTwoFactorRequest struct {
+	ChallengeToken string `valid:"type(string),required"`
+	Code           string `valid:"type(string),required"`
+}
+
+// setupTwoFactorResponse contains the TOTP secret to enrol, both directly and as an otpauth:// URI
+type setupTwoFactorResponse struct {
+	Secret string
+	URI    string
+}
+
+// confirmTwoFactorResponse contains the single-use recovery codes, which are not stored and so are only shown once
+type confirmTwoFactorResponse struct {
+	RecoveryCodes []string
+}
+
+// verifyTwoFactorResponse contains an access token and a refresh token
+type verifyTwoFactorResponse struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+func (env *env) setupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestSetupTwoFactor)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestSetupTwoFactor)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestSetupTwoFactor))
+	current, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestSetupTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestSetupTwoFactor)
+		}
+		return
+	}
+
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not generate secret: %s", err.Error()), http.StatusInternalServerError, metric.RequestSetupTwoFactor)
+		return
+	}
+
+	input := dao.CreateTwoFactorInput{
+		AuthID:    auth.ID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+
+	for _, hook := range env.hook.beforeSetupTwoFactorHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestSetupTwoFactor)
+			return
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestSetupTwoFactor))
+	twoFactor, err := env.dao.CreateTwoFactor(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrTwoFactorEnabled:
+			respondWithError(w, "Two-factor authentication is already enabled", http.StatusForbidden, metric.RequestSetupTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestSetupTwoFactor)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.afterSetupTwoFactorHooks {
+		err := (*hook)(env, twoFactor)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestSetupTwoFactor)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(setupTwoFactorResponse{
+		Secret: twoFactor.Secret,
+		URI:    util.TOTPURI(env.config.TwoFactor.Issuer, current.Email, twoFactor.Secret),
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestSetupTwoFactor).Inc()
+}
+
+func (env *env) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestConfirmTwoFactor)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestConfirmTwoFactor)
+		return
+	}
+
+	var req confirmTwoFactorRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestConfirmTwoFactor)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestConfirmTwoFactor)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestConfirmTwoFactor))
+	twoFactor, err := env.dao.ReadTwoFactor(dao.ReadTwoFactorInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrTwoFactorNotFound:
+			respondWithError(w, "Two-factor authentication has not been set up", http.StatusNotFound, metric.RequestConfirmTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestConfirmTwoFactor)
+		}
+		return
+	}
+
+	if twoFactor.Enabled {
+		respondWithError(w, "Two-factor authentication is already enabled", http.StatusForbidden, metric.RequestConfirmTwoFactor)
+		return
+	}
+
+	step, ok := util.ValidateTOTP(twoFactor.Secret, req.Code, time.Now())
+	if !ok {
+		respondWithError(w, "Invalid two-factor code", http.StatusUnauthorized, metric.RequestConfirmTwoFactor)
+		return
+	}
+
+	recoveryCodes := make([]string, recoveryCodeCount)
+	recoveryCodeHashes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		recoveryCodes[i], err = util.GenerateRecoveryCode()
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Could not generate recovery code: %s", err.Error()), http.StatusInternalServerError, metric.RequestConfirmTwoFactor)
+			return
+		}
+		recoveryCodeHashes[i] = util.HashToken(util.NormalizeRecoveryCode(recoveryCodes[i]))
+	}
+
+	input := dao.EnableTwoFactorInput{
+		AuthID:             auth.ID,
+		Step:               step,
+		RecoveryCodeHashes: recoveryCodeHashes,
+	}
+
+	for _, hook := range env.hook.beforeConfirmTwoFactorHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestConfirmTwoFactor)
+			return
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestConfirmTwoFactor))
+	twoFactor, err = env.dao.EnableTwoFactor(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrTwoFactorNotFound:
+			respondWithError(w, "Two-factor authentication has not been set up", http.StatusNotFound, metric.RequestConfirmTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestConfirmTwoFactor)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.afterConfirmTwoFactorHooks {
+		err := (*hook)(env, twoFactor)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestConfirmTwoFactor)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(confirmTwoFactorResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestConfirmTwoFactor).Inc()
+}
+
+func (env *env) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractAuth(env, r.Header, metric.RequestDisableTwoFactor)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDisableTwoFactor)
+		return
+	}
+
+	var req disableTwoFactorRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestDisableTwoFactor)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestDisableTwoFactor)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDisableTwoFactor))
+	current, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDisableTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestDisableTwoFactor)
+		}
+		return
+	}
+
+	if !verifyPassword(env, w, r, current, req.Password, metric.RequestDisableTwoFactor) {
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDisableTwoFactor))
+	twoFactor, err := env.dao.ReadTwoFactor(dao.ReadTwoFactorInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil && err != dao.ErrTwoFactorNotFound {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestDisableTwoFactor)
+		return
+	}
+
+	if twoFactor == nil || !twoFactor.Enabled {
+		respondWithError(w, "Two-factor authentication is not enabled", http.StatusNotFound, metric.RequestDisableTwoFactor)
+		return
+	}
+
+	ok, err := redeemTwoFactorCode(env, twoFactor, req.Code, metric.RequestDisableTwoFactor)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestDisableTwoFactor)
+		return
+	}
+
+	if !ok {
+		respondWithError(w, "Invalid two-factor code", http.StatusUnauthorized, metric.RequestDisableTwoFactor)
+		return
+	}
+
+	input := dao.DeleteTwoFactorInput{
+		AuthID: auth.ID,
+	}
+
+	for _, hook := range env.hook.beforeDisableTwoFactorHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestDisableTwoFactor)
+			return
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDisableTwoFactor))
+	err = env.dao.DeleteTwoFactor(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrTwoFactorNotFound:
+			respondWithError(w, "Two-factor authentication is not enabled", http.StatusNotFound, metric.RequestDisableTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestDisableTwoFactor)
+		}
+		return
+	}
+
+	for _, hook := range env.hook.afterDisableTwoFactorHooks {
+		err := (*hook)(env, current)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestDisableTwoFactor)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestDisableTwoFactor).Inc()
+}
+
+func (env *env) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var req verifyTwoFactorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestVerifyTwoFactor)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestVerifyTwoFactor)
+		return
+	}
+
+	input := dao.ReadTwoFactorChallengeInput{
+		TokenHash: util.HashToken(req.ChallengeToken),
+	}
+
+	for _, hook := range env.hook.beforeVerifyTwoFactorHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestVerifyTwoFactor)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestVerifyTwoFactor))
+	challenge, err := env.dao.ReadTwoFactorChallenge(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrTwoFactorChallengeNotFound:
+			respondWithError(w, "Invalid challenge token", http.StatusUnauthorized, metric.RequestVerifyTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyTwoFactor)
+		}
+		return
+	}
+
+	if challenge.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, "Challenge token has expired", http.StatusUnauthorized, metric.RequestVerifyTwoFactor)
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestVerifyTwoFactor))
+	twoFactor, err := env.dao.ReadTwoFactor(dao.ReadTwoFactorInput{
+		AuthID: challenge.AuthID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrTwoFactorNotFound:
+			respondWithError(w, "Two-factor authentication is not enabled", http.StatusUnauthorized, metric.RequestVerifyTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyTwoFactor)
+		}
+		return
+	}
+
+	ok, err := redeemTwoFactorCode(env, twoFactor, req.Code, metric.RequestVerifyTwoFactor)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyTwoFactor)
+		return
+	}
+
+	if !ok {
+		err = recordTwoFactorChallengeFailure(env, challenge, metric.RequestVerifyTwoFactor)
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyTwoFactor)
+			return
+		}
+		recordLoginEvent(env, r, &challenge.AuthID, "", loginEventLogin, loginOutcomeInvalidCredentials, metric.RequestVerifyTwoFactor)
+		respondWithError(w, "Invalid two-factor code", http.StatusUnauthorized, metric.RequestVerifyTwoFactor)
+		return
+	}
+
+	// Redeem the challenge, such that it can only be used once
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestVerifyTwoFactor))
+	err = env.dao.DeleteTwoFactorChallenge(dao.DeleteTwoFactorChallengeInput{
+		ID: challenge.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrTwoFactorChallengeNotFound:
+			respondWithError(w, "Invalid challenge token", http.StatusUnauthorized, metric.RequestVerifyTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyTwoFactor)
+		}
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestVerifyTwoFactor))
+	auth, err := env.dao.ReadAuthByID(dao.ReadAuthByIDInput{
+		ID: challenge.AuthID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, "Invalid challenge token", http.StatusUnauthorized, metric.RequestVerifyTwoFactor)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyTwoFactor)
+		}
+		return
+	}
+
+	// The account may have been suspended since the challenge was issued
+	if auth.Suspended {
+		recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeSuspended, metric.RequestVerifyTwoFactor)
+		respondWithError(w, "Account has been suspended", http.StatusForbidden, metric.RequestVerifyTwoFactor)
+		return
+	}
+
+	accessToken, err := createAccessToken(env, auth)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create access token: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyTwoFactor)
+		return
+	}
+
+	refreshToken, err := createRefreshTokenFamily(env, auth.ID, r, metric.RequestVerifyTwoFactor)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not create refresh token: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyTwoFactor)
+		return
+	}
+
+	recordLoginEvent(env, r, &auth.ID, auth.Email, loginEventLogin, loginOutcomeSuccess, metric.RequestVerifyTwoFactor)
+
+	for _, hook := range env.hook.afterVerifyTwoFactorHooks {
+		err := (*hook)(env, auth, accessToken)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestVerifyTwoFactor)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(verifyTwoFactorResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	})
+	metric.RequestSuccess.WithLabelValues(metric.RequestVerifyTwoFactor).Inc()
+}
+
+// Create a challenge for an auth that has passed the first factor, storing only its hash in the datastore
+func createTwoFactorChallenge(env *env, authID uuid.UUID, requestType string) (string, error) {
+	token, err := util.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return "", err
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	_, err = env.dao.CreateTwoFactorChallenge(dao.CreateTwoFactorChallengeInput{
+		ID:        id,
+		AuthID:    authID,
+		TokenHash: util.HashToken(token),
+		ExpiresAt: time.Now().Add(time.Duration(env.config.TwoFactor.ChallengeLifetimeSeconds) * time.Second),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Record a failed attempt at a challenge, deleting it once the maximum attempts are reached such that codes cannot be
+// guessed without logging in again
+func recordTwoFactorChallengeFailure(env *env, challenge *dao.TwoFactorChallenge, requestType string) error {
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	challenge, err := env.dao.RecordTwoFactorChallengeFailure(dao.RecordTwoFactorChallengeFailureInput{
+		ID: challenge.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrTwoFactorChallengeNotFound:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if challenge.Attempts < env.config.TwoFactor.MaxChallengeAttempts {
+		return nil
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	err = env.dao.DeleteTwoFactorChallenge(dao.DeleteTwoFactorChallengeInput{
+		ID: challenge.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil && err != dao.ErrTwoFactorChallengeNotFound {
+		return err
+	}
+
+	return nil
+}
+
+// Redeem a TOTP or recovery code for an auth, returning whether it was valid
+// Each TOTP code and each recovery code can only be redeemed once
+func redeemTwoFactorCode(env *env, twoFactor *dao.TwoFactor, code string, requestType string) (bool, error) {
+	step, ok := util.ValidateTOTP(twoFactor.Secret, code, time.Now())
+	if ok {
+		timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+		err := env.dao.UseTwoFactorStep(dao.UseTwoFactorStepInput{
+			AuthID: twoFactor.AuthID,
+			Step:   step,
+		})
+		timer.ObserveDuration()
+		switch err {
+		case nil:
+			return true, nil
+		case dao.ErrTwoFactorStepUsed:
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	err := env.dao.DeleteRecoveryCode(dao.DeleteRecoveryCodeInput{
+		AuthID:   twoFactor.AuthID,
+		CodeHash: util.HashToken(util.NormalizeRecoveryCode(code)),
+	})
+	timer.ObserveDuration()
+	switch err {
+	case nil:
+		return true, nil
+	case dao.ErrRecoveryCodeNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/util"
+)
+
+// totpCode returns a valid TOTP code for the secret that has not yet been used, being the code of the next time step
+func totpCode(t *testing.T, secret string) string {
+	code, err := util.TOTPCode(secret, util.TOTPStep(time.Now())+1)
+	if err != nil {
+		t.Fatalf("Could not generate code: %s", err.Error())
+	}
+	return code
+}
+
+// enableTwoFactor sets up and confirms two-factor authentication, returning the secret and recovery codes
+func enableTwoFactor(t *testing.T, env env, accessToken string) (string, []string) {
+	res, err := makeAuthenticatedRequest(env, http.MethodPost, "/auth/2fa/setup", "", accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var setup setupTwoFactorResponse
+	err = json.Unmarshal([]byte(res.Body.String()), &setup)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	code, err := util.TOTPCode(setup.Secret, util.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatalf("Could not generate code: %s", err.Error())
+	}
+
+	res, err = makeAuthenticatedRequest(env, http.MethodPost, "/auth/2fa/confirm", fmt.Sprintf(`{"code": "%s"}`, code), accessToken)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var confirm confirmTwoFactorResponse
+	err = json.Unmarshal([]byte(res.Body.String()), &confirm)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	return setup.Secret, confirm.RecoveryCodes
+}
+
+// loginChallenge logs in with two-factor authentication enabled, returning the challenge token
+func loginChallenge(t *testing.T, env env, email string) string {
+	res, err := makeRequest(env, http.MethodPost, "/auth/login", fmt.Sprintf(`{"email": "%s", "password": "BlackcurrantCrush123"}`, email))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	if _, ok := decoded["AccessToken"]; ok {
+		t.Fatalf("Access token was issued before the second factor")
+	}
+
+	if decoded["ChallengeToken"] == "" {
+		t.Fatalf("No challenge token was issued")
+	}
+
+	return decoded["ChallengeToken"]
+}
+
+// Test that TOTP codes match the RFC 6238 SHA-1 test vectors, truncated to 6 digits
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// Base32 encoding of "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for seconds, expected := range vectors {
+		code, err := util.TOTPCode(secret, util.TOTPStep(time.Unix(seconds, 0)))
+		if err != nil {
+			t.Fatalf("Could not generate code: %s", err.Error())
+		}
+
+		if code != expected {
+			t.Fatalf("Wrong code at %d: %s", seconds, code)
+		}
+	}
+}
+
+// Test that setting up two-factor authentication returns an otpauth:// URI for the secret
+func TestSetupTwoFactorHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/2fa/setup", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded setupTwoFactorResponse
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	if !strings.HasPrefix(decoded.URI, "otpauth://totp/spec-golang:jay@test.com?") || !strings.Contains(decoded.URI, "secret="+decoded.Secret) {
+		t.Fatalf("Wrong URI: %s", decoded.URI)
+	}
+
+	// Two-factor authentication is not enforced until confirmed
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "AccessToken") {
+		t.Fatalf("Wrong response: %v %s", res.Code, res.Body.String())
+	}
+}
+
+// Test that two-factor authentication cannot be set up again once enabled
+func TestSetupTwoFactorHandlerFailsWhenEnabled(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	enableTwoFactor(t, mockEnv, tokens["AccessToken"])
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/2fa/setup", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that two-factor authentication is not enabled with an invalid code
+func TestConfirmTwoFactorHandlerFailsOnInvalidCode(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/2fa/setup", "", tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/2fa/confirm", `{"code": "abcdef"}`, tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a login with two-factor authentication enabled is completed by a TOTP code
+func TestVerifyTwoFactorHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	secret, _ := enableTwoFactor(t, mockEnv, tokens["AccessToken"])
+	challengeToken := loginChallenge(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/2fa/verify", fmt.Sprintf(`{"challengeToken": "%s", "code": "%s"}`, challengeToken, totpCode(t, secret)))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded verifyTwoFactorResponse
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	if decoded.AccessToken == "" || decoded.RefreshToken == "" {
+		t.Fatalf("Tokens were not issued: %s", res.Body.String())
+	}
+
+	// The challenge can only be redeemed once
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/2fa/verify", fmt.Sprintf(`{"challengeToken": "%s", "code": "%s"}`, challengeToken, totpCode(t, secret)))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a TOTP code cannot be replayed
+func TestVerifyTwoFactorHandlerFailsOnReusedCode(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	secret, _ := enableTwoFactor(t, mockEnv, tokens["AccessToken"])
+	code := totpCode(t, secret)
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/2fa/verify", fmt.Sprintf(`{"challengeToken": "%s", "code": "%s"}`, loginChallenge(t, mockEnv, "jay@test.com"), code))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/2fa/verify", fmt.Sprintf(`{"challengeToken": "%s", "code": "%s"}`, loginChallenge(t, mockEnv, "jay@test.com"), code))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that each recovery code can complete a login only once
+func TestVerifyTwoFactorHandlerAcceptsRecoveryCodeOnce(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	_, recoveryCodes := enableTwoFactor(t, mockEnv, tokens["AccessToken"])
+
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Wrong number of recovery codes: %d", len(recoveryCodes))
+	}
+
+	// Recovery codes are accepted regardless of case
+	recoveryCode := strings.ToUpper(recoveryCodes[0])
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/2fa/verify", fmt.Sprintf(`{"challengeToken": "%s", "code": "%s"}`, loginChallenge(t, mockEnv, "jay@test.com"), recoveryCode))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/2fa/verify", fmt.Sprintf(`{"challengeToken": "%s", "code": "%s"}`, loginChallenge(t, mockEnv, "jay@test.com"), recoveryCode))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that a challenge is invalidated after too many incorrect codes
+func TestVerifyTwoFactorHandlerFailsAfterMaxAttempts(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	secret, _ := enableTwoFactor(t, mockEnv, tokens["AccessToken"])
+	challengeToken := loginChallenge(t, mockEnv, "jay@test.com")
+
+	for i := 0; i < mockEnv.config.TwoFactor.MaxChallengeAttempts; i++ {
+		res, err := makeRequest(mockEnv, http.MethodPost, "/auth/2fa/verify", fmt.Sprintf(`{"challengeToken": "%s", "code": "abcdef"}`, challengeToken))
+		if err != nil {
+			t.Fatalf("Could not make request: %s", err.Error())
+		}
+
+		if res.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong status code: %v", res.Code)
+		}
+	}
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/2fa/verify", fmt.Sprintf(`{"challengeToken": "%s", "code": "%s"}`, challengeToken, totpCode(t, secret)))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that two-factor authentication can be disabled with the password and a code
+func TestDisableTwoFactorHandlerSucceeds(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	secret, _ := enableTwoFactor(t, mockEnv, tokens["AccessToken"])
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/2fa/disable", fmt.Sprintf(`{"password": "BlackcurrantCrush123", "code": "%s"}`, totpCode(t, secret)), tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), "AccessToken") {
+		t.Fatalf("Wrong response: %v %s", res.Code, res.Body.String())
+	}
+}
+
+// Test that two-factor authentication cannot be disabled without the password
+func TestDisableTwoFactorHandlerFailsOnWrongPassword(t *testing.T) {
+	mockEnv := makeMockEnv()
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	secret, _ := enableTwoFactor(t, mockEnv, tokens["AccessToken"])
+
+	res, err := makeAuthenticatedRequest(mockEnv, http.MethodPost, "/auth/2fa/disable", fmt.Sprintf(`{"password": "WrongPassword123", "code": "%s"}`, totpCode(t, secret)), tokens["AccessToken"])
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
@@ -1,10 +1,171 @@
 package util
 
 type Config struct {
-	User     string            `json:"user"`
-	DBName   string            `json:"dbName"`
-	Host     string            `json:"host"`
-	SSLMode  string            `json:"sslMode"`
-	Services map[string]string `json:"services"`
-	Ports    map[string]int    `json:"ports"`
+	User                        string                  `json:"user"`
+	DBName                      string                  `json:"dbName"`
+	Host                        string                  `json:"host"`
+	SSLMode                     string                  `json:"sslMode"`
+	Services                    map[string]string       `json:"services"`
+	KongRetry                   KongRetryConfig         `json:"kongRetry"`
+	Ports                       map[string]int          `json:"ports"`
+	Mail                        MailConfig              `json:"mail"`
+	PasswordResetURL            string                  `json:"passwordResetURL"`
+	PasswordResetResendSeconds  int                     `json:"passwordResetResendSeconds"`
+	EmailVerification           EmailVerificationConfig `json:"emailVerification"`
+	AccountDeletionRetrySeconds int                     `json:"accountDeletionRetrySeconds"`
+	LoginLockout                LoginLockoutConfig      `json:"loginLockout"`
+	Signing                     SigningConfig           `json:"signing"`
+	TwoFactor                   TwoFactorConfig         `json:"twoFactor"`
+	ServiceClients              ServiceClientsConfig    `json:"serviceClients"`
+	OIDC                        OIDCConfig              `json:"oidc"`
+	MagicLink                   MagicLinkConfig         `json:"magicLink"`
+	PasswordHashing             PasswordHashingConfig   `json:"passwordHashing"`
+	PasswordPolicy              PasswordPolicyConfig    `json:"passwordPolicy"`
+}
+
+// KongRetryConfig determines how requests to the Kong admin API are retried while Kong is unavailable, such as while
+// it is starting, with the delay doubling after each attempt up to the maximum
+type KongRetryConfig struct {
+	MaxAttempts           int `json:"maxAttempts"`
+	BaseDelayMilliseconds int `json:"baseDelayMilliseconds"`
+	MaxDelayMilliseconds  int `json:"maxDelayMilliseconds"`
+}
+
+// MailConfig contains the SMTP server used to send emails, which are kept in memory if no host is provided
+type MailConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// Email verification modes, determining what an auth can do before verifying their email
+const (
+	// EmailVerificationOptional issues full access tokens regardless of verification
+	EmailVerificationOptional = "optional"
+	// EmailVerificationRequired refuses to issue access tokens until the email is verified
+	EmailVerificationRequired = "required"
+	// EmailVerificationRestricted issues access tokens marked as unverified, which other services refuse
+	EmailVerificationRestricted = "restricted"
+)
+
+// EmailVerificationConfig determines how email verification is enforced
+type EmailVerificationConfig struct {
+	Mode                  string `json:"mode"`
+	URL                   string `json:"url"`
+	ResendIntervalSeconds int    `json:"resendIntervalSeconds"`
+}
+
+// Login lockout stores, determining where failed login attempts are tracked
+const (
+	// LoginLockoutStoreDatastore tracks failed login attempts in the datastore, shared between all instances
+	LoginLockoutStoreDatastore = "datastore"
+	// LoginLockoutStoreMemory tracks failed login attempts in memory, for use when running a single instance
+	LoginLockoutStoreMemory = "memory"
+)
+
+// LoginLockoutConfig determines how failed login attempts are throttled, per email and per client IP
+// Once a key reaches its maximum attempts it is locked out, with the lockout doubling on every further failure
+// Failures are forgotten once a key has been inactive for the window
+type LoginLockoutConfig struct {
+	Store              string `json:"store"`
+	EmailMaxAttempts   int    `json:"emailMaxAttempts"`
+	IPMaxAttempts      int    `json:"ipMaxAttempts"`
+	BaseLockoutSeconds int    `json:"baseLockoutSeconds"`
+	MaxLockoutSeconds  int    `json:"maxLockoutSeconds"`
+	WindowSeconds      int    `json:"windowSeconds"`
+	TrustForwardedFor  bool   `json:"trustForwardedFor"`
+}
+
+// TwoFactorConfig determines how two-factor authentication is presented and how long a login may await a second factor
+type TwoFactorConfig struct {
+	Issuer                   string `json:"issuer"`
+	ChallengeLifetimeSeconds int    `json:"challengeLifetimeSeconds"`
+	MaxChallengeAttempts     int    `json:"maxChallengeAttempts"`
+}
+
+// Signing modes, determining whether signing keys are registered with Kong
+const (
+	// SigningModeKong registers every signing key with Kong as a JWT credential, so Kong can verify access tokens
+	SigningModeKong = "kong"
+	// SigningModeLocal never contacts Kong, leaving other gateways or services to verify access tokens using the JWKS
+	SigningModeLocal = "local"
+)
+
+// SigningConfig determines the algorithm that access tokens are signed with, and how often the signing key is rotated
+// Whether a rotation is due is checked at every rotation check, so keys may be rotated up to one check late
+// In local mode, a PEM-encoded private key may be provided inline or as a file, in which case it is never rotated
+type SigningConfig struct {
+	Mode                    string `json:"mode"`
+	Algorithm               string `json:"algorithm"`
+	RotationIntervalSeconds int    `json:"rotationIntervalSeconds"`
+	RotationCheckSeconds    int    `json:"rotationCheckSeconds"`
+	PrivateKey              string `json:"privateKey"`
+	KeyFile                 string `json:"keyFile"`
+}
+
+// ServiceClientSecretsEnv is the environment variable the client secrets are read from, taking precedence over the file
+const ServiceClientSecretsEnv = "SERVICE_CLIENT_SECRETS"
+
+// ServiceClientsConfig determines which services may request service tokens, and how long those tokens last
+// Each secret is stored as its hex-encoded SHA-256 hash, keyed by client ID, in a JSON object that is never read from
+// the config itself, but from the environment or a mounted secret file
+type ServiceClientsConfig struct {
+	TokenLifetimeSeconds int               `json:"tokenLifetimeSeconds"`
+	SecretsFile          string            `json:"secretsFile"`
+	Secrets              map[string]string `json:"-"`
+}
+
+// OIDCConfig contains the external OpenID Connect identity providers that auths may log in with, keyed by the name used
+// in their routes, and how long a login may take to return from the provider
+type OIDCConfig struct {
+	LoginLifetimeSeconds int                           `json:"loginLifetimeSeconds"`
+	Providers            map[string]OIDCProviderConfig `json:"providers"`
+}
+
+// OIDCProviderConfig contains the client registered with a single identity provider, whose endpoints are discovered
+// from its issuer
+// The redirect URL must route to the provider's callback, and openid and email are always included in the scopes
+type OIDCProviderConfig struct {
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientID"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectURL"`
+	Scopes       []string `json:"scopes"`
+}
+
+// MagicLinkConfig determines where passwordless login links point to, how long they can be redeemed for, and how long
+// to wait before emailing another link to the same auth
+// If no URL is provided, the token is emailed on its own
+type MagicLinkConfig struct {
+	URL                   string `json:"url"`
+	LifetimeSeconds       int    `json:"lifetimeSeconds"`
+	ResendIntervalSeconds int    `json:"resendIntervalSeconds"`
+}
+
+// PasswordHashingConfig determines the algorithm and parameters that passwords are hashed with
+// Hashes from any supported algorithm or parameters are still accepted, and are rehashed with the configured ones on the
+// next successful login
+type PasswordHashingConfig struct {
+	Algorithm  string         `json:"algorithm"`
+	BcryptCost int            `json:"bcryptCost"`
+	Argon2id   Argon2idConfig `json:"argon2id"`
+}
+
+// Argon2idConfig contains the Argon2id parameters, with memory given in KiB
+type Argon2idConfig struct {
+	Time      uint32 `json:"time"`
+	MemoryKiB uint32 `json:"memoryKiB"`
+	Threads   uint8  `json:"threads"`
+}
+
+// PasswordPolicyConfig determines which passwords may be set, on top of their length
+// Passwords must reach the minimum estimated entropy, and must not contain any banned substring or the local part of
+// the auth's email, case-insensitively
+// If a directory is provided, passwords listed in the Have I Been Pwned range files within it are also refused
+type PasswordPolicyConfig struct {
+	MinEntropyBits         float64  `json:"minEntropyBits"`
+	BannedSubstrings       []string `json:"bannedSubstrings"`
+	BreachedRangeDirectory string   `json:"breachedRangeDirectory"`
 }
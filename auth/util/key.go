@@ -0,0 +1,214 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Algorithms that access tokens can be signed with
+const (
+	SigningAlgorithmRS256 = "RS256"
+	SigningAlgorithmES256 = "ES256"
+)
+
+// rsaKeyBits is the size of generated RSA keys
+const rsaKeyBits = 2048
+
+// SigningKey is an asymmetric key pair used to sign access tokens, identified by the RFC 7638 thumbprint of its public key
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+}
+
+// JWK is the public part of a signing key, in the JSON Web Key format defined by RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a set of JSON Web Keys, published such that other parties can verify access tokens
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// GenerateSigningKey generates a new key pair for the given algorithm
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch algorithm {
+	case SigningAlgorithmRS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case SigningAlgorithmES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(privateKey, algorithm)
+}
+
+// EncodeSigningKey returns the private key of a signing key, PEM-encoded in PKCS #8 form
+func EncodeSigningKey(signingKey *SigningKey) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(signingKey.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// DecodeSigningKey parses a PEM-encoded PKCS #8 private key, checking that it can be used with the given algorithm
+func DecodeSigningKey(encoded string, algorithm string) (*SigningKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("signing key is not PEM-encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported signing key type")
+	}
+
+	return newSigningKey(privateKey, algorithm)
+}
+
+// newSigningKey checks that a private key can be used with the given algorithm, identifying it by its thumbprint
+func newSigningKey(privateKey crypto.Signer, algorithm string) (*SigningKey, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != SigningAlgorithmRS256 {
+			return nil, fmt.Errorf("an RSA key cannot be used with %s", algorithm)
+		}
+	case *ecdsa.PrivateKey:
+		if algorithm != SigningAlgorithmES256 || key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("an ECDSA key on this curve cannot be used with %s", algorithm)
+		}
+	default:
+		return nil, errors.New("unsupported signing key type")
+	}
+
+	signingKey := SigningKey{
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+	}
+	signingKey.ID = signingKey.thumbprint()
+	return &signingKey, nil
+}
+
+// thumbprint returns the RFC 7638 thumbprint of the public key, being the hash of its required JWK members in
+// lexicographic order
+func (k *SigningKey) thumbprint() string {
+	jwk := k.JWK()
+
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	}
+
+	// Marshalling a struct of strings cannot fail
+	encoded, _ := json.Marshal(members)
+	hash := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// SigningMethod returns the JWT signing method for the key
+func (k *SigningKey) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// PublicKeyPEM returns the PEM-encoded public key, as registered with Kong
+func (k *SigningKey) PublicKeyPEM() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.PrivateKey.Public())
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// JWK returns the public key as a JSON Web Key
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{
+		Use: "sig",
+		Alg: k.Algorithm,
+		Kid: k.ID,
+	}
+
+	switch key := k.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(key.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(key.Y.Bytes(), size))
+	}
+
+	return jwk
+}
+
+// Keyfunc returns the public key to verify a token with, provided it was signed by this key, for use with jwt.Parse
+func (k *SigningKey) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != k.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid != k.ID {
+		return nil, fmt.Errorf("unknown signing key %v", token.Header["kid"])
+	}
+
+	return k.PrivateKey.Public(), nil
+}
+
+// padBytes left-pads a big-endian integer to a fixed size, as required for EC coordinates
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
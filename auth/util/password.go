@@ -0,0 +1,233 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms, of which new passwords are hashed with the configured one
+const (
+	// PasswordAlgorithmBcrypt hashes passwords with bcrypt, encoded in the modular crypt format
+	PasswordAlgorithmBcrypt = "bcrypt"
+	// PasswordAlgorithmArgon2id hashes passwords with Argon2id, encoded in the PHC string format
+	PasswordAlgorithmArgon2id = "argon2id"
+)
+
+// argon2idSaltLength and argon2idKeyLength are the lengths in bytes of the salt and hash of new Argon2id hashes
+const (
+	argon2idSaltLength = 16
+	argon2idKeyLength  = 32
+)
+
+// ErrPasswordMismatch is returned when a password does not match its hash
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher provides the interface adopted by each password hashing algorithm
+// Every hash encodes the algorithm and parameters used, such that it can be verified after they have changed
+type PasswordHasher interface {
+	Algorithm() string
+	Hash(password string) (string, error)
+	Identify(encoded string) bool
+	Verify(encoded string, password string) error
+	NeedsRehash(encoded string) bool
+}
+
+// Passwords hashes new passwords with the target hasher, and verifies passwords against hashes from any supported
+// hasher, reporting whether they should be rehashed with the target
+type Passwords struct {
+	target  PasswordHasher
+	hashers []PasswordHasher
+}
+
+// NewPasswords sets up the supported hashers from the config, targeting the configured algorithm and parameters
+func NewPasswords(config PasswordHashingConfig) (*Passwords, error) {
+	if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	if config.Argon2id.Time == 0 || config.Argon2id.MemoryKiB < 8*uint32(config.Argon2id.Threads) || config.Argon2id.Threads == 0 {
+		return nil, errors.New("argon2id time and threads must be positive, with at least 8KiB of memory per thread")
+	}
+
+	hashers := []PasswordHasher{
+		&BcryptHasher{Cost: config.BcryptCost},
+		&Argon2idHasher{Time: config.Argon2id.Time, MemoryKiB: config.Argon2id.MemoryKiB, Threads: config.Argon2id.Threads},
+	}
+
+	for _, hasher := range hashers {
+		if hasher.Algorithm() == config.Algorithm {
+			return &Passwords{hasher, hashers}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown password hashing algorithm %s", config.Algorithm)
+}
+
+// Hash hashes a password with the target algorithm and parameters
+func (passwords *Passwords) Hash(password string) (string, error) {
+	return passwords.target.Hash(password)
+}
+
+// Verify checks a password against its encoded hash, returning ErrPasswordMismatch if it does not match
+// Once verified, the password should be rehashed if it was hashed with anything other than the target algorithm and
+// parameters
+func (passwords *Passwords) Verify(encoded string, password string) (bool, error) {
+	for _, hasher := range passwords.hashers {
+		if !hasher.Identify(encoded) {
+			continue
+		}
+
+		err := hasher.Verify(encoded, password)
+		if err != nil {
+			return false, err
+		}
+
+		return hasher != passwords.target || hasher.NeedsRehash(encoded), nil
+	}
+
+	return false, errors.New("unknown password hash format")
+}
+
+// BcryptHasher hashes passwords with bcrypt at the given cost
+type BcryptHasher struct {
+	Cost int
+}
+
+// Algorithm returns the name of the algorithm, as used in the config
+func (hasher *BcryptHasher) Algorithm() string {
+	return PasswordAlgorithmBcrypt
+}
+
+// Hash hashes a password with bcrypt
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Identify reports whether an encoded hash was produced by bcrypt
+func (hasher *BcryptHasher) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// Verify checks a password against a bcrypt hash
+func (hasher *BcryptHasher) Verify(encoded string, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+// NeedsRehash reports whether a bcrypt hash was produced at a different cost
+func (hasher *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != hasher.Cost
+}
+
+// Argon2idHasher hashes passwords with Argon2id using the given parameters, as defined by RFC 9106
+type Argon2idHasher struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+}
+
+// argon2idHash encapsulates the parameters, salt and hash decoded from an Argon2id hash
+type argon2idHash struct {
+	version   int
+	time      uint32
+	memoryKiB uint32
+	threads   uint8
+	salt      []byte
+	key       []byte
+}
+
+// Algorithm returns the name of the algorithm, as used in the config
+func (hasher *Argon2idHasher) Algorithm() string {
+	return PasswordAlgorithmArgon2id
+}
+
+// Hash hashes a password with Argon2id and a random salt, encoding it as $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hasher.Time, hasher.MemoryKiB, hasher.Threads, argon2idKeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, hasher.MemoryKiB, hasher.Time, hasher.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Identify reports whether an encoded hash was produced by Argon2id
+func (hasher *Argon2idHasher) Identify(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+// Verify checks a password against an Argon2id hash, using the parameters it was produced with
+func (hasher *Argon2idHasher) Verify(encoded string, password string) error {
+	hash, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(password), hash.salt, hash.time, hash.memoryKiB, hash.threads, uint32(len(hash.key)))
+	if subtle.ConstantTimeCompare(key, hash.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether an Argon2id hash was produced with different parameters
+func (hasher *Argon2idHasher) NeedsRehash(encoded string) bool {
+	hash, err := decodeArgon2id(encoded)
+	return err != nil || hash.version != argon2.Version || hash.time != hasher.Time || hash.memoryKiB != hasher.MemoryKiB ||
+		hash.threads != hasher.Threads || len(hash.key) != argon2idKeyLength
+}
+
+// decodeArgon2id decodes the parameters, salt and hash of an Argon2id hash in the PHC string format
+func decodeArgon2id(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != PasswordAlgorithmArgon2id {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	var hash argon2idHash
+	_, err := fmt.Sscanf(parts[2], "v=%d", &hash.version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id version: %s", err.Error())
+	}
+	if hash.version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %d", hash.version)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hash.memoryKiB, &hash.time, &hash.threads)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters: %s", err.Error())
+	}
+	if hash.time == 0 || hash.threads == 0 {
+		return nil, errors.New("invalid argon2id parameters")
+	}
+
+	hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %s", err.Error())
+	}
+
+	hash.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(hash.key) == 0 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	return &hash, nil
+}
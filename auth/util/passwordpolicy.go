@@ -0,0 +1,173 @@
+package util
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// breachedRangePrefixLength is the number of hex characters of a SHA-1 hash that name the range file it is listed in
+const breachedRangePrefixLength = 5
+
+// PasswordPolicy decides whether a password is strong enough to be set, giving the reasons it is not
+type PasswordPolicy struct {
+	minEntropyBits   float64
+	bannedSubstrings []string
+	breachedRangeDir string
+}
+
+// NewPasswordPolicy sets up a password policy from the config, checking the breached password ranges can be read
+func NewPasswordPolicy(config PasswordPolicyConfig) (*PasswordPolicy, error) {
+	if config.MinEntropyBits < 0 {
+		return nil, fmt.Errorf("minimum entropy must not be negative")
+	}
+
+	if len(config.BreachedRangeDirectory) > 0 {
+		info, err := os.Stat(config.BreachedRangeDirectory)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", config.BreachedRangeDirectory)
+		}
+	}
+
+	bannedSubstrings := make([]string, 0, len(config.BannedSubstrings))
+	for _, substring := range config.BannedSubstrings {
+		if len(substring) > 0 {
+			bannedSubstrings = append(bannedSubstrings, strings.ToLower(substring))
+		}
+	}
+
+	return &PasswordPolicy{config.MinEntropyBits, bannedSubstrings, config.BreachedRangeDirectory}, nil
+}
+
+// Check returns the reasons a password may not be set by the auth with the given email, which is empty if it may be
+// An error is only returned if the breached password ranges could not be read
+func (policy *PasswordPolicy) Check(password string, email string) ([]string, error) {
+	reasons := make([]string, 0)
+	lowered := strings.ToLower(password)
+
+	if EstimateEntropy(password) < policy.minEntropyBits {
+		reasons = append(reasons, "Password is too easy to guess, use a longer password without repeated or sequential characters")
+	}
+
+	// The local part of the email is the most obvious guess for an attacker who knows it
+	localPart := strings.ToLower(strings.SplitN(email, "@", 2)[0])
+	if len(localPart) >= 3 && strings.Contains(lowered, localPart) {
+		reasons = append(reasons, "Password must not contain your email address")
+	}
+
+	for _, substring := range policy.bannedSubstrings {
+		if strings.Contains(lowered, substring) {
+			reasons = append(reasons, fmt.Sprintf("Password must not contain %q", substring))
+		}
+	}
+
+	breached, err := policy.breached(password)
+	if err != nil {
+		return nil, err
+	}
+	if breached {
+		reasons = append(reasons, "Password has appeared in a data breach, so must not be used")
+	}
+
+	return reasons, nil
+}
+
+// breached reports whether a password is listed in the breached password ranges, if there are any
+// The ranges follow the Have I Been Pwned format, where each file is named by the first 5 hex characters of the SHA-1
+// hash and lists the remaining 35 hex characters of each breached hash with that prefix as <suffix>:<count>
+// A missing range file means no breached hash has that prefix, such that a subset of the ranges may be used
+func (policy *PasswordPolicy) breached(password string) (bool, error) {
+	if len(policy.breachedRangeDir) == 0 {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedRangePrefixLength], hash[breachedRangePrefixLength:]
+
+	file, err := os.Open(filepath.Join(policy.breachedRangeDir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		// Padding entries are listed with a count of 0, and are not breached
+		if strings.EqualFold(fields[0], suffix) && (len(fields) == 1 || fields[1] != "0") {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// EstimateEntropy estimates the number of bits of entropy in a password, in the manner of zxcvbn but far simpler
+// Each character contributes the bits needed to pick it from the character classes the password uses, except those
+// repeating or continuing a sequence from the previous characters, which are almost free to guess
+func EstimateEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, char := range password {
+		switch {
+		case char > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(char):
+			lower = true
+		case unicode.IsUpper(char):
+			upper = true
+		case unicode.IsDigit(char):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	bitsPerChar := math.Log2(float64(pool))
+	entropy := 0.0
+	chars := []rune(password)
+	for i, char := range chars {
+		predictable := false
+		if i > 0 {
+			step := unicode.ToLower(char) - unicode.ToLower(chars[i-1])
+			if step == 0 {
+				predictable = true
+			} else if (step == 1 || step == -1) && i > 1 {
+				predictable = unicode.ToLower(chars[i-1])-unicode.ToLower(chars[i-2]) == step
+			}
+		}
+
+		if predictable {
+			entropy++
+		} else {
+			entropy += bitsPerChar
+		}
+	}
+
+	return entropy
+}
@@ -1,10 +1,49 @@
 package util
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 )
 
+// Auth contains the verified claims of an access token
+// Service tokens name the calling service in place of an auth, leaving the ID nil
+// Tokens issued before the iat claim was included have a zero IssuedAt, so are revoked by any token cutoff
+type Auth struct {
+	ID        uuid.UUID
+	JTI       string
+	ExpiresAt time.Time
+	IssuedAt  time.Time
+	Role      string
+	Service   string
+}
+
+// TokenTimePrecision is the precision of the iat claim, and so of the times before which tokens are revoked, such that
+// the two can be compared exactly
+const TokenTimePrecision = time.Millisecond
+
+// NumericDate returns the given time as the number of seconds since the epoch, to the precision of token times
+func NumericDate(t time.Time) float64 {
+	return float64(t.UnixNano()/int64(TokenTimePrecision)) / float64(time.Second/TokenTimePrecision)
+}
+
+// TokenCutoff returns the given time to the precision of token times, such that tokens issued since it are not revoked
+func TokenCutoff(t time.Time) time.Time {
+	return t.Truncate(TokenTimePrecision)
+}
+
 // GetConfig returns a configuration object from decoding the given configuration file
 func GetConfig(filePath string) (*Config, error) {
 	config := Config{}
@@ -21,6 +60,27 @@ func GetConfig(filePath string) (*Config, error) {
 	return &config, nil
 }
 
+// LoadServiceClientSecrets reads the hashed client secrets from the environment, or otherwise from the configured file
+func LoadServiceClientSecrets(config *ServiceClientsConfig) error {
+	encoded, ok := os.LookupEnv(ServiceClientSecretsEnv)
+	if !ok {
+		if config.SecretsFile == "" {
+			return nil
+		}
+
+		contents, err := ioutil.ReadFile(config.SecretsFile)
+		if err != nil {
+			return err
+		}
+		encoded = string(contents)
+	}
+
+	if strings.TrimSpace(encoded) == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(encoded), &config.Secrets)
+}
+
 // CreateErrorJSON returns a JSON string containing the key error associated with provided value
 func CreateErrorJSON(message string) string {
 	payload := map[string]string{"error": message}
@@ -30,3 +90,101 @@ func CreateErrorJSON(message string) string {
 	}
 	return string(json)
 }
+
+// CreateFieldErrorJSON returns a JSON string containing the key error associated with provided value, and the key
+// fields associating each refused request field with the reasons it was refused
+func CreateFieldErrorJSON(message string, fields map[string][]string) string {
+	payload := map[string]interface{}{"error": message, "fields": fields}
+	json, err := json.Marshal(payload)
+	if err != nil {
+		return err.Error()
+	}
+	return string(json)
+}
+
+// GenerateToken returns a cryptographically secure random token, safe for use in URLs
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of a token, such that only the hash needs to be stored
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// ExtractIDFromRequest extracts the parameter provided under parameter ID and converts it into a uuid
+func ExtractIDFromRequest(requestParams map[string]string) (uuid.UUID, error) {
+	id := requestParams["id"]
+	if len(id) == 0 {
+		return uuid.Nil, errors.New("No ID provided")
+	}
+
+	return uuid.Parse(id)
+}
+
+// ExtractAuthFromRequest extracts and verifies a token from a header of the form `Authorization: Bearer <token>`
+// The key used to verify the token is returned by keyFunc, which should reject unexpected signing methods
+func ExtractAuthFromRequest(headers http.Header, keyFunc jwt.Keyfunc) (*Auth, error) {
+	authHeader := headers.Get("Authorization")
+	if len(authHeader) == 0 {
+		return nil, errors.New("Authorization header not provided")
+	}
+
+	// Extract, parse and verify JWT, which also validates the exp claim
+	rawToken := strings.Replace(authHeader, "Bearer ", "", 1)
+	token, err := jwt.Parse(rawToken, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract claims from JWT
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("JWT claims are invalid")
+	}
+
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		return nil, errors.New("JWT does not contain a jti")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("JWT does not contain an exp")
+	}
+
+	var issuedAt time.Time
+	if iat, ok := claims["iat"].(float64); ok {
+		issuedAt = time.Unix(0, int64(math.Round(iat*float64(time.Second/TokenTimePrecision)))*int64(TokenTimePrecision))
+	}
+
+	// Service tokens authenticate another service rather than an auth, so contain a service claim in place of an id
+	if service, ok := claims["service"].(string); ok {
+		return &Auth{uuid.Nil, jti, time.Unix(int64(exp), 0), issuedAt, RoleUser, service}, nil
+	}
+
+	// Extract ID from JWT claims
+	id, ok := claims["id"].(string)
+	if !ok {
+		return nil, errors.New("JWT does not contain an id")
+	}
+
+	uuid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens issued before roles were supported do not contain a role, and were only issued to users
+	role, ok := claims["role"].(string)
+	if !ok {
+		role = RoleUser
+	}
+
+	return &Auth{uuid, jti, time.Unix(int64(exp), 0), issuedAt, role, ""}, nil
+}
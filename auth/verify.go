@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/dao"
+	"github.com/TempleEight/spec-golang/auth/metric"
+	"github.com/TempleEight/spec-golang/auth/util"
+	valid "github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// emailVerificationLifetime is how long an email verification token can be redeemed for after being issued
+const emailVerificationLifetime = 24 * time.Hour
+
+// verifyEmailRequest contains the client-provided email verification token
+type verifyEmailRequest struct {
+	Token string `valid:"type(string),required"`
+}
+
+// resendVerificationRequest contains the client-provided email of the auth to resend a verification email to
+type resendVerificationRequest struct {
+	Email string `valid:"email,required"`
+}
+
+func (env *env) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// The token is provided in the query string when following the emailed link, and in the body otherwise
+	var req verifyEmailRequest
+	if r.Method == http.MethodGet {
+		req.Token = r.URL.Query().Get("token")
+	} else {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestVerifyEmail)
+			return
+		}
+	}
+
+	_, err := valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestVerifyEmail)
+		return
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestVerifyEmail))
+	emailVerification, err := env.dao.ReadEmailVerification(dao.ReadEmailVerificationInput{
+		TokenHash: util.HashToken(req.Token),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrEmailVerificationNotFound:
+			respondWithError(w, "Invalid email verification token", http.StatusUnauthorized, metric.RequestVerifyEmail)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyEmail)
+		}
+		return
+	}
+
+	if emailVerification.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, "Email verification token has expired", http.StatusUnauthorized, metric.RequestVerifyEmail)
+		return
+	}
+
+	input := dao.VerifyAuthEmailInput{
+		ID: emailVerification.AuthID,
+	}
+
+	for _, hook := range env.hook.beforeVerifyEmailHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestVerifyEmail)
+			return
+		}
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestVerifyEmail))
+	auth, err := env.dao.VerifyAuthEmail(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			respondWithError(w, "Invalid email verification token", http.StatusUnauthorized, metric.RequestVerifyEmail)
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyEmail)
+		}
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestVerifyEmail))
+	err = env.dao.DeleteAuthEmailVerifications(dao.DeleteAuthEmailVerificationsInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestVerifyEmail)
+		return
+	}
+
+	for _, hook := range env.hook.afterVerifyEmailHooks {
+		err := (*hook)(env, auth)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestVerifyEmail)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestVerifyEmail).Inc()
+}
+
+func (env *env) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var req resendVerificationRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestResendVerification)
+		return
+	}
+
+	_, err = valid.ValidateStruct(req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestResendVerification)
+		return
+	}
+
+	input := dao.ReadAuthInput{
+		Email: req.Email,
+	}
+
+	for _, hook := range env.hook.beforeResendVerificationHooks {
+		err := (*hook)(env, req, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestResendVerification)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestResendVerification))
+	auth, err := env.dao.ReadAuth(input)
+	timer.ObserveDuration()
+	if err != nil {
+		switch err {
+		case dao.ErrAuthNotFound:
+			// Respond as if the email was sent, such that this endpoint cannot be used to discover registered emails
+			json.NewEncoder(w).Encode(struct{}{})
+			metric.RequestSuccess.WithLabelValues(metric.RequestResendVerification).Inc()
+		default:
+			respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestResendVerification)
+		}
+		return
+	}
+
+	// There is nothing to resend once the email has been verified
+	if auth.EmailVerified {
+		json.NewEncoder(w).Encode(struct{}{})
+		metric.RequestSuccess.WithLabelValues(metric.RequestResendVerification).Inc()
+		return
+	}
+
+	timer = prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestResendVerification))
+	latest, err := env.dao.ReadLatestEmailVerification(dao.ReadLatestEmailVerificationInput{
+		AuthID: auth.ID,
+	})
+	timer.ObserveDuration()
+	switch err {
+	case nil:
+		resendInterval := time.Duration(env.config.EmailVerification.ResendIntervalSeconds) * time.Second
+		if latest.CreatedAt.Add(resendInterval).After(time.Now()) {
+			respondWithError(w, "A verification email was sent recently, try again later", http.StatusTooManyRequests, metric.RequestResendVerification)
+			return
+		}
+	case dao.ErrEmailVerificationNotFound:
+		break
+	default:
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestResendVerification)
+		return
+	}
+
+	err = sendVerificationEmail(env, auth, metric.RequestResendVerification)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestResendVerification)
+		return
+	}
+
+	for _, hook := range env.hook.afterResendVerificationHooks {
+		err := (*hook)(env, auth)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestResendVerification)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestResendVerification).Inc()
+}
+
+// Create an email verification token and email it to the auth
+// A failure to send is logged rather than returned, as the email can be resent
+func sendVerificationEmail(env *env, auth *dao.Auth, requestType string) error {
+	token, err := util.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(requestType))
+	_, err = env.dao.CreateEmailVerification(dao.CreateEmailVerificationInput{
+		ID:        id,
+		AuthID:    auth.ID,
+		TokenHash: util.HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailVerificationLifetime),
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		return err
+	}
+
+	err = env.mailer.SendMail(auth.Email, "Verify your email address", verificationBody(env.config.EmailVerification.URL, token))
+	if err != nil {
+		log.Printf("Could not send verification email: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Construct the body of a verification email, linking to the configured verification page if there is one
+func verificationBody(verifyURL string, token string) string {
+	if len(verifyURL) == 0 {
+		return fmt.Sprintf("Use the following token to verify your email address: %s\n\nIt expires in %s.", token, emailVerificationLifetime)
+	}
+	return fmt.Sprintf("Follow the link below to verify your email address:\n\n%s?token=%s\n\nIt expires in %s.", verifyURL, token, emailVerificationLifetime)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TempleEight/spec-golang/auth/comm"
+	"github.com/TempleEight/spec-golang/auth/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Test that following the emailed link verifies the email address
+func TestVerifyEmailHandlerSucceedsWithQuery(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := emailedToken(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodGet, fmt.Sprintf("/auth/verify?token=%s", token), "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if !mockEnv.dao.(*mockDAO).authList[0].EmailVerified {
+		t.Fatalf("Email was not verified")
+	}
+}
+
+// Test that providing the token in the body verifies the email address, and that the token can only be used once
+func TestVerifyEmailHandlerSucceedsWithBody(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := emailedToken(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/verify", fmt.Sprintf(`{"token": "%s"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/verify", fmt.Sprintf(`{"token": "%s"}`, token))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that an unknown verification token is rejected
+func TestVerifyEmailHandlerFailsOnUnknownToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodGet, "/auth/verify?token=NotAToken", "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that an expired verification token is rejected
+func TestVerifyEmailHandlerFailsOnExpiredToken(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+	token := emailedToken(t, mockEnv, "jay@test.com")
+
+	mockEnv.dao.(*mockDAO).emailVerificationList[0].ExpiresAt = time.Now().Add(-time.Minute)
+
+	res, err := makeRequest(mockEnv, http.MethodGet, fmt.Sprintf("/auth/verify?token=%s", token), "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that login is refused until the email is verified when verification is required
+func TestLoginAuthHandlerFailsOnUnverifiedEmailWhenRequired(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.config.EmailVerification.Mode = util.EmailVerificationRequired
+
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	if _, ok := tokens["AccessToken"]; ok {
+		t.Fatalf("An access token was issued before the email was verified")
+	}
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	token := emailedToken(t, mockEnv, "jay@test.com")
+	res, err = makeRequest(mockEnv, http.MethodGet, fmt.Sprintf("/auth/verify?token=%s", token), "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/login", `{"email": "jay@test.com", "password": "BlackcurrantCrush123"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+}
+
+// Test that restricted access tokens are issued until the email is verified when verification is restricted
+func TestRegisterAuthHandlerIssuesRestrictedTokenWhenRestricted(t *testing.T) {
+	mockEnv := makeMockEnv()
+	mockEnv.config.EmailVerification.Mode = util.EmailVerificationRestricted
+
+	tokens := registerAuth(t, mockEnv, "jay@test.com")
+	claims := unverifiedClaims(t, tokens["AccessToken"])
+	if verified, ok := claims["email_verified"]; !ok || verified != false {
+		t.Fatalf("Access token is not restricted: %v", claims)
+	}
+
+	token := emailedToken(t, mockEnv, "jay@test.com")
+	res, err := makeRequest(mockEnv, http.MethodGet, fmt.Sprintf("/auth/verify?token=%s", token), "")
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	// Refreshing after verification issues an unrestricted token
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/refresh", fmt.Sprintf(`{"RefreshToken": "%s"}`, tokens["RefreshToken"]))
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	var decoded map[string]string
+	err = json.Unmarshal([]byte(res.Body.String()), &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode json: %s", err.Error())
+	}
+
+	claims = unverifiedClaims(t, decoded["AccessToken"])
+	if _, ok := claims["email_verified"]; ok {
+		t.Fatalf("Access token is restricted: %v", claims)
+	}
+}
+
+// Test that verification emails cannot be resent more often than the configured interval
+func TestResendVerificationHandlerIsThrottled(t *testing.T) {
+	mockEnv := makeMockEnv()
+	registerAuth(t, mockEnv, "jay@test.com")
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/verify/resend", `{"email": "jay@test.com"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	mockEnv.dao.(*mockDAO).emailVerificationList[0].CreatedAt = time.Now().Add(-time.Hour)
+
+	res, err = makeRequest(mockEnv, http.MethodPost, "/auth/verify/resend", `{"email": "jay@test.com"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if len(mockEnv.mailer.(*comm.MemoryMailer).Messages) != 2 {
+		t.Fatalf("Verification email was not resent")
+	}
+}
+
+// Test that resending to an unregistered email responds identically, without sending an email
+func TestResendVerificationHandlerSucceedsOnUnknownEmail(t *testing.T) {
+	mockEnv := makeMockEnv()
+
+	res, err := makeRequest(mockEnv, http.MethodPost, "/auth/verify/resend", `{"email": "jay@test.com"}`)
+	if err != nil {
+		t.Fatalf("Could not make request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: %v", res.Code)
+	}
+
+	if len(mockEnv.mailer.(*comm.MemoryMailer).Messages) != 0 {
+		t.Fatalf("An email was sent to an unregistered address")
+	}
+}
+
+// unverifiedClaims decodes the claims of an access token without verifying its signature
+func unverifiedClaims(t *testing.T, rawToken string) jwt.MapClaims {
+	token, _, err := new(jwt.Parser).ParseUnverified(rawToken, jwt.MapClaims{})
+	if err != nil {
+		t.Fatalf("Could not decode JWT: %s", err.Error())
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		t.Fatalf("Could not decode claims")
+	}
+
+	return claims
+}
@@ -15,6 +15,19 @@ echo $GREEN
 
 kubectl create secret docker-registry regcred --docker-server=$REG_URL --docker-username=$REG_USERNAME --docker-password=$REG_PASSWORD --docker-email=$REG_EMAIL
 
+echo
+echo Creating service client secrets...
+echo
+
+# Each calling service gets a random client secret, and the auth service only gets its SHA-256 hash
+USER_SERVICE_CLIENT_SECRET=$(head -c 32 /dev/urandom | base64 | tr '+/' '-_' | tr -d '=')
+USER_SERVICE_CLIENT_HASH=$(printf '%s' "$USER_SERVICE_CLIENT_SECRET" | sha256sum | cut -d ' ' -f 1)
+MATCH_SERVICE_CLIENT_SECRET=$(head -c 32 /dev/urandom | base64 | tr '+/' '-_' | tr -d '=')
+MATCH_SERVICE_CLIENT_HASH=$(printf '%s' "$MATCH_SERVICE_CLIENT_SECRET" | sha256sum | cut -d ' ' -f 1)
+kubectl create secret generic user-service-credentials --from-literal=clientSecret="$USER_SERVICE_CLIENT_SECRET"
+kubectl create secret generic match-service-credentials --from-literal=clientSecret="$MATCH_SERVICE_CLIENT_SECRET"
+kubectl create secret generic auth-service-clients --from-literal=secrets="{\"user\": \"$USER_SERVICE_CLIENT_HASH\", \"match\": \"$MATCH_SERVICE_CLIENT_HASH\"}"
+
 echo
 echo Creating ConfigMaps...
 echo
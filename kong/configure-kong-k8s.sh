@@ -36,12 +36,20 @@ curl -s -i -X POST \
   --data "hosts[]=$KONG_ENTRY" \
   --data 'paths[]=/api/auth'
 
-# Require a JWT for the user service
+# Add a consumer for requests without a JWT, such that those authorized by an API key reach the services, which
+# resolve the key themselves
+curl -s -X POST $KONG_ADMIN/consumers \
+    --data "username=anonymous"\
+    --data "id=00000000-0000-0000-0000-000000000000"
+
+# Require a JWT for the user service, unless an API key is provided in its place
 curl -s -X POST $KONG_ADMIN/services/user-service/plugins \
     --data "name=jwt"\
-    --data "config.claims_to_verify=exp"
+    --data "config.claims_to_verify=exp"\
+    --data "config.anonymous=00000000-0000-0000-0000-000000000000"
 
-# Require a JWT for the match service
+# Require a JWT for the match service, unless an API key is provided in its place
 curl -s -X POST $KONG_ADMIN/services/match-service/plugins \
     --data "name=jwt"\
-    --data "config.claims_to_verify=exp"
+    --data "config.claims_to_verify=exp"\
+    --data "config.anonymous=00000000-0000-0000-0000-000000000000"
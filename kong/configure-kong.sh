@@ -36,12 +36,20 @@ curl -i -X POST \
   --data 'hosts[]=localhost:8000' \
   --data 'paths[]=/api/auth'
 
-# Require a JWT for the user service
+# Add a consumer for requests without a JWT, such that those authorized by an API key reach the services, which
+# resolve the key themselves
+curl -X POST http://localhost:8001/consumers \
+    --data "username=anonymous"\
+    --data "id=00000000-0000-0000-0000-000000000000"
+
+# Require a JWT for the user service, unless an API key is provided in its place
 curl -X POST http://localhost:8001/services/user-service/plugins \
     --data "name=jwt"\
-    --data "config.claims_to_verify=exp"
+    --data "config.claims_to_verify=exp"\
+    --data "config.anonymous=00000000-0000-0000-0000-000000000000"
 
-# Require a JWT for the match service
+# Require a JWT for the match service, unless an API key is provided in its place
 curl -X POST http://localhost:8001/services/match-service/plugins \
     --data "name=jwt"\
-    --data "config.claims_to_verify=exp"
+    --data "config.claims_to_verify=exp"\
+    --data "config.anonymous=00000000-0000-0000-0000-000000000000"
@@ -0,0 +1,135 @@
+package comm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/match/util"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidAPIKey is returned when the auth service does not recognise an API key, or its auth has been suspended
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// introspectAPIKeyResponse encapsulates the response from the auth service after resolving an API key
+type introspectAPIKeyResponse struct {
+	ID            uuid.UUID
+	AuthID        uuid.UUID
+	Prefix        string
+	Role          string
+	EmailVerified bool
+	Scopes        []string
+}
+
+// cachedAPIKey is an API key the auth service has resolved, and when it must be resolved again
+type cachedAPIKey struct {
+	auth   *util.Auth
+	expiry time.Time
+}
+
+// ResolveAPIKey asks the auth service which auth an API key acts on behalf of, and the scopes it is limited to
+// Resolved keys are cached for as long as the revoked access tokens are, so revoking a key takes as long to apply
+func (comm *Handler) ResolveAPIKey(key string) (*util.Auth, error) {
+	hash := sha256.Sum256([]byte(key))
+	cacheKey := hex.EncodeToString(hash[:])
+	now := time.Now()
+
+	comm.apiKeyMutex.Lock()
+	cached, ok := comm.apiKeys[cacheKey]
+	comm.apiKeyMutex.Unlock()
+	if ok && now.Before(cached.expiry) {
+		return cached.auth, nil
+	}
+
+	auth, err := comm.introspectAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	comm.apiKeyMutex.Lock()
+	defer comm.apiKeyMutex.Unlock()
+	if comm.apiKeys == nil {
+		comm.apiKeys = make(map[string]cachedAPIKey)
+	}
+	for cacheKey, cached := range comm.apiKeys {
+		if !now.Before(cached.expiry) {
+			delete(comm.apiKeys, cacheKey)
+		}
+	}
+	comm.apiKeys[cacheKey] = cachedAPIKey{auth, now.Add(comm.revocationTTL)}
+
+	return auth, nil
+}
+
+// introspectAPIKey makes a request to the auth service to resolve an API key
+// The request is authorized by a service token, which is refreshed once if the auth service rejects it
+func (comm *Handler) introspectAPIKey(key string) (*util.Auth, error) {
+	resp, err := comm.requestIntrospection(key, false)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		resp, err = comm.requestIntrospection(key, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		break
+	case http.StatusNotFound, http.StatusForbidden:
+		return nil, ErrInvalidAPIKey
+	default:
+		return nil, fmt.Errorf("auth service responded with status code %d", resp.StatusCode)
+	}
+
+	var introspected introspectAPIKeyResponse
+	err = json.NewDecoder(resp.Body).Decode(&introspected)
+	if err != nil {
+		return nil, err
+	}
+
+	return &util.Auth{
+		ID:            introspected.AuthID,
+		EmailVerified: introspected.EmailVerified,
+		Role:          introspected.Role,
+		APIKey:        introspected.Prefix,
+		Scopes:        introspected.Scopes,
+	}, nil
+}
+
+// requestIntrospection makes a single request to the auth service to resolve an API key, returning the response
+func (comm *Handler) requestIntrospection(key string, refresh bool) (*http.Response, error) {
+	hostname, ok := comm.Services["auth"]
+	if !ok {
+		return nil, fmt.Errorf("service %s's hostname not in config file", "auth")
+	}
+
+	token, err := comm.serviceAuthorization(refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]string{"key": key})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api-keys/introspect", hostname), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	return client.Do(req)
+}
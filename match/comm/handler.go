@@ -1,47 +1,227 @@
 package comm
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/TempleEight/spec-golang/match/util"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 )
 
 // Comm provides the interface adopted by Handler, allowing for mocking
 type Comm interface {
-	CheckUser(userID uuid.UUID, token string) (bool, error)
+	CheckUsers(userIDs []uuid.UUID) ([]uuid.UUID, error)
+	CheckRevoked(auth *util.Auth) (bool, error)
+	Keyfunc() jwt.Keyfunc
+	ResolveAPIKey(key string) (*util.Auth, error)
 }
 
 // Handler maintains the list of services and their associated hostnames
 type Handler struct {
 	Services map[string]string
+
+	// The revoked access tokens, and the times before which each auth's tokens are revoked, are cached, such that the
+	// auth service isn't contacted on every request
+	revocationTTL    time.Duration
+	revocationMutex  sync.Mutex
+	revocationExpiry time.Time
+	revokedTokens    map[string]bool
+	revokedAuths     map[uuid.UUID]time.Time
+
+	// The auth service's signing keys are cached, such that it is only contacted when they may have been rotated
+	tokenVerification util.TokenVerificationConfig
+	jwksTTL           time.Duration
+	jwksMutex         sync.Mutex
+	jwksExpiry        time.Time
+	jwksFetchedAt     time.Time
+	jwks              map[string]*verificationKey
+
+	// Resolved API keys are cached by their hash, such that the auth service isn't contacted on every request
+	apiKeyMutex sync.Mutex
+	apiKeys     map[string]cachedAPIKey
+
+	// The service token is cached until shortly before it expires, such that a new one isn't requested on every call
+	serviceCredentials util.ServiceCredentialsConfig
+	serviceTokenMutex  sync.Mutex
+	serviceTokenExpiry time.Time
+	serviceToken       string
+}
+
+// checkUsersResponse encapsulates the response from the user service after reading a batch of users
+type checkUsersResponse struct {
+	MissingIDs []uuid.UUID
+}
+
+// revokedResponse encapsulates the response from the auth service after listing the revoked access tokens
+type revokedResponse struct {
+	RevokedTokens []struct {
+		JTI string
+	}
+	RevokedAuths []struct {
+		AuthID        uuid.UUID
+		RevokedBefore time.Time
+	}
 }
 
 // Init sets up the Handler object with a list of services from the config
 func Init(config *util.Config) *Handler {
-	return &Handler{config.Services}
+	return &Handler{
+		Services:      config.Services,
+		revocationTTL: time.Duration(config.RevocationCacheSeconds) * time.Second,
+
+		tokenVerification: config.TokenVerification,
+		jwksTTL:           time.Duration(config.TokenVerification.JWKSCacheSeconds) * time.Second,
+
+		serviceCredentials: config.ServiceCredentials,
+	}
 }
 
-// CheckUser makes a request to the target service to check if a user ID exists
-func (comm *Handler) CheckUser(userID uuid.UUID, token string) (bool, error) {
+// CheckUsers makes a single request to the user service to check which of several user IDs exist, returning those that
+// do not
+// The request is authorized by a service token, which is refreshed once if the user service rejects it
+func (comm *Handler) CheckUsers(userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	resp, err := comm.checkUsers(userIDs, false)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		resp, err = comm.checkUsers(userIDs, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user service responded with status code %d", resp.StatusCode)
+	}
+
+	var checked checkUsersResponse
+	err = json.NewDecoder(resp.Body).Decode(&checked)
+	if err != nil {
+		return nil, err
+	}
+
+	return checked.MissingIDs, nil
+}
+
+// checkUsers makes a single request to the user service to read a batch of users, returning the response
+func (comm *Handler) checkUsers(userIDs []uuid.UUID, refresh bool) (*http.Response, error) {
 	hostname, ok := comm.Services["user"]
 	if !ok {
-		return false, fmt.Errorf("service %s's hostname not in config file", "user")
+		return nil, fmt.Errorf("service %s's hostname not in config file", "user")
+	}
+
+	token, err := comm.serviceAuthorization(refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string][]uuid.UUID{"IDs": userIDs})
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/%s", hostname, userID.String()), nil)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/batch", hostname), bytes.NewReader(body))
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	// Token should already be in the form `Bearer <token>`
-	req.Header.Set("Authorization", token)
-	resp, err := new(http.Client).Do(req)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", "application/json")
+	return new(http.Client).Do(req)
+}
+
+// CheckRevoked checks whether the auth service has revoked the given access token, either by its ID or by invalidating
+// the credentials of its auth after it was issued
+// If the auth service cannot be reached, the previously fetched lists are used until it can be
+func (comm *Handler) CheckRevoked(auth *util.Auth) (bool, error) {
+	comm.revocationMutex.Lock()
+	defer comm.revocationMutex.Unlock()
+
+	if time.Now().After(comm.revocationExpiry) {
+		revokedTokens, revokedAuths, err := comm.listRevoked()
+		if err != nil {
+			if comm.revokedTokens == nil {
+				return false, err
+			}
+			log.Printf("Unable to refresh revoked tokens, using cached list: %s", err.Error())
+		} else {
+			comm.revokedTokens = revokedTokens
+			comm.revokedAuths = revokedAuths
+		}
+		comm.revocationExpiry = time.Now().Add(comm.revocationTTL)
+	}
+
+	if len(auth.JTI) > 0 && comm.revokedTokens[auth.JTI] {
+		return true, nil
+	}
+
+	revokedBefore, ok := comm.revokedAuths[auth.ID]
+	return ok && auth.IssuedAt.Before(revokedBefore), nil
+}
+
+// listRevoked makes a request to the auth service to list every access token that has been revoked but not yet expired,
+// and the time before which every access token of an auth is revoked
+// The request is authorized by a service token, which is refreshed once if the auth service rejects it
+func (comm *Handler) listRevoked() (map[string]bool, map[uuid.UUID]time.Time, error) {
+	resp, err := comm.requestRevoked(false)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		resp, err = comm.requestRevoked(true)
+	}
 	if err != nil {
-		return false, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	return resp.StatusCode == http.StatusOK, nil
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("auth service responded with status code %d", resp.StatusCode)
+	}
+
+	var revoked revokedResponse
+	err = json.NewDecoder(resp.Body).Decode(&revoked)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	revokedTokens := make(map[string]bool)
+	for _, revokedToken := range revoked.RevokedTokens {
+		revokedTokens[revokedToken.JTI] = true
+	}
+
+	revokedAuths := make(map[uuid.UUID]time.Time)
+	for _, revokedAuth := range revoked.RevokedAuths {
+		revokedAuths[revokedAuth.AuthID] = revokedAuth.RevokedBefore
+	}
+
+	return revokedTokens, revokedAuths, nil
+}
+
+// requestRevoked makes a single request to the auth service to list the revoked access tokens, returning the response
+func (comm *Handler) requestRevoked(refresh bool) (*http.Response, error) {
+	hostname, ok := comm.Services["auth"]
+	if !ok {
+		return nil, fmt.Errorf("service %s's hostname not in config file", "auth")
+	}
+
+	token, err := comm.serviceAuthorization(refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/revoked", hostname), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	return client.Do(req)
 }
@@ -0,0 +1,216 @@
+package comm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/TempleEight/spec-golang/match/util"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// jwksRefreshInterval is the minimum time between fetching the signing keys on encountering an unknown key ID, such that
+// tokens naming made-up keys cannot overwhelm the auth service
+const jwksRefreshInterval = time.Second
+
+// jwksResponse encapsulates the response from the auth service after listing its signing keys, in the JSON Web Key Set
+// format defined by RFC 7517
+type jwksResponse struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"keys"`
+}
+
+// verificationKey is a public key that access tokens may be signed by, and the algorithm they must be signed with
+type verificationKey struct {
+	algorithm string
+	publicKey interface{}
+}
+
+// Keyfunc returns the function used to verify access tokens, for use with jwt.Parse, or nil if they are not verified
+func (comm *Handler) Keyfunc() jwt.Keyfunc {
+	switch comm.tokenVerification.Mode {
+	case util.TokenVerificationJWKS:
+		return comm.jwksKey
+	case util.TokenVerificationSecret:
+		return comm.secretKey
+	default:
+		return nil
+	}
+}
+
+// secretKey returns the shared secret to verify a token with, provided it was signed with HS256 by the configured issuer
+func (comm *Handler) secretKey(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodHS256 {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyIssuer(comm.tokenVerification.Issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+
+	return []byte(comm.tokenVerification.Secret), nil
+}
+
+// jwksKey returns the public key to verify a token with, looked up by its kid in the keys published by the auth service
+// The auth service names the signing key in both the kid header and the iss claim, so the two must match
+func (comm *Handler) jwksKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || kid == "" || !claims.VerifyIssuer(kid, true) {
+		return nil, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+
+	key, err := comm.lookupKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	return key.publicKey, nil
+}
+
+// lookupKey returns the signing key with the given ID, fetching the keys from the auth service if the cached keys have
+// expired or do not contain it
+// If the auth service cannot be reached, the previously fetched keys are used until it can be
+func (comm *Handler) lookupKey(kid string) (*verificationKey, error) {
+	comm.jwksMutex.Lock()
+	defer comm.jwksMutex.Unlock()
+
+	key, ok := comm.jwks[kid]
+	now := time.Now()
+	if now.After(comm.jwksExpiry) || (!ok && now.Sub(comm.jwksFetchedAt) >= jwksRefreshInterval) {
+		keys, err := comm.listKeys()
+		comm.jwksFetchedAt = now
+		if err != nil {
+			if comm.jwks == nil {
+				return nil, err
+			}
+			log.Printf("Unable to refresh signing keys, using cached keys: %s", err.Error())
+		} else {
+			comm.jwks = keys
+		}
+		comm.jwksExpiry = now.Add(comm.jwksTTL)
+		key, ok = comm.jwks[kid]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+
+	return key, nil
+}
+
+// listKeys makes a request to the auth service to list the public keys that unexpired access tokens may be signed by
+func (comm *Handler) listKeys() (map[string]*verificationKey, error) {
+	hostname, ok := comm.Services["auth"]
+	if !ok {
+		return nil, fmt.Errorf("service %s's hostname not in config file", "auth")
+	}
+
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	resp, err := client.Get(fmt.Sprintf("%s/.well-known/jwks.json", hostname))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth service responded with status code %d", resp.StatusCode)
+	}
+
+	var jwks jwksResponse
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*verificationKey)
+	for _, jwk := range jwks.Keys {
+		var publicKey interface{}
+		switch {
+		case jwk.Kty == "RSA" && jwk.Alg == jwt.SigningMethodRS256.Alg():
+			publicKey, err = rsaPublicKey(jwk.N, jwk.E)
+		case jwk.Kty == "EC" && jwk.Alg == jwt.SigningMethodES256.Alg() && jwk.Crv == "P-256":
+			publicKey, err = ecdsaPublicKey(jwk.X, jwk.Y)
+		default:
+			err = fmt.Errorf("unsupported key type %s with algorithm %s", jwk.Kty, jwk.Alg)
+		}
+		if err != nil {
+			log.Printf("Ignoring signing key %s: %s", jwk.Kid, err.Error())
+			continue
+		}
+
+		keys[jwk.Kid] = &verificationKey{jwk.Alg, publicKey}
+	}
+
+	return keys, nil
+}
+
+// rsaPublicKey decodes an RSA public key from its base64url-encoded modulus and exponent
+func rsaPublicKey(encodedN string, encodedE string) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(encodedN)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(encodedE)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("RSA exponent is too large")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
+
+// ecdsaPublicKey decodes a P-256 public key from its base64url-encoded coordinates
+func ecdsaPublicKey(encodedX string, encodedY string) (*ecdsa.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(encodedX)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(encodedY)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, errors.New("EC point is not on the curve")
+	}
+
+	return &publicKey, nil
+}
@@ -0,0 +1,83 @@
+package comm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// serviceTokenLeeway is how long before its expiry a cached service token is replaced, such that it does not expire
+// while a request using it is in flight
+const serviceTokenLeeway = 30 * time.Second
+
+// serviceTokenResponse encapsulates the response from the auth service after a client credentials grant
+type serviceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// serviceAuthorization returns a service token identifying this service, requesting a new one from the auth service if
+// the cached token has expired or refresh is set
+func (comm *Handler) serviceAuthorization(refresh bool) (string, error) {
+	comm.serviceTokenMutex.Lock()
+	defer comm.serviceTokenMutex.Unlock()
+
+	if refresh || comm.serviceToken == "" || time.Now().After(comm.serviceTokenExpiry) {
+		token, err := comm.requestServiceToken()
+		if err != nil {
+			return "", err
+		}
+
+		comm.serviceToken = token.AccessToken
+		comm.serviceTokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - serviceTokenLeeway)
+	}
+
+	return comm.serviceToken, nil
+}
+
+// requestServiceToken makes a request to the auth service to exchange the client credentials for a service token
+func (comm *Handler) requestServiceToken() (*serviceTokenResponse, error) {
+	hostname, ok := comm.Services["auth"]
+	if !ok {
+		return nil, fmt.Errorf("service %s's hostname not in config file", "auth")
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/token", hostname), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(comm.serviceCredentials.ClientID, comm.serviceCredentials.ClientSecret)
+
+	client := http.Client{
+		Timeout: 5 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth service responded with status code %d", resp.StatusCode)
+	}
+
+	var token serviceTokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken == "" || !strings.EqualFold(token.TokenType, "bearer") {
+		return nil, errors.New("auth service did not issue a bearer token")
+	}
+
+	return &token, nil
+}
@@ -19,6 +19,7 @@ type BaseDatastore interface {
 	ReadMatch(input ReadMatchInput) (*Match, error)
 	UpdateMatch(input UpdateMatchInput) (*Match, error)
 	DeleteMatch(input DeleteMatchInput) error
+	DeleteAllMatch(input DeleteAllMatchInput) error
 }
 
 // DAO encapsulates access to the datastore
@@ -67,6 +68,13 @@ type DeleteMatchInput struct {
 	ID uuid.UUID
 }
 
+// DeleteAllMatchInput encapsulates the information required to delete every match created by a given user in the
+// datastore, and optionally every match the user appears in
+type DeleteAllMatchInput struct {
+	UserID         uuid.UUID
+	IncludeMatched bool
+}
+
 // Init opens the datastore connection, returning a DAO
 func Init(config *util.Config) (*DAO, error) {
 	connStr := fmt.Sprintf("user=%s dbname=%s host=%s sslmode=%s", config.User, config.DBName, config.Host, config.SSLMode)
@@ -183,3 +191,15 @@ func (dao *DAO) DeleteMatch(input DeleteMatchInput) error {
 
 	return nil
 }
+
+// DeleteAllMatch deletes every match in the datastore that was created by a given user, and every match that involves
+// them if requested
+func (dao *DAO) DeleteAllMatch(input DeleteAllMatchInput) error {
+	var err error
+	if input.IncludeMatched {
+		_, err = executeQuery(dao.DB, "DELETE FROM match WHERE created_by = $1 OR userOne = $1 OR userTwo = $1", input.UserID)
+	} else {
+		_, err = executeQuery(dao.DB, "DELETE FROM match WHERE created_by = $1", input.UserID)
+	}
+	return err
+}
@@ -5,17 +5,19 @@ import "github.com/TempleEight/spec-golang/match/dao"
 // Hook allows additional code to be executed before and after every datastore interaction
 // Hooks are executed in the order they are defined, such that if any hook errors, future hooks are not executed and the request is terminated
 type Hook struct {
-	beforeListHooks   []*func(env *env, input *dao.ListMatchInput) *HookError
-	beforeCreateHooks []*func(env *env, req createMatchRequest, input *dao.CreateMatchInput) *HookError
-	beforeReadHooks   []*func(env *env, input *dao.ReadMatchInput) *HookError
-	beforeUpdateHooks []*func(env *env, req updateMatchRequest, input *dao.UpdateMatchInput) *HookError
-	beforeDeleteHooks []*func(env *env, input *dao.DeleteMatchInput) *HookError
-
-	afterListHooks   []*func(env *env, userList *[]dao.Match) *HookError
-	afterCreateHooks []*func(env *env, user *dao.Match) *HookError
-	afterReadHooks   []*func(env *env, user *dao.Match) *HookError
-	afterUpdateHooks []*func(env *env, user *dao.Match) *HookError
-	afterDeleteHooks []*func(env *env) *HookError
+	beforeListHooks      []*func(env *env, input *dao.ListMatchInput) *HookError
+	beforeCreateHooks    []*func(env *env, req createMatchRequest, input *dao.CreateMatchInput) *HookError
+	beforeReadHooks      []*func(env *env, input *dao.ReadMatchInput) *HookError
+	beforeUpdateHooks    []*func(env *env, req updateMatchRequest, input *dao.UpdateMatchInput) *HookError
+	beforeDeleteHooks    []*func(env *env, input *dao.DeleteMatchInput) *HookError
+	beforeDeleteAllHooks []*func(env *env, input *dao.DeleteAllMatchInput) *HookError
+
+	afterListHooks      []*func(env *env, userList *[]dao.Match) *HookError
+	afterCreateHooks    []*func(env *env, user *dao.Match) *HookError
+	afterReadHooks      []*func(env *env, user *dao.Match) *HookError
+	afterUpdateHooks    []*func(env *env, user *dao.Match) *HookError
+	afterDeleteHooks    []*func(env *env) *HookError
+	afterDeleteAllHooks []*func(env *env) *HookError
 }
 
 // HookError wraps an existing error with HTTP status code
@@ -53,6 +55,11 @@ func (h *Hook) BeforeDelete(hook func(env *env, input *dao.DeleteMatchInput) *Ho
 	h.beforeDeleteHooks = append(h.beforeDeleteHooks, &hook)
 }
 
+// BeforeDeleteAll adds a new hook to be executed before deleting every object involving a user in the datastore
+func (h *Hook) BeforeDeleteAll(hook func(env *env, input *dao.DeleteAllMatchInput) *HookError) {
+	h.beforeDeleteAllHooks = append(h.beforeDeleteAllHooks, &hook)
+}
+
 // AfterList adds a new hook to be executed after listing the objects in the datastore
 func (h *Hook) AfterList(hook func(env *env, userList *[]dao.Match) *HookError) {
 	h.afterListHooks = append(h.afterListHooks, &hook)
@@ -77,3 +84,8 @@ func (h *Hook) AfterUpdate(hook func(env *env, user *dao.Match) *HookError) {
 func (h *Hook) AfterDelete(hook func(env *env) *HookError) {
 	h.afterDeleteHooks = append(h.afterDeleteHooks, &hook)
 }
+
+// AfterDeleteAll adds a new hook to be executed after deleting every object involving a user in the datastore
+func (h *Hook) AfterDeleteAll(hook func(env *env) *HookError) {
+	h.afterDeleteAllHooks = append(h.afterDeleteAllHooks, &hook)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -27,6 +28,23 @@ type env struct {
 	hook Hook
 }
 
+// Policies deciding which auths may act on a match, where the owner is the auth that created the match
+var (
+	readMatchPolicy   = util.AnyOf(util.Owner, util.HasRole(util.RoleModerator))
+	updateMatchPolicy = util.Owner
+	deleteMatchPolicy = util.AnyOf(util.Owner, util.HasRole(util.RoleModerator))
+)
+
+// requestScopes contains the scope an API key needs for each request type
+var requestScopes = map[string]string{
+	metric.RequestList:      util.ScopeMatchRead,
+	metric.RequestCreate:    util.ScopeMatchWrite,
+	metric.RequestRead:      util.ScopeMatchRead,
+	metric.RequestUpdate:    util.ScopeMatchWrite,
+	metric.RequestDelete:    util.ScopeMatchWrite,
+	metric.RequestDeleteAll: util.ScopeMatchWrite,
+}
+
 // createMatchRequest contains the client-provided information required to create a single match
 type createMatchRequest struct {
 	UserOne *uuid.UUID `valid:"-"`
@@ -73,6 +91,7 @@ func defaultRouter(env *env) *mux.Router {
 	r := mux.NewRouter()
 	// Mux directs to first matching route, i.e. the order matters
 	r.HandleFunc("/match/all", env.listMatchHandler).Methods(http.MethodGet)
+	r.HandleFunc("/match/all", env.deleteAllMatchHandler).Methods(http.MethodDelete)
 	r.HandleFunc("/match", env.createMatchHandler).Methods(http.MethodPost)
 	r.HandleFunc("/match/{id}", env.readMatchHandler).Methods(http.MethodGet)
 	r.HandleFunc("/match/{id}", env.updateMatchHandler).Methods(http.MethodPut)
@@ -100,6 +119,31 @@ func main() {
 		log.Fatal(err)
 	}
 
+	switch config.TokenVerification.Mode {
+	case "":
+		config.TokenVerification.Mode = util.TokenVerificationJWKS
+	case util.TokenVerificationJWKS, util.TokenVerificationSecret:
+		break
+	case util.TokenVerificationNone:
+		log.Print("Access tokens will not be verified, so the service must only be reachable through the gateway")
+	default:
+		log.Fatalf("Unknown token verification mode %s", config.TokenVerification.Mode)
+	}
+
+	if config.TokenVerification.Mode == util.TokenVerificationJWKS && config.TokenVerification.JWKSCacheSeconds <= 0 {
+		log.Fatal("tokenVerification jwksCacheSeconds must be positive")
+	}
+	if config.TokenVerification.Mode == util.TokenVerificationSecret && (config.TokenVerification.Secret == "" || config.TokenVerification.Issuer == "") {
+		log.Fatal("tokenVerification secret and issuer must be provided in secret mode")
+	}
+	err = util.LoadServiceClientSecret(&config.ServiceCredentials)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.ServiceCredentials.ClientID == "" || config.ServiceCredentials.ClientSecret == "" {
+		log.Fatalf("serviceCredentials clientID and a client secret, from %s or clientSecretFile, must be provided", util.ServiceClientSecretEnv)
+	}
+
 	// Prometheus metrics
 	promPort, ok := config.Ports["prometheus"]
 	if !ok {
@@ -137,7 +181,76 @@ func jsonMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func checkAuthorization(env *env, matchID uuid.UUID, auth *util.Auth) (bool, error) {
+// extractAuth extracts the auth from a request, rejecting service tokens, which do not act on behalf of any auth
+func extractAuth(env *env, headers http.Header, requestType string) (*util.Auth, error) {
+	auth, err := extractCaller(env, headers, requestType)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(auth.Service) > 0 {
+		return nil, errors.New("service tokens cannot act on behalf of an auth")
+	}
+
+	return auth, nil
+}
+
+// extractCaller extracts the auth or service from a request, for routes that other services call
+// Restricted access tokens and those that have been revoked by the auth service are rejected
+// An API key may be provided in place of an access token, provided it has the scope the request type requires
+func extractCaller(env *env, headers http.Header, requestType string) (*util.Auth, error) {
+	if key := headers.Get("X-API-Key"); len(key) > 0 {
+		return extractAPIKey(env, key, requestType)
+	}
+
+	// The gateway lets requests without a valid access token through for API keys, so tokens it has not verified
+	// cannot be trusted unless this service verifies them itself
+	if env.comm.Keyfunc() == nil && headers.Get("X-Anonymous-Consumer") == "true" {
+		return nil, errors.New("access token was not verified by the gateway")
+	}
+
+	auth, err := util.ExtractAuthIDFromRequest(headers, env.comm.Keyfunc())
+	if err != nil {
+		return nil, err
+	}
+
+	if !auth.EmailVerified {
+		return nil, errors.New("email address has not been verified")
+	}
+
+	revoked, err := env.comm.CheckRevoked(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
+
+	return auth, nil
+}
+
+// extractAPIKey resolves an API key to the auth it acts on behalf of, recording its usage
+func extractAPIKey(env *env, key string, requestType string) (*util.Auth, error) {
+	auth, err := env.comm.ResolveAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !auth.EmailVerified {
+		return nil, errors.New("email address has not been verified")
+	}
+
+	if !auth.HasScope(requestScopes[requestType]) {
+		return nil, fmt.Errorf("API key does not have the %s scope", requestScopes[requestType])
+	}
+
+	metric.APIKeyRequests.WithLabelValues(requestType, auth.APIKey).Inc()
+	return auth, nil
+}
+
+// checkAuthorization reads the match, returning whether the given policy permits the auth to act on it
+func checkAuthorization(env *env, matchID uuid.UUID, auth *util.Auth, policy util.Policy) (bool, error) {
 	match, err := env.dao.ReadMatch(dao.ReadMatchInput{
 		ID: matchID,
 	})
@@ -145,11 +258,11 @@ func checkAuthorization(env *env, matchID uuid.UUID, auth *util.Auth) (bool, err
 		return false, err
 	}
 
-	return match.CreatedBy == auth.ID, nil
+	return policy(auth, match.CreatedBy), nil
 }
 
 func (env *env) listMatchHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestList)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestList)
 		return
@@ -201,7 +314,7 @@ func (env *env) listMatchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (env *env) createMatchHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestCreate)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestCreate)
 		return
@@ -225,25 +338,15 @@ func (env *env) createMatchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userOneValid, err := env.comm.CheckUser(*req.UserOne, r.Header.Get("Authorization"))
+	// Both users are checked in a single request, with any unknown users reported in the order they were provided
+	missingUsers, err := env.comm.CheckUsers([]uuid.UUID{*req.UserOne, *req.UserTwo})
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Unable to reach user service: %s", err.Error()), http.StatusInternalServerError, metric.RequestCreate)
 		return
 	}
 
-	if !userOneValid {
-		respondWithError(w, fmt.Sprintf("Unknown User: %s", req.UserOne.String()), http.StatusBadRequest, metric.RequestCreate)
-		return
-	}
-
-	userTwoValid, err := env.comm.CheckUser(*req.UserTwo, r.Header.Get("Authorization"))
-	if err != nil {
-		respondWithError(w, fmt.Sprintf("Unable to reach user service: %s", err.Error()), http.StatusInternalServerError, metric.RequestCreate)
-		return
-	}
-
-	if !userTwoValid {
-		respondWithError(w, fmt.Sprintf("Unknown User: %s", req.UserTwo.String()), http.StatusBadRequest, metric.RequestCreate)
+	if len(missingUsers) > 0 {
+		respondWithError(w, fmt.Sprintf("Unknown User: %s", missingUsers[0].String()), http.StatusBadRequest, metric.RequestCreate)
 		return
 	}
 
@@ -295,7 +398,7 @@ func (env *env) createMatchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (env *env) readMatchHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestRead)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestRead)
 		return
@@ -307,7 +410,7 @@ func (env *env) readMatchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authorized, err := checkAuthorization(env, matchID, auth)
+	authorized, err := checkAuthorization(env, matchID, auth, readMatchPolicy)
 	if err != nil {
 		switch err.(type) {
 		case dao.ErrMatchNotFound:
@@ -367,7 +470,7 @@ func (env *env) readMatchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (env *env) updateMatchHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestUpdate)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestUpdate)
 		return
@@ -379,7 +482,7 @@ func (env *env) updateMatchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authorized, err := checkAuthorization(env, matchID, auth)
+	authorized, err := checkAuthorization(env, matchID, auth, updateMatchPolicy)
 	if err != nil {
 		switch err.(type) {
 		case dao.ErrMatchNotFound:
@@ -412,25 +515,15 @@ func (env *env) updateMatchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userOneValid, err := env.comm.CheckUser(*req.UserOne, r.Header.Get("Authorization"))
-	if err != nil {
-		respondWithError(w, fmt.Sprintf("Unable to reach %s service: %s", "user", err.Error()), http.StatusInternalServerError, metric.RequestUpdate)
-		return
-	}
-
-	if !userOneValid {
-		respondWithError(w, fmt.Sprintf("Unknown User: %s", req.UserOne.String()), http.StatusBadRequest, metric.RequestUpdate)
-		return
-	}
-
-	userTwoValid, err := env.comm.CheckUser(*req.UserTwo, r.Header.Get("Authorization"))
+	// Both users are checked in a single request, with any unknown users reported in the order they were provided
+	missingUsers, err := env.comm.CheckUsers([]uuid.UUID{*req.UserOne, *req.UserTwo})
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Unable to reach %s service: %s", "user", err.Error()), http.StatusInternalServerError, metric.RequestUpdate)
 		return
 	}
 
-	if !userTwoValid {
-		respondWithError(w, fmt.Sprintf("Unknown User: %s", req.UserTwo.String()), http.StatusBadRequest, metric.RequestUpdate)
+	if len(missingUsers) > 0 {
+		respondWithError(w, fmt.Sprintf("Unknown User: %s", missingUsers[0].String()), http.StatusBadRequest, metric.RequestUpdate)
 		return
 	}
 
@@ -480,7 +573,7 @@ func (env *env) updateMatchHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (env *env) deleteMatchHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestDelete)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDelete)
 		return
@@ -492,7 +585,7 @@ func (env *env) deleteMatchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authorized, err := checkAuthorization(env, matchID, auth)
+	authorized, err := checkAuthorization(env, matchID, auth, deleteMatchPolicy)
 	if err != nil {
 		switch err.(type) {
 		case dao.ErrMatchNotFound:
@@ -545,3 +638,63 @@ func (env *env) deleteMatchHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(struct{}{})
 	metric.RequestSuccess.WithLabelValues(metric.RequestDelete).Inc()
 }
+
+func (env *env) deleteAllMatchHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractCaller(env, r.Header, metric.RequestDeleteAll)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDeleteAll)
+		return
+	}
+
+	// An auth may only delete the matches it created, while the auth service deletes every match a deleted user created
+	// or appears in, such that no match references a deleted user
+	var input dao.DeleteAllMatchInput
+	switch auth.Service {
+	case "":
+		input = dao.DeleteAllMatchInput{
+			UserID: auth.ID,
+		}
+	case "auth":
+		userID, err := uuid.Parse(r.URL.Query().Get("user"))
+		if err != nil {
+			respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestDeleteAll)
+			return
+		}
+
+		input = dao.DeleteAllMatchInput{
+			UserID:         userID,
+			IncludeMatched: true,
+		}
+	default:
+		respondWithError(w, "Unauthorized", http.StatusUnauthorized, metric.RequestDeleteAll)
+		return
+	}
+
+	for _, hook := range env.hook.beforeDeleteAllHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestDeleteAll)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestDeleteAll))
+	err = env.dao.DeleteAllMatch(input)
+	timer.ObserveDuration()
+
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestDeleteAll)
+		return
+	}
+
+	for _, hook := range env.hook.afterDeleteAllHooks {
+		err := (*hook)(env)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestDeleteAll)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(struct{}{})
+	metric.RequestSuccess.WithLabelValues(metric.RequestDeleteAll).Inc()
+}
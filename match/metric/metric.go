@@ -6,11 +6,12 @@ import (
 )
 
 var (
-	RequestList   = "list"
-	RequestCreate = "create"
-	RequestRead   = "read"
-	RequestUpdate = "update"
-	RequestDelete = "delete"
+	RequestList      = "list"
+	RequestCreate    = "create"
+	RequestRead      = "read"
+	RequestUpdate    = "update"
+	RequestDelete    = "delete"
+	RequestDeleteAll = "delete_all"
 
 	RequestSuccess = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "match_request_success_total",
@@ -22,6 +23,11 @@ var (
 		Help: "The total number of failed requests",
 	}, []string{"request_type", "error_code"})
 
+	APIKeyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "match_api_key_requests_total",
+		Help: "The total number of requests authorized by an API key, by the prefix of the key",
+	}, []string{"request_type", "api_key"})
+
 	DatabaseRequestDuration = promauto.NewSummaryVec(prometheus.SummaryOpts{
 		Name:       "match_database_request_seconds",
 		Help:       "The time spent executing database requests in seconds",
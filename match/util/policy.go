@@ -0,0 +1,57 @@
+package util
+
+import "github.com/google/uuid"
+
+// Roles that an auth may be granted, in increasing order of privilege
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Scopes that API keys for this service may be limited to
+const (
+	ScopeMatchRead  = "match:read"
+	ScopeMatchWrite = "match:write"
+)
+
+// roleRank orders the roles, such that each role is permitted everything the roles before it are
+var roleRank = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ValidRole returns whether the given role is one that can be granted
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Policy decides whether an auth may act on data belonging to the given owner
+type Policy func(auth *Auth, ownerID uuid.UUID) bool
+
+// Owner permits only the owner of the data
+func Owner(auth *Auth, ownerID uuid.UUID) bool {
+	return auth.ID == ownerID
+}
+
+// HasRole permits any auth granted at least the given role, regardless of who owns the data
+func HasRole(role string) Policy {
+	return func(auth *Auth, ownerID uuid.UUID) bool {
+		rank, ok := roleRank[auth.Role]
+		return ok && rank >= roleRank[role]
+	}
+}
+
+// AnyOf permits any auth permitted by at least one of the given policies
+func AnyOf(policies ...Policy) Policy {
+	return func(auth *Auth, ownerID uuid.UUID) bool {
+		for _, policy := range policies {
+			if policy(auth, ownerID) {
+				return true
+			}
+		}
+		return false
+	}
+}
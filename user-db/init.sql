@@ -1,6 +1,12 @@
 CREATE TABLE user_temple (
   id UUID PRIMARY KEY,
-  name TEXT
+  name TEXT,
+  bio TEXT NOT NULL DEFAULT '',
+  date_of_birth DATE,
+  gender TEXT NOT NULL DEFAULT '',
+  interested_in TEXT[] NOT NULL DEFAULT '{}',
+  location TEXT NOT NULL DEFAULT '',
+  interests TEXT[] NOT NULL DEFAULT '{}'
 );
 
 CREATE TABLE picture (
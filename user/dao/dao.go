@@ -3,6 +3,8 @@ package dao
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 
@@ -17,8 +19,10 @@ const psqlForeignKeyViolation = "foreign_key_violation"
 
 // BaseDatastore provides the basic datastore methods
 type BaseDatastore interface {
+	ListUser(input ListUserInput) (*[]User, error)
 	CreateUser(input CreateUserInput) (*User, error)
 	ReadUser(input ReadUserInput) (*User, error)
+	ReadUserBatch(input ReadUserBatchInput) (*[]User, error)
 	UpdateUser(input UpdateUserInput) (*User, error)
 	DeleteUser(input DeleteUserInput) error
 	CreatePicture(input CreatePictureInput) (*Picture, error)
@@ -33,9 +37,16 @@ type DAO struct {
 }
 
 // User encapsulates the object stored in the datastore
+// DateOfBirth is nil if the user has not provided it
 type User struct {
-	ID   uuid.UUID
-	Name string
+	ID           uuid.UUID
+	Name         string
+	Bio          string
+	DateOfBirth  *time.Time
+	Gender       string
+	InterestedIn []string
+	Location     string
+	Interests    []string
 }
 
 // Picture encapsulates the object stored in the datastore
@@ -45,10 +56,33 @@ type Picture struct {
 	Img    []byte
 }
 
+// ListUserInput encapsulates the information required to list a page of users in the datastore, ordered by name then ID
+// Only users whose name starts with NamePrefix and contains NameContains are listed, ignoring case, and only those
+// ordered after the cursor if one is provided
+type ListUserInput struct {
+	NamePrefix   string
+	NameContains string
+	Descending   bool
+	After        *UserCursor
+	Limit        int
+}
+
+// UserCursor identifies the position of a user in the order of a listing, such that the next page can start after it
+type UserCursor struct {
+	Name string
+	ID   uuid.UUID
+}
+
 // CreateUserInput encapsulates the information required to create a single user in the datastore
 type CreateUserInput struct {
-	ID   uuid.UUID
-	Name string
+	ID           uuid.UUID
+	Name         string
+	Bio          string
+	DateOfBirth  *time.Time
+	Gender       string
+	InterestedIn []string
+	Location     string
+	Interests    []string
 }
 
 // ReadUserInput encapsulates the information required to read a single user in the datastore
@@ -56,10 +90,23 @@ type ReadUserInput struct {
 	ID uuid.UUID
 }
 
+// ReadUserBatchInput encapsulates the information required to read several users in the datastore with a single query
+type ReadUserBatchInput struct {
+	IDs []uuid.UUID
+}
+
 // UpdateUserInput encapsulates the information required to update a single user in the datastore
+// Profile fields that are nil keep their stored value, except that the date of birth is removed if RemoveDateOfBirth is set
 type UpdateUserInput struct {
-	ID   uuid.UUID
-	Name string
+	ID                uuid.UUID
+	Name              string
+	Bio               *string
+	DateOfBirth       *time.Time
+	RemoveDateOfBirth bool
+	Gender            *string
+	InterestedIn      []string
+	Location          *string
+	Interests         []string
 }
 
 // DeleteUserInput encapsulates the information required to delete a single user in the datastore
@@ -118,12 +165,57 @@ func executeQueryWithRowResponse(db *sql.DB, query string, args ...interface{})
 	return db.QueryRow(query, args...)
 }
 
+// Executes a query, returning the rows
+func executeQueryWithRowResponses(db *sql.DB, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.Query(query, args...)
+}
+
+// ListUser returns a page of users in the datastore, ordered by name then ID
+func (dao *DAO) ListUser(input ListUserInput) (*[]User, error) {
+	query := "SELECT * FROM user_temple WHERE name ILIKE $1 AND name ILIKE $2 AND ($3 OR (name, id) > ($4, $5)) ORDER BY name, id LIMIT $6"
+	if input.Descending {
+		query = "SELECT * FROM user_temple WHERE name ILIKE $1 AND name ILIKE $2 AND ($3 OR (name, id) < ($4, $5)) ORDER BY name DESC, id DESC LIMIT $6"
+	}
+
+	var after UserCursor
+	if input.After != nil {
+		after = *input.After
+	}
+
+	// Escape the wildcards of LIKE, such that the name is matched literally
+	escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	prefixPattern := escaper.Replace(input.NamePrefix) + "%"
+	containsPattern := "%" + escaper.Replace(input.NameContains) + "%"
+	rows, err := executeQueryWithRowResponses(dao.DB, query, prefixPattern, containsPattern, input.After == nil, after.Name, after.ID, input.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userList := make([]User, 0)
+	for rows.Next() {
+		var user User
+		err = rows.Scan(&user.ID, &user.Name, &user.Bio, &user.DateOfBirth, &user.Gender, pq.Array(&user.InterestedIn), &user.Location, pq.Array(&user.Interests))
+		if err != nil {
+			return nil, err
+		}
+		userList = append(userList, user)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &userList, nil
+}
+
 // CreateUser creates a new user in the datastore, returning the newly created user
 func (dao *DAO) CreateUser(input CreateUserInput) (*User, error) {
-	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO user_temple (id, name) VALUES ($1, $2) RETURNING *", input.ID, input.Name)
+	row := executeQueryWithRowResponse(dao.DB, "INSERT INTO user_temple (id, name, bio, date_of_birth, gender, interested_in, location, interests) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *", input.ID, input.Name, input.Bio, input.DateOfBirth, input.Gender, pq.Array(input.InterestedIn), input.Location, pq.Array(input.Interests))
 
 	var user User
-	err := row.Scan(&user.ID, &user.Name)
+	err := row.Scan(&user.ID, &user.Name, &user.Bio, &user.DateOfBirth, &user.Gender, pq.Array(&user.InterestedIn), &user.Location, pq.Array(&user.Interests))
 	if err != nil {
 		return nil, err
 	}
@@ -136,7 +228,7 @@ func (dao *DAO) ReadUser(input ReadUserInput) (*User, error) {
 	row := executeQueryWithRowResponse(dao.DB, "SELECT * FROM user_temple WHERE id = $1", input.ID)
 
 	var user User
-	err := row.Scan(&user.ID, &user.Name)
+	err := row.Scan(&user.ID, &user.Name, &user.Bio, &user.DateOfBirth, &user.Gender, pq.Array(&user.InterestedIn), &user.Location, pq.Array(&user.Interests))
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -149,12 +241,38 @@ func (dao *DAO) ReadUser(input ReadUserInput) (*User, error) {
 	return &user, nil
 }
 
+// ReadUserBatch returns every user in the datastore whose ID is one of the given IDs, omitting those that do not exist
+func (dao *DAO) ReadUserBatch(input ReadUserBatchInput) (*[]User, error) {
+	rows, err := executeQueryWithRowResponses(dao.DB, "SELECT * FROM user_temple WHERE id = ANY($1)", pq.Array(input.IDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userList := make([]User, 0)
+	for rows.Next() {
+		var user User
+		err = rows.Scan(&user.ID, &user.Name, &user.Bio, &user.DateOfBirth, &user.Gender, pq.Array(&user.InterestedIn), &user.Location, pq.Array(&user.Interests))
+		if err != nil {
+			return nil, err
+		}
+		userList = append(userList, user)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &userList, nil
+}
+
 // UpdateUser updates a user in the datastore, returning an error if it fails
 func (dao *DAO) UpdateUser(input UpdateUserInput) (*User, error) {
-	row := executeQueryWithRowResponse(dao.DB, "UPDATE user_temple set name = $1 WHERE id = $2 RETURNING *", input.Name, input.ID)
+	row := executeQueryWithRowResponse(dao.DB, "UPDATE user_temple set name = $1, bio = COALESCE($2, bio), date_of_birth = CASE WHEN $9 THEN NULL ELSE COALESCE($3, date_of_birth) END, gender = COALESCE($4, gender), interested_in = COALESCE($5, interested_in), location = COALESCE($6, location), interests = COALESCE($7, interests) WHERE id = $8 RETURNING *", input.Name, input.Bio, input.DateOfBirth, input.Gender, pq.Array(input.InterestedIn), input.Location, pq.Array(input.Interests), input.ID, input.RemoveDateOfBirth)
 
 	var user User
-	err := row.Scan(&user.ID, &user.Name)
+	err := row.Scan(&user.ID, &user.Name, &user.Bio, &user.DateOfBirth, &user.Gender, pq.Array(&user.InterestedIn), &user.Location, pq.Array(&user.Interests))
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -167,16 +285,33 @@ func (dao *DAO) UpdateUser(input UpdateUserInput) (*User, error) {
 	return &user, nil
 }
 
-// DeleteUser deletes a user in the datastore
+// DeleteUser deletes a user in the datastore, along with every picture belonging to it
+// Both are deleted in a single transaction, as the pictures reference the user
 func (dao *DAO) DeleteUser(input DeleteUserInput) error {
-	rowsAffected, err := executeQuery(dao.DB, "DELETE FROM user_temple WHERE id = $1", input.ID)
+	tx, err := dao.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM picture WHERE user_id = $1", input.ID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM user_temple WHERE id = $1", input.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	} else if rowsAffected == 0 {
 		return ErrUserNotFound(input.ID.String())
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 // CreatePicture new picture in the datastore, returning the newly created picture
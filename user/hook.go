@@ -5,8 +5,10 @@ import "github.com/TempleEight/spec-golang/user/dao"
 // Hook allows additional code to be executed before and after every datastore interaction
 // Hooks are executed in the order they are defined, such that if any hook errors, future hooks are not executed and the request is terminated
 type Hook struct {
+	beforeListHooks          []*func(env *env, input *dao.ListUserInput) *HookError
 	beforeCreateHooks        []*func(env *env, req createUserRequest, input *dao.CreateUserInput) *HookError
 	beforeReadHooks          []*func(env *env, input *dao.ReadUserInput) *HookError
+	beforeReadBatchHooks     []*func(env *env, input *dao.ReadUserBatchInput) *HookError
 	beforeUpdateHooks        []*func(env *env, req updateUserRequest, input *dao.UpdateUserInput) *HookError
 	beforeDeleteHooks        []*func(env *env, input *dao.DeleteUserInput) *HookError
 	beforeCreatePictureHooks []*func(env *env, req createPictureRequest, input *dao.CreatePictureInput) *HookError
@@ -14,8 +16,10 @@ type Hook struct {
 	beforeUpdatePictureHooks []*func(env *env, req updatePictureRequest, input *dao.UpdatePictureInput) *HookError
 	beforeDeletePictureHooks []*func(env *env, input *dao.DeletePictureInput) *HookError
 
+	afterListHooks          []*func(env *env, userList *[]dao.User) *HookError
 	afterCreateHooks        []*func(env *env, user *dao.User) *HookError
 	afterReadHooks          []*func(env *env, user *dao.User) *HookError
+	afterReadBatchHooks     []*func(env *env, userList *[]dao.User) *HookError
 	afterUpdateHooks        []*func(env *env, user *dao.User) *HookError
 	afterDeleteHooks        []*func(env *env) *HookError
 	afterCreatePictureHooks []*func(env *env, picture *dao.Picture) *HookError
@@ -34,6 +38,11 @@ func (e *HookError) Error() string {
 	return e.error.Error()
 }
 
+// BeforeList adds a new hook to be executed before listing the objects in the datastore
+func (h *Hook) BeforeList(hook func(env *env, input *dao.ListUserInput) *HookError) {
+	h.beforeListHooks = append(h.beforeListHooks, &hook)
+}
+
 // BeforeCreate adds a new hook to be executed before creating an object in the datastore
 func (h *Hook) BeforeCreate(hook func(env *env, req createUserRequest, input *dao.CreateUserInput) *HookError) {
 	h.beforeCreateHooks = append(h.beforeCreateHooks, &hook)
@@ -44,6 +53,11 @@ func (h *Hook) BeforeRead(hook func(env *env, input *dao.ReadUserInput) *HookErr
 	h.beforeReadHooks = append(h.beforeReadHooks, &hook)
 }
 
+// BeforeReadBatch adds a new hook to be executed before reading a batch of objects in the datastore
+func (h *Hook) BeforeReadBatch(hook func(env *env, input *dao.ReadUserBatchInput) *HookError) {
+	h.beforeReadBatchHooks = append(h.beforeReadBatchHooks, &hook)
+}
+
 // BeforeUpdate adds a new hook to be executed before updating an object in the datastore
 func (h *Hook) BeforeUpdate(hook func(env *env, req updateUserRequest, input *dao.UpdateUserInput) *HookError) {
 	h.beforeUpdateHooks = append(h.beforeUpdateHooks, &hook)
@@ -74,6 +88,11 @@ func (h *Hook) BeforeDeletePicture(hook func(env *env, input *dao.DeletePictureI
 	h.beforeDeletePictureHooks = append(h.beforeDeletePictureHooks, &hook)
 }
 
+// AfterList adds a new hook to be executed after listing the objects in the datastore
+func (h *Hook) AfterList(hook func(env *env, userList *[]dao.User) *HookError) {
+	h.afterListHooks = append(h.afterListHooks, &hook)
+}
+
 // AfterCreate adds a new hook to be executed after creating an object in the datastore
 func (h *Hook) AfterCreate(hook func(env *env, user *dao.User) *HookError) {
 	h.afterCreateHooks = append(h.afterCreateHooks, &hook)
@@ -84,6 +103,11 @@ func (h *Hook) AfterRead(hook func(env *env, user *dao.User) *HookError) {
 	h.afterReadHooks = append(h.afterReadHooks, &hook)
 }
 
+// AfterReadBatch adds a new hook to be executed after reading a batch of objects in the datastore
+func (h *Hook) AfterReadBatch(hook func(env *env, userList *[]dao.User) *HookError) {
+	h.afterReadBatchHooks = append(h.afterReadBatchHooks, &hook)
+}
+
 // AfterUpdate adds a new hook to be executed after updating an object in the datastore
 func (h *Hook) AfterUpdate(hook func(env *env, user *dao.User) *HookError) {
 	h.afterUpdateHooks = append(h.afterUpdateHooks, &hook)
@@ -6,8 +6,10 @@ import (
 )
 
 var (
+	RequestList          = "list"
 	RequestCreate        = "create"
 	RequestRead          = "read"
+	RequestReadBatch     = "read_batch"
 	RequestUpdate        = "update"
 	RequestDelete        = "delete"
 	RequestCreatePicture = "create_picture"
@@ -25,6 +27,11 @@ var (
 		Help: "The total number of failed requests",
 	}, []string{"request_type", "error_code"})
 
+	APIKeyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_api_key_requests_total",
+		Help: "The total number of requests authorized by an API key, by the prefix of the key",
+	}, []string{"request_type", "api_key"})
+
 	DatabaseRequestDuration = promauto.NewSummaryVec(prometheus.SummaryOpts{
 		Name:       "user_database_request_seconds",
 		Help:       "The time spent executing database requests in seconds",
@@ -3,12 +3,18 @@ package main
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/TempleEight/spec-golang/user/comm"
 	"github.com/TempleEight/spec-golang/user/dao"
 	"github.com/TempleEight/spec-golang/user/metric"
 	"github.com/TempleEight/spec-golang/user/util"
@@ -22,17 +28,87 @@ import (
 // env defines the environment that requests should be executed within
 type env struct {
 	dao  dao.Datastore
+	comm comm.Comm
 	hook Hook
 }
 
+// Policies deciding which auths may act on a user and its pictures, where the owner is the auth that created the user
+var (
+	updateUserPolicy    = util.AnyOf(util.Owner, util.HasRole(util.RoleModerator))
+	deleteUserPolicy    = util.AnyOf(util.Owner, util.HasRole(util.RoleAdmin))
+	createPicturePolicy = util.Owner
+	updatePicturePolicy = util.AnyOf(util.Owner, util.HasRole(util.RoleModerator))
+	deletePicturePolicy = util.AnyOf(util.Owner, util.HasRole(util.RoleModerator))
+)
+
+// requestScopes contains the scope an API key needs for each request type
+var requestScopes = map[string]string{
+	metric.RequestList:          util.ScopeUserRead,
+	metric.RequestCreate:        util.ScopeUserWrite,
+	metric.RequestRead:          util.ScopeUserRead,
+	metric.RequestReadBatch:     util.ScopeUserRead,
+	metric.RequestUpdate:        util.ScopeUserWrite,
+	metric.RequestDelete:        util.ScopeUserWrite,
+	metric.RequestCreatePicture: util.ScopeUserWrite,
+	metric.RequestReadPicture:   util.ScopeUserRead,
+	metric.RequestUpdatePicture: util.ScopeUserWrite,
+	metric.RequestDeletePicture: util.ScopeUserWrite,
+}
+
+// The default and maximum number of users returned in a single page of a listing
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// The orders users can be listed in, by name then ID
+const (
+	sortNameAscending  = "name"
+	sortNameDescending = "-name"
+)
+
+// maxBatchSize is the most users that can be read in a single batch
+const maxBatchSize = 100
+
+// Limits on the profile of a user
+const (
+	minimumAge        = 18
+	maxInterests      = 20
+	maxInterestLength = 50
+)
+
+// dateFormat is the format of a date of birth, as in RFC 3339 but without a time
+const dateFormat = "2006-01-02"
+
+// genders contains every gender a user can identify as, or be interested in
+var genders = []string{"woman", "man", "non_binary", "other"}
+
 // createUserRequest contains the client-provided information required to create a single user
 type createUserRequest struct {
-	Name string `valid:"type(string),required,stringlength(2|255)"`
+	Name         string   `valid:"type(string),required,stringlength(2|255)"`
+	Bio          string   `valid:"type(string),stringlength(0|500)"`
+	DateOfBirth  string   `valid:"-"`
+	Gender       string   `valid:"-"`
+	InterestedIn []string `valid:"-"`
+	Location     string   `valid:"type(string),stringlength(0|255)"`
+	Interests    []string `valid:"-"`
 }
 
 // updateUserRequest contains the client-provided information required to update a single user, excluding ID
+// Profile fields that are omitted keep their stored value, while an empty DateOfBirth removes it
 type updateUserRequest struct {
-	Name string `valid:"type(string),required,stringlength(2|255)"`
+	Name         string    `valid:"type(string),required,stringlength(2|255)"`
+	Bio          *string   `valid:"optional,stringlength(0|500)"`
+	DateOfBirth  *string   `valid:"-"`
+	Gender       *string   `valid:"-"`
+	InterestedIn *[]string `valid:"-"`
+	Location     *string   `valid:"optional,stringlength(0|255)"`
+	Interests    *[]string `valid:"-"`
+}
+
+// readUserBatchRequest contains the client-provided IDs of the users to read in a single request
+type readUserBatchRequest struct {
+	IDs []uuid.UUID `valid:"-"`
 }
 
 // createPictureRequest contains the client-provided information required to create a single picture
@@ -45,22 +121,63 @@ type updatePictureRequest struct {
 	Img string `valid:"-"`
 }
 
+// listUserResponse contains a page of users to be returned to the client, and the cursor of the next page if there is one
+type listUserResponse struct {
+	UserList   []readUserResponse
+	NextCursor *string
+}
+
+// listUserCursor is the position in a listing that the next page starts after, along with the order it applies to
+type listUserCursor struct {
+	Sort string
+	Name string
+	ID   uuid.UUID
+}
+
 // createUserResponse contains a newly created user to be returned to the client
 type createUserResponse struct {
-	ID   uuid.UUID
-	Name string
+	ID           uuid.UUID
+	Name         string
+	Bio          string
+	DateOfBirth  *string
+	Age          *int
+	Gender       string
+	InterestedIn []string
+	Location     string
+	Interests    []string
 }
 
-// readUserResponse contains a single user to be returned to the client
+// readUserResponse contains a single user to be returned to the client, with their age if their date of birth is known
+// The date of birth itself is only returned to the user it belongs to
 type readUserResponse struct {
-	ID   uuid.UUID
-	Name string
+	ID           uuid.UUID
+	Name         string
+	Bio          string
+	DateOfBirth  *string
+	Age          *int
+	Gender       string
+	InterestedIn []string
+	Location     string
+	Interests    []string
+}
+
+// readUserBatchResponse contains the users found for a batch of IDs, and the IDs no user was found for
+type readUserBatchResponse struct {
+	UserList   []readUserResponse
+	MissingIDs []uuid.UUID
 }
 
 // updateUserResponse contains a newly updated user to be returned to the client
 type updateUserResponse struct {
-	ID   uuid.UUID
-	Name string
+	ID           uuid.UUID
+	Name         string
+	Bio          string
+	DateOfBirth  *string
+	Age          *int
+	Gender       string
+	InterestedIn []string
+	Location     string
+	Interests    []string
 }
 
 // createPictureResponse contains a newly created picture to be returned to the client
@@ -82,6 +199,8 @@ type updatePictureResponse struct {
 // router generates a router for this service
 func defaultRouter(env *env) *mux.Router {
 	r := mux.NewRouter()
+	r.HandleFunc("/user/all", env.listUserHandler).Methods(http.MethodGet)
+	r.HandleFunc("/user/batch", env.readUserBatchHandler).Methods(http.MethodPost)
 	r.HandleFunc("/user", env.createUserHandler).Methods(http.MethodPost)
 	r.HandleFunc("/user/{id}", env.readUserHandler).Methods(http.MethodGet)
 	r.HandleFunc("/user/{id}", env.updateUserHandler).Methods(http.MethodPut)
@@ -113,6 +232,31 @@ func main() {
 		log.Fatal(err)
 	}
 
+	switch config.TokenVerification.Mode {
+	case "":
+		config.TokenVerification.Mode = util.TokenVerificationJWKS
+	case util.TokenVerificationJWKS, util.TokenVerificationSecret:
+		break
+	case util.TokenVerificationNone:
+		log.Print("Access tokens will not be verified, so the service must only be reachable through the gateway")
+	default:
+		log.Fatalf("Unknown token verification mode %s", config.TokenVerification.Mode)
+	}
+
+	if config.TokenVerification.Mode == util.TokenVerificationJWKS && config.TokenVerification.JWKSCacheSeconds <= 0 {
+		log.Fatal("tokenVerification jwksCacheSeconds must be positive")
+	}
+	if config.TokenVerification.Mode == util.TokenVerificationSecret && (config.TokenVerification.Secret == "" || config.TokenVerification.Issuer == "") {
+		log.Fatal("tokenVerification secret and issuer must be provided in secret mode")
+	}
+	err = util.LoadServiceClientSecret(&config.ServiceCredentials)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.ServiceCredentials.ClientID == "" || config.ServiceCredentials.ClientSecret == "" {
+		log.Fatalf("serviceCredentials clientID and a client secret, from %s or clientSecretFile, must be provided", util.ServiceClientSecretEnv)
+	}
+
 	// Prometheus metrics
 	promPort, ok := config.Ports["prometheus"]
 	if !ok {
@@ -127,7 +271,9 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	env := env{d, Hook{}}
+	c := comm.Init(config)
+
+	env := env{d, c, Hook{}}
 
 	// Call into non-generated entry-point
 	router := defaultRouter(&env)
@@ -148,8 +294,301 @@ func jsonMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// extractAuth extracts the auth from a request, rejecting service tokens, which do not act on behalf of any auth
+func extractAuth(env *env, headers http.Header, requestType string) (*util.Auth, error) {
+	auth, err := extractCaller(env, headers, requestType)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(auth.Service) > 0 {
+		return nil, errors.New("service tokens cannot act on behalf of an auth")
+	}
+
+	return auth, nil
+}
+
+// extractCaller extracts the auth or service from a request, for routes that other services call
+// Restricted access tokens and those that have been revoked by the auth service are rejected
+// An API key may be provided in place of an access token, provided it has the scope the request type requires
+func extractCaller(env *env, headers http.Header, requestType string) (*util.Auth, error) {
+	if key := headers.Get("X-API-Key"); len(key) > 0 {
+		return extractAPIKey(env, key, requestType)
+	}
+
+	// The gateway lets requests without a valid access token through for API keys, so tokens it has not verified
+	// cannot be trusted unless this service verifies them itself
+	if env.comm.Keyfunc() == nil && headers.Get("X-Anonymous-Consumer") == "true" {
+		return nil, errors.New("access token was not verified by the gateway")
+	}
+
+	auth, err := util.ExtractAuthIDFromRequest(headers, env.comm.Keyfunc())
+	if err != nil {
+		return nil, err
+	}
+
+	if !auth.EmailVerified {
+		return nil, errors.New("email address has not been verified")
+	}
+
+	revoked, err := env.comm.CheckRevoked(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
+
+	return auth, nil
+}
+
+// extractAPIKey resolves an API key to the auth it acts on behalf of, recording its usage
+func extractAPIKey(env *env, key string, requestType string) (*util.Auth, error) {
+	auth, err := env.comm.ResolveAPIKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !auth.EmailVerified {
+		return nil, errors.New("email address has not been verified")
+	}
+
+	if !auth.HasScope(requestScopes[requestType]) {
+		return nil, fmt.Errorf("API key does not have the %s scope", requestScopes[requestType])
+	}
+
+	metric.APIKeyRequests.WithLabelValues(requestType, auth.APIKey).Inc()
+	return auth, nil
+}
+
+// Parse the filters, order and page of a user listing from the query of a request
+func parseListUserQuery(query url.Values) (dao.ListUserInput, int, error) {
+	limit := defaultPageLimit
+	if len(query.Get("limit")) > 0 {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return dao.ListUserInput{}, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+
+	sort := sortNameAscending
+	if len(query.Get("sort")) > 0 {
+		sort = query.Get("sort")
+		if sort != sortNameAscending && sort != sortNameDescending {
+			return dao.ListUserInput{}, 0, fmt.Errorf("sort must be either %s or %s", sortNameAscending, sortNameDescending)
+		}
+	}
+
+	input := dao.ListUserInput{
+		NamePrefix:   query.Get("name_prefix"),
+		NameContains: query.Get("name_contains"),
+		Descending:   sort == sortNameDescending,
+	}
+
+	if len(query.Get("cursor")) > 0 {
+		cursor, err := decodeListUserCursor(query.Get("cursor"))
+		if err != nil || cursor.Sort != sort {
+			return dao.ListUserInput{}, 0, errors.New("cursor is invalid, or was issued for a different sort")
+		}
+		input.After = &dao.UserCursor{
+			Name: cursor.Name,
+			ID:   cursor.ID,
+		}
+	}
+
+	return input, limit, nil
+}
+
+// Encode the position of a user in a listing as an opaque cursor
+func encodeListUserCursor(sort string, user dao.User) (string, error) {
+	cursor, err := json.Marshal(listUserCursor{
+		Sort: sort,
+		Name: user.Name,
+		ID:   user.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cursor), nil
+}
+
+// Decode a cursor returned from a previous page of a listing
+func decodeListUserCursor(encoded string) (*listUserCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor listUserCursor
+	err = json.Unmarshal(decoded, &cursor)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// Validate the profile of a user, shared by creating and updating it, returning the parsed date of birth
+// The date of birth is optional, but a user that provides it must be at least the minimum age
+func validateUserProfile(dateOfBirth string, gender string, interestedIn []string, interests []string, now time.Time) (*time.Time, error) {
+	if len(gender) > 0 && !validGender(gender) {
+		return nil, fmt.Errorf("Gender: must be one of %s", strings.Join(genders, ", "))
+	}
+
+	interestedInSeen := make(map[string]bool)
+	for _, gender := range interestedIn {
+		if !validGender(gender) || interestedInSeen[gender] {
+			return nil, fmt.Errorf("InterestedIn: must contain distinct genders from %s", strings.Join(genders, ", "))
+		}
+		interestedInSeen[gender] = true
+	}
+
+	if len(interests) > maxInterests {
+		return nil, fmt.Errorf("Interests: must contain at most %d interests", maxInterests)
+	}
+	for _, interest := range interests {
+		if len(strings.TrimSpace(interest)) == 0 || utf8.RuneCountInString(interest) > maxInterestLength {
+			return nil, fmt.Errorf("Interests: each interest must be between 1 and %d characters", maxInterestLength)
+		}
+	}
+
+	if len(dateOfBirth) == 0 {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(dateFormat, dateOfBirth)
+	if err != nil {
+		return nil, errors.New("DateOfBirth: must be a date in the format YYYY-MM-DD")
+	}
+
+	if age(parsed, now) < minimumAge {
+		return nil, fmt.Errorf("DateOfBirth: users must be at least %d years old", minimumAge)
+	}
+
+	return &parsed, nil
+}
+
+// validGender returns whether a user can identify as, or be interested in, the given gender
+func validGender(gender string) bool {
+	for _, valid := range genders {
+		if gender == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// age returns the age in whole years of someone born on the given date
+func age(dateOfBirth time.Time, now time.Time) int {
+	years := now.Year() - dateOfBirth.Year()
+	if now.Month() < dateOfBirth.Month() || (now.Month() == dateOfBirth.Month() && now.Day() < dateOfBirth.Day()) {
+		years--
+	}
+	return years
+}
+
+// stringList returns the given list, or an empty list in place of nil, such that lists are never stored or returned as null
+func stringList(list []string) []string {
+	if list == nil {
+		return make([]string, 0)
+	}
+	return list
+}
+
+// Construct the response for a single user, including their age if their date of birth is known
+// Only the user themselves is given their date of birth, such that anyone else only learns their age
+func userResponse(user *dao.User, caller *util.Auth) readUserResponse {
+	resp := readUserResponse{
+		ID:           user.ID,
+		Name:         user.Name,
+		Bio:          user.Bio,
+		Gender:       user.Gender,
+		InterestedIn: stringList(user.InterestedIn),
+		Location:     user.Location,
+		Interests:    stringList(user.Interests),
+	}
+
+	if user.DateOfBirth != nil {
+		userAge := age(*user.DateOfBirth, time.Now())
+		resp.Age = &userAge
+		if len(caller.Service) == 0 && caller.ID == user.ID {
+			dateOfBirth := user.DateOfBirth.Format(dateFormat)
+			resp.DateOfBirth = &dateOfBirth
+		}
+	}
+
+	return resp
+}
+
+func (env *env) listUserHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractCaller(env, r.Header, metric.RequestList)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestList)
+		return
+	}
+
+	input, limit, err := parseListUserQuery(r.URL.Query())
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestList)
+		return
+	}
+
+	// Request one more than the limit, to find whether there is another page
+	input.Limit = limit + 1
+
+	for _, hook := range env.hook.beforeListHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestList)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestList))
+	userList, err := env.dao.ListUser(input)
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestList)
+		return
+	}
+
+	for _, hook := range env.hook.afterListHooks {
+		err := (*hook)(env, userList)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestList)
+			return
+		}
+	}
+
+	userListResp := listUserResponse{
+		UserList: make([]readUserResponse, 0),
+	}
+	for i, user := range *userList {
+		if i == limit {
+			sort := sortNameAscending
+			if input.Descending {
+				sort = sortNameDescending
+			}
+
+			// The cursor is the last user of this page, as the next page starts after it
+			nextCursor, err := encodeListUserCursor(sort, (*userList)[i-1])
+			if err != nil {
+				respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestList)
+				return
+			}
+			userListResp.NextCursor = &nextCursor
+			break
+		}
+		userListResp.UserList = append(userListResp.UserList, userResponse(&user, auth))
+	}
+
+	json.NewEncoder(w).Encode(userListResp)
+	metric.RequestSuccess.WithLabelValues(metric.RequestList).Inc()
+}
+
 func (env *env) createUserHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestCreate)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestCreate)
 		return
@@ -168,9 +607,21 @@ func (env *env) createUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	dateOfBirth, err := validateUserProfile(req.DateOfBirth, req.Gender, req.InterestedIn, req.Interests, time.Now())
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestCreate)
+		return
+	}
+
 	input := dao.CreateUserInput{
-		ID:   auth.ID,
-		Name: req.Name,
+		ID:           auth.ID,
+		Name:         req.Name,
+		Bio:          req.Bio,
+		DateOfBirth:  dateOfBirth,
+		Gender:       req.Gender,
+		InterestedIn: stringList(req.InterestedIn),
+		Location:     req.Location,
+		Interests:    stringList(req.Interests),
 	}
 
 	for _, hook := range env.hook.beforeCreateHooks {
@@ -198,15 +649,12 @@ func (env *env) createUserHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	json.NewEncoder(w).Encode(createUserResponse{
-		ID:   user.ID,
-		Name: user.Name,
-	})
+	json.NewEncoder(w).Encode(createUserResponse(userResponse(user, auth)))
 	metric.RequestSuccess.WithLabelValues(metric.RequestCreate).Inc()
 }
 
 func (env *env) readUserHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractCaller(env, r.Header, metric.RequestRead)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestRead)
 		return
@@ -252,15 +700,91 @@ func (env *env) readUserHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	json.NewEncoder(w).Encode(readUserResponse{
-		ID:   user.ID,
-		Name: user.Name,
-	})
+	json.NewEncoder(w).Encode(userResponse(user, auth))
 	metric.RequestSuccess.WithLabelValues(metric.RequestRead).Inc()
 }
 
+// readUserBatchHandler reads every user in a batch of IDs with a single query, such that other services can check
+// several users in one request
+// Users are returned in the order their IDs were requested, with duplicate IDs returned once
+func (env *env) readUserBatchHandler(w http.ResponseWriter, r *http.Request) {
+	auth, err := extractCaller(env, r.Header, metric.RequestReadBatch)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestReadBatch)
+		return
+	}
+
+	var req readUserBatchRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestReadBatch)
+		return
+	}
+
+	if len(req.IDs) < 1 || len(req.IDs) > maxBatchSize {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: IDs must contain between 1 and %d IDs", maxBatchSize), http.StatusBadRequest, metric.RequestReadBatch)
+		return
+	}
+
+	input := dao.ReadUserBatchInput{
+		IDs: make([]uuid.UUID, 0, len(req.IDs)),
+	}
+	requested := make(map[uuid.UUID]bool)
+	for _, id := range req.IDs {
+		if !requested[id] {
+			requested[id] = true
+			input.IDs = append(input.IDs, id)
+		}
+	}
+
+	for _, hook := range env.hook.beforeReadBatchHooks {
+		err := (*hook)(env, &input)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestReadBatch)
+			return
+		}
+	}
+
+	timer := prometheus.NewTimer(metric.DatabaseRequestDuration.WithLabelValues(metric.RequestReadBatch))
+	userList, err := env.dao.ReadUserBatch(input)
+	timer.ObserveDuration()
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Something went wrong: %s", err.Error()), http.StatusInternalServerError, metric.RequestReadBatch)
+		return
+	}
+
+	for _, hook := range env.hook.afterReadBatchHooks {
+		err := (*hook)(env, userList)
+		if err != nil {
+			respondWithError(w, err.Error(), err.statusCode, metric.RequestReadBatch)
+			return
+		}
+	}
+
+	found := make(map[uuid.UUID]dao.User)
+	for _, user := range *userList {
+		found[user.ID] = user
+	}
+
+	userBatchResp := readUserBatchResponse{
+		UserList:   make([]readUserResponse, 0),
+		MissingIDs: make([]uuid.UUID, 0),
+	}
+	for _, id := range input.IDs {
+		user, ok := found[id]
+		if !ok {
+			userBatchResp.MissingIDs = append(userBatchResp.MissingIDs, id)
+			continue
+		}
+		userBatchResp.UserList = append(userBatchResp.UserList, userResponse(&user, auth))
+	}
+
+	json.NewEncoder(w).Encode(userBatchResp)
+	metric.RequestSuccess.WithLabelValues(metric.RequestReadBatch).Inc()
+}
+
 func (env *env) updateUserHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestUpdate)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestUpdate)
 		return
@@ -272,8 +796,7 @@ func (env *env) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only the auth that created the user can update it
-	if auth.ID != userID {
+	if !updateUserPolicy(auth, userID) {
 		respondWithError(w, "Not authorized to make request", http.StatusUnauthorized, metric.RequestUpdate)
 		return
 	}
@@ -291,9 +814,38 @@ func (env *env) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Omitted profile fields are validated as empty, and left as nil in the input such that the stored values are kept
+	var dateOfBirth, gender string
+	var interestedIn, interests []string
+	if req.DateOfBirth != nil {
+		dateOfBirth = *req.DateOfBirth
+	}
+	if req.Gender != nil {
+		gender = *req.Gender
+	}
+	if req.InterestedIn != nil {
+		interestedIn = stringList(*req.InterestedIn)
+	}
+	if req.Interests != nil {
+		interests = stringList(*req.Interests)
+	}
+
+	parsedDateOfBirth, err := validateUserProfile(dateOfBirth, gender, interestedIn, interests, time.Now())
+	if err != nil {
+		respondWithError(w, fmt.Sprintf("Invalid request parameters: %s", err.Error()), http.StatusBadRequest, metric.RequestUpdate)
+		return
+	}
+
 	input := dao.UpdateUserInput{
-		ID:   userID,
-		Name: req.Name,
+		ID:                userID,
+		Name:              req.Name,
+		Bio:               req.Bio,
+		DateOfBirth:       parsedDateOfBirth,
+		RemoveDateOfBirth: req.DateOfBirth != nil && parsedDateOfBirth == nil,
+		Gender:            req.Gender,
+		InterestedIn:      interestedIn,
+		Location:          req.Location,
+		Interests:         interests,
 	}
 
 	for _, hook := range env.hook.beforeUpdateHooks {
@@ -326,15 +878,12 @@ func (env *env) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	json.NewEncoder(w).Encode(updateUserResponse{
-		ID:   user.ID,
-		Name: user.Name,
-	})
+	json.NewEncoder(w).Encode(updateUserResponse(userResponse(user, auth)))
 	metric.RequestSuccess.WithLabelValues(metric.RequestUpdate).Inc()
 }
 
 func (env *env) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractCaller(env, r.Header, metric.RequestDelete)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDelete)
 		return
@@ -346,8 +895,16 @@ func (env *env) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only the auth that created the user can delete it
-	if auth.ID != userID {
+	// An auth may only delete users the policy permits, while the auth service deletes the user of a deleted account
+	switch auth.Service {
+	case "":
+		if !deleteUserPolicy(auth, userID) {
+			respondWithError(w, "Not authorized to make request", http.StatusUnauthorized, metric.RequestDelete)
+			return
+		}
+	case "auth":
+		break
+	default:
 		respondWithError(w, "Not authorized to make request", http.StatusUnauthorized, metric.RequestDelete)
 		return
 	}
@@ -391,7 +948,7 @@ func (env *env) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (env *env) createPictureHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestCreatePicture)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestCreatePicture)
 		return
@@ -403,8 +960,7 @@ func (env *env) createPictureHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only the auth that created the user can create a single picture
-	if auth.ID != userID {
+	if !createPicturePolicy(auth, userID) {
 		respondWithError(w, "Not authorized to make request", http.StatusUnauthorized, metric.RequestCreatePicture)
 		return
 	}
@@ -436,7 +992,7 @@ func (env *env) createPictureHandler(w http.ResponseWriter, r *http.Request) {
 
 	input := dao.CreatePictureInput{
 		ID:     uuid,
-		UserID: auth.ID,
+		UserID: userID,
 		Img:    decoded,
 	}
 
@@ -477,7 +1033,7 @@ func (env *env) createPictureHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (env *env) readPictureHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := util.ExtractAuthIDFromRequest(r.Header)
+	_, err := extractAuth(env, r.Header, metric.RequestReadPicture)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestReadPicture)
 		return
@@ -538,7 +1094,7 @@ func (env *env) readPictureHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (env *env) updatePictureHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestUpdatePicture)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestUpdatePicture)
 		return
@@ -550,8 +1106,7 @@ func (env *env) updatePictureHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only the auth that created the picture can update it
-	if auth.ID != userID {
+	if !updatePicturePolicy(auth, userID) {
 		respondWithError(w, "Not authorized to make request", http.StatusUnauthorized, metric.RequestUpdatePicture)
 		return
 	}
@@ -583,7 +1138,7 @@ func (env *env) updatePictureHandler(w http.ResponseWriter, r *http.Request) {
 
 	input := dao.UpdatePictureInput{
 		ID:     pictureID,
-		UserID: auth.ID,
+		UserID: userID,
 		Img:    decoded,
 	}
 
@@ -624,7 +1179,7 @@ func (env *env) updatePictureHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (env *env) deletePictureHandler(w http.ResponseWriter, r *http.Request) {
-	auth, err := util.ExtractAuthIDFromRequest(r.Header)
+	auth, err := extractAuth(env, r.Header, metric.RequestDeletePicture)
 	if err != nil {
 		respondWithError(w, fmt.Sprintf("Could not authorize request: %s", err.Error()), http.StatusUnauthorized, metric.RequestDeletePicture)
 		return
@@ -636,8 +1191,7 @@ func (env *env) deletePictureHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only the auth that created the picture can delete it
-	if auth.ID != userID {
+	if !deletePicturePolicy(auth, userID) {
 		respondWithError(w, "Not authorized to make request", http.StatusUnauthorized, metric.RequestDeletePicture)
 		return
 	}
@@ -9,7 +9,9 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/TempleEight/spec-golang/user/comm"
 	"github.com/TempleEight/spec-golang/user/dao"
 	"github.com/TempleEight/spec-golang/user/util"
 )
@@ -27,7 +29,11 @@ func TestMain(m *testing.M) {
 		log.Fatal(err)
 	}
 
-	environment = env{d, Hook{}}
+	// The fixed tokens used are not issued by a running auth service, so cannot be verified
+	config.TokenVerification.Mode = util.TokenVerificationNone
+	c := comm.Init(config)
+
+	environment = env{d, c, Hook{}}
 
 	os.Exit(m.Run())
 }
@@ -44,7 +50,7 @@ func TestIntegrationUser(t *testing.T) {
 	}
 
 	received := res.Body.String()
-	expected := fmt.Sprintf(`{"ID":"%s","Name":"Jay"}`, UUID1)
+	expected := fmt.Sprintf(`{"ID":"%s","Name":"Jay",%s}`, UUID1, emptyProfileJSON)
 	if expected != strings.TrimSuffix(received, "\n") {
 		t.Errorf("Handler returned incorrect body: got %+v want %+v", received, expected)
 	}
@@ -60,13 +66,15 @@ func TestIntegrationUser(t *testing.T) {
 	}
 
 	received = res.Body.String()
-	expected = fmt.Sprintf(`{"ID":"%s","Name":"Jay"}`, UUID1)
+	expected = fmt.Sprintf(`{"ID":"%s","Name":"Jay",%s}`, UUID1, emptyProfileJSON)
 	if expected != strings.TrimSuffix(received, "\n") {
 		t.Errorf("Handler returned incorrect body: got %+v want %+v", received, expected)
 	}
 
-	// Update that same user
-	res, err = makeRequest(environment, http.MethodPut, fmt.Sprintf("/user/%s", UUID1), `{"Name": "Lewis"}`, JWT1)
+	// Update that same user, providing the rest of their profile
+	profileJSON := fmt.Sprintf(`"Bio":"Likes long walks","DateOfBirth":"1990-01-02","Age":%d,"Gender":"man","InterestedIn":["woman","non_binary"],"Location":"London","Interests":["hiking","chess"]`, age(time.Date(1990, time.January, 2, 0, 0, 0, 0, time.UTC), time.Now()))
+	body := `{"Name": "Lewis", "Bio": "Likes long walks", "DateOfBirth": "1990-01-02", "Gender": "man", "InterestedIn": ["woman", "non_binary"], "Location": "London", "Interests": ["hiking", "chess"]}`
+	res, err = makeRequest(environment, http.MethodPut, fmt.Sprintf("/user/%s", UUID1), body, JWT1)
 	if err != nil {
 		t.Fatalf("Could not make PUT request: %s", err.Error())
 	}
@@ -76,11 +84,53 @@ func TestIntegrationUser(t *testing.T) {
 	}
 
 	received = res.Body.String()
-	expected = fmt.Sprintf(`{"ID":"%s","Name":"Lewis"}`, UUID1)
+	expected = fmt.Sprintf(`{"ID":"%s","Name":"Lewis",%s}`, UUID1, profileJSON)
 	if expected != strings.TrimSuffix(received, "\n") {
 		t.Errorf("Handler returned incorrect body: got %+v want %+v", received, expected)
 	}
 
+	// List users whose name starts with that of the same user
+	res, err = makeRequest(environment, http.MethodGet, "/user/all?name_prefix=lew&limit=1", "", JWT1)
+	if err != nil {
+		t.Fatalf("Could not make GET request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Errorf("Wrong status code: %v", res.Code)
+	}
+
+	received = res.Body.String()
+	expected = fmt.Sprintf(`{"UserList":[{"ID":"%s","Name":"Lewis",%s}],"NextCursor":null}`, UUID1, profileJSON)
+	if expected != strings.TrimSuffix(received, "\n") {
+		t.Errorf("Handler returned incorrect body: got %+v want %+v", received, expected)
+	}
+
+	// Read a batch containing that same user and one that does not exist
+	res, err = makeRequest(environment, http.MethodPost, "/user/batch", fmt.Sprintf(`{"IDs": ["%s", "%s"]}`, UUID1, UUID0), JWT1)
+	if err != nil {
+		t.Fatalf("Could not make POST request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Errorf("Wrong status code: %v", res.Code)
+	}
+
+	received = res.Body.String()
+	expected = fmt.Sprintf(`{"UserList":[{"ID":"%s","Name":"Lewis",%s}],"MissingIDs":["%s"]}`, UUID1, profileJSON, UUID0)
+	if expected != strings.TrimSuffix(received, "\n") {
+		t.Errorf("Handler returned incorrect body: got %+v want %+v", received, expected)
+	}
+
+	// Create a picture for that same user, which must be deleted along with it
+	res, err = makeRequest(environment, http.MethodPost, fmt.Sprintf("/user/%s/picture", UUID1), `{"Img": "aGVsbG8="}`, JWT1)
+	if err != nil {
+		t.Fatalf("Could not make POST request: %s", err.Error())
+	}
+
+	if res.Code != http.StatusOK {
+		t.Errorf("Wrong status code: %v", res.Code)
+	}
+
 	// Delete that same user
 	res, err = makeRequest(environment, http.MethodDelete, fmt.Sprintf("/user/%s", UUID1), "", JWT1)
 	if err != nil {
@@ -1,10 +1,44 @@
 package util
 
 type Config struct {
-	User     string            `json:"user"`
-	DBName   string            `json:"dbName"`
-	Host     string            `json:"host"`
-	SSLMode  string            `json:"sslMode"`
-	Services map[string]string `json:"services"`
-	Ports    map[string]int    `json:"ports"`
+	User                   string                   `json:"user"`
+	DBName                 string                   `json:"dbName"`
+	Host                   string                   `json:"host"`
+	SSLMode                string                   `json:"sslMode"`
+	Services               map[string]string        `json:"services"`
+	Ports                  map[string]int           `json:"ports"`
+	RevocationCacheSeconds int                      `json:"revocationCacheSeconds"`
+	TokenVerification      TokenVerificationConfig  `json:"tokenVerification"`
+	ServiceCredentials     ServiceCredentialsConfig `json:"serviceCredentials"`
+}
+
+// Token verification modes, determining how the signatures of access tokens are verified
+const (
+	// TokenVerificationJWKS verifies tokens using the public keys published by the auth service
+	TokenVerificationJWKS = "jwks"
+	// TokenVerificationSecret verifies HS256 tokens using a secret shared with their issuer
+	TokenVerificationSecret = "secret"
+	// TokenVerificationNone trusts the gateway to have verified tokens, so the service must not be reachable directly
+	TokenVerificationNone = "none"
+)
+
+// TokenVerificationConfig determines how access tokens are verified, in addition to their exp and nbf claims
+// In secret mode, the iss claim must match the configured issuer
+type TokenVerificationConfig struct {
+	Mode             string `json:"mode"`
+	Secret           string `json:"secret"`
+	Issuer           string `json:"issuer"`
+	JWKSCacheSeconds int    `json:"jwksCacheSeconds"`
+}
+
+// ServiceClientSecretEnv is the environment variable the client secret is read from, taking precedence over the file
+const ServiceClientSecretEnv = "SERVICE_CLIENT_SECRET"
+
+// ServiceCredentialsConfig contains the client credentials this service uses to obtain service tokens from the auth
+// service, for calls to other services that are not made on behalf of an auth
+// The client secret is never read from the config itself, but from the environment or a mounted secret file
+type ServiceCredentialsConfig struct {
+	ClientID         string `json:"clientID"`
+	ClientSecretFile string `json:"clientSecretFile"`
+	ClientSecret     string `json:"-"`
 }
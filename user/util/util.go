@@ -3,17 +3,44 @@ package util
 import (
 	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 )
 
-// Auth contains the unique identifier for a given auth
+// Auth contains the unique identifier for a given auth, the unique identifier of the token used, whether its email has been verified, and its role
+// Service tokens name the calling service in place of an auth, leaving the ID nil
+// API keys act on behalf of an auth, but only within their scopes, and are named by their prefix in place of a JTI
+// Tokens issued before the iat claim was included have a zero IssuedAt, so are revoked by any token cutoff
 type Auth struct {
-	ID uuid.UUID
+	ID            uuid.UUID
+	JTI           string
+	IssuedAt      time.Time
+	EmailVerified bool
+	Role          string
+	Service       string
+	APIKey        string
+	Scopes        []string
+}
+
+// HasScope returns whether the auth may make requests requiring the given scope, which access tokens always may
+func (auth *Auth) HasScope(scope string) bool {
+	if len(auth.APIKey) == 0 {
+		return true
+	}
+
+	for _, granted := range auth.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
 }
 
 // GetConfig returns a configuration object from decoding the given configuration file
@@ -32,6 +59,25 @@ func GetConfig(filePath string) (*Config, error) {
 	return &config, nil
 }
 
+// LoadServiceClientSecret reads the client secret from the environment, or otherwise from the configured file
+func LoadServiceClientSecret(config *ServiceCredentialsConfig) error {
+	if secret, ok := os.LookupEnv(ServiceClientSecretEnv); ok {
+		config.ClientSecret = strings.TrimSpace(secret)
+		return nil
+	}
+
+	if config.ClientSecretFile == "" {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(config.ClientSecretFile)
+	if err != nil {
+		return err
+	}
+	config.ClientSecret = strings.TrimSpace(string(contents))
+	return nil
+}
+
 // CreateErrorJSON returns a JSON string containing the key error associated with provided value
 func CreateErrorJSON(message string) string {
 	payload := map[string]string{"error": message}
@@ -63,7 +109,8 @@ func ExtractPictureIDFromRequest(requestParams map[string]string) (uuid.UUID, er
 }
 
 // ExtractAuthIDFromRequest extracts a token from a header of the form `Authorization: Bearer <token>`
-func ExtractAuthIDFromRequest(headers http.Header) (*Auth, error) {
+// The token's signature, exp and nbf claims are verified using keyFunc, unless it is nil
+func ExtractAuthIDFromRequest(headers http.Header, keyFunc jwt.Keyfunc) (*Auth, error) {
 	authHeader := headers.Get("Authorization")
 	if len(authHeader) == 0 {
 		return nil, errors.New("Authorization header not provided")
@@ -72,7 +119,13 @@ func ExtractAuthIDFromRequest(headers http.Header) (*Auth, error) {
 	// Extract and parse JWT
 	rawToken := strings.Replace(authHeader, "Bearer ", "", 1)
 	jwtParser := jwt.Parser{UseJSONNumber: true}
-	token, _, err := jwtParser.ParseUnverified(rawToken, jwt.MapClaims{})
+	var token *jwt.Token
+	var err error
+	if keyFunc == nil {
+		token, _, err = jwtParser.ParseUnverified(rawToken, jwt.MapClaims{})
+	} else {
+		token, err = jwtParser.Parse(rawToken, keyFunc)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -83,6 +136,29 @@ func ExtractAuthIDFromRequest(headers http.Header) (*Auth, error) {
 		return nil, errors.New("JWT claims are invalid")
 	}
 
+	// Parsing only checks the exp claim if it is present
+	if keyFunc != nil && !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("JWT does not contain an exp")
+	}
+
+	// Tokens issued before revocation was supported do not contain a jti
+	jti, _ := claims["jti"].(string)
+
+	// The auth service issues the iat claim to the millisecond, such that it can be compared exactly with token cutoffs
+	var issuedAt time.Time
+	if iat, ok := claims["iat"].(json.Number); ok {
+		seconds, err := iat.Float64()
+		if err != nil {
+			return nil, err
+		}
+		issuedAt = time.Unix(0, int64(math.Round(seconds*float64(time.Second/time.Millisecond)))*int64(time.Millisecond))
+	}
+
+	// Service tokens authenticate another service rather than an auth, so contain a service claim in place of an id
+	if service, ok := claims["service"].(string); ok {
+		return &Auth{uuid.Nil, jti, issuedAt, true, RoleUser, service, "", nil}, nil
+	}
+
 	// Extract ID from JWT claims
 	id, ok := claims["id"]
 	if !ok {
@@ -94,5 +170,17 @@ func ExtractAuthIDFromRequest(headers http.Header) (*Auth, error) {
 		return nil, err
 	}
 
-	return &Auth{uuid}, nil
+	// Only restricted tokens, issued before an email has been verified, contain the email_verified claim
+	emailVerified, ok := claims["email_verified"].(bool)
+	if !ok {
+		emailVerified = true
+	}
+
+	// Tokens issued before roles were supported do not contain a role, and were only issued to users
+	role, ok := claims["role"].(string)
+	if !ok {
+		role = RoleUser
+	}
+
+	return &Auth{uuid, jti, issuedAt, emailVerified, role, "", "", nil}, nil
 }